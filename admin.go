@@ -0,0 +1,36 @@
+package main
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// requireAdmin restricts a handler to callers presenting ADMIN_TOKEN as a
+// bearer token. Admin endpoints are disabled entirely when no token is
+// configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        token := os.Getenv("ADMIN_TOKEN")
+        if token == "" {
+            writeError(response, http.StatusNotFound, "not found")
+            return
+        }
+
+        if !isAdmin(request) {
+            writeError(response, http.StatusUnauthorized, "unauthorized")
+            return
+        }
+
+        next(response, request)
+    }
+}
+
+// isAdmin reports whether request presents ADMIN_TOKEN as a bearer token. It
+// never does when no token is configured.
+func isAdmin(request *http.Request) bool {
+    token := os.Getenv("ADMIN_TOKEN")
+    given := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+    return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Page sizes of the audit log.
+const (
+    defaultAuditPageSize = 100
+    maxAuditPageSize     = 1000
+)
+
+// AuditEntry is a write to the database: who made it, what it was and the
+// record before and after it. Before is left out of creates and After of
+// deletes.
+type AuditEntry struct {
+    Id        int             `json:"id"`
+    Actor     string          `json:"actor"`
+    Action    string          `json:"action"`
+    Target    string          `json:"target"`
+    Before    json.RawMessage `json:"before,omitempty"`
+    After     json.RawMessage `json:"after,omitempty"`
+    CreatedAt time.Time       `json:"created_at"`
+}
+
+// auditFilter selects audit entries. Zero fields don't filter.
+type auditFilter struct {
+    Actor    string
+    From, To time.Time
+    // BeforeID pages back through the log: only entries with a smaller id
+    // are returned.
+    BeforeID int
+    Limit    int
+}
+
+// AuditRepository stores the audit log.
+type AuditRepository interface {
+    // RecordAudit appends entry to the audit log.
+    RecordAudit(ctx context.Context, entry AuditEntry) error
+
+    // AuditLog returns the entries matching filter, newest first.
+    AuditLog(ctx context.Context, filter auditFilter) ([]AuditEntry, error)
+}
+
+var audit AuditRepository
+
+type actorKey struct{}
+
+// withActor records who is behind the writes made with ctx.
+func withActor(ctx context.Context, actor string) context.Context {
+    return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorOf returns who is behind the writes made with ctx. Writes without a
+// request, from background jobs and commands, are made by the system.
+func actorOf(ctx context.Context) string {
+    if actor, ok := ctx.Value(actorKey{}).(string); ok {
+        return actor
+    }
+    return "system"
+}
+
+// auditActors tells the audit log who made a request: the admin, or an
+// anonymous client, identified like reporters by a hash of its address.
+func auditActors(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        actor := "client:" + reporterKey(request)
+        if isAdmin(request) {
+            actor = "admin"
+        }
+        next.ServeHTTP(response, request.WithContext(withActor(request.Context(), actor)))
+    })
+}
+
+// withAudit returns store with every write recorded in the audit log.
+// Bookkeeping, like delivery attempts, digest claims, peer cursors and
+// share visits, isn't recorded.
+func withAudit(store storage) storage {
+    return auditedStorage{storage: store}
+}
+
+// auditedStorage records the writes made through storage once they succeed.
+// An entry that can't be recorded is logged, since the write has been made.
+type auditedStorage struct {
+    storage
+}
+
+func (s auditedStorage) record(ctx context.Context, action, target string, before, after any) {
+    entry := AuditEntry{Actor: actorOf(ctx), Action: action, Target: target, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+    var err error
+    if before != nil {
+        entry.Before, err = json.Marshal(before)
+    }
+    if after != nil && err == nil {
+        entry.After, err = json.Marshal(after)
+    }
+    if err == nil {
+        err = s.storage.RecordAudit(ctx, entry)
+    }
+    if err != nil {
+        slog.ErrorContext(ctx, "Error recording audit entry", "action", action, "target", target, "error", err)
+    }
+}
+
+// joke returns the joke with id for a snapshot, or nil if it can't be read,
+// e.g. because it's hidden.
+func (s auditedStorage) joke(ctx context.Context, id int) any {
+    joke, err := s.storage.Get(ctx, id)
+    if err != nil {
+        return nil
+    }
+    return joke
+}
+
+func jokeTarget(id int) string {
+    return "joke:" + jokeRef(id).String()
+}
+
+// JokeRepository
+
+func (s auditedStorage) Create(ctx context.Context, joke *Joke) error {
+    if err := s.storage.Create(ctx, joke); err != nil {
+        return err
+    }
+    s.record(ctx, "create", jokeTarget(joke.Id), nil, joke)
+    return nil
+}
+
+func (s auditedStorage) CreateBatch(ctx context.Context, jokes []Joke) ([]error, error) {
+    errs, err := s.storage.CreateBatch(ctx, jokes)
+    if err != nil {
+        return errs, err
+    }
+    for i, joke := range jokes {
+        if errs[i] == nil {
+            s.record(ctx, "create", jokeTarget(joke.Id), nil, joke)
+        }
+    }
+    return errs, nil
+}
+
+func (s auditedStorage) Delete(ctx context.Context, id int) error {
+    before := s.joke(ctx, id)
+    if err := s.storage.Delete(ctx, id); err != nil {
+        return err
+    }
+    s.record(ctx, "delete", jokeTarget(id), before, nil)
+    return nil
+}
+
+func (s auditedStorage) Restore(ctx context.Context, id int) error {
+    if err := s.storage.Restore(ctx, id); err != nil {
+        return err
+    }
+    s.record(ctx, "restore", jokeTarget(id), nil, s.joke(ctx, id))
+    return nil
+}
+
+func (s auditedStorage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+    n, err := s.storage.PurgeDeleted(ctx, before)
+    if err == nil && n > 0 {
+        s.record(ctx, "purge", "jokes", map[string]any{"deleted_before": before.UTC(), "count": n}, nil)
+    }
+    return n, err
+}
+
+// AuthorRepository
+
+func (s auditedStorage) MergeAuthors(ctx context.Context, into string, aliases []string) (Author, error) {
+    author, err := s.storage.MergeAuthors(ctx, into, aliases)
+    if err == nil {
+        s.record(ctx, "merge", "author:"+strconv.Itoa(author.Id), map[string]any{"into": into, "aliases": aliases}, author)
+    }
+    return author, err
+}
+
+// FederationRepository
+
+func (s auditedStorage) ImportFederated(ctx context.Context, joke FederatedJoke) (bool, error) {
+    created, err := s.storage.ImportFederated(ctx, joke)
+    if err == nil && created {
+        s.record(ctx, "import", "federated:"+joke.Source+"/"+joke.ExternalID, nil, joke)
+    }
+    return created, err
+}
+
+// DailyJokeRepository
+
+func (s auditedStorage) ScheduleJokes(ctx context.Context, entries []ScheduledJoke) error {
+    if err := s.storage.ScheduleJokes(ctx, entries); err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        s.record(ctx, "schedule", "schedule:"+entry.Date, nil, entry)
+    }
+    return nil
+}
+
+func (s auditedStorage) UnscheduleJoke(ctx context.Context, day string) error {
+    var before any
+    if entries, err := s.storage.ScheduledJokes(ctx, day); err == nil && len(entries) > 0 && entries[0].Date == day {
+        before = entries[0]
+    }
+    if err := s.storage.UnscheduleJoke(ctx, day); err != nil {
+        return err
+    }
+    s.record(ctx, "unschedule", "schedule:"+day, before, nil)
+    return nil
+}
+
+// ModerationRepository
+
+func (s auditedStorage) FlagJoke(ctx context.Context, jokeID int, source, reason string) error {
+    if err := s.storage.FlagJoke(ctx, jokeID, source, reason); err != nil {
+        return err
+    }
+    s.record(ctx, "flag", jokeTarget(jokeID), nil, map[string]string{"source": source, "reason": reason})
+    return nil
+}
+
+func (s auditedStorage) ResolveFlag(ctx context.Context, id int) error {
+    if err := s.storage.ResolveFlag(ctx, id); err != nil {
+        return err
+    }
+    s.record(ctx, "approve", "flag:"+strconv.Itoa(id), nil, nil)
+    return nil
+}
+
+func (s auditedStorage) EscalateFlags(ctx context.Context, before time.Time) (int, error) {
+    n, err := s.storage.EscalateFlags(ctx, before)
+    if err == nil && n > 0 {
+        s.record(ctx, "escalate", "flags", nil, map[string]any{"created_before": before.UTC(), "count": n})
+    }
+    return n, err
+}
+
+func (s auditedStorage) ReportJoke(ctx context.Context, jokeID int, reporter, reason string) (int, error) {
+    reports, err := s.storage.ReportJoke(ctx, jokeID, reporter, reason)
+    if err == nil {
+        s.record(ctx, "report", jokeTarget(jokeID), nil, map[string]any{"reason": reason, "reports": reports})
+    }
+    return reports, err
+}
+
+func (s auditedStorage) HideJoke(ctx context.Context, jokeID int) error {
+    before := s.joke(ctx, jokeID)
+    if err := s.storage.HideJoke(ctx, jokeID); err != nil {
+        return err
+    }
+    s.record(ctx, "hide", jokeTarget(jokeID), before, nil)
+    return nil
+}
+
+func (s auditedStorage) ResolveReports(ctx context.Context, jokeID int, hide bool) error {
+    before := s.joke(ctx, jokeID)
+    if err := s.storage.ResolveReports(ctx, jokeID, hide); err != nil {
+        return err
+    }
+    if hide {
+        s.record(ctx, "hide", jokeTarget(jokeID), before, nil)
+    } else {
+        s.record(ctx, "approve", jokeTarget(jokeID), before, s.joke(ctx, jokeID))
+    }
+    return nil
+}
+
+// ModerateFlags records a single entry for the batch. Approving and
+// rejecting are recorded like resolving reports, as approve and hide.
+func (s auditedStorage) ModerateFlags(ctx context.Context, ids []int, action, tag string) ([]int, []int, error) {
+    flags, jokes, err := s.storage.ModerateFlags(ctx, ids, action, tag)
+    if err != nil || len(flags) == 0 {
+        return flags, jokes, err
+    }
+    switch action {
+    case moderateTag:
+        s.record(ctx, "tag", "flags", nil, map[string]any{"flags": flags, "tag": tag})
+    case moderateReject:
+        s.record(ctx, "hide", "flags", nil, map[string]any{"flags": flags, "jokes": jokes})
+    default:
+        s.record(ctx, "approve", "flags", nil, map[string]any{"flags": flags, "jokes": jokes})
+    }
+    return flags, jokes, nil
+}
+
+// BestOfRepository
+
+func (s auditedStorage) SaveBestOf(ctx context.Context, best BestOf) error {
+    if err := s.storage.SaveBestOf(ctx, best); err != nil {
+        return err
+    }
+    s.record(ctx, "create", "best-of:"+best.Week, nil, best)
+    return nil
+}
+
+// SubscriptionRepository
+
+func (s auditedStorage) Subscribe(ctx context.Context, email, frequency, token string) (Subscription, error) {
+    subscription, err := s.storage.Subscribe(ctx, email, frequency, token)
+    if err == nil {
+        s.record(ctx, "subscribe", "subscription:"+strconv.Itoa(subscription.Id), nil, subscription)
+    }
+    return subscription, err
+}
+
+func (s auditedStorage) ConfirmSubscription(ctx context.Context, token string, since time.Time) (Subscription, error) {
+    subscription, err := s.storage.ConfirmSubscription(ctx, token, since)
+    if err == nil {
+        s.record(ctx, "confirm", "subscription:"+strconv.Itoa(subscription.Id), nil, subscription)
+    }
+    return subscription, err
+}
+
+func (s auditedStorage) Unsubscribe(ctx context.Context, token string) (Subscription, error) {
+    subscription, err := s.storage.Unsubscribe(ctx, token)
+    if err == nil {
+        s.record(ctx, "unsubscribe", "subscription:"+strconv.Itoa(subscription.Id), subscription, nil)
+    }
+    return subscription, err
+}
+
+func (s auditedStorage) PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error) {
+    n, err := s.storage.PurgeUnconfirmed(ctx, before)
+    if err == nil && n > 0 {
+        s.record(ctx, "purge", "subscriptions", map[string]any{"created_before": before.UTC(), "count": n}, nil)
+    }
+    return n, err
+}
+
+// ThemeRepository
+
+func (s auditedStorage) SaveTheme(ctx context.Context, theme Theme) error {
+    var before any
+    if old, err := s.storage.Theme(ctx, theme.Name); err == nil {
+        before = old
+    }
+    if err := s.storage.SaveTheme(ctx, theme); err != nil {
+        return err
+    }
+    action := "update"
+    if before == nil {
+        action = "create"
+    }
+    s.record(ctx, action, "theme:"+theme.Name, before, theme)
+    return nil
+}
+
+func (s auditedStorage) DeleteTheme(ctx context.Context, name string) error {
+    var before any
+    if old, err := s.storage.Theme(ctx, name); err == nil {
+        before = old
+    }
+    if err := s.storage.DeleteTheme(ctx, name); err != nil {
+        return err
+    }
+    s.record(ctx, "delete", "theme:"+name, before, nil)
+    return nil
+}
+
+// WebhookRepository
+
+func (s auditedStorage) CreateWebhook(ctx context.Context, url, secret string) (Webhook, error) {
+    webhook, err := s.storage.CreateWebhook(ctx, url, secret)
+    if err == nil {
+        logged := webhook
+        logged.Secret = ""
+        s.record(ctx, "create", "webhook:"+strconv.Itoa(webhook.Id), nil, logged)
+    }
+    return webhook, err
+}
+
+func (s auditedStorage) DeleteWebhook(ctx context.Context, id int) error {
+    if err := s.storage.DeleteWebhook(ctx, id); err != nil {
+        return err
+    }
+    s.record(ctx, "delete", "webhook:"+strconv.Itoa(id), nil, nil)
+    return nil
+}
+
+// parseAuditFilter reads the actor, from, to, before and limit parameters.
+// from and to take an RFC 3339 time or a date; a date in to includes the
+// whole day.
+func parseAuditFilter(request *http.Request) (auditFilter, error) {
+    query := request.URL.Query()
+    filter := auditFilter{Actor: query.Get("actor"), Limit: defaultAuditPageSize}
+    var problems validationErrors
+
+    for _, param := range []struct {
+        name   string
+        target *time.Time
+        dayEnd bool
+    }{{"from", &filter.From, false}, {"to", &filter.To, true}} {
+        value := query.Get(param.name)
+        if value == "" {
+            continue
+        }
+        if t, err := time.Parse(time.RFC3339, value); err == nil {
+            *param.target = t.UTC()
+        } else if day, err := time.Parse(dayLayout, value); err == nil {
+            if param.dayEnd {
+                day = day.AddDate(0, 0, 1).Add(-time.Second)
+            }
+            *param.target = day
+        } else {
+            problems.add(param.name, "must be an RFC 3339 time or a date like 2024-01-31")
+        }
+    }
+
+    if value := query.Get("before"); value != "" {
+        id, err := strconv.Atoi(value)
+        if err != nil || id < 1 {
+            problems.add("before", "must be a positive integer")
+        }
+        filter.BeforeID = id
+    }
+    if value := query.Get("limit"); value != "" {
+        limit, err := strconv.Atoi(value)
+        if err != nil || limit < 1 || limit > maxAuditPageSize {
+            problems.add("limit", "must be between 1 and %d", maxAuditPageSize)
+        }
+        filter.Limit = limit
+    }
+    return filter, problems.err()
+}
+
+// auditPage is a page of the audit log. NextBefore, when set, is the before
+// parameter of the next page.
+type auditPage struct {
+    Entries    []AuditEntry `json:"entries"`
+    NextBefore int          `json:"next_before,omitempty"`
+}
+
+// getAuditLog lists the audit log, newest first.
+func getAuditLog(response http.ResponseWriter, request *http.Request) {
+    filter, err := parseAuditFilter(request)
+    if err != nil {
+        writeValidationError(response, "invalid_request", err)
+        return
+    }
+
+    entries, err := audit.AuditLog(request.Context(), filter)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    page := auditPage{Entries: entries}
+    if len(entries) == filter.Limit {
+        page.NextBefore = entries[len(entries)-1].Id
+    }
+    writeJSON(response, request, http.StatusOK, page)
+}
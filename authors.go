@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/gorilla/mux"
+)
+
+// Author is a canonical author name together with the spellings that have
+// been folded into it.
+type Author struct {
+    Id      int      `json:"id"`
+    Name    string   `json:"name"`
+    Aliases []string `json:"aliases"`
+}
+
+// AuthorRepository manages canonical authors and their aliases.
+type AuthorRepository interface {
+    // MergeAuthors folds every author known under one of aliases into the
+    // author named into, renaming their jokes to the canonical name.
+    MergeAuthors(ctx context.Context, into string, aliases []string) (Author, error)
+
+    // BackfillAuthors registers the authors of existing jokes and rewrites
+    // spelling variants to the canonical name.
+    BackfillAuthors(ctx context.Context) error
+
+    // ListAuthors returns the authors credited with jokes, most jokes first,
+    // skipping offset and returning at most limit.
+    ListAuthors(ctx context.Context, offset, limit int) ([]AuthorSummary, error)
+
+    // AuthorJokes returns the jokes of the author known as name, newest
+    // first, or errAuthorNotFound.
+    AuthorJokes(ctx context.Context, name string, offset, limit int) (Author, []Joke, error)
+
+    // AuthorStats summarizes the jokes of the author known as name, or
+    // returns errAuthorNotFound.
+    AuthorStats(ctx context.Context, name string) (AuthorStats, error)
+}
+
+// AuthorSummary is an entry of the author listing.
+type AuthorSummary struct {
+    Name  string `json:"name"`
+    Jokes int    `json:"jokes"`
+}
+
+// AuthorStats are the statistics of an author. The submission dates are
+// missing when the author has no jokes left.
+type AuthorStats struct {
+    Name            string     `json:"name"`
+    TotalJokes      int        `json:"total_jokes"`
+    FirstSubmission *time.Time `json:"first_submission,omitempty"`
+    LastSubmission  *time.Time `json:"last_submission,omitempty"`
+}
+
+type authorPage struct {
+    Authors []AuthorSummary `json:"authors"`
+    Page    int             `json:"page"`
+    PerPage int             `json:"per_page"`
+}
+
+type authorJokesPage struct {
+    Author  string `json:"author"`
+    Jokes   []Joke `json:"jokes"`
+    Page    int    `json:"page"`
+    PerPage int    `json:"per_page"`
+}
+
+var authors AuthorRepository
+
+// errAuthorNotFound is returned when a name doesn't belong to any author.
+var errAuthorNotFound = errors.New("author not found")
+
+// normalizeAuthor returns the key used to match author names, so "Bob",
+// "bob" and " BOB " are treated as the same person.
+func normalizeAuthor(name string) string {
+    return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+type mergeAuthorsRequest struct {
+    Into    string   `json:"into"`
+    Aliases []string `json:"aliases"`
+}
+
+func (m *mergeAuthorsRequest) validate() error {
+    var problems validationErrors
+    switch {
+    case normalizeAuthor(m.Into) == "":
+        problems.add("into", "is required")
+    case utf8.RuneCountInString(strings.TrimSpace(m.Into)) > limits.MaxAuthorLength:
+        problems.add("into", "must be at most %d characters", limits.MaxAuthorLength)
+    }
+    if len(m.Aliases) == 0 {
+        problems.add("aliases", "is required")
+    }
+    for i, alias := range m.Aliases {
+        if normalizeAuthor(alias) == "" {
+            problems.add(fmt.Sprintf("aliases[%d]", i), "is empty")
+        }
+    }
+    return problems.err()
+}
+
+func mergeAuthors(response http.ResponseWriter, request *http.Request) {
+    var merge mergeAuthorsRequest
+    if !decodeRequest(response, request, &merge) {
+        return
+    }
+
+    author, err := authors.MergeAuthors(request.Context(), merge.Into, merge.Aliases)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    responses.invalidate()
+
+    response.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(response).Encode(author)
+}
+
+func listAuthors(response http.ResponseWriter, request *http.Request) {
+    page, perPage := pagination(request)
+
+    list, err := authors.ListAuthors(request.Context(), (page-1)*perPage, perPage)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, authorPage{Authors: list, Page: page, PerPage: perPage})
+}
+
+// getAuthorJokes lists the jokes of an author. The name in the path can be
+// any spelling merged into the author.
+func getAuthorJokes(response http.ResponseWriter, request *http.Request) {
+    page, perPage := pagination(request)
+
+    author, jokes, err := authors.AuthorJokes(request.Context(), mux.Vars(request)["name"], (page-1)*perPage, perPage)
+    if errors.Is(err, errAuthorNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    if len(jokes) > 0 {
+        response.Header().Set("Last-Modified", lastModified(jokes).Format(http.TimeFormat))
+    }
+    writeJSON(response, request, http.StatusOK, authorJokesPage{Author: author.Name, Jokes: jokes, Page: page, PerPage: perPage})
+}
+
+func getAuthorStats(response http.ResponseWriter, request *http.Request) {
+    stats, err := authors.AuthorStats(request.Context(), mux.Vars(request)["name"])
+    if errors.Is(err, errAuthorNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, stats)
+}
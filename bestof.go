@@ -0,0 +1,273 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    // bestOfInterval is how often the compiler checks whether last week's
+    // best of is due.
+    bestOfInterval = time.Hour
+
+    // bestOfFeedSize is how many weeks the best of feed carries.
+    bestOfFeedSize = 10
+)
+
+// bestOfSize is how many jokes a weekly best of holds. Zero turns the
+// compilations off.
+var bestOfSize = 10
+
+// errBestOfNotFound is returned for weeks without a best of.
+var errBestOfNotFound = errors.New("no best of for that week")
+
+// errBestOfExists is returned when a week's best of has been compiled
+// already, typically by another instance.
+var errBestOfExists = errors.New("best of already compiled")
+
+// BestOf is a week's most shared jokes, compiled once the week is over.
+// Week is the ISO week, like 2024-07.
+type BestOf struct {
+    Week       string        `json:"week"`
+    CompiledAt time.Time     `json:"compiled_at"`
+    Jokes      []bestOfEntry `json:"jokes"`
+}
+
+// bestOfEntry is a joke in a best of, with how often it was shared that
+// week. Jokes are ranked by their share link visits, the one signal of
+// popularity the service keeps.
+type bestOfEntry struct {
+    Joke   Joke `json:"joke"`
+    Shares int  `json:"shares"`
+}
+
+// BestOfRepository stores the weekly best of compilations.
+type BestOfRepository interface {
+    // TopShared returns the visible jokes shared most between from and to,
+    // up to limit, with Joke holding only their id.
+    TopShared(ctx context.Context, from, to time.Time, limit int) ([]bestOfEntry, error)
+
+    // SaveBestOf stores a compilation, or returns errBestOfExists if its
+    // week has one.
+    SaveBestOf(ctx context.Context, best BestOf) error
+
+    // BestOf returns the compilation of week, leaving out jokes that have
+    // been hidden or deleted since, or errBestOfNotFound.
+    BestOf(ctx context.Context, week string) (BestOf, error)
+
+    // BestOfWeeks returns the weeks with a compilation, newest first, up to
+    // limit.
+    BestOfWeeks(ctx context.Context, limit int) ([]string, error)
+}
+
+var bestOf BestOfRepository
+
+// isoWeek formats the ISO week of t, like 2024-07.
+func isoWeek(t time.Time) string {
+    year, week := t.ISOWeek()
+    return fmt.Sprintf("%d-%02d", year, week)
+}
+
+// parseISOWeek parses a week as formatted by isoWeek, also accepting a
+// single digit week, and returns the Monday it starts on.
+func parseISOWeek(week string) (string, time.Time, bool) {
+    y, w, ok := strings.Cut(week, "-")
+    year, yerr := strconv.Atoi(y)
+    number, werr := strconv.Atoi(w)
+    if !ok || yerr != nil || werr != nil || number < 1 || number > 53 {
+        return "", time.Time{}, false
+    }
+
+    // January 4th is always in the first week.
+    jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+    start := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(number-1)*7)
+    if _, n := start.ISOWeek(); n != number {
+        return "", time.Time{}, false
+    }
+    return isoWeek(start), start, true
+}
+
+// runBestOf compiles each week's best of once the week is over, checking
+// every bestOfInterval until ctx is cancelled.
+func runBestOf(ctx context.Context) {
+    ticker := time.NewTicker(bestOfInterval)
+    defer ticker.Stop()
+
+    for {
+        if err := compileBestOf(ctx, time.Now().UTC()); err != nil {
+            slog.Error("Error compiling the best of", "error", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// compileBestOf compiles and publishes the best of the week before now, if
+// it hasn't been. A week without shares gets none.
+func compileBestOf(ctx context.Context, now time.Time) error {
+    week, start, _ := parseISOWeek(isoWeek(now.AddDate(0, 0, -7)))
+    if _, err := bestOf.BestOf(ctx, week); !errors.Is(err, errBestOfNotFound) {
+        return err
+    }
+
+    top, err := bestOf.TopShared(ctx, start, start.AddDate(0, 0, 7), bestOfSize)
+    if err != nil || len(top) == 0 {
+        return err
+    }
+    err = bestOf.SaveBestOf(ctx, BestOf{Week: week, CompiledAt: now.Truncate(time.Second), Jokes: top})
+    if errors.Is(err, errBestOfExists) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    best, err := bestOf.BestOf(ctx, week)
+    if err != nil {
+        return err
+    }
+    slog.Info("Compiled the best of", "week", week, "jokes", len(best.Jokes))
+    notifyBestOf(ctx, best)
+    return nil
+}
+
+// bestOfEvent is the body POSTed to webhooks when a best of is published.
+type bestOfEvent struct {
+    Event      string `json:"event"`
+    Collection BestOf `json:"collection"`
+}
+
+// notifyBestOf queues a collection.published event for every webhook.
+// Subscribers and feed readers pick the compilation up by themselves.
+func notifyBestOf(ctx context.Context, best BestOf) {
+    payload, err := json.Marshal(bestOfEvent{Event: "collection.published", Collection: best})
+    if err == nil {
+        err = webhooks.EnqueueWebhooks(ctx, "collection.published", payload)
+    }
+    if err != nil {
+        slog.ErrorContext(ctx, "Error queueing webhooks", "week", best.Week, "error", err)
+    }
+}
+
+// latestBestOf returns the best of the week before now, or nil if there's
+// none, for the weekly digest.
+func latestBestOf(ctx context.Context, now time.Time) (*BestOf, error) {
+    best, err := bestOf.BestOf(ctx, isoWeek(now.AddDate(0, 0, -7)))
+    if errors.Is(err, errBestOfNotFound) || len(best.Jokes) == 0 {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &best, nil
+}
+
+func getBestOf(response http.ResponseWriter, request *http.Request) {
+    week, _, ok := parseISOWeek(mux.Vars(request)["week"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errBestOfNotFound.Error())
+        return
+    }
+
+    best, err := bestOf.BestOf(request.Context(), week)
+    if errors.Is(err, errBestOfNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, best)
+}
+
+// listBestOf lists the weeks with a best of, newest first.
+func listBestOf(response http.ResponseWriter, request *http.Request) {
+    weeks, err := bestOf.BestOfWeeks(request.Context(), 52)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, weeks)
+}
+
+// bestOfTitle is the title of a week's best of in the feed and emails.
+func bestOfTitle(week string) string {
+    year, number, _ := strings.Cut(week, "-")
+    return fmt.Sprintf("Best of week %s, %s", strings.TrimLeft(number, "0"), year)
+}
+
+// bestOfText lists the jokes of a best of as plain text.
+func bestOfText(best BestOf) string {
+    var b strings.Builder
+    for i, entry := range best.Jokes {
+        author := stripBidiControls(entry.Joke.Author)
+        if author == "" {
+            author = "Anonymous"
+        }
+        fmt.Fprintf(&b, "%d. %s\n   - %s\n\n", i+1, entry.Joke.plainText(), author)
+    }
+    return strings.TrimRight(b.String(), "\n")
+}
+
+// getBestOfFeed serves the latest compilations as an RSS feed, one item
+// per week.
+func getBestOfFeed(response http.ResponseWriter, request *http.Request) {
+    ctx := request.Context()
+    weeks, err := bestOf.BestOfWeeks(ctx, bestOfFeedSize)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    base := apiURL(request)
+    feed := rssFeed{
+        Version: "2.0",
+        DC:      "http://purl.org/dc/elements/1.1/",
+        Channel: rssChannel{
+            Title:       instanceInfo(request).Name + ": Best of the week",
+            Link:        base + "/collections/best-of",
+            Description: "The most shared jokes of every week",
+            Items:       []rssItem{},
+        },
+    }
+    var latest time.Time
+    for _, week := range weeks {
+        best, err := bestOf.BestOf(ctx, week)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+        if best.CompiledAt.After(latest) {
+            latest = best.CompiledAt
+        }
+        link := base + "/collections/best-of/" + week
+        feed.Channel.Items = append(feed.Channel.Items, rssItem{
+            Title:       bestOfTitle(week),
+            Link:        link,
+            Description: bestOfText(best),
+            GUID:        rssGUID{IsPermaLink: true, Value: link},
+            PubDate:     best.CompiledAt.Format(time.RFC1123Z),
+        })
+    }
+    if !latest.IsZero() {
+        feed.Channel.LastBuildDate = latest.Format(time.RFC1123Z)
+    }
+
+    writeFeed(response, request, "application/rss+xml; charset=utf-8", feed, latest)
+}
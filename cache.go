@@ -0,0 +1,208 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// responseCacheSize caps the number of cached responses. Once it's reached,
+// new responses aren't cached until expired ones have been dropped.
+const responseCacheSize = 1000
+
+// responseCacheRefreshTimeout bounds a background refresh of a stale entry.
+const responseCacheRefreshTimeout = 30 * time.Second
+
+// responses caches the output of expensive read endpoints. It's nil, and
+// caching is off, unless serve sets it up.
+var responses *responseCache
+
+// cachedResponse is a recorded 200 response.
+type cachedResponse struct {
+    header http.Header
+    body   []byte
+    stored time.Time
+}
+
+// responseCache is an in-process cache of GET responses with
+// stale-while-revalidate semantics: for ttl after it's stored an entry is
+// served as is; for stale after that it's still served, but the first request
+// to see it kicks off a refresh in the background. Writes call invalidate so
+// clients don't keep seeing the old data for a whole ttl.
+type responseCache struct {
+    ttl   time.Duration
+    stale time.Duration
+
+    mu         sync.Mutex
+    entries    map[string]cachedResponse
+    refreshing map[string]bool
+
+    // generation is bumped by invalidate, so a refresh that started before
+    // a write doesn't store what it read.
+    generation uint64
+}
+
+func newResponseCache(ttl, stale time.Duration) *responseCache {
+    return &responseCache{
+        ttl:        ttl,
+        stale:      stale,
+        entries:    map[string]cachedResponse{},
+        refreshing: map[string]bool{},
+    }
+}
+
+// invalidate drops every cached response.
+func (c *responseCache) invalidate() {
+    if c == nil {
+        return
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.entries = map[string]cachedResponse{}
+    c.generation++
+}
+
+// cached wraps next so its responses are served from the cache.
+func (c *responseCache) cached(next http.HandlerFunc) http.HandlerFunc {
+    if c == nil {
+        return next
+    }
+
+    return func(response http.ResponseWriter, request *http.Request) {
+        key := cacheKey(request)
+        now := time.Now()
+
+        c.mu.Lock()
+        entry, ok := c.entries[key]
+        age := now.Sub(entry.stored)
+        switch {
+        case ok && age < c.ttl:
+            c.mu.Unlock()
+            writeCached(response, entry, "HIT")
+            return
+        case ok && age < c.ttl+c.stale:
+            if !c.refreshing[key] {
+                c.refreshing[key] = true
+                go c.refresh(key, next, request.Clone(context.WithoutCancel(request.Context())))
+            }
+            c.mu.Unlock()
+            writeCached(response, entry, "STALE")
+            return
+        }
+        generation := c.generation
+        c.mu.Unlock()
+
+        recorder := newResponseRecorder()
+        next(recorder, request)
+        if recorder.status == http.StatusOK {
+            c.store(key, generation, recorder)
+        }
+
+        copyHeader(response.Header(), recorder.header)
+        response.Header().Set("X-Cache", "MISS")
+        response.WriteHeader(recorder.status)
+        response.Write(recorder.body.Bytes())
+    }
+}
+
+// refresh re-runs next for a stale entry and stores the new response.
+func (c *responseCache) refresh(key string, next http.HandlerFunc, request *http.Request) {
+    defer func() {
+        c.mu.Lock()
+        delete(c.refreshing, key)
+        c.mu.Unlock()
+    }()
+
+    ctx, cancel := context.WithTimeout(request.Context(), responseCacheRefreshTimeout)
+    defer cancel()
+
+    c.mu.Lock()
+    generation := c.generation
+    c.mu.Unlock()
+
+    recorder := newResponseRecorder()
+    next(recorder, request.WithContext(ctx))
+    if recorder.status != http.StatusOK {
+        slog.WarnContext(ctx, "Error refreshing cached response", "key", key, "status", recorder.status)
+        return
+    }
+    c.store(key, generation, recorder)
+}
+
+func (c *responseCache) store(key string, generation uint64, recorder *responseRecorder) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if generation != c.generation {
+        return
+    }
+
+    if len(c.entries) >= responseCacheSize {
+        now := time.Now()
+        for k, entry := range c.entries {
+            if now.Sub(entry.stored) >= c.ttl+c.stale {
+                delete(c.entries, k)
+            }
+        }
+        if _, ok := c.entries[key]; !ok && len(c.entries) >= responseCacheSize {
+            return
+        }
+    }
+
+    c.entries[key] = cachedResponse{
+        header: recorder.header.Clone(),
+        body:   bytes.Clone(recorder.body.Bytes()),
+        stored: time.Now(),
+    }
+}
+
+// cacheKey identifies a response by everything the cached handlers read from
+// the request: the URL, the host and scheme that links are built from, and
+// the languages listings are negotiated with.
+func cacheKey(request *http.Request) string {
+    return request.Header.Get("X-Forwarded-Proto") + " " + request.Host + request.URL.RequestURI() + " " + request.Header.Get("Accept-Language")
+}
+
+func writeCached(response http.ResponseWriter, entry cachedResponse, status string) {
+    copyHeader(response.Header(), entry.header)
+    response.Header().Set("X-Cache", status)
+    response.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+    response.WriteHeader(http.StatusOK)
+    response.Write(entry.body)
+}
+
+// copyHeader adds recorded headers to a response's, keeping those set by
+// outer middleware, such as their Vary.
+func copyHeader(dst, src http.Header) {
+    for name, values := range src {
+        dst[name] = append(dst[name], values...)
+    }
+}
+
+// responseRecorder captures a handler's response so it can be cached.
+type responseRecorder struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+    return &responseRecorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *responseRecorder) Header() http.Header {
+    return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+    r.status = status
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+    return r.body.Write(b)
+}
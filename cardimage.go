@@ -0,0 +1,293 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/gofont/goregular"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+)
+
+// jokeImageVersion is part of the media cache key of PNG cards. Bump it when
+// renderJokePNG changes, so cached cards are rendered again.
+const jokeImageVersion = "1"
+
+// jokeImageMaxAge is how long browsers and CDNs may cache a PNG card. Jokes
+// don't change, so social networks can keep their copy for a day.
+const jokeImageMaxAge = 86400
+
+// Limits of CARD_IMAGE_SIZE, so a card can't take a lot of memory to draw.
+const (
+    minCardImageSide = 200
+    maxCardImageSide = 4096
+)
+
+// cardImageTemplate is the layout of PNG cards, read from the CARD_IMAGE_
+// settings. Colors come from the theme; the size, font and footer are the
+// same for every card.
+type cardImageTemplate struct {
+    width, height int
+    font          *opentype.Font
+    // fontKey identifies the font, for the media cache key.
+    fontKey string
+    // footer is drawn in the bottom left corner, e.g. the address of the
+    // service.
+    footer string
+}
+
+// cardImage is the template of PNG cards, set by loadCardImage.
+var cardImage cardImageTemplate
+
+// loadCardImage reads CARD_IMAGE_SIZE, the card size as WIDTHxHEIGHT and
+// 1200x630 by default, which is what social networks show in link previews;
+// CARD_IMAGE_FONT, a TrueType or OpenType file to draw with instead of the
+// embedded Go font; and CARD_IMAGE_FOOTER, which defaults to the host of
+// PUBLIC_URL.
+func loadCardImage() (cardImageTemplate, error) {
+    t := cardImageTemplate{width: 1200, height: 630}
+    if size := os.Getenv("CARD_IMAGE_SIZE"); size != "" {
+        w, h, ok := strings.Cut(strings.ToLower(size), "x")
+        width, werr := strconv.Atoi(w)
+        height, herr := strconv.Atoi(h)
+        if !ok || werr != nil || herr != nil || width < minCardImageSide || width > maxCardImageSide || height < minCardImageSide || height > maxCardImageSide {
+            return t, fmt.Errorf("CARD_IMAGE_SIZE must be WIDTHxHEIGHT with sides between %d and %d, like 1200x630, not %q", minCardImageSide, maxCardImageSide, size)
+        }
+        t.width, t.height = width, height
+    }
+
+    data := goregular.TTF
+    t.fontKey = "goregular"
+    var err error
+    if path := os.Getenv("CARD_IMAGE_FONT"); path != "" {
+        data, err = os.ReadFile(path)
+        if err != nil {
+            return t, fmt.Errorf("reading CARD_IMAGE_FONT: %w", err)
+        }
+        sum := sha256.Sum256(data)
+        t.fontKey = hex.EncodeToString(sum[:])
+    }
+    t.font, err = opentype.Parse(data)
+    if err != nil {
+        return t, fmt.Errorf("parsing CARD_IMAGE_FONT: %w", err)
+    }
+
+    t.footer = os.Getenv("CARD_IMAGE_FOOTER")
+    if t.footer == "" {
+        if u, err := url.Parse(os.Getenv("PUBLIC_URL")); err == nil {
+            t.footer = u.Host
+        }
+    }
+    return t, nil
+}
+
+// cacheKey identifies what the template draws, for the media cache key.
+func (t cardImageTemplate) cacheKey() string {
+    return fmt.Sprintf("%dx%d\x00%s\x00%s", t.width, t.height, t.fontKey, t.footer)
+}
+
+// getJokeImage draws a joke as a PNG card for link previews, styled with the
+// theme named by ?theme=. Cards are cached by joke, theme and template, and
+// the media key doubles as the ETag.
+func getJokeImage(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+
+    theme, err := requestedTheme(request.Context(), request)
+    if errors.Is(err, errThemeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    joke, err := repo.Get(request.Context(), id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    key := mediaKey("card.png/"+jokeImageVersion, joke.Text, joke.Author, theme.cacheKey(), cardImage.cacheKey())
+    etag := `"` + key[:32] + `"`
+    response.Header().Set("ETag", etag)
+    response.Header().Set("Last-Modified", joke.Date.Format(http.TimeFormat))
+    response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jokeImageMaxAge))
+    if notModified(request, etag, joke.Date) {
+        response.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    card := cachedMedia(key, func() []byte {
+        return renderJokePNG(joke, theme, cardImage)
+    })
+    response.Header().Set("Content-Type", "image/png")
+    response.Write(card)
+}
+
+// renderJokePNG draws a joke on a card of the template's size: the theme's
+// border as a frame, the text as large as fits, the author under it and the
+// footer in the corner. Text too long to fit at the smallest size is cut
+// short with an ellipsis. The font has no shaping, so right-to-left jokes
+// are set flush right but scripts that join letters won't look right.
+func renderJokePNG(joke Joke, theme Theme, t cardImageTemplate) []byte {
+    width, height := t.width, t.height
+    frame := max(min(width, height)/60, 4)
+    padding := min(width, height) / 12
+    textWidth := width - padding*2
+    smallSize := float64(min(width, height)) / 28
+
+    card := image.NewRGBA(image.Rect(0, 0, width, height))
+    draw.Draw(card, card.Bounds(), image.NewUniform(hexColor(theme.Border)), image.Point{}, draw.Src)
+    inner := image.Rect(frame, frame, width-frame, height-frame)
+    draw.Draw(card, inner, image.NewUniform(hexColor(theme.Background)), image.Point{}, draw.Src)
+
+    author := stripBidiControls(joke.Author)
+    if author == "" {
+        author = "Anonymous"
+    }
+    small := cardFace(t.font, smallSize)
+    defer small.Close()
+    smallHeight := small.Metrics().Height.Ceil()
+    // The text gets what's left between the padding, the author line and the
+    // footer line.
+    textHeight := height - padding*2 - smallHeight*2 - padding/2
+
+    text := stripBidiControls(joke.Text)
+    rtl := isRTL(text)
+    face, lines := fitCardText(t.font, text, textWidth, textHeight, float64(height)/8, smallSize*1.25)
+    defer face.Close()
+    lineHeight := face.Metrics().Height.Ceil()
+
+    // The text block is centered vertically in its space.
+    y := padding + (textHeight-lineHeight*len(lines))/2 + face.Metrics().Ascent.Ceil()
+    for _, line := range lines {
+        drawCardLine(card, face, line, hexColor(theme.TextColor), padding, width-padding, y, rtl)
+        y += lineHeight
+    }
+
+    y += padding / 2
+    drawCardLine(card, small, "- "+author, hexColor(theme.AuthorColor), padding, width-padding, y, !rtl)
+    if t.footer != "" {
+        drawCardLine(card, small, t.footer, hexColor(theme.AuthorColor), padding, width-padding, height-padding, false)
+    }
+
+    var b bytes.Buffer
+    png.Encode(&b, card)
+    return b.Bytes()
+}
+
+// cardFace returns f at size pixels.
+func cardFace(f *opentype.Font, size float64) font.Face {
+    // The font was parsed at startup and the options are valid, so this
+    // doesn't fail.
+    face, _ := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+    return face
+}
+
+// fitCardText finds the largest size, from largest down to smallest, at which
+// text wraps into a box of width by height pixels, and returns the face and
+// lines. At the smallest size the lines that don't fit are dropped and the
+// last one ends with an ellipsis.
+func fitCardText(f *opentype.Font, text string, width, height int, largest, smallest float64) (font.Face, []string) {
+    for size := largest; ; size *= 0.9 {
+        size = max(size, smallest)
+        face := cardFace(f, size)
+        lines := wrapPixels(face, text, width)
+        fits := face.Metrics().Height.Ceil()*len(lines) <= height
+        if fits || size == smallest {
+            if !fits {
+                lines = lines[:max(height/face.Metrics().Height.Ceil(), 1)]
+                last := len(lines) - 1
+                for lines[last] != "" && font.MeasureString(face, lines[last]+"…").Ceil() > width {
+                    pieces := clusters(lines[last])
+                    lines[last] = strings.Join(pieces[:len(pieces)-1], "")
+                }
+                lines[last] = strings.TrimSpace(lines[last]) + "…"
+            }
+            return face, lines
+        }
+        face.Close()
+    }
+}
+
+// wrapPixels breaks text into lines at most width pixels wide in face,
+// splitting on whitespace. Like wrapText, words too long for a line are split
+// between characters.
+func wrapPixels(face font.Face, text string, width int) []string {
+    fits := func(s string) bool {
+        return font.MeasureString(face, s).Ceil() <= width
+    }
+    var lines []string
+    var line string
+    for _, word := range strings.Fields(text) {
+        var pieces []string
+        var piece string
+        for _, cluster := range clusters(word) {
+            if piece != "" && !fits(piece+cluster) {
+                pieces = append(pieces, piece)
+                piece = ""
+            }
+            piece += cluster
+        }
+        for _, piece := range append(pieces, piece) {
+            if line != "" && !fits(line+" "+piece) {
+                lines = append(lines, line)
+                line = ""
+            }
+            if line != "" {
+                line += " "
+            }
+            line += piece
+        }
+    }
+    if line != "" || len(lines) == 0 {
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+// drawCardLine draws s on its baseline y, flush left from left or, when
+// right is set, flush right to end.
+func drawCardLine(dst draw.Image, face font.Face, s string, c color.Color, left, end, y int, right bool) {
+    d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+    x := fixed.I(left)
+    if right {
+        x = fixed.I(end) - d.MeasureString(s)
+    }
+    d.Dot = fixed.Point26_6{X: x, Y: fixed.I(y)}
+    d.DrawString(s)
+}
+
+// hexColor parses a theme color, which validate has checked is #rgb or
+// #rrggbb.
+func hexColor(s string) color.RGBA {
+    s = strings.TrimPrefix(s, "#")
+    if len(s) == 3 {
+        s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+    }
+    v, _ := strconv.ParseUint(s, 16, 32)
+    return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
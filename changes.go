@@ -0,0 +1,74 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Kinds of change recorded in the change feed.
+const (
+    changeCreate  = "create"
+    changeUpdate  = "update"
+    changeHide    = "hide"
+    changeUnhide  = "unhide"
+    changeDelete  = "delete"
+    changeRestore = "restore"
+)
+
+// Page sizes of the change feed.
+const (
+    defaultChangesPageSize = 100
+    maxChangesPageSize     = 1000
+)
+
+// JokeChange is an entry in the change feed. Joke holds the joke as it is
+// now, not as it was at the time of the change, and is left out once the
+// joke has been deleted or hidden.
+type JokeChange struct {
+    Cursor    int       `json:"cursor"`
+    Op        string    `json:"op"`
+    JokeID    jokeRef   `json:"joke_id"`
+    ChangedAt time.Time `json:"changed_at"`
+    Joke      *Joke     `json:"joke,omitempty"`
+}
+
+type changesPage struct {
+    Changes    []JokeChange `json:"changes"`
+    NextCursor int          `json:"next_cursor"`
+}
+
+// ChangeRepository reads the append-only log of changes to jokes. Entries
+// are written by the other repositories in the same transaction as the
+// change itself.
+type ChangeRepository interface {
+    // Changes returns up to limit changes with a cursor greater than since,
+    // oldest first.
+    Changes(ctx context.Context, since, limit int) ([]JokeChange, error)
+}
+
+var changes ChangeRepository
+
+// getChanges serves the change feed. Clients pass the next_cursor of the
+// previous page as ?since= to pick up where they left off.
+func getChanges(response http.ResponseWriter, request *http.Request) {
+    since, _ := strconv.Atoi(request.URL.Query().Get("since"))
+    limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+    if limit <= 0 {
+        limit = defaultChangesPageSize
+    }
+    limit = min(limit, maxChangesPageSize)
+
+    list, err := changes.Changes(request.Context(), since, limit)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    page := changesPage{Changes: list, NextCursor: since}
+    if len(list) > 0 {
+        page.NextCursor = list[len(list)-1].Cursor
+    }
+    writeJSON(response, request, http.StatusOK, page)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io/fs"
+    "log/slog"
+    "os"
+
+    "github.com/joho/godotenv"
+    "github.com/spf13/cobra"
+    "github.com/spf13/pflag"
+)
+
+// envAnnotation marks flags that override an environment variable. The
+// value is the variable's name.
+const envAnnotation = "env"
+
+// envFlag adds a string flag to flags that overrides the environment
+// variable env, and so whatever .env sets it to.
+func envFlag(flags *pflag.FlagSet, name, env, usage string) {
+    flags.String(name, "", usage+" (overrides "+env+")")
+    flags.SetAnnotation(name, envAnnotation, []string{env})
+}
+
+// applyEnvFlags copies the env flags given on the command line into the
+// environment, where the config is read from.
+func applyEnvFlags(flags *pflag.FlagSet) {
+    flags.Visit(func(f *pflag.Flag) {
+        if env := f.Annotations[envAnnotation]; len(env) == 1 {
+            os.Setenv(env[0], f.Value.String())
+        }
+    })
+}
+
+// newCLI builds the dadjokes command. Without a subcommand it serves the
+// API, as the binary always has and as the Lambda runtime expects.
+func newCLI() *cobra.Command {
+    var envFile string
+    var migrateOnly bool
+
+    root := &cobra.Command{
+        Use:   "dadjokes",
+        Short: "Serve and manage the dad jokes API",
+        Args:  cobra.NoArgs,
+        PersistentPreRun: func(cmd *cobra.Command, args []string) {
+            // A missing .env is only an error when one was asked for: the
+            // environment can hold the whole config.
+            err := godotenv.Load(envFile)
+            if errors.Is(err, fs.ErrNotExist) && !cmd.Flags().Changed("env-file") {
+                err = nil
+            }
+            applyEnvFlags(cmd.Flags())
+            setupLogging()
+            if err != nil {
+                fatal("Error loading .env file", "error", err)
+            }
+
+            limits, err = loadLimits()
+            if err != nil {
+                fatal("Error reading config", "error", err)
+            }
+            if key := os.Getenv("ID_KEY"); key != "" {
+                publicIDs = newIDCodec(key)
+            }
+        },
+        Run: func(cmd *cobra.Command, args []string) {
+            if migrateOnly {
+                migrateUp(cmd.Context())
+                return
+            }
+            runServe(cmd.Context())
+        },
+        SilenceUsage: true,
+    }
+    root.PersistentFlags().StringVar(&envFile, "env-file", ".env", "file to load environment variables from")
+    envFlag(root.PersistentFlags(), "db-driver", "DB_DRIVER", "database driver: mysql, postgres, sqlite3 or mongodb")
+    envFlag(root.PersistentFlags(), "db", "DB_CONN_STRING", "database connection string")
+    envFlag(root.PersistentFlags(), "log-level", "LOG_LEVEL", "debug, info, warn or error")
+    root.Flags().BoolVar(&migrateOnly, "migrate-only", false, "apply database migrations and exit")
+    root.Flags().MarkDeprecated("migrate-only", "use `dadjokes migrate up` instead")
+
+    serveCmd := &cobra.Command{
+        Use:   "serve",
+        Short: "Serve the HTTP API",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            runServe(cmd.Context())
+        },
+    }
+    envFlag(serveCmd.Flags(), "addr", "LISTEN_ADDR", "address to listen on")
+    envFlag(serveCmd.Flags(), "public-url", "PUBLIC_URL", "public address of the service root")
+    envFlag(serveCmd.Flags(), "admin-token", "ADMIN_TOKEN", "token for the admin API")
+    envFlag(serveCmd.Flags(), "tls-cert", "TLS_CERT_FILE", "certificate to serve HTTPS with")
+    envFlag(serveCmd.Flags(), "tls-key", "TLS_KEY_FILE", "private key of the certificate")
+
+    lambdaCmd := &cobra.Command{
+        Use:   "lambda",
+        Short: "Serve AWS Lambda invocations",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            store, done := openApp(cmd.Context())
+            defer done()
+            serveLambda(cmd.Context(), store)
+        },
+    }
+
+    selftestCmd := &cobra.Command{
+        Use:   "selftest",
+        Short: "Probe every public endpoint against the configured database",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            store, done := openApp(cmd.Context())
+            defer done()
+            if err := runSelfTest(cmd.Context(), store); err != nil {
+                fatal("Self-test failed", "error", err)
+            }
+        },
+    }
+
+    importCmd := &cobra.Command{
+        Use:   "import FILE.json|FILE.csv",
+        Short: "Import jokes from a JSON array or a CSV file",
+        Args:  cobra.ExactArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            _, done := openApp(cmd.Context())
+            defer done()
+            if incompatibleSchema != nil {
+                fatal("Error importing jokes", "error", "the database schema doesn't match this build")
+            }
+            if err := runImport(cmd.Context(), args); err != nil {
+                fatal("Error importing jokes", "error", err)
+            }
+        },
+    }
+
+    var count int
+    seedCmd := &cobra.Command{
+        Use:   "seed",
+        Short: "Add the bundled starter jokes",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            _, done := openApp(cmd.Context())
+            defer done()
+            if incompatibleSchema != nil {
+                fatal("Error seeding jokes", "error", "the database schema doesn't match this build")
+            }
+            result, err := seedJokes(cmd.Context(), count)
+            if err != nil {
+                fatal("Error seeding jokes", "error", err)
+            }
+            slog.Info("Seeded jokes", "added", result.Added, "skipped", result.Skipped)
+        },
+    }
+    seedCmd.Flags().IntVar(&count, "count", 0, "how many starter jokes to add, 0 for all of them")
+
+    snapshotCmd := &cobra.Command{
+        Use:   "snapshot [FILE]",
+        Short: "Write a manifest of every joke, to compare with diff",
+        Args:  cobra.MaximumNArgs(1),
+        Run: func(cmd *cobra.Command, args []string) {
+            _, done := openApp(cmd.Context())
+            defer done()
+            name, err := runSnapshot(cmd.Context(), args)
+            if err != nil {
+                fatal("Error taking a snapshot", "error", err)
+            }
+            slog.Info("Wrote snapshot", "file", name)
+        },
+    }
+
+    var asJSON, exitCode bool
+    diffCmd := &cobra.Command{
+        Use:   "diff SNAPSHOT_A SNAPSHOT_B",
+        Short: "Show the jokes added, removed and changed between two snapshots",
+        Args:  cobra.ExactArgs(2),
+        Run: func(cmd *cobra.Command, args []string) {
+            changed, err := runDiff(cmd.OutOrStdout(), args[0], args[1], asJSON)
+            if err != nil {
+                fatal("Error comparing snapshots", "error", err)
+            }
+            if changed && exitCode {
+                os.Exit(1)
+            }
+        },
+    }
+    diffCmd.Flags().BoolVar(&asJSON, "json", false, "write the differences as JSON")
+    diffCmd.Flags().BoolVar(&exitCode, "exit-code", false, "exit with status 1 if the snapshots differ")
+
+    tuiCmd := &cobra.Command{
+        Use:   "tui",
+        Short: "Browse, submit and report jokes on a running server from the terminal",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            err := runTUI(cmd.Context(), getEnv("DADJOKES_URL", "http://localhost:8080"), os.Getenv("ADMIN_TOKEN"))
+            if err != nil {
+                fatal("Error running the terminal client", "error", err)
+            }
+        },
+    }
+    envFlag(tuiCmd.Flags(), "url", "DADJOKES_URL", "address of the server's service root")
+    envFlag(tuiCmd.Flags(), "admin-token", "ADMIN_TOKEN", "token for the admin API, which skips the proof of work")
+
+    root.AddCommand(serveCmd, lambdaCmd, selftestCmd, importCmd, seedCmd, snapshotCmd, diffCmd, tuiCmd, newMigrateCommand())
+    return root
+}
+
+func newMigrateCommand() *cobra.Command {
+    migrateCmd := &cobra.Command{
+        Use:   "migrate",
+        Short: "Apply or undo database migrations",
+    }
+
+    upCmd := &cobra.Command{
+        Use:   "up",
+        Short: "Apply every pending migration",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            migrateUp(cmd.Context())
+        },
+    }
+
+    var steps int
+    downCmd := &cobra.Command{
+        Use:   "down",
+        Short: "Undo the most recent migrations",
+        Args:  cobra.NoArgs,
+        Run: func(cmd *cobra.Command, args []string) {
+            if steps < 1 {
+                fatal("Error undoing migrations", "error", "--steps must be at least 1")
+            }
+            if err := migrateDown(cmd.Context(), steps); err != nil {
+                fatal("Error undoing migrations", "error", err)
+            }
+        },
+    }
+    downCmd.Flags().IntVar(&steps, "steps", 1, "how many migrations to undo")
+
+    migrateCmd.AddCommand(upCmd, downCmd)
+    return migrateCmd
+}
+
+// migrateUp applies pending migrations. MongoDB has none, so this only
+// creates its indexes.
+func migrateUp(ctx context.Context) {
+    store, err := openStorage(ctx, getEnv("DB_DRIVER", "mysql"))
+    if err != nil {
+        fatal("Error opening database", "error", err)
+    }
+    store.Close()
+}
+
+func migrateDown(ctx context.Context, steps int) error {
+    driver := getEnv("DB_DRIVER", "mysql")
+    if driver == "mongodb" {
+        return errors.New("MongoDB has no migrations to undo")
+    }
+    db, d, err := openSQLDB(ctx, driver)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+
+    undone, err := rollback(db, d, steps)
+    for _, name := range undone {
+        slog.Info("Undid migration", "migration", name)
+    }
+    if err == nil && len(undone) < steps {
+        slog.Warn("Fewer migrations applied than steps", "steps", steps, "undone", len(undone))
+    }
+    return err
+}
+
+// runServe serves the API, or Lambda invocations when started by the Lambda
+// runtime.
+func runServe(ctx context.Context) {
+    store, done := openApp(ctx)
+    defer done()
+    if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+        serveLambda(ctx, store)
+        return
+    }
+    serve(ctx, store)
+}
+
+// openApp sets up tracing and opens and migrates the database, for the
+// commands that use the repositories. It checks the schema and runs the
+// backfills, and returns a function that closes everything again.
+func openApp(ctx context.Context) (storage, func()) {
+    shutdownTracing, err := setupTracing(ctx)
+    if err != nil {
+        fatal("Error setting up tracing", "error", err)
+    }
+
+    store, err := openStorage(ctx, getEnv("DB_DRIVER", "mysql"))
+    if err != nil {
+        fatal("Error opening database", "error", err)
+    }
+    done := func() {
+        store.Close()
+        shutdownTracing(context.Background())
+    }
+
+    drift, err := store.schemaDrift(ctx)
+    if err != nil {
+        fatal("Error checking the database schema", "error", err)
+    }
+    logSchemaDrift(drift)
+    if drift.incompatible() {
+        incompatibleSchema = &drift
+    }
+
+    timeout, err := loadQueryTimeout()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    store = withQueryTimeout(withAudit(store), timeout)
+    repo, authors, federation, daily, webhooks, changes, moderation, themes, stats, subscriptions, shares, bestOf, audit, corpus, translations = store, store, store, store, store, store, store, store, store, store, store, store, store, store, store
+
+    // The backfills write, so they wait for a compatible schema too.
+    if incompatibleSchema == nil {
+        err = authors.BackfillAuthors(ctx)
+        if err != nil {
+            fatal("Error backfilling authors", "error", err)
+        }
+
+        err = repo.BackfillTextHashes(ctx)
+        if err != nil {
+            fatal("Error backfilling joke hashes", "error", err)
+        }
+    }
+    return store, done
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "mime"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/websocket"
+)
+
+// compatProfile reshapes JSON responses for clients written against an older
+// response shape. Clients pick one with ?compat=NAME.
+type compatProfile struct {
+    // rename maps field names to the names the clients expect. Fields are
+    // renamed wherever they appear in a response.
+    rename map[string]string
+
+    // plainErrors writes errors as their bare message in text/plain, as the
+    // API did before errors were JSON.
+    plainErrors bool
+}
+
+// compatProfiles are the profiles clients can pick. v0 is the shape before
+// the error envelope and field cleanups; COMPAT_PROFILES can change its
+// renames and add profiles.
+var compatProfiles = map[string]compatProfile{
+    "v0": {rename: map[string]string{"joke_text": "joke"}, plainErrors: true},
+}
+
+// loadCompatProfiles reads COMPAT_PROFILES, a semicolon separated list of
+// NAME:FIELD=LEGACY,FIELD=LEGACY profiles, e.g. "v0:joke_text=joke,
+// entry_date=date". A profile named like a built-in one replaces its renames.
+func loadCompatProfiles() error {
+    for _, spec := range strings.Split(os.Getenv("COMPAT_PROFILES"), ";") {
+        spec = strings.TrimSpace(spec)
+        if spec == "" {
+            continue
+        }
+        name, renames, ok := strings.Cut(spec, ":")
+        name = strings.TrimSpace(name)
+        if !ok || name == "" {
+            return fmt.Errorf("COMPAT_PROFILES: %q isn't NAME:FIELD=LEGACY,...", spec)
+        }
+
+        profile := compatProfiles[name]
+        profile.rename = map[string]string{}
+        for _, rename := range splitList(renames) {
+            field, legacy, ok := strings.Cut(rename, "=")
+            field, legacy = strings.TrimSpace(field), strings.TrimSpace(legacy)
+            if !ok || field == "" || legacy == "" {
+                return fmt.Errorf("COMPAT_PROFILES: %q isn't FIELD=LEGACY", rename)
+            }
+            profile.rename[field] = legacy
+        }
+        compatProfiles[name] = profile
+    }
+    return nil
+}
+
+// compat applies the profile named by ?compat= to JSON responses. Other
+// responses, such as feeds, streams and WebSockets, pass through as they are.
+func compat(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        name := request.URL.Query().Get("compat")
+        if name == "" || websocket.IsWebSocketUpgrade(request) {
+            next.ServeHTTP(response, request)
+            return
+        }
+        profile, ok := compatProfiles[name]
+        if !ok {
+            writeError(response, http.StatusBadRequest, fmt.Sprintf("unknown compat profile %q", name))
+            return
+        }
+
+        w := &compatWriter{ResponseWriter: response, profile: profile}
+        defer w.close()
+        next.ServeHTTP(w, request)
+    })
+}
+
+// compatWriter holds back JSON responses to reshape them once they're
+// complete, and passes through everything else.
+type compatWriter struct {
+    http.ResponseWriter
+    profile compatProfile
+
+    status int
+    // passthrough is set for responses that aren't reshaped, once their
+    // headers have been sent.
+    passthrough bool
+    buffer      bytes.Buffer
+}
+
+func (w *compatWriter) WriteHeader(status int) {
+    if w.status != 0 || w.passthrough {
+        return
+    }
+    if status < http.StatusOK {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+    if mediaType != "application/json" {
+        w.passthrough = true
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.status = status
+}
+
+func (w *compatWriter) Write(data []byte) (int, error) {
+    if w.status == 0 && !w.passthrough {
+        w.WriteHeader(http.StatusOK)
+    }
+    if w.passthrough {
+        return w.ResponseWriter.Write(data)
+    }
+    return w.buffer.Write(data)
+}
+
+// FlushError only flushes responses that are passed through; reshaped ones
+// are sent whole. It flushes through http.ResponseController, since the
+// signing and compressing writers underneath only implement FlushError.
+func (w *compatWriter) FlushError() error {
+    if !w.passthrough {
+        return nil
+    }
+    return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compatWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// close reshapes and sends a held back response. A body that doesn't parse
+// is sent unchanged.
+func (w *compatWriter) close() {
+    if w.passthrough || w.status == 0 {
+        return
+    }
+    body := w.buffer.Bytes()
+
+    var value any
+    decoder := json.NewDecoder(bytes.NewReader(body))
+    decoder.UseNumber()
+    if len(body) > 0 && decoder.Decode(&value) == nil {
+        if message, ok := errorMessage(value); ok && w.profile.plainErrors && w.status >= http.StatusBadRequest {
+            w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+            body = []byte(message + "\n")
+        } else if reshaped, err := json.Marshal(w.profile.reshape(value)); err == nil {
+            body = append(reshaped, '\n')
+        }
+    }
+
+    w.Header().Del("Content-Length")
+    if len(body) > 0 {
+        w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+    }
+    w.ResponseWriter.WriteHeader(w.status)
+    w.ResponseWriter.Write(body)
+}
+
+// reshape renames the fields of value, recursively.
+func (p compatProfile) reshape(value any) any {
+    switch v := value.(type) {
+    case map[string]any:
+        reshaped := make(map[string]any, len(v))
+        for name, field := range v {
+            if legacy, ok := p.rename[name]; ok {
+                name = legacy
+            }
+            reshaped[name] = p.reshape(field)
+        }
+        return reshaped
+    case []any:
+        for i, item := range v {
+            v[i] = p.reshape(item)
+        }
+        return v
+    }
+    return value
+}
+
+// errorMessage returns the message of an error envelope.
+func errorMessage(value any) (string, bool) {
+    body, ok := value.(map[string]any)
+    if !ok || len(body) != 1 {
+        return "", false
+    }
+    e, ok := body["error"].(map[string]any)
+    if !ok {
+        return "", false
+    }
+    message, ok := e["message"].(string)
+    return message, ok
+}
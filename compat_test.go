@@ -0,0 +1,45 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// flushErrorWriter is a writer that, like signingWriter and gzipWriter, can
+// only be flushed through FlushError.
+type flushErrorWriter struct {
+    *httptest.ResponseRecorder
+    flushes int
+}
+
+func (w *flushErrorWriter) FlushError() error {
+    w.flushes++
+    return nil
+}
+
+// TestCompatFlush flushes a passed through response, such as an event stream,
+// and a reshaped one through a writer that only implements FlushError.
+func TestCompatFlush(t *testing.T) {
+    tests := []struct {
+        contentType string
+        flushes     int
+    }{
+        {"text/event-stream", 1},
+        {"application/json", 0},
+    }
+    for _, test := range tests {
+        under := &flushErrorWriter{ResponseRecorder: httptest.NewRecorder()}
+        w := &compatWriter{ResponseWriter: under}
+        w.Header().Set("Content-Type", test.contentType)
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("data\n\n"))
+
+        if err := http.NewResponseController(w).Flush(); err != nil {
+            t.Fatal(err)
+        }
+        if under.flushes != test.flushes {
+            t.Errorf("%s: flushed %d times, want %d", test.contentType, under.flushes, test.flushes)
+        }
+    }
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/gorilla/websocket"
+)
+
+// compressMinSize is the smallest body worth compressing. Smaller ones can
+// come out larger than they went in.
+const compressMinSize = 1024
+
+// incompressibleTypes are media types that are compressed already, or the
+// prefixes of families of them.
+var incompressibleTypes = []string{
+    "image/", "audio/", "video/", "font/woff", "application/zip", "application/gzip",
+    "application/x-gzip", "application/zstd", "application/octet-stream",
+}
+
+// compressor gzips responses for clients that accept it.
+type compressor struct {
+    writers sync.Pool
+}
+
+// loadCompressor reads COMPRESSION_LEVEL, the gzip level from 1 (fastest) to
+// 9 (smallest). It returns nil, which leaves responses uncompressed, when
+// the level is 0.
+func loadCompressor() (*compressor, error) {
+    level, err := getEnvInt("COMPRESSION_LEVEL", 6)
+    if err != nil {
+        return nil, err
+    }
+    if level < 0 || level > gzip.BestCompression {
+        return nil, fmt.Errorf("COMPRESSION_LEVEL must be between 0 and %d", gzip.BestCompression)
+    }
+    if level == 0 {
+        return nil, nil
+    }
+
+    c := &compressor{}
+    c.writers.New = func() any {
+        w, _ := gzip.NewWriterLevel(nil, level)
+        return w
+    }
+    return c, nil
+}
+
+// compress gzips the responses of next when the request's Accept-Encoding
+// allows it. Bodies are held back until there's enough to be worth
+// compressing, or the handler flushes, so streamed responses such as
+// exports are compressed chunk by chunk as they're flushed. A nil compressor
+// passes requests straight through.
+func (c *compressor) compress(next http.Handler) http.Handler {
+    if c == nil {
+        return next
+    }
+
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        response.Header().Add("Vary", "Accept-Encoding")
+        if request.Method == http.MethodHead || websocket.IsWebSocketUpgrade(request) || !acceptsGzip(request.Header.Get("Accept-Encoding")) {
+            next.ServeHTTP(response, request)
+            return
+        }
+
+        w := &gzipWriter{ResponseWriter: response, compressor: c, status: http.StatusOK}
+        defer w.close()
+        next.ServeHTTP(w, request)
+    })
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or with *, and doesn't give it a quality of zero.
+func acceptsGzip(header string) bool {
+    for _, accepted := range strings.Split(header, ",") {
+        coding, params, _ := strings.Cut(strings.TrimSpace(accepted), ";")
+        coding = strings.ToLower(strings.TrimSpace(coding))
+        if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+            continue
+        }
+        q := 1.0
+        if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+            q, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+        }
+        return q > 0
+    }
+    return false
+}
+
+// compressible reports whether a response with these headers should be
+// gzipped.
+func compressible(header http.Header, status int) bool {
+    if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+        return false
+    }
+    if header.Get("Content-Encoding") != "" {
+        return false
+    }
+    mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+    for _, prefix := range incompressibleTypes {
+        if strings.HasPrefix(mediaType, prefix) {
+            return false
+        }
+    }
+    return true
+}
+
+// gzipWriter buffers the start of a response to decide whether to compress
+// it, then either gzips or passes through the rest.
+type gzipWriter struct {
+    http.ResponseWriter
+    compressor *compressor
+    status     int
+
+    // decided is set once the headers have been sent; gz is then the gzip
+    // writer, or nil if the response isn't compressed.
+    decided bool
+    gz      *gzip.Writer
+    buffer  bytes.Buffer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+    if w.decided {
+        return
+    }
+    if status < http.StatusOK {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.status = status
+    if !compressible(w.Header(), status) {
+        w.decide(false)
+    }
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+    if !w.decided {
+        w.buffer.Write(b)
+        if w.buffer.Len() < compressMinSize {
+            return len(b), nil
+        }
+        if err := w.start(false); err != nil {
+            return 0, err
+        }
+        return len(b), nil
+    }
+    if w.gz != nil {
+        return w.gz.Write(b)
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// start decides how to send the response and writes out what's buffered.
+// A response flushed before it reaches compressMinSize is compressed anyway,
+// since it's being streamed and more is likely to follow.
+func (w *gzipWriter) start(flushing bool) error {
+    if w.Header().Get("Content-Type") == "" && w.buffer.Len() > 0 {
+        w.Header().Set("Content-Type", http.DetectContentType(w.buffer.Bytes()))
+    }
+    w.decide((flushing || w.buffer.Len() >= compressMinSize) && compressible(w.Header(), w.status))
+
+    data := w.buffer.Bytes()
+    w.buffer = bytes.Buffer{}
+    if w.gz != nil {
+        _, err := w.gz.Write(data)
+        return err
+    }
+    _, err := w.ResponseWriter.Write(data)
+    return err
+}
+
+// decide sends the headers for a compressed or an uncompressed response.
+func (w *gzipWriter) decide(compress bool) {
+    w.decided = true
+    if compress {
+        header := w.Header()
+        header.Set("Content-Encoding", "gzip")
+        header.Del("Content-Length")
+        // The compressed body isn't byte for byte the one the ETag was made
+        // from, so it's only a weak match.
+        if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+            header.Set("ETag", "W/"+etag)
+        }
+        w.gz = w.compressor.writers.Get().(*gzip.Writer)
+        w.gz.Reset(w.ResponseWriter)
+    }
+    w.ResponseWriter.WriteHeader(w.status)
+}
+
+// FlushError sends what has been written so far, compressing it if the
+// response is compressed, so streamed responses reach the client as they're
+// produced.
+func (w *gzipWriter) FlushError() error {
+    if !w.decided {
+        if err := w.start(true); err != nil {
+            return err
+        }
+    }
+    if w.gz != nil {
+        if err := w.gz.Flush(); err != nil {
+            return err
+        }
+    }
+    return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler has returned.
+func (w *gzipWriter) close() {
+    if !w.decided {
+        w.start(false)
+    }
+    if w.gz != nil {
+        w.gz.Close()
+        w.compressor.writers.Put(w.gz)
+        w.gz = nil
+    }
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
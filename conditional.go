@@ -0,0 +1,46 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+)
+
+// conditional gives the 200 responses of next an ETag, a hash of the body,
+// and answers requests whose If-None-Match or If-Modified-Since show the
+// client's copy is current with 304 Not Modified. Handlers that know when
+// their data last changed set Last-Modified themselves.
+//
+// The ETag is computed from the body, so it covers the Accept format and
+// ?fields= without the handler having to know about either.
+func conditional(next http.HandlerFunc) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        recorder := newResponseRecorder()
+        next(recorder, request)
+
+        header := response.Header()
+        copyHeader(header, recorder.header)
+        if recorder.status != http.StatusOK {
+            response.WriteHeader(recorder.status)
+            response.Write(recorder.body.Bytes())
+            return
+        }
+
+        h := sha256.New()
+        h.Write([]byte(header.Get("Content-Type")))
+        h.Write([]byte{0})
+        h.Write(recorder.body.Bytes())
+        etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+        header.Set("ETag", etag)
+
+        modified, _ := http.ParseTime(header.Get("Last-Modified"))
+        if notModified(request, etag, modified) {
+            header.Del("Content-Type")
+            response.WriteHeader(http.StatusNotModified)
+            return
+        }
+
+        response.WriteHeader(http.StatusOK)
+        response.Write(recorder.body.Bytes())
+    }
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// getEnv returns the value of the environment variable key, or fallback when
+// it is unset or empty.
+func getEnv(key, fallback string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return fallback
+}
+
+// getEnvDuration parses the environment variable key as a time.Duration
+// ("30s", "1m"). It returns fallback when the variable is unset, and fallback
+// together with an error when it can't be parsed.
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback, nil
+    }
+
+    duration, err := time.ParseDuration(value)
+    if err != nil {
+        return fallback, fmt.Errorf("%s: %w", key, err)
+    }
+    return duration, nil
+}
+
+// getEnvInt parses the environment variable key as an integer. It returns
+// fallback when the variable is unset, and fallback together with an error
+// when it can't be parsed.
+func getEnvInt(key string, fallback int) (int, error) {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback, nil
+    }
+
+    n, err := strconv.Atoi(value)
+    if err != nil {
+        return fallback, fmt.Errorf("%s: %w", key, err)
+    }
+    return n, nil
+}
+
+// getEnvFloat parses the environment variable key as a floating point
+// number. It returns fallback when the variable is unset, and fallback
+// together with an error when it can't be parsed.
+func getEnvFloat(key string, fallback float64) (float64, error) {
+    value := os.Getenv(key)
+    if value == "" {
+        return fallback, nil
+    }
+
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return fallback, fmt.Errorf("%s: %w", key, err)
+    }
+    return f, nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(value string) []string {
+    var list []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// What happens to a submission a content filter objects to.
+const (
+    // contentReject refuses the submission.
+    contentReject = "reject"
+
+    // contentFlag stores the joke and puts it in the moderation queue.
+    contentFlag = "flag"
+)
+
+// contentFilterTimeout bounds a call to an external moderation service.
+const contentFilterTimeout = 5 * time.Second
+
+// contentFilter checks submitted jokes for content that isn't welcome.
+// Deployments can wire in a moderation service by implementing it.
+type contentFilter interface {
+    // name identifies the filter as the source of the flags it raises.
+    name() string
+
+    // check returns why joke isn't welcome, or "" if it is.
+    check(ctx context.Context, joke Joke) (string, error)
+}
+
+// contentFilters run on every submission, in order, until one objects.
+// contentAction says what happens then.
+var (
+    contentFilters []contentFilter
+    contentAction  = contentReject
+)
+
+// contentRejectedError is returned by submitJoke when a content filter
+// objects to a joke and CONTENT_FILTER_ACTION is reject.
+type contentRejectedError struct {
+    reason string
+}
+
+func (e *contentRejectedError) Error() string {
+    return "joke was rejected by the content filter: " + e.reason
+}
+
+// loadContentFilters sets up the filters from CONTENT_WORDLIST and
+// CONTENT_FILTER_URL, and the action from CONTENT_FILTER_ACTION.
+func loadContentFilters() error {
+    contentFilters = nil
+    if path := os.Getenv("CONTENT_WORDLIST"); path != "" {
+        filter, err := loadWordlistFilter(path)
+        if err != nil {
+            return fmt.Errorf("CONTENT_WORDLIST: %w", err)
+        }
+        contentFilters = append(contentFilters, filter)
+    }
+    if url := os.Getenv("CONTENT_FILTER_URL"); url != "" {
+        contentFilters = append(contentFilters, &serviceFilter{url: url, token: os.Getenv("CONTENT_FILTER_TOKEN")})
+    }
+
+    contentAction = getEnv("CONTENT_FILTER_ACTION", contentReject)
+    if contentAction != contentReject && contentAction != contentFlag {
+        return fmt.Errorf("CONTENT_FILTER_ACTION must be %s or %s", contentReject, contentFlag)
+    }
+    return nil
+}
+
+// filterContent runs the filters over joke and returns the first objection
+// and the filter that raised it.
+func filterContent(ctx context.Context, joke Joke) (reason, source string, err error) {
+    for _, filter := range contentFilters {
+        reason, err := filter.check(ctx, joke)
+        if err != nil {
+            return "", "", fmt.Errorf("content filter %s: %w", filter.name(), err)
+        }
+        if reason != "" {
+            return reason, filter.name(), nil
+        }
+    }
+    return "", "", nil
+}
+
+// wordlistFilter objects to jokes or authors containing a listed word or
+// phrase. Matching ignores case and punctuation and only counts whole words,
+// so "ass" doesn't match "class".
+type wordlistFilter struct {
+    phrases []string
+}
+
+// loadWordlistFilter reads a wordlist file with one word or phrase per line.
+// Blank lines and lines starting with # are skipped.
+func loadWordlistFilter(path string) (*wordlistFilter, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    filter := &wordlistFilter{}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if phrase := normalizeJokeText(line); phrase != "" {
+            filter.phrases = append(filter.phrases, " "+phrase+" ")
+        }
+    }
+    return filter, scanner.Err()
+}
+
+func (f *wordlistFilter) name() string {
+    return "wordlist"
+}
+
+// check doesn't say which word matched, so the reason can be shown to the
+// submitter without repeating it.
+func (f *wordlistFilter) check(ctx context.Context, joke Joke) (string, error) {
+    for _, text := range []string{joke.Text, joke.Author} {
+        padded := " " + normalizeJokeText(text) + " "
+        for _, phrase := range f.phrases {
+            if strings.Contains(padded, phrase) {
+                return "contains a blocked word", nil
+            }
+        }
+    }
+    return "", nil
+}
+
+// serviceFilter asks an external moderation service. It POSTs
+// {"author": ..., "joke_text": ...} to the URL and expects
+// {"flagged": bool, "reason": "..."} back.
+type serviceFilter struct {
+    url   string
+    token string
+}
+
+type serviceVerdict struct {
+    Flagged bool   `json:"flagged"`
+    Reason  string `json:"reason"`
+}
+
+func (f *serviceFilter) name() string {
+    return "service"
+}
+
+func (f *serviceFilter) check(ctx context.Context, joke Joke) (string, error) {
+    ctx, cancel := context.WithTimeout(ctx, contentFilterTimeout)
+    defer cancel()
+
+    body, _ := json.Marshal(map[string]string{"author": joke.Author, "joke_text": joke.Text})
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+    if err != nil {
+        return "", err
+    }
+    request.Header.Set("Content-Type", "application/json")
+    forwardDeadline(ctx, request.Header)
+    if f.token != "" {
+        request.Header.Set("Authorization", "Bearer "+f.token)
+    }
+
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        return "", err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("%s responded %s", f.url, response.Status)
+    }
+
+    var verdict serviceVerdict
+    if err := json.NewDecoder(response.Body).Decode(&verdict); err != nil {
+        return "", err
+    }
+    if !verdict.Flagged {
+        return "", nil
+    }
+    if verdict.Reason == "" {
+        verdict.Reason = "flagged by the moderation service"
+    }
+    return verdict.Reason, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// maxCorpusFileSize bounds the joke files read from the corpus. Larger files
+// are skipped.
+const maxCorpusFileSize = 64 << 10
+
+// CorpusRepository reads back the jokes synced from the git corpus.
+type CorpusRepository interface {
+    // SourceJokes returns the jokes stored with source, deleted ones
+    // included, by external id.
+    SourceJokes(ctx context.Context, source string) (map[string]sourceJoke, error)
+}
+
+// sourceJoke is a joke stored from an outside source.
+type sourceJoke struct {
+    Id      int
+    Deleted bool
+}
+
+var corpus CorpusRepository
+
+// corpusConfig is read by loadCorpus. The corpus is off when url is empty.
+var corpusConfig struct {
+    url, branch, dir, path string
+    interval               time.Duration
+}
+
+// loadCorpus reads CORPUS_GIT_URL, the repository to sync jokes from, and
+// CORPUS_GIT_BRANCH, CORPUS_GIT_PATH, CORPUS_GIT_DIR and
+// CORPUS_SYNC_INTERVAL.
+func loadCorpus() error {
+    corpusConfig.url = os.Getenv("CORPUS_GIT_URL")
+    if corpusConfig.url == "" {
+        return nil
+    }
+    if _, err := exec.LookPath("git"); err != nil {
+        return errors.New("CORPUS_GIT_URL requires git to be installed")
+    }
+
+    corpusConfig.branch = getEnv("CORPUS_GIT_BRANCH", "main")
+    corpusConfig.path = strings.Trim(os.Getenv("CORPUS_GIT_PATH"), "/")
+    corpusConfig.dir = getEnv("CORPUS_GIT_DIR", filepath.Join(os.TempDir(), "dadjokes-corpus"))
+    var err error
+    corpusConfig.interval, err = getEnvDuration("CORPUS_SYNC_INTERVAL", 5*time.Minute)
+    if err != nil {
+        return err
+    }
+    if corpusConfig.interval <= 0 {
+        return errors.New("CORPUS_SYNC_INTERVAL must be positive")
+    }
+    return nil
+}
+
+// corpusSource is the source of the jokes synced from the corpus: its URL,
+// without any credentials in it.
+func corpusSource() string {
+    u, err := url.Parse(corpusConfig.url)
+    if err != nil || u.User == nil {
+        return "git+" + corpusConfig.url
+    }
+    u.User = nil
+    return "git+" + u.String()
+}
+
+// corpusSyncResult is what a sync of the corpus changed.
+type corpusSyncResult struct {
+    Commit   string       `json:"commit"`
+    Added    int          `json:"added"`
+    Restored int          `json:"restored"`
+    Removed  int          `json:"removed"`
+    Skipped  []corpusSkip `json:"skipped,omitempty"`
+}
+
+// corpusSkip is a joke file that wasn't synced, and why.
+type corpusSkip struct {
+    Path   string `json:"path"`
+    Reason string `json:"reason"`
+}
+
+// corpusFile is a joke file at the synced commit. Jokes are keyed by the
+// hash of their file's content, so an edited file counts as a new joke
+// replacing the old one while a moved file stays the same joke.
+type corpusFile struct {
+    path, blob string
+}
+
+func (f corpusFile) externalID() string {
+    return corpusSource() + "@" + f.blob
+}
+
+// corpusMutex keeps the sync loop and the admin endpoint from syncing at the
+// same time.
+var corpusMutex sync.Mutex
+
+// lastCorpusCommit is the commit the loop last synced, so it can skip
+// commits it has seen.
+var lastCorpusCommit string
+
+// runCorpusSync pulls the corpus and syncs it every CORPUS_SYNC_INTERVAL
+// until ctx is cancelled.
+func runCorpusSync(ctx context.Context) {
+    ticker := time.NewTicker(corpusConfig.interval)
+    defer ticker.Stop()
+
+    for {
+        result, err := syncCorpus(ctx, false)
+        if err != nil {
+            slog.Error("Error syncing the joke corpus", "error", err)
+        } else if result.Added+result.Restored+result.Removed+len(result.Skipped) > 0 {
+            slog.Info("Synced the joke corpus", "commit", result.Commit, "added", result.Added, "restored", result.Restored, "removed", result.Removed, "skipped", len(result.Skipped))
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// syncCorpus pulls the corpus and makes the jokes stored from it match its
+// files: new files are added, files that are gone are deleted and files
+// that come back are restored. Unless force is set, a commit synced before
+// isn't synced again.
+func syncCorpus(ctx context.Context, force bool) (corpusSyncResult, error) {
+    corpusMutex.Lock()
+    defer corpusMutex.Unlock()
+
+    var result corpusSyncResult
+    commit, err := pullCorpus(ctx)
+    if err != nil {
+        return result, err
+    }
+    result.Commit = commit
+    if commit == lastCorpusCommit && !force {
+        return result, nil
+    }
+
+    files, err := corpusFiles(ctx)
+    if err != nil {
+        return result, err
+    }
+    stored, err := corpus.SourceJokes(ctx, corpusSource())
+    if err != nil {
+        return result, err
+    }
+    // Deletes and restores are credited to the author of the synced commit.
+    author, err := git(ctx, "log", "-1", "--format=%an", "HEAD")
+    if err != nil {
+        return result, err
+    }
+    committed := withActor(ctx, "git:"+author)
+
+    for id, file := range files {
+        if joke, ok := stored[id]; ok {
+            if joke.Deleted {
+                if err := repo.Restore(committed, joke.Id); err != nil {
+                    return result, fmt.Errorf("restoring %s: %w", file.path, err)
+                }
+                result.Restored++
+            }
+            continue
+        }
+
+        reason, err := addCorpusJoke(ctx, id, file)
+        if err != nil {
+            return result, fmt.Errorf("adding %s: %w", file.path, err)
+        }
+        if reason != "" {
+            result.Skipped = append(result.Skipped, corpusSkip{Path: file.path, Reason: reason})
+            continue
+        }
+        result.Added++
+    }
+
+    for id, joke := range stored {
+        if _, ok := files[id]; ok || joke.Deleted {
+            continue
+        }
+        err := repo.Delete(committed, joke.Id)
+        if err != nil && !errors.Is(err, errJokeNotFound) {
+            return result, fmt.Errorf("removing joke %s: %w", jokeRef(joke.Id), err)
+        }
+        jokeHidden(joke.Id)
+        result.Removed++
+    }
+
+    sort.Slice(result.Skipped, func(i, j int) bool { return result.Skipped[i].Path < result.Skipped[j].Path })
+    if result.Added+result.Restored > 0 {
+        responses.invalidate()
+    }
+    if result.Added > 0 {
+        newJokes.notify()
+    }
+    lastCorpusCommit = commit
+    return result, nil
+}
+
+// addCorpusJoke stores the joke in file, credited to the author of the
+// commit that last changed it. It returns why the joke was skipped, if it
+// was.
+func addCorpusJoke(ctx context.Context, id string, file corpusFile) (string, error) {
+    info, err := os.Stat(filepath.Join(corpusConfig.dir, filepath.FromSlash(file.path)))
+    if err != nil {
+        return "", err
+    }
+    if info.Size() > maxCorpusFileSize {
+        return fmt.Sprintf("larger than %d bytes", maxCorpusFileSize), nil
+    }
+    text, err := os.ReadFile(filepath.Join(corpusConfig.dir, filepath.FromSlash(file.path)))
+    if err != nil {
+        return "", err
+    }
+
+    author, err := git(ctx, "log", "-1", "--format=%an", "--", file.path)
+    if err != nil {
+        return "", err
+    }
+    joke := Joke{Author: author, Text: strings.TrimSpace(string(text))}
+    normalizeJoke(&joke)
+    if err := limits.check(joke); err != nil {
+        return err.Error(), nil
+    }
+
+    created, err := federation.ImportFederated(withActor(ctx, "git:"+joke.Author), FederatedJoke{
+        ExternalID: id,
+        Source:     corpusSource(),
+        Author:     joke.Author,
+        Text:       joke.Text,
+        Language:   joke.Language,
+    })
+    if err != nil {
+        return "", err
+    }
+    if !created {
+        return "the joke already exists", nil
+    }
+    return "", nil
+}
+
+// pullCorpus clones the corpus, or updates the clone to the tip of the
+// branch, and returns the commit it's at.
+func pullCorpus(ctx context.Context) (string, error) {
+    if _, err := os.Stat(filepath.Join(corpusConfig.dir, ".git")); errors.Is(err, os.ErrNotExist) {
+        if err := os.MkdirAll(filepath.Dir(corpusConfig.dir), 0o755); err != nil {
+            return "", err
+        }
+        if _, err := gitIn(ctx, "", "clone", "--quiet", "--single-branch", "--branch", corpusConfig.branch, corpusConfig.url, corpusConfig.dir); err != nil {
+            return "", err
+        }
+    } else {
+        if _, err := git(ctx, "fetch", "--quiet", "origin", corpusConfig.branch); err != nil {
+            return "", err
+        }
+        if _, err := git(ctx, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+            return "", err
+        }
+    }
+    return git(ctx, "rev-parse", "HEAD")
+}
+
+// corpusFiles lists the .txt files under CORPUS_GIT_PATH at the checked out
+// commit, by external id.
+func corpusFiles(ctx context.Context) (map[string]corpusFile, error) {
+    args := []string{"ls-tree", "-r", "-z", "HEAD"}
+    if corpusConfig.path != "" {
+        args = append(args, "--", corpusConfig.path)
+    }
+    out, err := git(ctx, args...)
+    if err != nil {
+        return nil, err
+    }
+
+    files := map[string]corpusFile{}
+    for _, line := range strings.Split(out, "\x00") {
+        // <mode> SP <type> SP <object> TAB <file>
+        meta, name, ok := strings.Cut(line, "\t")
+        fields := strings.Fields(meta)
+        if !ok || len(fields) != 3 || fields[1] != "blob" || path.Ext(name) != ".txt" {
+            continue
+        }
+        file := corpusFile{path: name, blob: fields[2]}
+        files[file.externalID()] = file
+    }
+    return files, nil
+}
+
+// git runs a git command in the clone of the corpus and returns its output,
+// trimmed.
+func git(ctx context.Context, args ...string) (string, error) {
+    return gitIn(ctx, corpusConfig.dir, args...)
+}
+
+func gitIn(ctx context.Context, dir string, args ...string) (string, error) {
+    cmd := exec.CommandContext(ctx, "git", args...)
+    cmd.Dir = dir
+    // Never wait for credentials on a terminal nobody is watching.
+    cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &stdout, &stderr
+    if err := cmd.Run(); err != nil {
+        return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+    }
+    return strings.TrimSpace(stdout.String()), nil
+}
+
+// syncCorpusNow syncs the corpus right away, e.g. from a push webhook of the
+// git host, and reports what changed.
+func syncCorpusNow(response http.ResponseWriter, request *http.Request) {
+    if corpusConfig.url == "" {
+        writeError(response, http.StatusNotFound, "the joke corpus isn't configured")
+        return
+    }
+
+    result, err := syncCorpus(request.Context(), true)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    writeJSON(response, request, http.StatusOK, result)
+}
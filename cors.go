@@ -0,0 +1,98 @@
+package main
+
+import (
+    "net/http"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// corsExposedHeaders are the response headers browsers let scripts read, on
+// top of the CORS safelisted ones.
+var corsExposedHeaders = []string{
+    "X-Request-Id", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+    "Retry-After", "X-Cache", "Age", "ETag", "Deprecation", "Sunset", "Link",
+    "X-Signature", "X-Signature-Key-Id",
+}
+
+// corsPolicy decides which browser origins may call the API.
+type corsPolicy struct {
+    origins []string
+    methods []string
+    headers []string
+    maxAge  time.Duration
+}
+
+// loadCORS reads CORS_ORIGINS, a comma separated list of origins such as
+// "https://example.com" or "*" for any, and the optional CORS_METHODS,
+// CORS_HEADERS and CORS_MAX_AGE. It returns nil when CORS_ORIGINS is unset,
+// which leaves cross-origin browser requests blocked.
+func loadCORS() (*corsPolicy, error) {
+    origins := splitList(os.Getenv("CORS_ORIGINS"))
+    if len(origins) == 0 {
+        return nil, nil
+    }
+
+    maxAge, err := getEnvDuration("CORS_MAX_AGE", 10*time.Minute)
+    if err != nil {
+        return nil, err
+    }
+    return &corsPolicy{
+        origins: origins,
+        methods: splitList(getEnv("CORS_METHODS", "GET,POST,PUT,DELETE")),
+        headers: splitList(getEnv("CORS_HEADERS", "Content-Type,Authorization,X-API-Key,X-Pow-Challenge,X-Pow-Solution,X-Request-Timeout")),
+        maxAge:  maxAge,
+    }, nil
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+    return slices.Contains(p.origins, "*") || slices.Contains(p.origins, origin)
+}
+
+// cors adds CORS headers for allowed origins and answers preflight requests
+// itself. It wraps the router rather than being router middleware because
+// preflights are OPTIONS requests, which no route accepts. A nil policy
+// passes requests straight through.
+func (p *corsPolicy) cors(next http.Handler) http.Handler {
+    if p == nil {
+        return next
+    }
+
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        origin := request.Header.Get("Origin")
+        preflight := request.Method == http.MethodOptions && request.Header.Get("Access-Control-Request-Method") != ""
+
+        header := response.Header()
+        header.Add("Vary", "Origin")
+        if origin == "" {
+            next.ServeHTTP(response, request)
+            return
+        }
+        if !p.allowed(origin) {
+            if preflight {
+                writeError(response, http.StatusForbidden, "origin not allowed")
+                return
+            }
+            // Without the CORS headers the browser won't let the page read
+            // the response.
+            next.ServeHTTP(response, request)
+            return
+        }
+
+        header.Set("Access-Control-Allow-Origin", origin)
+        if !preflight {
+            header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+            next.ServeHTTP(response, request)
+            return
+        }
+
+        header.Add("Vary", "Access-Control-Request-Method")
+        header.Add("Vary", "Access-Control-Request-Headers")
+        header.Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+        header.Set("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+        header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+        response.WriteHeader(http.StatusNoContent)
+    })
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestCORS sends simple and preflight requests from allowed and denied
+// origins through the CORS handler.
+func TestCORS(t *testing.T) {
+    policy := &corsPolicy{
+        origins: []string{"https://allowed.example"},
+        methods: []string{"GET", "POST"},
+        headers: []string{"Content-Type", "X-API-Key"},
+        maxAge:  10 * time.Minute,
+    }
+    next := http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        response.WriteHeader(http.StatusTeapot)
+    })
+    handler := policy.cors(next)
+
+    tests := []struct {
+        name      string
+        method    string
+        origin    string
+        preflight bool
+        status    int
+        // allowOrigin is the expected Access-Control-Allow-Origin, "" for
+        // none.
+        allowOrigin string
+        headers     map[string]string
+    }{
+        {
+            name:        "no origin",
+            method:      http.MethodGet,
+            status:      http.StatusTeapot,
+        },
+        {
+            name:        "allowed origin",
+            method:      http.MethodGet,
+            origin:      "https://allowed.example",
+            status:      http.StatusTeapot,
+            allowOrigin: "https://allowed.example",
+            headers: map[string]string{
+                "Access-Control-Expose-Headers": strings.Join(corsExposedHeaders, ", "),
+            },
+        },
+        {
+            name:        "denied origin",
+            method:      http.MethodGet,
+            origin:      "https://denied.example",
+            status:      http.StatusTeapot,
+        },
+        {
+            name:        "allowed preflight",
+            method:      http.MethodOptions,
+            origin:      "https://allowed.example",
+            preflight:   true,
+            status:      http.StatusNoContent,
+            allowOrigin: "https://allowed.example",
+            headers: map[string]string{
+                "Access-Control-Allow-Methods": "GET, POST",
+                "Access-Control-Allow-Headers": "Content-Type, X-API-Key",
+                "Access-Control-Max-Age":       "600",
+            },
+        },
+        {
+            name:        "denied preflight",
+            method:      http.MethodOptions,
+            origin:      "https://denied.example",
+            preflight:   true,
+            status:      http.StatusForbidden,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            request := httptest.NewRequest(test.method, "/random", nil)
+            if test.origin != "" {
+                request.Header.Set("Origin", test.origin)
+            }
+            if test.preflight {
+                request.Header.Set("Access-Control-Request-Method", "POST")
+            }
+            recorder := httptest.NewRecorder()
+            handler.ServeHTTP(recorder, request)
+
+            if recorder.Code != test.status {
+                t.Errorf("status = %d, want %d", recorder.Code, test.status)
+            }
+            header := recorder.Header()
+            if got := header.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+                t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, test.allowOrigin)
+            }
+            if !slices.Contains(header.Values("Vary"), "Origin") {
+                t.Errorf("Vary = %q, want it to include Origin", header.Values("Vary"))
+            }
+            for name, want := range test.headers {
+                if got := header.Get(name); got != want {
+                    t.Errorf("%s = %q, want %q", name, got, want)
+                }
+            }
+        })
+    }
+}
+
+// TestCORSWildcard checks that "*" allows any origin, echoing it back.
+func TestCORSWildcard(t *testing.T) {
+    policy := &corsPolicy{origins: []string{"*"}}
+    handler := policy.cors(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+    request := httptest.NewRequest(http.MethodGet, "/random", nil)
+    request.Header.Set("Origin", "https://anywhere.example")
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != "https://anywhere.example" {
+        t.Errorf("Access-Control-Allow-Origin = %q, want the request's origin", got)
+    }
+}
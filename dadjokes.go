@@ -1,73 +1,651 @@
 package main
 
 import (
-    "database/sql"
+    "context"
     "encoding/json"
-    "log"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
     "net/http"
     "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
 
     "github.com/gorilla/mux"
-    "github.com/joho/godotenv"
-    _ "github.com/go-sql-driver/mysql"
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type Joke struct {
-    Id     int    `json:"id"`
-    Date   string `json:"entry_date"`
-    Author string `json:"author"`
-    Text   string `json:"joke_text"`
+    Id     int       `json:"id"`
+    Date   time.Time `json:"entry_date"`
+    Author string    `json:"author"`
+    Text   string    `json:"joke_text"`
+
+    // Language is a BCP 47 tag, defaultLanguage unless the joke was
+    // submitted with another.
+    Language string `json:"lang"`
+
+    // OriginalDate backdates a joke to when it was first told, for imports
+    // from other collections. It's only read when creating a joke.
+    OriginalDate *time.Time `json:"original_date,omitempty"`
+
+    // Translation marks a joke served translated with ?translate=. It's
+    // never read from requests.
+    Translation *translationNote `json:"translation,omitempty"`
 }
 
-var db *sql.DB
+var repo JokeRepository
 
 func main() {
-    err := godotenv.Load()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    if err := newCLI().ExecuteContext(ctx); err != nil {
+        os.Exit(1)
+    }
+}
+
+// newHandler builds the HTTP API: the router with its middleware, wrapped in
+// CORS handling, request logging and tracing.
+func newHandler(ctx context.Context, store storage) http.Handler {
+    router := mux.NewRouter()
+    router.NotFoundHandler = http.HandlerFunc(routeNotFound)
+    router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+    router.Use(nameSpanByRoute)
+    router.Use(auditActors)
+
+    var (
+        limitPolicy rateLimitPolicy
+        limitStore  RateLimitStore
+    )
+    if spec := os.Getenv("RATE_LIMITS"); spec != "" {
+        var err error
+        limitPolicy, err = parseRateLimits(spec)
+        if err != nil {
+            fatal("Error reading config", "error", err)
+        }
+        if getEnv("RATE_LIMIT_BY", "ip") == "api_key" {
+            limitPolicy.apiKeys, err = loadRateLimitKeys()
+            if err != nil {
+                fatal("Error reading config", "error", err)
+            }
+        }
+
+        limitStore, err = newRateLimitStore(ctx)
+        if err != nil {
+            fatal("Error setting up rate limiting", "error", err)
+        }
+        router.Use(rateLimiter(limitPolicy, limitStore))
+    }
+
+    cacheTTL, err := getEnvDuration("CACHE_TTL", 10*time.Second)
     if err != nil {
-        log.Fatalf("Error loading .env file")
+        fatal("Error reading config", "error", err)
+    }
+    cacheStale, err := getEnvDuration("CACHE_STALE", time.Minute)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    if cacheTTL > 0 {
+        responses = newResponseCache(cacheTTL, cacheStale)
     }
 
-    db, err = sql.Open("mysql", os.Getenv("DB_CONN_STRING"))
+    randomBudget, err = getEnvDuration("RANDOM_BUDGET", randomBudget)
     if err != nil {
-        log.Fatalf("Error opening database: %v", err)
+        fatal("Error reading config", "error", err)
     }
-    defer db.Close()
+    go fillRandomPool(ctx)
 
-    router := mux.NewRouter()
+    // Building the similarity index reads every joke, so it's done in the
+    // background rather than on the first submission.
+    minSimilarity, err := getEnvFloat("DUPLICATE_SIMILARITY", qualitySimilarity)
+    if err != nil || minSimilarity < 0 || minSimilarity > 1 {
+        fatal("Error reading config", "error", "DUPLICATE_SIMILARITY must be between 0 and 1")
+    }
+    if minSimilarity > 0 {
+        nearDuplicates = newLiveSimilarityIndex(minSimilarity)
+        go func() {
+            if err := nearDuplicates.catchUp(ctx); err != nil {
+                slog.Error("Error indexing jokes for duplicate detection", "error", err)
+            }
+        }()
+    }
+
+    if err := loadContentFilters(); err != nil {
+        fatal("Error reading config", "error", err)
+    }
+
+    reportThreshold, err = getEnvInt("REPORT_THRESHOLD", reportThreshold)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    escalateAfter, err = getEnvDuration("MODERATION_ESCALATE_AFTER", escalateAfter)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    queueBacklog, err = getEnvInt("MODERATION_QUEUE_ALERT", queueBacklog)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    jokeRetention, err = getEnvDuration("JOKE_RETENTION", jokeRetention)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    bestOfSize, err = getEnvInt("BEST_OF_SIZE", bestOfSize)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    if err := loadCorpus(); err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    translator, err = loadTranslator()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    cardImage, err = loadCardImage()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+
+    if dir := os.Getenv("MEDIA_CACHE_DIR"); dir != "" {
+        mediaCache = diskMediaStore{dir: dir}
+    }
+
+    sunset, err := legacySunset()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+
+    registerRoutes(router, store, limitPolicy, limitStore, sunset)
+    checkAPIDocs(router)
+
+    crossOrigin, err := loadCORS()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    err = loadWebSockets(crossOrigin)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    err = loadLongPoll()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    err = loadSubscriptions()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    // After the mailer, which the email channel sends with.
+    err = loadNotifiers()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+
+    err = loadCompatProfiles()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    err = loadBodyLimits()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    // Reshaping goes inside signing and compression, so both see the body
+    // the client gets.
+    var handler http.Handler = compat(requestDeadlines(router))
+    powChallenges, err = loadProofOfWork()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    responseSigner, err = loadSigner()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    if responseSigner != nil {
+        handler = responseSigner.signResponses(handler)
+    }
+    // Compression goes outside signing, so signatures cover the body as the
+    // client sees it once decoded.
+    compression, err := loadCompressor()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    handler = compression.compress(handler)
+    return otelhttp.NewHandler(logRequests(crossOrigin.cors(handler)), "http.request")
+}
+
+// registerRoutes adds every route of the service to router: the unversioned
+// ones at the root and the API under /v1 and its legacy aliases.
+func registerRoutes(router *mux.Router, store storage, limitPolicy rateLimitPolicy, limitStore RateLimitStore, sunset time.Time) {
+    // Unversioned routes describe the deployment rather than the API, so
+    // they stay at the root.
+    router.HandleFunc("/.well-known/dadjokes.json", getInstance).Methods("GET")
+    router.HandleFunc("/.well-known/dadjokes-signing-key.json", getSigningKey).Methods("GET")
+    router.HandleFunc("/healthz", getHealth).Methods("GET")
+    router.HandleFunc("/readyz", newReadyHandler(store.readyChecks())).Methods("GET")
+    router.HandleFunc("/debug/vars", requireAdmin(getMetrics)).Methods("GET")
+    router.HandleFunc("/openapi.json", newOpenAPIHandler(router)).Methods("GET")
+    router.HandleFunc("/deprecations", newDeprecationsHandler(router, sunset)).Methods("GET")
+    router.HandleFunc("/rate-limits", newRateLimitsHandler(router, limitPolicy, limitStore)).Methods("GET")
+    router.HandleFunc("/j/{slug:[0-9A-Za-z]+}", followShareLink).Methods("GET")
+    router.Handle("/docs", http.RedirectHandler("docs/", http.StatusMovedPermanently)).Methods("GET")
+    router.PathPrefix("/docs/").Handler(docsHandler()).Methods("GET")
+
+    // SQL dumps are written in the dialect of the database; other backends
+    // can't export them.
+    var d *dialect
+    if sqlStore, ok := store.(*sqlRepository); ok {
+        d = sqlStore.dialect
+    }
+
+    registerV1(apiMount{router: router, prefix: apiV1, middleware: []mux.MiddlewareFunc{announceDeprecations}}, d)
+    registerV1(apiMount{router: router, middleware: []mux.MiddlewareFunc{deprecated(sunset)}}, d)
+}
+
+// serve runs the HTTP API until ctx is cancelled.
+func serve(ctx context.Context, store storage) {
+    handler := newHandler(ctx, store)
+
+    shutdownTimeout, err := getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    https, err := loadTLS()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+
+    // Pulling from peers, delivering webhooks, escalating flags and emailing
+    // subscribers write to the database, so none of them runs while the schema is incompatible.
+    if peers := federationPeers(); len(peers) > 0 && incompatibleSchema == nil {
+        if federationID() == "" {
+            fatal("Error reading config", "error", "FEDERATION_PEERS requires PUBLIC_URL or FEDERATION_ID")
+        }
+        interval, err := getEnvDuration("FEDERATION_INTERVAL", 5*time.Minute)
+        if err != nil {
+            fatal("Error reading config", "error", err)
+        }
+        go runFederation(ctx, federationID(), peers, interval)
+    }
+
+    jobs, err = loadJobRunner()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    jobs.start()
+    // Digests are only held by long-running servers; elsewhere
+    // notifications are sent right away.
+    notifyDigest, err = loadNotifyDigest()
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    if notifyDigest != nil {
+        go notifyDigest.run(ctx)
+    }
+    shareBuffer = newShareRecorder()
+    go shareBuffer.run()
+
+    if incompatibleSchema == nil {
+        go runWebhooks(ctx)
+        go runModerationQueue(ctx)
+        if jokeRetention > 0 {
+            go runPurge(ctx)
+        }
+        if bestOfSize > 0 {
+            go runBestOf(ctx)
+        }
+        if corpusConfig.url != "" {
+            go runCorpusSync(ctx)
+        }
+        if mailer != nil {
+            go runSubscriptions(ctx)
+        }
+    }
+
+    // The quality report is only visible to admins, so don't spend time on
+    // it without an admin token.
+    if os.Getenv("ADMIN_TOKEN") != "" {
+        interval, err := getEnvDuration("QUALITY_INTERVAL", time.Hour)
+        if err != nil {
+            fatal("Error reading config", "error", err)
+        }
+        go runQualityReports(ctx, interval)
+    }
+
+    server := &http.Server{
+        Addr:    getEnv("LISTEN_ADDR", ":8080"),
+        Handler: handler,
+    }
+    err = configureServer(server)
+    if err != nil {
+        fatal("Error reading config", "error", err)
+    }
+    server.RegisterOnShutdown(closeStreams)
+    go wsClients.run(ctx)
+
+    // ListenAndServe returns as soon as Shutdown is called, so main waits on
+    // drained until the in-flight requests are done.
+    drained := make(chan struct{})
+    go func() {
+        defer close(drained)
+        <-ctx.Done()
+        slog.Info("Shutting down")
 
-    router.HandleFunc("/random", getRandomJoke).Methods("GET")
-    router.HandleFunc("/write", saveJoke).Methods("POST")
+        // Stop accepting connections and give in-flight requests a chance to
+        // finish before the deferred db.Close runs.
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+        defer cancel()
 
-    log.Fatal(http.ListenAndServe(":8080", router))
+        err := server.Shutdown(shutdownCtx)
+        if err != nil {
+            slog.Error("Error shutting down", "error", err)
+        }
+        // Requests can queue jobs and shares until they're done, so those are
+        // only written after them. The held notifications are queued first,
+        // so they're sent before the runner stops.
+        notifyDigest.flush(shutdownCtx)
+        jobs.drain(shutdownCtx)
+        shareBuffer.close()
+    }()
+
+    if addr := os.Getenv("GRPC_ADDR"); addr != "" {
+        go serveGRPC(ctx, addr)
+    }
+
+    if https == nil {
+        err = server.ListenAndServe()
+    } else {
+        if https.redirectAddr != "" {
+            go https.serveRedirects(ctx, server.Addr)
+        }
+        err = https.listen(server)
+    }
+    if err != nil && !errors.Is(err, http.ErrServerClosed) {
+        fatal("Error starting server", "error", err)
+    }
+    <-drained
+}
+
+// serveGRPC runs the gRPC service on addr until ctx is cancelled.
+func serveGRPC(ctx context.Context, addr string) {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        fatal("Error starting gRPC server", "error", err)
+    }
+
+    server := newGRPCServer()
+    go func() {
+        <-ctx.Done()
+        server.GracefulStop()
+    }()
+
+    err = server.Serve(listener)
+    if err != nil {
+        fatal("Error starting gRPC server", "error", err)
+    }
 }
 
 func getRandomJoke(response http.ResponseWriter, request *http.Request) {
-    var joke Joke
-    err := db.QueryRow("SELECT id, entry_date, author, joke_text FROM jokes ORDER BY RAND() LIMIT 1").Scan(&joke.Id, &joke.Date, &joke.Author, &joke.Text)
+    lang, err := requestLanguage(request)
+    if errors.Is(err, errBadLanguage) {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    response.Header().Add("Vary", "Accept-Language")
+
+    joke, err := randomJoke(request.Context(), lang)
+    if errors.Is(err, errJokeNotFound) && lang != "" {
+        writeError(response, http.StatusNotFound, "no jokes in "+lang)
+        return
+    }
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJoke(response, request, joke)
+}
+
+func getJoke(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+
+    joke, err := repo.Get(request.Context(), id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    if lang := request.URL.Query().Get("translate"); lang != "" {
+        target, ok := canonicalLanguage(lang)
+        if !ok {
+            writeError(response, http.StatusBadRequest, "translate must be a BCP 47 language tag, like es or pt-BR")
+            return
+        }
+        if translator == nil {
+            writeError(response, http.StatusNotImplemented, "translation isn't available on this deployment")
+            return
+        }
+        // A joke asked for in its own language is served as it is.
+        if target != joke.Language {
+            joke, err = translateJoke(request.Context(), joke, target)
+            if err != nil {
+                slog.ErrorContext(request.Context(), "Error translating a joke", "joke", id, "lang", target, "error", err)
+                writeError(response, http.StatusBadGateway, "the joke couldn't be translated, try again later")
+                return
+            }
+        }
+    }
+
+    // Jokes aren't edited, apart from author merges renaming them; the ETag
+    // catches those.
+    response.Header().Set("Last-Modified", joke.Date.Format(http.TimeFormat))
+    writeJoke(response, request, joke)
+}
+
+// writeJoke encodes a single joke in the format requested by the client:
+// ?format=slack for a Slack Block Kit payload, ?format=ssml for speech,
+// otherwise whatever the Accept header asks for.
+func writeJoke(response http.ResponseWriter, request *http.Request, joke Joke) {
+    response.Header().Set("Content-Language", joke.Language)
+    switch request.URL.Query().Get("format") {
+    case "slack":
+        response.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(response).Encode(newSlackMessage(joke, request))
+        return
+    case "ssml":
+        writeJokeSSML(response, joke)
+        return
+    }
+    respond(response, request, http.StatusOK, joke)
+}
+
+// envelope wraps API responses when INSTANCE_IN_RESPONSES is enabled.
+type envelope struct {
+    Data     any      `json:"data"`
+    Instance Instance `json:"instance"`
+}
+
+// writeJSON writes value as a JSON response with the given status. Jokes are
+// trimmed to the ?fields= sparse fieldset, and the response is wrapped in an
+// envelope with the instance metadata when that's enabled.
+func writeJSON(response http.ResponseWriter, request *http.Request, status int, value any) {
+    value, err := shapeResponse(request, value)
     if err != nil {
-        http.Error(response, err.Error(), http.StatusInternalServerError)
+        writeError(response, http.StatusBadRequest, err.Error())
         return
     }
 
     response.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(response).Encode(joke)
+    response.WriteHeader(status)
+    json.NewEncoder(response).Encode(value)
+}
+
+// shapeResponse applies the ?fields= sparse fieldset and the instance
+// envelope to value.
+func shapeResponse(request *http.Request, value any) (any, error) {
+    fields, err := requestedFields(request)
+    if err != nil {
+        return nil, err
+    }
+    if fields != nil {
+        value = selectFields(value, fields)
+    }
+
+    if instanceInResponses() {
+        value = envelope{Data: value, Instance: instanceInfo(request)}
+    }
+    return value, nil
+}
+
+// baseURL returns the public URL of the service root; apiURL adds the version
+// prefix for links to API resources. PUBLIC_URL takes precedence because behind a reverse proxy the
+// request's host and path don't match what clients see.
+func baseURL(request *http.Request) string {
+    if url := os.Getenv("PUBLIC_URL"); url != "" {
+        return strings.TrimRight(url, "/")
+    }
+
+    scheme := "http"
+    if request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https" {
+        scheme = "https"
+    }
+    return scheme + "://" + request.Host
 }
 
+// saveJoke handles submissions. Admins can store a joke that's only similar
+// to an existing one with ?force=true.
 func saveJoke(response http.ResponseWriter, request *http.Request) {
+    force := request.URL.Query().Get("force") == "true"
+    if force && !isAdmin(request) {
+        writeError(response, http.StatusForbidden, "force=true requires the admin token")
+        return
+    }
+    if !checkProofOfWork(response, request) {
+        return
+    }
+
     var joke Joke
-    err := json.NewDecoder(request.Body).Decode(&joke)
-    if err != nil {
-        http.Error(response, err.Error(), http.StatusBadRequest)
+    if !decodeRequest(response, request, &joke) {
         return
     }
 
-    _, err = db.Exec("INSERT INTO jokes (author, joke_text) VALUES (?, ?)", joke.Author, joke.Text)
+    err := submitJoke(request.Context(), &joke, force)
+    var invalid *invalidJokeError
+    if errors.As(err, &invalid) {
+        writeValidationError(response, "invalid_joke", invalid.err)
+        return
+    }
+    var rejected *contentRejectedError
+    if errors.As(err, &rejected) {
+        writeAPIError(response, http.StatusBadRequest, apiError{Code: "content_rejected", Message: err.Error()})
+        return
+    }
+    var duplicate *duplicateJokeError
+    if errors.As(err, &duplicate) {
+        details := duplicateDetails{Similarity: duplicate.Similarity}
+        if duplicate.Existing.Id != 0 {
+            details.Existing = &duplicate.Existing
+        }
+        writeAPIError(response, http.StatusConflict, apiError{
+            Code:    "duplicate_joke",
+            Message: err.Error(),
+            Details: details,
+        })
+        return
+    }
     if err != nil {
-        http.Error(response, err.Error(), http.StatusInternalServerError)
+        writeInternalError(response, request, err)
         return
     }
 
-    response.WriteHeader(http.StatusCreated)
-    response.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(response).Encode(joke)
+    writeJSON(response, request, http.StatusCreated, joke)
+}
+
+// invalidJokeError is returned by submitJoke for jokes that break the
+// submission limits. It wraps the validationErrors.
+type invalidJokeError struct {
+    err error
+}
+
+func (e *invalidJokeError) Error() string {
+    return e.err.Error()
+}
+
+// duplicateDetails are the details of a duplicate_joke error. Existing is
+// left out when the joke is hidden or deleted.
+type duplicateDetails struct {
+    Existing   *Joke   `json:"existing,omitempty"`
+    Similarity float64 `json:"similarity,omitempty"`
+}
+
+// submitJoke stores a joke submitted through the API, whichever transport it
+// came in on. It returns an *invalidJokeError when the joke breaks the
+// limits, a *contentRejectedError when a content filter objects to it and
+// a *duplicateJokeError when it already exists or, unless force is set, a
+// very similar one does. With CONTENT_FILTER_ACTION=flag, jokes a filter
+// objects to are stored and flagged for moderation instead.
+func submitJoke(ctx context.Context, joke *Joke, force bool) error {
+    // Only imports may backdate jokes.
+    joke.OriginalDate = nil
+
+    normalizeJoke(joke)
+    if err := limits.check(*joke); err != nil {
+        return &invalidJokeError{err}
+    }
+
+    reason, source, err := filterContent(ctx, *joke)
+    if err != nil {
+        return err
+    }
+    if reason != "" && contentAction == contentReject {
+        return &contentRejectedError{reason}
+    }
+
+    // Exact duplicates are refused by the repository, even when forced.
+    if nearDuplicates != nil && !force {
+        if err := nearDuplicates.check(ctx, joke.Text); err != nil {
+            return err
+        }
+    }
+
+    if err := repo.Create(ctx, joke); err != nil {
+        return err
+    }
+    if reason != "" {
+        // The joke is stored either way; a lost flag only means a moderator
+        // won't be pointed at it.
+        if err := moderation.FlagJoke(ctx, joke.Id, "content_filter:"+source, reason); err != nil {
+            slog.ErrorContext(ctx, "Error flagging joke", "joke_id", joke.Id, "error", err)
+        } else {
+            notify(ctx, notification{
+                Event:   eventJokeFlagged,
+                Title:   "Joke flagged for review",
+                Message: fmt.Sprintf("Joke %s was flagged by the content filter: %s.", jokeRef(joke.Id), reason),
+                JokeId:  jokeRef(joke.Id),
+                Joke:    joke.Text,
+            })
+        }
+    }
+    responses.invalidate()
+    newJokes.notify()
+    notifyJokeCreated(ctx, *joke)
+    return nil
 }
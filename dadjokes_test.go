@@ -0,0 +1,127 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// TestSaveJokeConcurrentDuplicates submits the same joke many times at once.
+// Exactly one submission is stored and the others are turned away as
+// duplicates of it.
+func TestSaveJokeConcurrentDuplicates(t *testing.T) {
+    server := newTestServer(t)
+    const submissions = 10
+    body := `{"joke_text": "I only know 25 letters of the alphabet. I don't know y.", "author": "Tester"}`
+
+    statuses := make([]int, submissions)
+    var wg sync.WaitGroup
+    for i := range submissions {
+        wg.Go(func() {
+            statuses[i], _ = testRequest(t, server, "POST", "/v1/write", body, false)
+        })
+    }
+    wg.Wait()
+
+    created, conflicts := 0, 0
+    for _, status := range statuses {
+        switch status {
+        case http.StatusCreated:
+            created++
+        case http.StatusConflict:
+            conflicts++
+        default:
+            t.Errorf("got status %d, want 201 or 409", status)
+        }
+    }
+    if created != 1 || conflicts != submissions-1 {
+        t.Errorf("got %d created and %d conflicts, want 1 and %d", created, conflicts, submissions-1)
+    }
+}
+
+// TestSaveJokeDuplicateOfDeletedJoke resubmits a deleted joke. It's still
+// refused, but the error doesn't give the deleted joke away.
+func TestSaveJokeDuplicateOfDeletedJoke(t *testing.T) {
+    server := newTestServer(t)
+    body := `{"joke_text": "What do you call a fake noodle? An impasta.", "author": "Tester"}`
+
+    status, data := testRequest(t, server, "POST", "/v1/write", body, false)
+    if status != http.StatusCreated {
+        t.Fatalf("got status %d creating the joke: %s", status, data)
+    }
+    var joke struct {
+        Id jokeRef `json:"id"`
+    }
+    if err := json.Unmarshal(data, &joke); err != nil {
+        t.Fatal(err)
+    }
+
+    status, data = testRequest(t, server, "POST", "/v1/write", body, false)
+    if status != http.StatusConflict || !strings.Contains(string(data), `"existing"`) {
+        t.Fatalf("got status %d resubmitting a visible joke, want 409 with the existing joke: %s", status, data)
+    }
+
+    if status, data := testRequest(t, server, "DELETE", "/v1/admin/jokes/"+joke.Id.String(), "", true); status != http.StatusNoContent {
+        t.Fatalf("got status %d deleting the joke: %s", status, data)
+    }
+    status, data = testRequest(t, server, "POST", "/v1/write", body, false)
+    if status != http.StatusConflict {
+        t.Fatalf("got status %d resubmitting a deleted joke, want 409: %s", status, data)
+    }
+    if strings.Contains(string(data), "impasta") || strings.Contains(string(data), `"existing"`) {
+        t.Errorf("the conflict gives the deleted joke away: %s", data)
+    }
+}
+
+// TestSaveJokeNearDuplicateOfRemovedJoke submits a retelling of a hidden or
+// deleted joke, which the near-duplicate check passes over.
+func TestSaveJokeNearDuplicateOfRemovedJoke(t *testing.T) {
+    removals := []struct {
+        name   string
+        remove func(t *testing.T, server *httptest.Server, id jokeRef)
+    }{
+        {"hidden", func(t *testing.T, server *httptest.Server, id jokeRef) {
+            if err := moderation.HideJoke(context.Background(), int(id)); err != nil {
+                t.Fatal(err)
+            }
+            jokeHidden(int(id))
+        }},
+        {"deleted", func(t *testing.T, server *httptest.Server, id jokeRef) {
+            if status, data := testRequest(t, server, "DELETE", "/v1/admin/jokes/"+id.String(), "", true); status != http.StatusNoContent {
+                t.Fatalf("got status %d deleting the joke: %s", status, data)
+            }
+        }},
+    }
+    for _, removal := range removals {
+        t.Run(removal.name, func(t *testing.T) {
+            server := newTestServer(t)
+
+            status, data := testRequest(t, server, "POST", "/v1/write", `{"joke_text": "Why did the scarecrow win an award? Because he was outstanding in his field.", "author": "Tester"}`, false)
+            if status != http.StatusCreated {
+                t.Fatalf("got status %d creating the joke: %s", status, data)
+            }
+            var joke struct {
+                Id jokeRef `json:"id"`
+            }
+            if err := json.Unmarshal(data, &joke); err != nil {
+                t.Fatal(err)
+            }
+
+            retelling := `{"joke_text": "Why did the scarecrow win an award? Because he was outstanding in the field.", "author": "Tester"}`
+            status, data = testRequest(t, server, "POST", "/v1/write", retelling, false)
+            if status != http.StatusConflict || !strings.Contains(string(data), `"similarity"`) {
+                t.Fatalf("got status %d submitting a retelling of a visible joke, want 409 with the similarity: %s", status, data)
+            }
+
+            removal.remove(t, server, joke.Id)
+            status, data = testRequest(t, server, "POST", "/v1/write", retelling, false)
+            if status != http.StatusCreated {
+                t.Errorf("got status %d submitting a retelling of a %s joke, want 201: %s", status, removal.name, data)
+            }
+        })
+    }
+}
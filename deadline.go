@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// grpcTimeoutUnits are the units of a gRPC style timeout header.
+var grpcTimeoutUnits = map[byte]time.Duration{
+    'H': time.Hour,
+    'M': time.Minute,
+    'S': time.Second,
+    'm': time.Millisecond,
+    'u': time.Microsecond,
+    'n': time.Nanosecond,
+}
+
+// parseRequestTimeout reads the time a client is willing to wait from
+// X-Request-Timeout, a Go duration like "250ms" or a number of seconds, or
+// from Grpc-Timeout, e.g. "250m". ok is false when neither header is set.
+func parseRequestTimeout(header http.Header) (timeout time.Duration, ok bool, err error) {
+    if value := header.Get("X-Request-Timeout"); value != "" {
+        timeout, err = time.ParseDuration(value)
+        if err != nil {
+            seconds, ferr := strconv.ParseFloat(value, 64)
+            if ferr != nil {
+                return 0, true, errors.New("X-Request-Timeout must be a duration like 500ms or a number of seconds")
+            }
+            timeout = time.Duration(seconds * float64(time.Second))
+        }
+    } else if value := header.Get("Grpc-Timeout"); value != "" {
+        unit, known := grpcTimeoutUnits[value[len(value)-1]]
+        amount, aerr := strconv.ParseInt(value[:len(value)-1], 10, 64)
+        if !known || aerr != nil || len(value) > 9 {
+            return 0, true, errors.New("Grpc-Timeout must be up to 8 digits and a unit, H, M, S, m, u or n")
+        }
+        timeout = time.Duration(amount) * unit
+    } else {
+        return 0, false, nil
+    }
+
+    if timeout <= 0 {
+        return 0, true, errors.New("the request timeout must be positive")
+    }
+    return timeout, true, nil
+}
+
+// requestDeadlines gives requests with a timeout header a context deadline,
+// so the database queries and outgoing calls they make give up once the
+// client has.
+func requestDeadlines(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        timeout, ok, err := parseRequestTimeout(request.Header)
+        if err != nil {
+            writeError(response, http.StatusBadRequest, err.Error())
+            return
+        }
+        if !ok {
+            next.ServeHTTP(response, request)
+            return
+        }
+
+        ctx, cancel := context.WithTimeout(request.Context(), timeout)
+        defer cancel()
+        next.ServeHTTP(response, request.WithContext(ctx))
+    })
+}
+
+// forwardDeadline passes what is left of the deadline of ctx on to an
+// outgoing request, in X-Request-Timeout, for services that honor it.
+func forwardDeadline(ctx context.Context, header http.Header) {
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        return
+    }
+    remaining := max(time.Until(deadline).Milliseconds(), 1)
+    header.Set("X-Request-Timeout", strconv.FormatInt(remaining, 10)+"ms")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// apiDeprecation is set on the apiOperations of /v1 routes whose behaviour
+// is going to change or go away. It drives the headers sent on the route
+// and its entry in /deprecations, so SDKs can warn their users.
+type apiDeprecation struct {
+    // since is when the change was announced.
+    since time.Time
+    // sunset is when the old behaviour goes away, or zero if that isn't
+    // scheduled yet.
+    sunset time.Time
+    // change says what is changing, for people.
+    change string
+    // successor is the path, under the current version, to use instead, if
+    // there is one.
+    successor string
+}
+
+// Deprecation is an entry in /deprecations.
+type Deprecation struct {
+    Method       string     `json:"method"`
+    Path         string     `json:"path"`
+    Change       string     `json:"change"`
+    DeprecatedAt time.Time  `json:"deprecated_at"`
+    SunsetAt     *time.Time `json:"sunset_at,omitempty"`
+    Successor    string     `json:"successor,omitempty"`
+}
+
+type deprecationList struct {
+    Deprecations []Deprecation `json:"deprecations"`
+}
+
+// setDeprecationHeaders sends the Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers for d, and links to the successor, if any, and to
+// /deprecations.
+func setDeprecationHeaders(response http.ResponseWriter, request *http.Request, d apiDeprecation) {
+    header := response.Header()
+    header.Set("Deprecation", fmt.Sprintf("@%d", d.since.Unix()))
+    if !d.sunset.IsZero() {
+        header.Set("Sunset", d.sunset.Format(http.TimeFormat))
+    }
+    if d.successor != "" {
+        header.Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, apiURL(request), d.successor))
+    }
+    header.Add("Link", fmt.Sprintf(`<%s/deprecations>; rel="deprecation"`, baseURL(request)))
+}
+
+// announceDeprecations sends the deprecation headers on /v1 routes whose
+// apiOperation has a deprecation.
+func announceDeprecations(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        path := routeKey(routeTemplate(request))
+        if d := apiOperations[request.Method+" "+path].deprecation; d != nil {
+            setDeprecationHeaders(response, request, *d)
+        }
+        next.ServeHTTP(response, request)
+    })
+}
+
+// listDeprecations returns the deprecated routes of router: the legacy
+// unprefixed aliases, which go away at sunset, and the /v1 routes with a
+// deprecation in apiOperations. They're sorted by sunset, soonest first,
+// then by path.
+func listDeprecations(router *mux.Router, request *http.Request, sunset time.Time) []Deprecation {
+    var list []Deprecation
+    add := func(method, path string, d apiDeprecation) {
+        entry := Deprecation{Method: method, Path: path, Change: d.change, DeprecatedAt: d.since}
+        if !d.sunset.IsZero() {
+            entry.SunsetAt = &d.sunset
+        }
+        if d.successor != "" {
+            entry.Successor = apiURL(request) + d.successor
+        }
+        list = append(list, entry)
+    }
+
+    for _, route := range listRoutes(router) {
+        if route.legacy {
+            add(route.method, route.path, apiDeprecation{
+                since:     legacyDeprecated,
+                sunset:    sunset,
+                change:    "unversioned alias, use the " + apiV1 + " route",
+                successor: route.path,
+            })
+            continue
+        }
+        if d := apiOperations[route.method+" "+unversioned(route.path)].deprecation; d != nil {
+            add(route.method, route.path, *d)
+        }
+    }
+
+    sort.SliceStable(list, func(i, j int) bool {
+        a, b := list[i], list[j]
+        if (a.SunsetAt == nil) != (b.SunsetAt == nil) {
+            return a.SunsetAt != nil
+        }
+        if a.SunsetAt != nil && !a.SunsetAt.Equal(*b.SunsetAt) {
+            return a.SunsetAt.Before(*b.SunsetAt)
+        }
+        if a.Path != b.Path {
+            return a.Path < b.Path
+        }
+        return a.Method < b.Method
+    })
+    return list
+}
+
+// newDeprecationsHandler serves the deprecated routes of router. sunset is
+// when the legacy aliases are removed, or zero.
+func newDeprecationsHandler(router *mux.Router, sunset time.Time) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        list := listDeprecations(router, request, sunset)
+        if list == nil {
+            list = []Deprecation{}
+        }
+        writeJSON(response, request, http.StatusOK, deprecationList{Deprecations: list})
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/go-sql-driver/mysql"
+    _ "github.com/lib/pq"
+    _ "github.com/mattn/go-sqlite3"
+)
+
+// dialect captures the differences between the SQL databases we support, so
+// sqlRepository can issue the same queries against each of them.
+type dialect struct {
+    // name is the database/sql driver name, which is also the DB_DRIVER value.
+    name string
+
+    // numberedPlaceholders is set for databases that use $1, $2, ... rather
+    // than ? as bind parameters.
+    numberedPlaceholders bool
+
+    // backslashEscapes is set for databases that treat a backslash in a
+    // string literal as an escape character.
+    backslashEscapes bool
+
+    // returningID is set for databases without LastInsertId support, where
+    // the new id has to be read back with INSERT ... RETURNING id.
+    returningID bool
+
+    // lockingRead is appended to a SELECT inside a transaction to read the
+    // latest committed rows rather than the transaction's snapshot. MySQL's
+    // default REPEATABLE READ needs it; the others read committed rows anyway.
+    lockingRead string
+}
+
+var dialects = map[string]*dialect{
+    "mysql": {
+        name:             "mysql",
+        backslashEscapes: true,
+        lockingRead:      " LOCK IN SHARE MODE",
+    },
+    "sqlite3": {
+        name: "sqlite3",
+    },
+    "postgres": {
+        name:                 "postgres",
+        numberedPlaceholders: true,
+        returningID:          true,
+    },
+}
+
+// lookupDialect returns the dialect for a DB_DRIVER value.
+func lookupDialect(driver string) (*dialect, error) {
+    d, ok := dialects[driver]
+    if !ok {
+        return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+    }
+    return d, nil
+}
+
+// dsn adjusts a DB_CONN_STRING so dates round-trip as UTC time.Time values.
+// Only MySQL needs this: by default it returns DATETIME columns as strings
+// and interprets them in the server's time zone.
+func (d *dialect) dsn(conn string) (string, error) {
+    if d.name != "mysql" {
+        return conn, nil
+    }
+
+    config, err := mysql.ParseDSN(conn)
+    if err != nil {
+        return "", err
+    }
+    config.ParseTime = true
+    config.Loc = time.UTC
+    if config.Params == nil {
+        config.Params = map[string]string{}
+    }
+    config.Params["time_zone"] = "'+00:00'"
+    return config.FormatDSN(), nil
+}
+
+// quote returns s as a SQL string literal.
+func (d *dialect) quote(s string) string {
+    if d.backslashEscapes {
+        s = strings.ReplaceAll(s, `\`, `\\`)
+    }
+    return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// ignoreDuplicates turns insert into a statement that inserts nothing, rather
+// than fail, when the row would break a unique index. A failed statement
+// aborts the whole transaction on Postgres, so inside one this is how to find
+// out the row is already there.
+func (d *dialect) ignoreDuplicates(insert string) string {
+    if d.name == "mysql" {
+        return strings.Replace(insert, "INSERT", "INSERT IGNORE", 1)
+    }
+    return insert + " ON CONFLICT DO NOTHING"
+}
+
+// rebind rewrites the ? placeholders in query into the dialect's bind
+// parameter style. Queries are written with ? throughout the code base.
+func (d *dialect) rebind(query string) string {
+    if !d.numberedPlaceholders {
+        return query
+    }
+
+    var b strings.Builder
+    n := 0
+    for _, c := range query {
+        if c == '?' {
+            n++
+            fmt.Fprintf(&b, "$%d", n)
+            continue
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+    "cmp"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "slices"
+    "strings"
+    "sync"
+    "unicode"
+)
+
+const (
+    // similarityMinWords skips jokes too short to compare meaningfully.
+    similarityMinWords = 4
+
+    // similarityCommonWord is how many jokes a word may appear in before
+    // it's ignored when looking for similar jokes, so "the" and "a" don't
+    // make every joke a candidate.
+    similarityCommonWord = 50
+)
+
+// duplicateJokeError is returned when a submitted joke already exists,
+// typically because the same form was submitted twice. Existing is the joke
+// that was stored first, or the zero Joke when that one is hidden or
+// deleted, so clients can't read it through a resubmission. Similarity is
+// set when the joke isn't the same but has mostly the same words.
+type duplicateJokeError struct {
+    Existing   Joke
+    Similarity float64
+}
+
+func (e *duplicateJokeError) Error() string {
+    if e.Similarity > 0 {
+        return "a very similar joke already exists"
+    }
+    return "joke already exists"
+}
+
+// normalizeJokeText reduces text to lower case letters and digits separated
+// by single spaces, so jokes differing only in case, punctuation or spacing
+// compare equal.
+func normalizeJokeText(text string) string {
+    var b strings.Builder
+    space := false
+    for _, c := range strings.ToLower(text) {
+        switch {
+        case unicode.IsLetter(c) || unicode.IsNumber(c):
+            if space && b.Len() > 0 {
+                b.WriteByte(' ')
+            }
+            b.WriteRune(c)
+            space = false
+        default:
+            space = true
+        }
+    }
+    return b.String()
+}
+
+// jokeTextHash is stored in jokes.text_hash, whose unique index guarantees
+// that concurrent submissions of the same joke create a single row. Jokes
+// without letters or digits, such as emoji-only ones, normalize to nothing,
+// so they're hashed with only their spacing collapsed.
+func jokeTextHash(text string) string {
+    normalized := normalizeJokeText(text)
+    if normalized == "" {
+        normalized = strings.Join(strings.Fields(text), " ")
+    }
+    sum := sha256.Sum256([]byte(normalized))
+    return hex.EncodeToString(sum[:])
+}
+
+// jokeWords returns the distinct words of text, after normalizing it.
+func jokeWords(text string) map[string]bool {
+    words := map[string]bool{}
+    for _, word := range strings.Fields(normalizeJokeText(text)) {
+        words[word] = true
+    }
+    return words
+}
+
+// similarity is the Jaccard index of two word sets: the share of their
+// combined words that they have in common, from 0 to 1.
+func similarity(a, b map[string]bool) float64 {
+    if len(a) == 0 || len(b) == 0 {
+        return 0
+    }
+
+    common := 0
+    for word := range a {
+        if b[word] {
+            common++
+        }
+    }
+    return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// similarityIndex finds jokes with mostly the same words as a given one
+// without comparing it with every joke.
+type similarityIndex struct {
+    // words holds every indexed joke's word set, and jokes which jokes each
+    // word appears in.
+    words map[int]map[string]bool
+    jokes map[string][]int
+}
+
+func newSimilarityIndex() *similarityIndex {
+    return &similarityIndex{words: map[int]map[string]bool{}, jokes: map[string][]int{}}
+}
+
+// similarJoke is an indexed joke and how similar it is to the one looked up.
+type similarJoke struct {
+    Id         int
+    Similarity float64
+}
+
+// match returns the indexed jokes at least min similar to set, in no
+// particular order.
+func (x *similarityIndex) match(set map[string]bool, min float64) []similarJoke {
+    if len(set) < similarityMinWords {
+        return nil
+    }
+
+    candidates := map[int]bool{}
+    for word := range set {
+        if len(x.jokes[word]) < similarityCommonWord {
+            for _, id := range x.jokes[word] {
+                candidates[id] = true
+            }
+        }
+    }
+
+    var matches []similarJoke
+    for id := range candidates {
+        if score := similarity(set, x.words[id]); score >= min {
+            matches = append(matches, similarJoke{Id: id, Similarity: score})
+        }
+    }
+    return matches
+}
+
+// add indexes the words of the joke with the given id.
+func (x *similarityIndex) add(id int, set map[string]bool) {
+    if len(set) < similarityMinWords {
+        return
+    }
+    for word := range set {
+        x.jokes[word] = append(x.jokes[word], id)
+    }
+    x.words[id] = set
+}
+
+// nearDuplicates backs near-duplicate detection on submission. It's nil when
+// DUPLICATE_SIMILARITY is 0.
+var nearDuplicates *liveSimilarityIndex
+
+// liveSimilarityIndex is a similarityIndex of every stored joke. Before each
+// lookup it indexes the jokes created since the last one, so it also sees
+// jokes submitted to other instances, imported or pulled from peers.
+type liveSimilarityIndex struct {
+    mu      sync.Mutex
+    index   *similarityIndex
+    lastID  int
+    minimum float64
+}
+
+func newLiveSimilarityIndex(minimum float64) *liveSimilarityIndex {
+    return &liveSimilarityIndex{index: newSimilarityIndex(), minimum: minimum}
+}
+
+// catchUp indexes the jokes created since the last call. The first call
+// indexes the whole collection.
+func (l *liveSimilarityIndex) catchUp(ctx context.Context) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.catchUpLocked(ctx)
+}
+
+func (l *liveSimilarityIndex) catchUpLocked(ctx context.Context) error {
+    for {
+        jokes, err := repo.ListAfter(ctx, l.lastID, exportChunkSize, "")
+        if err != nil {
+            return err
+        }
+        if len(jokes) == 0 {
+            return nil
+        }
+        for _, joke := range jokes {
+            l.index.add(joke.Id, jokeWords(joke.Text))
+        }
+        l.lastID = jokes[len(jokes)-1].Id
+    }
+}
+
+// check returns a *duplicateJokeError for the most similar stored joke if
+// any is similar enough to text. Hidden and deleted jokes stay indexed, since
+// they can be restored, but are passed over for the next best match.
+func (l *liveSimilarityIndex) check(ctx context.Context, text string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if err := l.catchUpLocked(ctx); err != nil {
+        return err
+    }
+    matches := l.index.match(jokeWords(text), l.minimum)
+    slices.SortFunc(matches, func(a, b similarJoke) int {
+        return cmp.Compare(b.Similarity, a.Similarity)
+    })
+
+    for _, match := range matches {
+        existing, err := repo.Get(ctx, match.Id)
+        if errors.Is(err, errJokeNotFound) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        // The same joke is left for the repository to report, as an exact
+        // duplicate that can't be forced.
+        if normalizeJokeText(existing.Text) == normalizeJokeText(text) {
+            return nil
+        }
+        return &duplicateJokeError{Existing: existing, Similarity: float64(int(match.Similarity*100)) / 100}
+    }
+    return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// embedMaxAge is how long browsers and CDNs may cache an embedded joke. It's
+// short so the widget still rotates jokes, but long enough that a popular
+// blog post doesn't hit the database on every page view.
+const embedMaxAge = 300
+
+// jokeCardVersion is part of the media cache key of joke cards. Bump it when
+// renderJokeSVG changes, so cached cards are rendered again.
+const jokeCardVersion = "2"
+
+// embedScript renders a self-contained widget next to the <script> tag that
+// loaded it. The joke is inlined so the embedding page doesn't need CORS.
+// Right-to-left jokes are set right to left, and the author is isolated so a
+// name in the other direction doesn't swallow the dash.
+const embedScript = `(function () {
+    var joke = %s;
+    var dir = %s;
+    var script = document.currentScript;
+    var box = document.createElement("blockquote");
+    box.className = "dadjoke-embed";
+    box.style.cssText = "font-family:sans-serif;border-left:4px solid #f5a623;margin:1em 0;padding:.5em 1em;";
+    var text = document.createElement("p");
+    text.style.margin = "0 0 .5em";
+    text.dir = dir;
+    text.textContent = joke.joke_text;
+    var footer = document.createElement("footer");
+    footer.style.cssText = "font-size:.85em;color:#666;";
+    footer.dir = dir;
+    var link = document.createElement("a");
+    link.href = %s;
+    var author = document.createElement("bdi");
+    author.textContent = joke.author || "Anonymous";
+    link.appendChild(document.createTextNode("- "));
+    link.appendChild(author);
+    footer.appendChild(link);
+    box.appendChild(text);
+    box.appendChild(footer);
+    script.parentNode.insertBefore(box, script.nextSibling);
+})();
+`
+
+func getEmbedScript(response http.ResponseWriter, request *http.Request) {
+    joke, err := repo.Random(request.Context(), "")
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    // json.Marshal escapes <, > and &, so the values are safe to inline in a
+    // script even if the joke contains "</script>".
+    data, _ := json.Marshal(joke)
+    permalink, _ := json.Marshal(fmt.Sprintf("%s/jokes/%s", apiURL(request), jokeRef(joke.Id)))
+    dir := `"ltr"`
+    if isRTL(joke.Text) {
+        dir = `"rtl"`
+    }
+
+    response.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+    response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", embedMaxAge))
+    fmt.Fprintf(response, embedScript, data, dir, permalink)
+}
+
+// getEmbedSVG draws a random joke as a card, styled with the theme named by
+// ?theme=.
+func getEmbedSVG(response http.ResponseWriter, request *http.Request) {
+    theme, err := requestedTheme(request.Context(), request)
+    if errors.Is(err, errThemeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    joke, err := repo.Random(request.Context(), "")
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.Header().Set("Content-Type", "image/svg+xml")
+    response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", embedMaxAge))
+    card := cachedMedia(mediaKey("card.svg/"+jokeCardVersion, joke.Text, joke.Author, theme.cacheKey()), func() []byte {
+        return renderJokeSVG(joke, theme)
+    })
+    response.Write(card)
+}
+
+// emojiFonts follow the theme's font on cards, so emoji are drawn in color
+// where the viewer has one of them.
+const emojiFonts = `, 'Apple Color Emoji', 'Segoe UI Emoji', 'Noto Color Emoji'`
+
+// renderJokeSVG draws a joke as a simple card. SVG has no text wrapping, so
+// the text is broken into lines up front and the card height follows. Line
+// length and spacing follow the width and font size of the theme; the
+// default theme draws 55 characters per line. Right-to-left jokes are set
+// flush right, with the author on the left.
+func renderJokeSVG(joke Joke, theme Theme) []byte {
+    const padding = 24
+    width := theme.Width
+    lineHeight := theme.FontSize * 4 / 3
+    authorSize := theme.FontSize * 7 / 9
+
+    text := stripBidiControls(joke.Text)
+    lines := wrapText(text, max((width-padding*2)*20/(theme.FontSize*11), 10))
+    height := padding*2 + lineHeight*(len(lines)+1)
+
+    author := stripBidiControls(joke.Author)
+    if author == "" {
+        author = "Anonymous"
+    }
+
+    // With direction="rtl" the start of a line is its right end.
+    direction, start, end := "ltr", padding, width-padding
+    if isRTL(text) {
+        direction, start, end = "rtl", width-padding, padding
+    }
+    font := theme.FontFamily + emojiFonts
+
+    var b bytes.Buffer
+    fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+    fmt.Fprintf(&b, `<rect width="100%%" height="100%%" rx="8" fill="%s" stroke="%s" stroke-width="2"/>`, theme.Background, theme.Border)
+    fmt.Fprintf(&b, `<text font-family="%s" font-size="%d" fill="%s" direction="%s">`, font, theme.FontSize, theme.TextColor, direction)
+    for i, line := range lines {
+        fmt.Fprintf(&b, `<tspan x="%d" y="%d">`, start, padding+lineHeight*(i+1))
+        xml.EscapeText(&b, []byte(line))
+        b.WriteString(`</tspan>`)
+    }
+    b.WriteString(`</text>`)
+    fmt.Fprintf(&b, `<text x="%d" y="%d" font-family="%s" font-size="%d" fill="%s" direction="%s" text-anchor="end">- `, end, height-padding, font, authorSize, theme.AuthorColor, direction)
+    xml.EscapeText(&b, []byte(author))
+    b.WriteString(`</text></svg>`)
+    return b.Bytes()
+}
+
+// wrapText breaks text into lines of at most width columns, as counted by
+// displayWidth, splitting on whitespace. Words longer than width, such as a
+// run of emoji, are split between characters.
+func wrapText(text string, width int) []string {
+    var lines []string
+    var line string
+    lineWidth := 0
+    for _, word := range strings.Fields(text) {
+        for _, piece := range splitWord(word, width) {
+            pieceWidth := displayWidth(piece)
+            if line != "" && lineWidth+1+pieceWidth > width {
+                lines = append(lines, line)
+                line, lineWidth = "", 0
+            }
+            if line != "" {
+                line += " "
+                lineWidth++
+            }
+            line += piece
+            lineWidth += pieceWidth
+        }
+    }
+    if line != "" || len(lines) == 0 {
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+// splitWord cuts word into pieces of at most width columns, keeping each
+// character whole.
+func splitWord(word string, width int) []string {
+    var pieces []string
+    var piece string
+    pieceWidth := 0
+    for _, cluster := range clusters(word) {
+        w := clusterWidth(cluster)
+        if piece != "" && pieceWidth+w > width {
+            pieces = append(pieces, piece)
+            piece, pieceWidth = "", 0
+        }
+        piece += cluster
+        pieceWidth += w
+    }
+    return append(pieces, piece)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// TestEmbedWithoutJokes asks for the widgets of an empty collection.
+func TestEmbedWithoutJokes(t *testing.T) {
+    useMemoryRepository(t, newMemoryRepository())
+
+    for path, handler := range map[string]http.HandlerFunc{"/embed.js": getEmbedScript, "/embed.svg": getEmbedSVG} {
+        recorder := httptest.NewRecorder()
+        handler(recorder, httptest.NewRequest("GET", path, nil))
+        if recorder.Code != http.StatusNotFound {
+            t.Errorf("GET %s = %d, want 404: %s", path, recorder.Code, recorder.Body)
+        }
+    }
+}
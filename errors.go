@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "net/http"
+)
+
+// apiError is the body of every error response, wrapped as {"error": ...}.
+// Code is a stable machine readable name; Message is meant for people and
+// may change.
+type apiError struct {
+    Code    string `json:"code"`
+    Message string `json:"message"`
+    Details any    `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+    Error apiError `json:"error"`
+}
+
+// errorCodes names the error of each status used by the API.
+var errorCodes = map[int]string{
+    http.StatusBadRequest:            "bad_request",
+    http.StatusUnauthorized:          "unauthorized",
+    http.StatusForbidden:             "forbidden",
+    http.StatusNotFound:              "not_found",
+    http.StatusMethodNotAllowed:      "method_not_allowed",
+    http.StatusConflict:              "conflict",
+    http.StatusRequestEntityTooLarge: "too_large",
+    http.StatusTooManyRequests:       "rate_limited",
+    http.StatusInternalServerError:   "internal",
+    http.StatusNotImplemented:        "not_implemented",
+    http.StatusBadGateway:            "bad_gateway",
+    http.StatusServiceUnavailable:    "unavailable",
+    http.StatusGatewayTimeout:        "timeout",
+}
+
+// writeError writes an error response with the code for status.
+func writeError(response http.ResponseWriter, status int, message string) {
+    writeAPIError(response, status, apiError{Code: errorCodes[status], Message: message})
+}
+
+func writeAPIError(response http.ResponseWriter, status int, e apiError) {
+    header := response.Header()
+    header.Set("Content-Type", "application/json")
+    header.Set("X-Content-Type-Options", "nosniff")
+    response.WriteHeader(status)
+    json.NewEncoder(response).Encode(errorResponse{e})
+}
+
+// writeInternalError logs err and answers with a generic 500, so database
+// errors and other internals don't reach clients, or with 504 when a query
+// or the client's X-Request-Timeout ran out. The request id in the log line matches the X-Request-Id
+// response header.
+func writeInternalError(response http.ResponseWriter, request *http.Request, err error) {
+    if errors.Is(request.Context().Err(), context.DeadlineExceeded) {
+        slog.WarnContext(request.Context(), "Request timeout passed", "error", err)
+        writeError(response, http.StatusGatewayTimeout, "the request timeout passed before the response was ready")
+        return
+    }
+    if errors.Is(err, errQueryTimeout) {
+        slog.WarnContext(request.Context(), "Database query timed out", "error", err)
+        writeError(response, http.StatusGatewayTimeout, "the database took too long to answer")
+        return
+    }
+    slog.ErrorContext(request.Context(), "Error handling request", "error", err)
+    writeError(response, http.StatusInternalServerError, "internal server error")
+}
+
+// routeNotFound and methodNotAllowed replace the router's plain text
+// responses.
+func routeNotFound(response http.ResponseWriter, request *http.Request) {
+    writeError(response, http.StatusNotFound, "no such endpoint")
+}
+
+func methodNotAllowed(response http.ResponseWriter, request *http.Request) {
+    writeError(response, http.StatusMethodNotAllowed, request.Method+" is not allowed here")
+}
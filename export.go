@@ -0,0 +1,205 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "mime"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// exportChunkSize is how many jokes are read per query while exporting. Each
+// chunk is written and flushed before the next is read, so memory use stays
+// flat however large the table is.
+const exportChunkSize = 500
+
+// exportFormat writes jokes in one of the export formats.
+type exportFormat struct {
+    contentType string
+    extension   string
+    header      func(w io.Writer) error
+    write       func(w io.Writer, joke Joke) error
+    footer      func(w io.Writer) error
+}
+
+func newExportFormat(name string, d *dialect) (exportFormat, bool) {
+    switch name {
+    case "jsonl":
+        return exportFormat{
+            contentType: "application/x-ndjson",
+            extension:   "jsonl",
+            write: func(w io.Writer, joke Joke) error {
+                return json.NewEncoder(w).Encode(joke)
+            },
+        }, true
+    case "csv":
+        var writer *csv.Writer
+        return exportFormat{
+            contentType: "text/csv; charset=utf-8",
+            extension:   "csv",
+            header: func(w io.Writer) error {
+                writer = csv.NewWriter(w)
+                return writer.Write([]string{"id", "entry_date", "author", "joke_text", "lang"})
+            },
+            write: func(w io.Writer, joke Joke) error {
+                err := writer.Write([]string{jokeRef(joke.Id).String(), joke.Date.Format(time.RFC3339), joke.Author, joke.Text, joke.Language})
+                writer.Flush()
+                return err
+            },
+        }, true
+    case "sql":
+        if d == nil {
+            return exportFormat{}, false
+        }
+        return exportFormat{
+            contentType: "application/sql; charset=utf-8",
+            extension:   "sql",
+            header: func(w io.Writer) error {
+                _, err := fmt.Fprintf(w, "-- Dad jokes export for %s, %s. Dates are UTC.\n", d.name, time.Now().UTC().Format(time.RFC3339))
+                return err
+            },
+            write: func(w io.Writer, joke Joke) error {
+                _, err := fmt.Fprintf(w, "INSERT INTO jokes (id, entry_date, author, joke_text, language) VALUES (%d, %s, %s, %s, %s);\n",
+                    joke.Id, d.quote(joke.Date.Format(time.DateTime)), d.quote(joke.Author), d.quote(joke.Text), d.quote(joke.Language))
+                return err
+            },
+        }, true
+    }
+    return exportFormat{}, false
+}
+
+// exportFormatName picks the format from ?format=, falling back to the
+// Accept header and then to JSON Lines.
+func exportFormatName(request *http.Request) string {
+    if format := request.URL.Query().Get("format"); format != "" {
+        return format
+    }
+
+    for _, accepted := range strings.Split(request.Header.Get("Accept"), ",") {
+        mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accepted))
+        switch mediaType {
+        case "text/csv":
+            return "csv"
+        case "application/sql":
+            return "sql"
+        case "application/x-ndjson", "application/jsonl":
+            return "jsonl"
+        }
+    }
+    return "jsonl"
+}
+
+// newExportHandler returns the handler for GET /jokes/export. SQL dumps are
+// written for the dialect the server runs on, and aren't available when it
+// doesn't run on a SQL database (d is nil). With ?since= only jokes with a
+// greater id are exported, for incremental syncs.
+func newExportHandler(d *dialect) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        format, ok := newExportFormat(exportFormatName(request), d)
+        if !ok {
+            formats := "jsonl, csv or sql"
+            if d == nil {
+                formats = "jsonl or csv"
+            }
+            writeError(response, http.StatusBadRequest, "format must be "+formats)
+            return
+        }
+
+        // Jokes aren't tagged or grouped into collections, so there's
+        // nothing to scope those filters to. Refuse them rather than hand a
+        // mirror the full dump it thinks is scoped.
+        query := request.URL.Query()
+        for _, filter := range []string{"tag", "collection"} {
+            if query.Has(filter) {
+                writeError(response, http.StatusBadRequest, filter+" filter is not supported")
+                return
+            }
+        }
+
+        var lang string
+        if query.Has("lang") {
+            var ok bool
+            lang, ok = canonicalLanguage(query.Get("lang"))
+            if !ok {
+                writeError(response, http.StatusBadRequest, errBadLanguage.Error())
+                return
+            }
+        }
+
+        since := 0
+        if param := query.Get("since"); param != "" {
+            var ok bool
+            since, ok = parseJokeRef(param)
+            if !ok {
+                writeError(response, http.StatusBadRequest, "since must be a joke id")
+                return
+            }
+        }
+
+        // Fetch the first chunk before committing to a 200, so a database
+        // error can still be reported properly.
+        ctx := request.Context()
+        jokes, err := repo.ListAfter(ctx, since, exportChunkSize, lang)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+
+        response.Header().Set("Content-Type", format.contentType)
+        response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dadjokes-export.%s"`, format.extension))
+
+        keepOpen(response, time.Time{})
+        controller := http.NewResponseController(response)
+        w := bufio.NewWriter(response)
+        err = streamExport(w, format, jokes, func(afterID int) ([]Joke, error) {
+            if err := w.Flush(); err != nil {
+                return nil, err
+            }
+            controller.Flush()
+            return repo.ListAfter(ctx, afterID, exportChunkSize, lang)
+        })
+        if err == nil {
+            err = w.Flush()
+        }
+        if err != nil {
+            // Headers are gone by now; all we can do is cut the response
+            // short and log why.
+            slog.ErrorContext(ctx, "Error exporting jokes", "error", err)
+        }
+    }
+}
+
+// streamExport writes the jokes in first and every following chunk returned
+// by next, until it returns an empty chunk.
+func streamExport(w io.Writer, format exportFormat, first []Joke, next func(afterID int) ([]Joke, error)) error {
+    if format.header != nil {
+        if err := format.header(w); err != nil {
+            return err
+        }
+    }
+
+    jokes := first
+    for len(jokes) > 0 {
+        for _, joke := range jokes {
+            if err := format.write(w, joke); err != nil {
+                return err
+            }
+        }
+
+        var err error
+        jokes, err = next(jokes[len(jokes)-1].Id)
+        if err != nil {
+            return err
+        }
+    }
+
+    if format.footer != nil {
+        return format.footer(w)
+    }
+    return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// federationPageSize caps how many jokes a peer can fetch per request.
+const federationPageSize = 100
+
+// FederatedJoke is a joke as exchanged between instances. ExternalID is
+// globally unique and never changes as the joke travels between peers;
+// Source is the instance it was originally submitted to.
+type FederatedJoke struct {
+    Cursor     int    `json:"cursor"`
+    ExternalID string `json:"external_id"`
+    Source     string `json:"source"`
+    Author     string `json:"author"`
+    Text       string `json:"joke_text"`
+    Language   string `json:"lang"`
+}
+
+type federationPage struct {
+    Jokes      []FederatedJoke `json:"jokes"`
+    NextCursor int             `json:"next_cursor"`
+}
+
+// FederationRepository stores what's needed to exchange jokes with peers.
+type FederationRepository interface {
+    // FederatedJokes returns up to limit jokes with a cursor greater than
+    // since, in cursor order. Local jokes get an external id derived from
+    // self.
+    FederatedJokes(ctx context.Context, self string, since, limit int) ([]FederatedJoke, error)
+
+    // ImportFederated stores a joke received from a peer. It returns false
+    // without error when a joke with the same external id already exists.
+    ImportFederated(ctx context.Context, joke FederatedJoke) (bool, error)
+
+    // PeerCursor returns the cursor to resume pulling from peer at.
+    PeerCursor(ctx context.Context, peer string) (int, error)
+
+    // SavePeerCursor records progress after a successful pull.
+    SavePeerCursor(ctx context.Context, peer string, cursor int) error
+}
+
+var federation FederationRepository
+
+// federationID identifies this instance to its peers. It defaults to
+// PUBLIC_URL, since that's what peers use to reach us.
+func federationID() string {
+    return strings.TrimRight(getEnv("FEDERATION_ID", os.Getenv("PUBLIC_URL")), "/")
+}
+
+// getFederatedJokes serves the pull feed peers sync from. It includes jokes
+// imported from other peers, so jokes propagate through the federation.
+func getFederatedJokes(response http.ResponseWriter, request *http.Request) {
+    since, _ := strconv.Atoi(request.URL.Query().Get("since"))
+    limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+    if limit <= 0 || limit > federationPageSize {
+        limit = federationPageSize
+    }
+
+    jokes, err := federation.FederatedJokes(request.Context(), federationID(), since, limit)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    page := federationPage{Jokes: jokes, NextCursor: since}
+    if len(jokes) > 0 {
+        page.NextCursor = jokes[len(jokes)-1].Cursor
+    }
+
+    response.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(response).Encode(page)
+}
+
+// federationPeers returns the peers configured in FEDERATION_PEERS.
+func federationPeers() []string {
+    var peers []string
+    for _, peer := range strings.Split(os.Getenv("FEDERATION_PEERS"), ",") {
+        if peer = strings.TrimRight(strings.TrimSpace(peer), "/"); peer != "" {
+            peers = append(peers, peer)
+        }
+    }
+    return peers
+}
+
+// runFederation pulls new jokes from every peer each interval until ctx is
+// cancelled.
+func runFederation(ctx context.Context, self string, peers []string, interval time.Duration) {
+    client := &http.Client{Timeout: 30 * time.Second}
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        for _, peer := range peers {
+            imported, err := pullPeer(ctx, client, self, peer)
+            if err != nil {
+                slog.Error("Error syncing with peer", "peer", peer, "error", err)
+                continue
+            }
+            if imported > 0 {
+                slog.Info("Imported jokes from peer", "peer", peer, "count", imported)
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// pullPeer fetches every page the peer has beyond our saved cursor.
+//
+// Jokes that originated here are skipped, which stops them from bouncing
+// back after travelling around the federation. When two peers deliver the
+// same joke, the first copy to arrive wins and later ones are ignored.
+func pullPeer(ctx context.Context, client *http.Client, self, peer string) (int, error) {
+    cursor, err := federation.PeerCursor(ctx, peer)
+    if err != nil {
+        return 0, err
+    }
+
+    imported := 0
+    for {
+        page, err := fetchFederationPage(ctx, client, peer, cursor)
+        if err != nil {
+            return imported, err
+        }
+        if len(page.Jokes) == 0 {
+            return imported, nil
+        }
+
+        for _, joke := range page.Jokes {
+            if joke.ExternalID == "" || joke.Source == self {
+                continue
+            }
+            // Peers that predate languages send none; their jokes are in the
+            // default one.
+            lang, ok := canonicalLanguage(joke.Language)
+            if !ok {
+                continue
+            }
+            joke.Language = lang
+
+            reason, err := vetFederatedJoke(ctx, &joke)
+            if err != nil {
+                return imported, fmt.Errorf("checking %s: %w", joke.ExternalID, err)
+            }
+            if reason != "" {
+                slog.WarnContext(ctx, "Skipping joke from peer", "peer", peer, "external_id", joke.ExternalID, "reason", reason)
+                continue
+            }
+
+            ok, err = federation.ImportFederated(ctx, joke)
+            if err != nil {
+                return imported, fmt.Errorf("importing %s: %w", joke.ExternalID, err)
+            }
+            if ok {
+                imported++
+                responses.invalidate()
+                newJokes.notify()
+            }
+        }
+
+        cursor = page.NextCursor
+        if err := federation.SavePeerCursor(ctx, peer, cursor); err != nil {
+            return imported, err
+        }
+    }
+}
+
+// vetFederatedJoke normalizes a joke from a peer and runs it through the
+// submission limits and content filters, like a submitted joke. It returns
+// why the joke should be skipped, or "" to import it. Jokes the filters object
+// to are skipped even with CONTENT_FILTER_ACTION=flag, since they have no id
+// to flag before they're stored.
+func vetFederatedJoke(ctx context.Context, joke *FederatedJoke) (string, error) {
+    candidate := Joke{Author: joke.Author, Text: joke.Text, Language: joke.Language}
+    normalizeJoke(&candidate)
+    if err := limits.check(candidate); err != nil {
+        return err.Error(), nil
+    }
+
+    reason, source, err := filterContent(ctx, candidate)
+    if err != nil {
+        return "", err
+    }
+    if reason != "" {
+        return fmt.Sprintf("%s: %s", source, reason), nil
+    }
+    joke.Author, joke.Text = candidate.Author, candidate.Text
+    return "", nil
+}
+
+func fetchFederationPage(ctx context.Context, client *http.Client, peer string, since int) (federationPage, error) {
+    var page federationPage
+
+    // Peers that predate API versioning only serve the unprefixed feed.
+    query := url.Values{"since": {strconv.Itoa(since)}, "limit": {strconv.Itoa(federationPageSize)}}
+    response, err := getFederationFeed(ctx, client, peer+apiV1+"/federation/jokes?"+query.Encode())
+    if err == nil && response.StatusCode == http.StatusNotFound {
+        response.Body.Close()
+        response, err = getFederationFeed(ctx, client, peer+"/federation/jokes?"+query.Encode())
+    }
+    if err != nil {
+        return page, err
+    }
+    defer response.Body.Close()
+
+    if response.StatusCode != http.StatusOK {
+        return page, fmt.Errorf("unexpected status %s", response.Status)
+    }
+
+    err = json.NewDecoder(response.Body).Decode(&page)
+    if err == nil && len(page.Jokes) > 0 && page.NextCursor <= since {
+        err = fmt.Errorf("cursor did not advance past %d", since)
+    }
+    return page, err
+}
+
+func getFederationFeed(ctx context.Context, client *http.Client, feedURL string) (*http.Response, error) {
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    return client.Do(request)
+}
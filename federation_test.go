@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// TestPullPeerVetsJokes pulls a page of jokes from a fake peer, of which only
+// the one within the limits and past the content filter is imported.
+func TestPullPeerVetsJokes(t *testing.T) {
+    wordlist := filepath.Join(t.TempDir(), "wordlist.txt")
+    if err := os.WriteFile(wordlist, []byte("turnip\n"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("CONTENT_WORDLIST", wordlist)
+    t.Setenv("MAX_JOKE_LENGTH", "100")
+    newTestServer(t)
+
+    jokes := []FederatedJoke{
+        {Cursor: 1, ExternalID: "https://peer.example/jokes/1", Text: "What do you call a bear with no teeth? A gummy bear.", Author: "Peer"},
+        {Cursor: 2, ExternalID: "https://peer.example/jokes/2", Text: strings.Repeat("Way too long. ", 20), Author: "Peer"},
+        {Cursor: 3, ExternalID: "https://peer.example/jokes/3", Text: "Why did the turnip blush? It saw the salad dressing.", Author: "Peer"},
+    }
+    for i := range jokes {
+        jokes[i].Source = "https://peer.example"
+    }
+    peer := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        page := federationPage{NextCursor: len(jokes)}
+        if request.URL.Query().Get("since") == "0" {
+            page.Jokes = jokes
+        }
+        json.NewEncoder(response).Encode(page)
+    }))
+    defer peer.Close()
+
+    imported, err := pullPeer(context.Background(), peer.Client(), "https://self.example", peer.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if imported != 1 {
+        t.Errorf("imported %d jokes, want 1", imported)
+    }
+    stored, err := repo.ListAfter(context.Background(), 0, 10, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(stored) != 1 || stored[0].Text != jokes[0].Text {
+        t.Errorf("stored %+v, want only %q", stored, jokes[0].Text)
+    }
+}
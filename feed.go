@@ -0,0 +1,242 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+    "unicode/utf8"
+)
+
+const (
+    // feedSize is how many of the latest jokes the feeds carry.
+    feedSize = 20
+
+    // feedMaxAge is how long feed readers and caches may keep a feed.
+    feedMaxAge = 600
+
+    // feedTitleLength is how much of a joke is used as its entry title.
+    feedTitleLength = 60
+)
+
+type rssFeed struct {
+    XMLName xml.Name   `xml:"rss"`
+    Version string     `xml:"version,attr"`
+    DC      string     `xml:"xmlns:dc,attr"`
+    Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+    Title         string    `xml:"title"`
+    Link          string    `xml:"link"`
+    Description   string    `xml:"description"`
+    LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+    Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+    Title       string  `xml:"title"`
+    Link        string  `xml:"link"`
+    Description string  `xml:"description"`
+    Creator     string  `xml:"dc:creator,omitempty"`
+    GUID        rssGUID `xml:"guid"`
+    PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+    IsPermaLink bool   `xml:"isPermaLink,attr"`
+    Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+    XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+    ID      string      `xml:"id"`
+    Title   string      `xml:"title"`
+    Updated string      `xml:"updated"`
+    Author  atomAuthor  `xml:"author"`
+    Links   []atomLink  `xml:"link"`
+    Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+    ID      string      `xml:"id"`
+    Title   string      `xml:"title"`
+    Updated string      `xml:"updated"`
+    Author  *atomAuthor `xml:"author,omitempty"`
+    Link    atomLink    `xml:"link"`
+    Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+    Name string `xml:"name"`
+}
+
+type atomLink struct {
+    Rel  string `xml:"rel,attr,omitempty"`
+    Href string `xml:"href,attr"`
+}
+
+type atomContent struct {
+    Type  string `xml:"type,attr"`
+    Value string `xml:",chardata"`
+}
+
+// feedTitle shortens a joke to an entry title.
+func feedTitle(text string) string {
+    text = strings.Join(strings.Fields(text), " ")
+    if utf8.RuneCountInString(text) <= feedTitleLength {
+        return text
+    }
+    return string([]rune(text)[:feedTitleLength-1]) + "…"
+}
+
+// lastModified returns the newest date among jokes.
+func lastModified(jokes []Joke) time.Time {
+    var latest time.Time
+    for _, joke := range jokes {
+        if joke.Date.After(latest) {
+            latest = joke.Date
+        }
+    }
+    return latest
+}
+
+func newRSSFeed(request *http.Request, jokes []Joke) rssFeed {
+    base := apiURL(request)
+    instance := instanceInfo(request)
+
+    feed := rssFeed{
+        Version: "2.0",
+        DC:      "http://purl.org/dc/elements/1.1/",
+        Channel: rssChannel{
+            Title:       instance.Name,
+            Link:        base,
+            Description: "The latest jokes submitted to " + instance.Name,
+            Items:       []rssItem{},
+        },
+    }
+    if len(jokes) > 0 {
+        feed.Channel.LastBuildDate = lastModified(jokes).Format(time.RFC1123Z)
+    }
+
+    for _, joke := range jokes {
+        link := fmt.Sprintf("%s/jokes/%s", base, jokeRef(joke.Id))
+        feed.Channel.Items = append(feed.Channel.Items, rssItem{
+            Title:       feedTitle(joke.Text),
+            Link:        link,
+            Description: joke.Text,
+            Creator:     joke.Author,
+            GUID:        rssGUID{IsPermaLink: true, Value: link},
+            PubDate:     joke.Date.Format(time.RFC1123Z),
+        })
+    }
+    return feed
+}
+
+func newAtomFeed(request *http.Request, jokes []Joke) atomFeed {
+    base := apiURL(request)
+    instance := instanceInfo(request)
+
+    feed := atomFeed{
+        ID:      base + "/feed.atom",
+        Title:   instance.Name,
+        Updated: lastModified(jokes).Format(time.RFC3339),
+        Author:  atomAuthor{Name: instance.Name},
+        Links: []atomLink{
+            {Rel: "self", Href: base + "/feed.atom"},
+            {Rel: "alternate", Href: base},
+        },
+    }
+
+    for _, joke := range jokes {
+        link := fmt.Sprintf("%s/jokes/%s", base, jokeRef(joke.Id))
+        entry := atomEntry{
+            ID:      link,
+            Title:   feedTitle(joke.Text),
+            Updated: joke.Date.Format(time.RFC3339),
+            Link:    atomLink{Href: link},
+            Content: atomContent{Type: "text", Value: joke.Text},
+        }
+        if joke.Author != "" {
+            entry.Author = &atomAuthor{Name: joke.Author}
+        }
+        feed.Entries = append(feed.Entries, entry)
+    }
+    return feed
+}
+
+// newFeedHandler returns a handler serving the latest jokes as the feed
+// built by build.
+func newFeedHandler(contentType string, build func(*http.Request, []Joke) any) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        jokes, err := repo.List(request.Context(), 0, feedSize, 0, "")
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+
+        writeFeed(response, request, contentType, build(request, jokes), lastModified(jokes))
+    }
+}
+
+// writeFeed writes feed as XML. Responses carry an ETag and Last-Modified,
+// so feed readers polling with If-None-Match or If-Modified-Since get a 304
+// when nothing changed.
+func writeFeed(response http.ResponseWriter, request *http.Request, contentType string, feed any, modified time.Time) {
+    var body bytes.Buffer
+    body.WriteString(xml.Header)
+    encoder := xml.NewEncoder(&body)
+    encoder.Indent("", "  ")
+    if err := encoder.Encode(feed); err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    body.WriteString("\n")
+
+    sum := sha256.Sum256(body.Bytes())
+    etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+    response.Header().Set("ETag", etag)
+    response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", feedMaxAge))
+    if !modified.IsZero() {
+        response.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+    }
+
+    if notModified(request, etag, modified) {
+        response.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    response.Header().Set("Content-Type", contentType)
+    response.Write(body.Bytes())
+}
+
+// notModified reports whether the client's cached copy, described by the
+// conditional request headers, is still current. If-None-Match takes
+// precedence over If-Modified-Since.
+func notModified(request *http.Request, etag string, modified time.Time) bool {
+    if match := request.Header.Get("If-None-Match"); match != "" {
+        for _, candidate := range strings.Split(match, ",") {
+            candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+            if candidate == etag || candidate == "*" {
+                return true
+            }
+        }
+        return false
+    }
+
+    since, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+    return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+var getRSSFeed = newFeedHandler("application/rss+xml; charset=utf-8", func(request *http.Request, jokes []Joke) any {
+    return newRSSFeed(request, jokes)
+})
+
+var getAtomFeed = newFeedHandler("application/atom+xml; charset=utf-8", func(request *http.Request, jokes []Joke) any {
+    return newAtomFeed(request, jokes)
+})
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "strings"
+)
+
+// jokeFields are the JSON field names of Joke that ?fields= can select.
+// original_date is input only and never part of a response.
+var jokeFields = func() map[string]bool {
+    names := jsonFieldNames(reflect.TypeOf(Joke{}))
+    delete(names, "original_date")
+    return names
+}()
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+    names := map[string]bool{}
+    for i := 0; i < t.NumField(); i++ {
+        name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+        if name != "" && name != "-" {
+            names[name] = true
+        }
+    }
+    return names
+}
+
+// requestedFields parses a ?fields=id,joke_text sparse fieldset. It returns
+// nil when every field is wanted.
+func requestedFields(request *http.Request) (map[string]bool, error) {
+    param := request.URL.Query().Get("fields")
+    if param == "" || request.Method != http.MethodGet {
+        return nil, nil
+    }
+
+    fields := map[string]bool{}
+    for _, name := range strings.Split(param, ",") {
+        name = strings.TrimSpace(name)
+        if name == "" {
+            continue
+        }
+        if !jokeFields[name] {
+            return nil, fmt.Errorf("unknown field %q", name)
+        }
+        fields[name] = true
+    }
+    return fields, nil
+}
+
+// selectFields trims the jokes in value down to fields. Values that don't
+// contain jokes are returned unchanged.
+func selectFields(value any, fields map[string]bool) any {
+    switch v := value.(type) {
+    case Joke:
+        return trimJoke(v, fields)
+    case []Joke:
+        trimmed := make([]map[string]any, len(v))
+        for i, joke := range v {
+            trimmed[i] = trimJoke(joke, fields)
+        }
+        return trimmed
+    case jokePage:
+        return struct {
+            jokePage
+            Jokes any `json:"jokes"`
+        }{v, selectFields(v.Jokes, fields)}
+    }
+    return value
+}
+
+func trimJoke(joke Joke, fields map[string]bool) map[string]any {
+    var all map[string]any
+    data, _ := json.Marshal(joke)
+    json.Unmarshal(data, &all)
+
+    trimmed := make(map[string]any, len(fields))
+    for name := range fields {
+        if v, ok := all[name]; ok {
+            trimmed[name] = v
+        }
+    }
+    return trimmed
+}
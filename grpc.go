@@ -0,0 +1,163 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+
+    "test/dadjokes/jokespb"
+)
+
+// jokesServer implements the gRPC Jokes service on top of the same
+// repository and helpers as the REST handlers.
+type jokesServer struct {
+    jokespb.UnimplementedJokesServer
+}
+
+func newGRPCServer() *grpc.Server {
+    server := grpc.NewServer(grpc.ChainUnaryInterceptor(logRPCs))
+    jokespb.RegisterJokesServer(server, jokesServer{})
+    return server
+}
+
+func toProtoJoke(joke Joke) *jokespb.Joke {
+    return &jokespb.Joke{
+        Id:        jokeRef(joke.Id).String(),
+        EntryDate: timestamppb.New(joke.Date),
+        Author:    joke.Author,
+        JokeText:  joke.Text,
+        Lang:      joke.Language,
+    }
+}
+
+func (jokesServer) GetRandomJoke(ctx context.Context, request *jokespb.GetRandomJokeRequest) (*jokespb.Joke, error) {
+    lang := request.GetLang()
+    if lang != "" {
+        var ok bool
+        lang, ok = canonicalLanguage(lang)
+        if !ok {
+            return nil, status.Error(codes.InvalidArgument, errBadLanguage.Error())
+        }
+    }
+
+    joke, err := randomJoke(ctx, lang)
+    if errors.Is(err, errJokeNotFound) && lang != "" {
+        return nil, status.Error(codes.NotFound, "no jokes in "+lang)
+    }
+    if errors.Is(err, errJokeNotFound) {
+        return nil, status.Error(codes.NotFound, err.Error())
+    }
+    if err != nil {
+        return nil, internalError(ctx, err)
+    }
+    return toProtoJoke(joke), nil
+}
+
+func (jokesServer) GetJoke(ctx context.Context, request *jokespb.GetJokeRequest) (*jokespb.Joke, error) {
+    id, ok := parseJokeRef(request.GetId())
+    if !ok {
+        return nil, status.Error(codes.NotFound, errJokeNotFound.Error())
+    }
+    joke, err := repo.Get(ctx, id)
+    if errors.Is(err, errJokeNotFound) {
+        return nil, status.Error(codes.NotFound, err.Error())
+    }
+    if err != nil {
+        return nil, internalError(ctx, err)
+    }
+    return toProtoJoke(joke), nil
+}
+
+func (jokesServer) ListJokes(ctx context.Context, request *jokespb.ListJokesRequest) (*jokespb.ListJokesResponse, error) {
+    page, perPage := clampPagination(int(request.GetPage()), int(request.GetPerPage()))
+
+    result, err := listPage(ctx, page, perPage, request.GetSnapshot(), "")
+    if errors.Is(err, errBadSnapshot) {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    if err != nil {
+        return nil, internalError(ctx, err)
+    }
+
+    response := &jokespb.ListJokesResponse{
+        Page:     int32(result.Page),
+        PerPage:  int32(result.PerPage),
+        Snapshot: result.Snapshot,
+    }
+    for _, joke := range result.Jokes {
+        response.Jokes = append(response.Jokes, toProtoJoke(joke))
+    }
+    return response, nil
+}
+
+func (jokesServer) SubmitJoke(ctx context.Context, request *jokespb.SubmitJokeRequest) (*jokespb.Joke, error) {
+    if incompatibleSchema != nil {
+        return nil, status.Error(codes.Unavailable, "the database schema doesn't match this version of the service")
+    }
+    if powChallenges != nil {
+        md, _ := metadata.FromIncomingContext(ctx)
+        err := powChallenges.verify(firstValue(md, "x-pow-challenge"), firstValue(md, "x-pow-solution"), time.Now())
+        if err != nil {
+            return nil, status.Error(codes.PermissionDenied, err.Error())
+        }
+    }
+    joke := Joke{Author: request.GetAuthor(), Text: request.GetJokeText(), Language: request.GetLang()}
+
+    err := submitJoke(ctx, &joke, false)
+    var invalid *invalidJokeError
+    if errors.As(err, &invalid) {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    var rejected *contentRejectedError
+    if errors.As(err, &rejected) {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    var duplicate *duplicateJokeError
+    if errors.As(err, &duplicate) {
+        if duplicate.Existing.Id == 0 {
+            return nil, status.Error(codes.AlreadyExists, err.Error())
+        }
+        return nil, status.Errorf(codes.AlreadyExists, "%s (joke %s)", err, jokeRef(duplicate.Existing.Id))
+    }
+    if err != nil {
+        return nil, internalError(ctx, err)
+    }
+    return toProtoJoke(joke), nil
+}
+
+// internalError logs err and hides it from the client, like
+// writeInternalError does for HTTP.
+func internalError(ctx context.Context, err error) error {
+    slog.ErrorContext(ctx, "Error handling call", "error", err)
+    return status.Error(codes.Internal, "internal server error")
+}
+
+// logRPCs logs every call like logRequests does for HTTP requests, with a
+// request id attached to the context.
+func logRPCs(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+    start := time.Now()
+    ctx = context.WithValue(ctx, requestIDKey, newRequestID())
+
+    response, err := handler(ctx, request)
+    slog.InfoContext(ctx, "RPC served",
+        "method", info.FullMethod,
+        "code", status.Code(err).String(),
+        "duration_ms", float64(time.Since(start).Microseconds())/1000,
+    )
+    return response, err
+}
+
+// firstValue returns the first value of key in md, or "" if there's none.
+func firstValue(md metadata.MD, key string) string {
+    if values := md.Get(key); len(values) > 0 {
+        return values[0]
+    }
+    return ""
+}
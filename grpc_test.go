@@ -0,0 +1,67 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "test/dadjokes/jokespb"
+)
+
+// TestGRPCJokes submits and reads jokes over gRPC with ID_KEY set, checking
+// the ids are the public ones and the language is kept and filtered on.
+func TestGRPCJokes(t *testing.T) {
+    t.Setenv("ID_KEY", "test-id-key")
+    newTestServer(t)
+    ctx := context.Background()
+    server := jokesServer{}
+
+    submitted, err := server.SubmitJoke(ctx, &jokespb.SubmitJokeRequest{
+        Author:   "Tester",
+        JokeText: "Por que o livro de matemática estava triste? Porque tinha muitos problemas.",
+        Lang:     "pt-br",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if submitted.Lang != "pt-BR" {
+        t.Errorf("submitted joke has lang %q, want pt-BR", submitted.Lang)
+    }
+    if _, ok := parseJokeRef(submitted.Id); !ok || submitted.Id == "1" {
+        t.Errorf("submitted joke has id %q, want an obfuscated id", submitted.Id)
+    }
+
+    got, err := server.GetJoke(ctx, &jokespb.GetJokeRequest{Id: submitted.Id})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.JokeText != submitted.JokeText {
+        t.Errorf("GetJoke(%q) = %q, want %q", submitted.Id, got.JokeText, submitted.JokeText)
+    }
+    _, err = server.GetJoke(ctx, &jokespb.GetJokeRequest{Id: "1"})
+    if status.Code(err) != codes.NotFound {
+        t.Errorf("GetJoke(\"1\") = %v, want NOT_FOUND", err)
+    }
+
+    tests := []struct {
+        lang string
+        code codes.Code
+    }{
+        {"", codes.OK},
+        {"pt-BR", codes.OK},
+        {"en", codes.NotFound},
+        {"not a tag", codes.InvalidArgument},
+    }
+    for _, test := range tests {
+        joke, err := server.GetRandomJoke(ctx, &jokespb.GetRandomJokeRequest{Lang: test.lang})
+        if status.Code(err) != test.code {
+            t.Errorf("GetRandomJoke(%q) = %v, want %v", test.lang, err, test.code)
+            continue
+        }
+        if err == nil && joke.Id != submitted.Id {
+            t.Errorf("GetRandomJoke(%q) = joke %q, want %q", test.lang, joke.Id, submitted.Id)
+        }
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log/slog"
+    "net/http"
+    "time"
+)
+
+// readyTimeout bounds the database checks behind /readyz, so a hung database
+// fails the probe instead of hanging it.
+const readyTimeout = 2 * time.Second
+
+// healthCheck is the result of one /readyz check. /readyz doesn't need a
+// token, so Error is one of the fixed messages below and the details are
+// only logged.
+type healthCheck struct {
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+}
+
+// Errors of failed /readyz checks.
+const (
+    errDatabaseUnreachable = "database unreachable"
+    errSchemaOutOfDate     = "schema out of date"
+)
+
+// failedCheck logs why the named check failed, given as slog args, and
+// returns its result with message as the error.
+func failedCheck(ctx context.Context, name, message string, args ...any) healthCheck {
+    slog.ErrorContext(ctx, "Readiness check failed", append([]any{"check", name}, args...)...)
+    return healthCheck{Status: "fail", Error: message}
+}
+
+type healthStatus struct {
+    Status string                 `json:"status"`
+    Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// getHealth reports that the process is up. It deliberately doesn't touch
+// the database, so a database outage doesn't get the process restarted.
+func getHealth(response http.ResponseWriter, request *http.Request) {
+    writeHealth(response, healthStatus{Status: "ok"})
+}
+
+// readyCheck checks one dependency for /readyz.
+type readyCheck func(ctx context.Context) healthCheck
+
+// newReadyHandler returns the /readyz handler, which reports whether the
+// service can take traffic: every check of the storage backend passes.
+func newReadyHandler(checks map[string]readyCheck) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        ctx, cancel := context.WithTimeout(request.Context(), readyTimeout)
+        defer cancel()
+
+        status := healthStatus{Status: "ok", Checks: map[string]healthCheck{}}
+        for name, check := range checks {
+            result := check(ctx)
+            if result.Status != "ok" {
+                status.Status = "fail"
+            }
+            status.Checks[name] = result
+        }
+        writeHealth(response, status)
+    }
+}
+
+// readyChecks are the /readyz checks of a SQL database: it answers a ping
+// and every migration has been applied.
+func (r *sqlRepository) readyChecks() map[string]readyCheck {
+    return map[string]readyCheck{
+        "database": func(ctx context.Context) healthCheck {
+            if err := r.db.PingContext(ctx); err != nil {
+                return failedCheck(ctx, "database", errDatabaseUnreachable, "error", err)
+            }
+            return healthCheck{Status: "ok"}
+        },
+        "migrations": func(ctx context.Context) healthCheck {
+            pending, err := pendingMigrations(ctx, r.db, r.dialect)
+            switch {
+            case err != nil:
+                return failedCheck(ctx, "migrations", errSchemaOutOfDate, "error", err)
+            case len(pending) > 0:
+                return failedCheck(ctx, "migrations", errSchemaOutOfDate, "pending", pending)
+            }
+            return healthCheck{Status: "ok"}
+        },
+    }
+}
+
+func writeHealth(response http.ResponseWriter, status healthStatus) {
+    response.Header().Set("Content-Type", "application/json")
+    response.Header().Set("Cache-Control", "no-store")
+    if status.Status != "ok" {
+        response.WriteHeader(http.StatusServiceUnavailable)
+    }
+    json.NewEncoder(response).Encode(status)
+}
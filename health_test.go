@@ -0,0 +1,26 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// TestReadyzHidesErrors fails the database check and expects the fixed
+// message in the response rather than the driver's error.
+func TestReadyzHidesErrors(t *testing.T) {
+    r := openTestRepository(t)
+    r.db.Close()
+
+    recorder := httptest.NewRecorder()
+    newReadyHandler(r.readyChecks())(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+    if recorder.Code != http.StatusServiceUnavailable {
+        t.Errorf("status = %d, want 503", recorder.Code)
+    }
+    body := recorder.Body.String()
+    if !strings.Contains(body, errDatabaseUnreachable) || strings.Contains(body, "sql:") {
+        t.Errorf("body = %s, want %q without the driver's error", body, errDatabaseUnreachable)
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// testAdminToken is the ADMIN_TOKEN of test servers.
+const testAdminToken = "test-admin-token"
+
+// useTestDatabase points DB_DRIVER and DB_CONN_STRING at an empty SQLite
+// database in a temporary directory. Writers wait for each other rather than
+// fail with "database is locked".
+func useTestDatabase(tb testing.TB) {
+    tb.Helper()
+    tb.Setenv("DB_DRIVER", "sqlite3")
+    tb.Setenv("DB_CONN_STRING", filepath.Join(tb.TempDir(), "jokes.db")+"?_busy_timeout=10000&_txlock=immediate")
+}
+
+// openTestRepository opens a migrated test database, closed again when tb
+// ends.
+func openTestRepository(tb testing.TB) *sqlRepository {
+    tb.Helper()
+    useTestDatabase(tb)
+    r, err := openSQLRepository(context.Background(), "sqlite3")
+    if err != nil {
+        tb.Fatal(err)
+    }
+    tb.Cleanup(func() { r.Close() })
+    return r
+}
+
+// createTestJokes stores n made-up jokes and returns them with their ids.
+func createTestJokes(tb testing.TB, r JokeRepository, n int) []Joke {
+    tb.Helper()
+    jokes := make([]Joke, n)
+    for i := range jokes {
+        jokes[i] = Joke{Text: fmt.Sprintf("Joke number %d walks into a bar. The bartender says %d.", i, i*7), Author: "Tester", Language: defaultLanguage}
+    }
+    errs, err := r.CreateBatch(context.Background(), jokes)
+    if err != nil {
+        tb.Fatal(err)
+    }
+    for i, err := range errs {
+        if err != nil {
+            tb.Fatalf("seeding joke %d: %v", i, err)
+        }
+    }
+    return jokes
+}
+
+// newTestServer serves the API from a test database, set up like the serve
+// command does but without the background jobs. Settings are taken from the
+// environment, so tests set theirs with t.Setenv first.
+func newTestServer(t *testing.T) *httptest.Server {
+    t.Helper()
+    useTestDatabase(t)
+    t.Setenv("ADMIN_TOKEN", testAdminToken)
+
+    var err error
+    limits, err = loadLimits()
+    if err != nil {
+        t.Fatal(err)
+    }
+    // newHandler leaves these alone when they're turned off, so they'd be
+    // left over from the previous test.
+    publicIDs, nearDuplicates = nil, nil
+    if key := os.Getenv("ID_KEY"); key != "" {
+        publicIDs = newIDCodec(key)
+    }
+    // Today's joke is cached across databases too.
+    todaysJoke.day = ""
+
+    ctx, cancel := context.WithCancel(context.Background())
+    store, done := openApp(ctx)
+    server := httptest.NewServer(newHandler(ctx, store))
+    t.Cleanup(func() {
+        server.Close()
+        cancel()
+        done()
+    })
+    return server
+}
+
+// testRequest makes a request to server, as admin when admin is set, and
+// returns the status and body of the response.
+func testRequest(t *testing.T, server *httptest.Server, method, path, body string, admin bool) (int, []byte) {
+    t.Helper()
+    request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if body != "" {
+        request.Header.Set("Content-Type", "application/json")
+    }
+    if admin {
+        request.Header.Set("Authorization", "Bearer "+testAdminToken)
+    }
+    response, err := server.Client().Do(request)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer response.Body.Close()
+    data, err := io.ReadAll(response.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return response.StatusCode, data
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// publicIDs obfuscates joke ids in responses and URLs. It's nil, and ids are
+// plain numbers, unless ID_KEY is set.
+var publicIDs *idCodec
+
+// idAlphabet is the base62 alphabet obfuscated ids are written in.
+const idAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// idRounds is the number of Feistel rounds. Four make the output of
+// consecutive ids look unrelated.
+const idRounds = 4
+
+// idCodec turns database ids into short opaque strings and back, so clients
+// can't walk the collection by counting up. It's a keyed permutation of
+// 64-bit numbers (a Feistel network with HMAC-SHA256 as the round function),
+// written in base62. Database keys are unchanged; only their public form is.
+type idCodec struct {
+    key []byte
+}
+
+func newIDCodec(key string) *idCodec {
+    return &idCodec{key: []byte(key)}
+}
+
+func (c *idCodec) round(i int, half uint32) uint32 {
+    mac := hmac.New(sha256.New, c.key)
+    var block [5]byte
+    block[0] = byte(i)
+    binary.BigEndian.PutUint32(block[1:], half)
+    mac.Write(block[:])
+    return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+func (c *idCodec) encode(id int) string {
+    left, right := uint32(uint64(id)>>32), uint32(id)
+    for i := 0; i < idRounds; i++ {
+        left, right = right, left^c.round(i, right)
+    }
+    return base62(uint64(left)<<32 | uint64(right))
+}
+
+// decode returns the id encoded in s, and false when s isn't an id this
+// codec produced.
+func (c *idCodec) decode(s string) (int, bool) {
+    n, ok := parseBase62(s)
+    if !ok || base62(n) != s {
+        return 0, false
+    }
+
+    left, right := uint32(n>>32), uint32(n)
+    for i := idRounds - 1; i >= 0; i-- {
+        left, right = right^c.round(i, left), left
+    }
+    id := uint64(left)<<32 | uint64(right)
+    if id < 1 || id > math.MaxInt32 {
+        return 0, false
+    }
+    return int(id), true
+}
+
+func base62(n uint64) string {
+    if n == 0 {
+        return idAlphabet[:1]
+    }
+    var digits []byte
+    for n > 0 {
+        digits = append(digits, idAlphabet[n%62])
+        n /= 62
+    }
+    for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+        digits[i], digits[j] = digits[j], digits[i]
+    }
+    return string(digits)
+}
+
+func parseBase62(s string) (uint64, bool) {
+    if s == "" || len(s) > 11 {
+        return 0, false
+    }
+    var n uint64
+    for _, c := range []byte(s) {
+        digit := strings.IndexByte(idAlphabet, c)
+        if digit < 0 {
+            return 0, false
+        }
+        next := n*62 + uint64(digit)
+        if next/62 != n {
+            return 0, false
+        }
+        n = next
+    }
+    return n, true
+}
+
+// jokeRef is a joke id as shown to clients: obfuscated when ID_KEY is set,
+// a plain number otherwise.
+type jokeRef int
+
+func (r jokeRef) String() string {
+    if publicIDs == nil {
+        return strconv.Itoa(int(r))
+    }
+    return publicIDs.encode(int(r))
+}
+
+func (r jokeRef) MarshalJSON() ([]byte, error) {
+    if publicIDs == nil {
+        return json.Marshal(int(r))
+    }
+    return json.Marshal(r.String())
+}
+
+// UnmarshalJSON reads an id the way clients see it. A JSON number is read
+// like the string of its digits, so plain ids can be sent either way.
+func (r *jokeRef) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        var n int
+        if err := json.Unmarshal(data, &n); err != nil {
+            return err
+        }
+        s = strconv.Itoa(n)
+    }
+
+    id, ok := parseJokeRef(s)
+    if !ok {
+        return fmt.Errorf("invalid joke id %q", s)
+    }
+    *r = jokeRef(id)
+    return nil
+}
+
+// parseJokeRef reads a joke id the way clients see it, returning false when
+// it isn't one.
+func parseJokeRef(s string) (int, bool) {
+    if publicIDs != nil {
+        return publicIDs.decode(s)
+    }
+    id, err := strconv.Atoi(s)
+    return id, err == nil && id > 0
+}
+
+// MarshalJSON writes the joke with its public id.
+func (j Joke) MarshalJSON() ([]byte, error) {
+    type plain Joke
+    return json.Marshal(struct {
+        Id jokeRef `json:"id"`
+        plain
+    }{jokeRef(j.Id), plain(j)})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    // maxBatchSize caps the number of jokes accepted by POST /jokes/batch.
+    maxBatchSize = 1000
+
+    // importBatchSize is how many jokes the import command inserts per
+    // transaction.
+    importBatchSize = 500
+)
+
+// batchResult reports what happened to one item of a batch, by its index in
+// the request.
+type batchResult struct {
+    Index  int              `json:"index"`
+    Status string           `json:"status"`
+    Id     jokeRef          `json:"id,omitempty"`
+    Error  string           `json:"error,omitempty"`
+    Errors validationErrors `json:"errors,omitempty"`
+}
+
+type batchResponse struct {
+    Created int           `json:"created"`
+    Failed  int           `json:"failed"`
+    Results []batchResult `json:"results"`
+}
+
+// checkImported returns why a joke can't be imported, or nil if it can.
+func checkImported(joke Joke) error {
+    return limits.check(joke)
+}
+
+// importJokes creates every valid joke in the request in one transaction and
+// reports per item which were created and why the others weren't.
+func importJokes(response http.ResponseWriter, request *http.Request) {
+    var jokes []Joke
+    if !decodeBody(response, request, bodyLimits.batch, &jokes) {
+        return
+    }
+    if len(jokes) > maxBatchSize {
+        writeError(response, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d jokes per batch", maxBatchSize))
+        return
+    }
+
+    result := batchResponse{Results: make([]batchResult, len(jokes))}
+    var valid []Joke
+    var indexes []int
+    for i, joke := range jokes {
+        normalizeJoke(&joke)
+        if err := checkImported(joke); err != nil {
+            var problems validationErrors
+            errors.As(err, &problems)
+            result.Results[i] = batchResult{Index: i, Status: "failed", Error: err.Error(), Errors: problems}
+            result.Failed++
+            continue
+        }
+        valid = append(valid, joke)
+        indexes = append(indexes, i)
+    }
+
+    var errs []error
+    if len(valid) > 0 {
+        var err error
+        errs, err = repo.CreateBatch(request.Context(), valid)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+    }
+    for j, joke := range valid {
+        var duplicate *duplicateJokeError
+        if errors.As(errs[j], &duplicate) {
+            result.Results[indexes[j]] = batchResult{Index: indexes[j], Status: "duplicate", Id: jokeRef(duplicate.Existing.Id), Error: duplicate.Error()}
+            result.Failed++
+            continue
+        }
+        result.Results[indexes[j]] = batchResult{Index: indexes[j], Status: "created", Id: jokeRef(joke.Id)}
+        result.Created++
+        notifyJokeCreated(request.Context(), joke)
+    }
+    if result.Created > 0 {
+        responses.invalidate()
+        newJokes.notify()
+    }
+
+    writeJSON(response, request, http.StatusOK, result)
+}
+
+// runImport implements `dadjokes import FILE`, loading jokes from a JSON
+// array or a CSV file with a header row. The file is streamed and inserted
+// in batches, so it can be larger than memory.
+func runImport(ctx context.Context, args []string) error {
+    if len(args) != 1 {
+        return errors.New("usage: dadjokes import FILE.json|FILE.csv")
+    }
+
+    file, err := os.Open(args[0])
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    var next func() (Joke, error)
+    switch strings.ToLower(filepath.Ext(args[0])) {
+    case ".json":
+        next, err = jsonJokeReader(file)
+    case ".csv":
+        next, err = csvJokeReader(file)
+    default:
+        err = errors.New("file must be .json or .csv")
+    }
+    if err != nil {
+        return err
+    }
+
+    imported, line := 0, 0
+    batch := make([]Joke, 0, importBatchSize)
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        errs, err := repo.CreateBatch(ctx, batch)
+        if err != nil {
+            return err
+        }
+        for i, err := range errs {
+            if err != nil {
+                slog.Warn("Skipping joke", "joke_text", batch[i].Text, "error", err)
+                continue
+            }
+            imported++
+        }
+        slog.Info("Imported jokes", "count", imported)
+        batch = batch[:0]
+        return nil
+    }
+
+    for {
+        joke, err := next()
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        line++
+        if err != nil {
+            return fmt.Errorf("record %d: %w", line, err)
+        }
+        normalizeJoke(&joke)
+        if err := checkImported(joke); err != nil {
+            slog.Warn("Skipping joke", "record", line, "error", err)
+            continue
+        }
+
+        batch = append(batch, joke)
+        if len(batch) == importBatchSize {
+            if err := flush(); err != nil {
+                return err
+            }
+        }
+    }
+    return flush()
+}
+
+// jsonJokeReader returns a function that decodes the elements of a JSON
+// array one at a time.
+func jsonJokeReader(r io.Reader) (func() (Joke, error), error) {
+    decoder := json.NewDecoder(r)
+    token, err := decoder.Token()
+    if err != nil {
+        return nil, err
+    }
+    if delim, ok := token.(json.Delim); !ok || delim != '[' {
+        return nil, errors.New("expected a JSON array of jokes")
+    }
+
+    return func() (Joke, error) {
+        var joke Joke
+        if !decoder.More() {
+            return joke, io.EOF
+        }
+        err := decoder.Decode(&joke)
+        return joke, err
+    }, nil
+}
+
+// csvJokeReader returns a function that reads jokes from CSV records. The
+// header row names the columns: joke_text is required, author, lang and
+// original_date (RFC 3339) are optional.
+func csvJokeReader(r io.Reader) (func() (Joke, error), error) {
+    reader := csv.NewReader(r)
+    header, err := reader.Read()
+    if err != nil {
+        return nil, err
+    }
+
+    columns := map[string]int{}
+    for i, name := range header {
+        columns[strings.TrimSpace(name)] = i
+    }
+    if _, ok := columns["joke_text"]; !ok {
+        return nil, errors.New("CSV header must include joke_text")
+    }
+
+    field := func(record []string, name string) string {
+        if i, ok := columns[name]; ok && i < len(record) {
+            return record[i]
+        }
+        return ""
+    }
+
+    return func() (Joke, error) {
+        var joke Joke
+        record, err := reader.Read()
+        if err != nil {
+            return joke, err
+        }
+
+        joke.Author = field(record, "author")
+        joke.Text = field(record, "joke_text")
+        joke.Language = field(record, "lang")
+        if date := field(record, "original_date"); date != "" {
+            t, err := time.Parse(time.RFC3339, date)
+            if err != nil {
+                return joke, fmt.Errorf("original_date: %w", err)
+            }
+            joke.OriginalDate = &t
+        }
+        return joke, nil
+    }, nil
+}
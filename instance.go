@@ -0,0 +1,40 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "strconv"
+)
+
+// Instance describes this deployment so aggregators and other clients can
+// discover and attribute public instances.
+type Instance struct {
+    Name     string `json:"name"`
+    URL      string `json:"url"`
+    Contact  string `json:"contact,omitempty"`
+    TermsURL string `json:"terms_url,omitempty"`
+}
+
+// instanceInfo reads the instance metadata from the environment.
+func instanceInfo(request *http.Request) Instance {
+    return Instance{
+        Name:     getEnv("INSTANCE_NAME", "Dad Jokes API"),
+        URL:      baseURL(request),
+        Contact:  os.Getenv("INSTANCE_CONTACT"),
+        TermsURL: os.Getenv("INSTANCE_TERMS_URL"),
+    }
+}
+
+// instanceInResponses reports whether JSON responses should be wrapped in an
+// envelope carrying the instance metadata.
+func instanceInResponses() bool {
+    enabled, _ := strconv.ParseBool(os.Getenv("INSTANCE_IN_RESPONSES"))
+    return enabled
+}
+
+func getInstance(response http.ResponseWriter, request *http.Request) {
+    response.Header().Set("Content-Type", "application/json")
+    response.Header().Set("Cache-Control", "public, max-age=3600")
+    json.NewEncoder(response).Encode(instanceInfo(request))
+}
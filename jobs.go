@@ -0,0 +1,289 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "expvar"
+    "fmt"
+    "log/slog"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // jobBaseBackoff is the wait after a job's first failed attempt. It
+    // doubles with every attempt after that.
+    jobBaseBackoff = 5 * time.Second
+
+    // jobDrainPoll is how often a draining runner checks whether it's done.
+    jobDrainPoll = 50 * time.Millisecond
+)
+
+// errJobQueueFull is returned by Push when the queue can't take more jobs.
+var errJobQueueFull = errors.New("job queue is full")
+
+// Job is a unit of background work: the kind names its handler, and the
+// payload is the handler's argument as JSON, so jobs can be stored outside
+// the process.
+type Job struct {
+    Kind     string          `json:"kind"`
+    Payload  json.RawMessage `json:"payload"`
+    Attempts int             `json:"attempts"`
+    RunAt    time.Time       `json:"run_at"`
+}
+
+// JobQueue holds jobs until a worker takes them. The in-memory queue loses
+// its jobs on restart; a queue backed by Redis or the database can take its
+// place without changing the runner or the jobs.
+type JobQueue interface {
+    // Push adds job, to be run from job.RunAt on.
+    Push(ctx context.Context, job Job) error
+
+    // Pop waits until a job is due and returns it, or returns ctx.Err().
+    Pop(ctx context.Context) (Job, error)
+
+    // Len returns how many jobs are due and waiting for a worker.
+    Len() int
+}
+
+// jobHandler runs one job. An error makes the runner try it again later.
+type jobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// jobHandlers maps job kinds to their handlers.
+var jobHandlers = map[string]jobHandler{
+    "mail.send":   sendMailJob,
+    "notify.send": sendNotificationJob,
+}
+
+// jobs runs background jobs for the server. It's nil in the other commands
+// and on Lambda, where enqueueJob runs jobs right away instead.
+var jobs *jobRunner
+
+var jobMetrics = expvar.NewMap("jobs")
+
+// enqueueJob queues a job of kind with payload, which is encoded as JSON.
+func enqueueJob(ctx context.Context, kind string, payload any) error {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    job := Job{Kind: kind, Payload: data, RunAt: time.Now()}
+    if jobs == nil {
+        return runJob(ctx, job)
+    }
+
+    err = jobs.queue.Push(ctx, job)
+    if err == nil {
+        jobMetrics.Add("queued", 1)
+    }
+    return err
+}
+
+func runJob(ctx context.Context, job Job) error {
+    handler, ok := jobHandlers[job.Kind]
+    if !ok {
+        return fmt.Errorf("no handler for job kind %q", job.Kind)
+    }
+    return handler(ctx, job.Payload)
+}
+
+// jobRunner takes jobs off a queue with a pool of workers, retrying the
+// ones that fail with exponential backoff.
+type jobRunner struct {
+    queue       JobQueue
+    workers     int
+    maxAttempts int
+    timeout     time.Duration
+
+    cancel context.CancelFunc
+    wg     sync.WaitGroup
+    // active counts the jobs being run.
+    active atomic.Int64
+}
+
+// loadJobRunner reads JOB_WORKERS, JOB_MAX_ATTEMPTS, JOB_TIMEOUT and
+// JOB_QUEUE_SIZE.
+func loadJobRunner() (*jobRunner, error) {
+    workers, err := getEnvInt("JOB_WORKERS", 4)
+    if err != nil {
+        return nil, err
+    }
+    maxAttempts, err := getEnvInt("JOB_MAX_ATTEMPTS", 5)
+    if err != nil {
+        return nil, err
+    }
+    timeout, err := getEnvDuration("JOB_TIMEOUT", time.Minute)
+    if err != nil {
+        return nil, err
+    }
+    size, err := getEnvInt("JOB_QUEUE_SIZE", 10000)
+    if err != nil {
+        return nil, err
+    }
+    if workers < 1 || maxAttempts < 1 || size < 1 {
+        return nil, errors.New("JOB_WORKERS, JOB_MAX_ATTEMPTS and JOB_QUEUE_SIZE must be positive")
+    }
+    return &jobRunner{queue: newMemoryJobQueue(size), workers: workers, maxAttempts: maxAttempts, timeout: timeout}, nil
+}
+
+// start starts the workers. They run until drain stops them.
+func (r *jobRunner) start() {
+    ctx, cancel := context.WithCancel(context.Background())
+    r.cancel = cancel
+    for range r.workers {
+        r.wg.Add(1)
+        go r.work(ctx)
+    }
+}
+
+func (r *jobRunner) work(ctx context.Context) {
+    defer r.wg.Done()
+    for {
+        job, err := r.queue.Pop(ctx)
+        if err != nil {
+            return
+        }
+        r.active.Add(1)
+        r.run(job)
+        r.active.Add(-1)
+    }
+}
+
+// run runs job, and queues it again if it fails and has attempts left. The
+// job gets a context of its own rather than the workers', so stopping the
+// workers doesn't cut a job short.
+func (r *jobRunner) run(job Job) {
+    ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+    defer cancel()
+
+    err := runJob(ctx, job)
+    if err == nil {
+        jobMetrics.Add("succeeded", 1)
+        return
+    }
+
+    job.Attempts++
+    if job.Attempts >= r.maxAttempts {
+        jobMetrics.Add("failed", 1)
+        slog.Error("Giving up on job", "kind", job.Kind, "attempts", job.Attempts, "error", err)
+        return
+    }
+    job.RunAt = time.Now().Add(jobBaseBackoff << (job.Attempts - 1))
+    slog.Warn("Error running job", "kind", job.Kind, "attempt", job.Attempts, "retry_at", job.RunAt, "error", err)
+    if err := r.queue.Push(context.Background(), job); err != nil {
+        jobMetrics.Add("failed", 1)
+        slog.Error("Error queueing job retry", "kind", job.Kind, "error", err)
+        return
+    }
+    jobMetrics.Add("retried", 1)
+}
+
+// drain waits for the queued jobs that are due to be run, then stops the
+// workers. Once ctx is done it stops waiting for the queue, but still lets
+// running jobs finish. Retries that aren't due yet are lost with an
+// in-memory queue.
+func (r *jobRunner) drain(ctx context.Context) {
+    ticker := time.NewTicker(jobDrainPoll)
+    defer ticker.Stop()
+    for r.queue.Len() > 0 || r.active.Load() > 0 {
+        select {
+        case <-ctx.Done():
+            slog.Warn("Stopping with jobs left in the queue", "jobs", r.queue.Len())
+            r.cancel()
+            r.wg.Wait()
+            return
+        case <-ticker.C:
+        }
+    }
+    r.cancel()
+    r.wg.Wait()
+}
+
+// memoryJobQueue is a JobQueue in memory. Jobs that aren't due yet wait on
+// a timer and join the queue when it fires.
+type memoryJobQueue struct {
+    size int
+
+    mu      sync.Mutex
+    ready   []Job
+    delayed int
+    // wake holds a token while there may be jobs for a waiting worker.
+    wake chan struct{}
+}
+
+func newMemoryJobQueue(size int) *memoryJobQueue {
+    return &memoryJobQueue{size: size, wake: make(chan struct{}, 1)}
+}
+
+func (q *memoryJobQueue) Push(ctx context.Context, job Job) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.ready)+q.delayed >= q.size {
+        return errJobQueueFull
+    }
+
+    if wait := time.Until(job.RunAt); wait > 0 {
+        q.delayed++
+        time.AfterFunc(wait, func() {
+            q.mu.Lock()
+            defer q.mu.Unlock()
+            q.delayed--
+            q.add(job)
+        })
+        return nil
+    }
+    q.add(job)
+    return nil
+}
+
+// add appends a due job and wakes a worker. q.mu must be held.
+func (q *memoryJobQueue) add(job Job) {
+    q.ready = append(q.ready, job)
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+}
+
+func (q *memoryJobQueue) Pop(ctx context.Context) (Job, error) {
+    for {
+        q.mu.Lock()
+        if len(q.ready) > 0 {
+            job := q.ready[0]
+            q.ready = q.ready[1:]
+            // Pass the token on if there's more, for the next worker.
+            if len(q.ready) > 0 {
+                select {
+                case q.wake <- struct{}{}:
+                default:
+                }
+            }
+            q.mu.Unlock()
+            return job, nil
+        }
+        q.mu.Unlock()
+
+        select {
+        case <-ctx.Done():
+            return Job{}, ctx.Err()
+        case <-q.wake:
+        }
+    }
+}
+
+func (q *memoryJobQueue) Len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.ready)
+}
+
+// sendMailJob sends a mailMessage.
+func sendMailJob(ctx context.Context, payload json.RawMessage) error {
+    var message mailMessage
+    if err := json.Unmarshal(payload, &message); err != nil {
+        return err
+    }
+    return mailer.Send(ctx, message)
+}
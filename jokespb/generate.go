@@ -0,0 +1,5 @@
+// Package jokespb holds the gRPC service definition and the code generated
+// from it.
+package jokespb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative jokes.proto
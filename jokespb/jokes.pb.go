@@ -0,0 +1,486 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: jokes.proto
+
+package jokespb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Joke struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id is the joke's public id, as in the REST API: a number, or an opaque
+	// string when the service obfuscates ids.
+	Id        string                 `protobuf:"bytes,5,opt,name=id,proto3" json:"id,omitempty"`
+	EntryDate *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=entry_date,json=entryDate,proto3" json:"entry_date,omitempty"`
+	Author    string                 `protobuf:"bytes,3,opt,name=author,proto3" json:"author,omitempty"`
+	JokeText  string                 `protobuf:"bytes,4,opt,name=joke_text,json=jokeText,proto3" json:"joke_text,omitempty"`
+	// lang is the BCP 47 tag of the joke's language, e.g. en or pt-BR.
+	Lang          string `protobuf:"bytes,6,opt,name=lang,proto3" json:"lang,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Joke) Reset() {
+	*x = Joke{}
+	mi := &file_jokes_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Joke) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Joke) ProtoMessage() {}
+
+func (x *Joke) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Joke.ProtoReflect.Descriptor instead.
+func (*Joke) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Joke) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Joke) GetEntryDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.EntryDate
+	}
+	return nil
+}
+
+func (x *Joke) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Joke) GetJokeText() string {
+	if x != nil {
+		return x.JokeText
+	}
+	return ""
+}
+
+func (x *Joke) GetLang() string {
+	if x != nil {
+		return x.Lang
+	}
+	return ""
+}
+
+type GetRandomJokeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// lang limits the pick to jokes in a BCP 47 language, like ?lang= does;
+	// empty means any language.
+	Lang          string `protobuf:"bytes,1,opt,name=lang,proto3" json:"lang,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRandomJokeRequest) Reset() {
+	*x = GetRandomJokeRequest{}
+	mi := &file_jokes_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRandomJokeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRandomJokeRequest) ProtoMessage() {}
+
+func (x *GetRandomJokeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRandomJokeRequest.ProtoReflect.Descriptor instead.
+func (*GetRandomJokeRequest) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetRandomJokeRequest) GetLang() string {
+	if x != nil {
+		return x.Lang
+	}
+	return ""
+}
+
+type GetJokeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id is a Joke.id.
+	Id            string `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetJokeRequest) Reset() {
+	*x = GetJokeRequest{}
+	mi := &file_jokes_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetJokeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetJokeRequest) ProtoMessage() {}
+
+func (x *GetJokeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetJokeRequest.ProtoReflect.Descriptor instead.
+func (*GetJokeRequest) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetJokeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ListJokesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// page starts at 1; 0 means the first page.
+	Page int32 `protobuf:"varint,1,opt,name=page,proto3" json:"page,omitempty"`
+	// per_page defaults to 20 and is at most 100.
+	PerPage int32 `protobuf:"varint,2,opt,name=per_page,json=perPage,proto3" json:"per_page,omitempty"`
+	// snapshot is the token from a previous response, to keep paging
+	// through the listing as it was then.
+	Snapshot      string `protobuf:"bytes,3,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJokesRequest) Reset() {
+	*x = ListJokesRequest{}
+	mi := &file_jokes_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJokesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJokesRequest) ProtoMessage() {}
+
+func (x *ListJokesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJokesRequest.ProtoReflect.Descriptor instead.
+func (*ListJokesRequest) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListJokesRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListJokesRequest) GetPerPage() int32 {
+	if x != nil {
+		return x.PerPage
+	}
+	return 0
+}
+
+func (x *ListJokesRequest) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+type ListJokesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jokes         []*Joke                `protobuf:"bytes,1,rep,name=jokes,proto3" json:"jokes,omitempty"`
+	Page          int32                  `protobuf:"varint,2,opt,name=page,proto3" json:"page,omitempty"`
+	PerPage       int32                  `protobuf:"varint,3,opt,name=per_page,json=perPage,proto3" json:"per_page,omitempty"`
+	Snapshot      string                 `protobuf:"bytes,4,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJokesResponse) Reset() {
+	*x = ListJokesResponse{}
+	mi := &file_jokes_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJokesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJokesResponse) ProtoMessage() {}
+
+func (x *ListJokesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJokesResponse.ProtoReflect.Descriptor instead.
+func (*ListJokesResponse) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListJokesResponse) GetJokes() []*Joke {
+	if x != nil {
+		return x.Jokes
+	}
+	return nil
+}
+
+func (x *ListJokesResponse) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListJokesResponse) GetPerPage() int32 {
+	if x != nil {
+		return x.PerPage
+	}
+	return 0
+}
+
+func (x *ListJokesResponse) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+type SubmitJokeRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Author   string                 `protobuf:"bytes,1,opt,name=author,proto3" json:"author,omitempty"`
+	JokeText string                 `protobuf:"bytes,2,opt,name=joke_text,json=jokeText,proto3" json:"joke_text,omitempty"`
+	// lang is the BCP 47 tag of the joke's language, en when empty.
+	Lang          string `protobuf:"bytes,3,opt,name=lang,proto3" json:"lang,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitJokeRequest) Reset() {
+	*x = SubmitJokeRequest{}
+	mi := &file_jokes_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitJokeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitJokeRequest) ProtoMessage() {}
+
+func (x *SubmitJokeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_jokes_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitJokeRequest.ProtoReflect.Descriptor instead.
+func (*SubmitJokeRequest) Descriptor() ([]byte, []int) {
+	return file_jokes_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SubmitJokeRequest) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *SubmitJokeRequest) GetJokeText() string {
+	if x != nil {
+		return x.JokeText
+	}
+	return ""
+}
+
+func (x *SubmitJokeRequest) GetLang() string {
+	if x != nil {
+		return x.Lang
+	}
+	return ""
+}
+
+var File_jokes_proto protoreflect.FileDescriptor
+
+const file_jokes_proto_rawDesc = "" +
+	"\n" +
+	"\vjokes.proto\x12\vdadjokes.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa0\x01\n" +
+	"\x04Joke\x12\x0e\n" +
+	"\x02id\x18\x05 \x01(\tR\x02id\x129\n" +
+	"\n" +
+	"entry_date\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tentryDate\x12\x16\n" +
+	"\x06author\x18\x03 \x01(\tR\x06author\x12\x1b\n" +
+	"\tjoke_text\x18\x04 \x01(\tR\bjokeText\x12\x12\n" +
+	"\x04lang\x18\x06 \x01(\tR\x04langJ\x04\b\x01\x10\x02\"*\n" +
+	"\x14GetRandomJokeRequest\x12\x12\n" +
+	"\x04lang\x18\x01 \x01(\tR\x04lang\"&\n" +
+	"\x0eGetJokeRequest\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02idJ\x04\b\x01\x10\x02\"]\n" +
+	"\x10ListJokesRequest\x12\x12\n" +
+	"\x04page\x18\x01 \x01(\x05R\x04page\x12\x19\n" +
+	"\bper_page\x18\x02 \x01(\x05R\aperPage\x12\x1a\n" +
+	"\bsnapshot\x18\x03 \x01(\tR\bsnapshot\"\x87\x01\n" +
+	"\x11ListJokesResponse\x12'\n" +
+	"\x05jokes\x18\x01 \x03(\v2\x11.dadjokes.v1.JokeR\x05jokes\x12\x12\n" +
+	"\x04page\x18\x02 \x01(\x05R\x04page\x12\x19\n" +
+	"\bper_page\x18\x03 \x01(\x05R\aperPage\x12\x1a\n" +
+	"\bsnapshot\x18\x04 \x01(\tR\bsnapshot\"\\\n" +
+	"\x11SubmitJokeRequest\x12\x16\n" +
+	"\x06author\x18\x01 \x01(\tR\x06author\x12\x1b\n" +
+	"\tjoke_text\x18\x02 \x01(\tR\bjokeText\x12\x12\n" +
+	"\x04lang\x18\x03 \x01(\tR\x04lang2\x96\x02\n" +
+	"\x05Jokes\x12E\n" +
+	"\rGetRandomJoke\x12!.dadjokes.v1.GetRandomJokeRequest\x1a\x11.dadjokes.v1.Joke\x129\n" +
+	"\aGetJoke\x12\x1b.dadjokes.v1.GetJokeRequest\x1a\x11.dadjokes.v1.Joke\x12J\n" +
+	"\tListJokes\x12\x1d.dadjokes.v1.ListJokesRequest\x1a\x1e.dadjokes.v1.ListJokesResponse\x12?\n" +
+	"\n" +
+	"SubmitJoke\x12\x1e.dadjokes.v1.SubmitJokeRequest\x1a\x11.dadjokes.v1.JokeB\x17Z\x15test/dadjokes/jokespbb\x06proto3"
+
+var (
+	file_jokes_proto_rawDescOnce sync.Once
+	file_jokes_proto_rawDescData []byte
+)
+
+func file_jokes_proto_rawDescGZIP() []byte {
+	file_jokes_proto_rawDescOnce.Do(func() {
+		file_jokes_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_jokes_proto_rawDesc), len(file_jokes_proto_rawDesc)))
+	})
+	return file_jokes_proto_rawDescData
+}
+
+var file_jokes_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_jokes_proto_goTypes = []any{
+	(*Joke)(nil),                  // 0: dadjokes.v1.Joke
+	(*GetRandomJokeRequest)(nil),  // 1: dadjokes.v1.GetRandomJokeRequest
+	(*GetJokeRequest)(nil),        // 2: dadjokes.v1.GetJokeRequest
+	(*ListJokesRequest)(nil),      // 3: dadjokes.v1.ListJokesRequest
+	(*ListJokesResponse)(nil),     // 4: dadjokes.v1.ListJokesResponse
+	(*SubmitJokeRequest)(nil),     // 5: dadjokes.v1.SubmitJokeRequest
+	(*timestamppb.Timestamp)(nil), // 6: google.protobuf.Timestamp
+}
+var file_jokes_proto_depIdxs = []int32{
+	6, // 0: dadjokes.v1.Joke.entry_date:type_name -> google.protobuf.Timestamp
+	0, // 1: dadjokes.v1.ListJokesResponse.jokes:type_name -> dadjokes.v1.Joke
+	1, // 2: dadjokes.v1.Jokes.GetRandomJoke:input_type -> dadjokes.v1.GetRandomJokeRequest
+	2, // 3: dadjokes.v1.Jokes.GetJoke:input_type -> dadjokes.v1.GetJokeRequest
+	3, // 4: dadjokes.v1.Jokes.ListJokes:input_type -> dadjokes.v1.ListJokesRequest
+	5, // 5: dadjokes.v1.Jokes.SubmitJoke:input_type -> dadjokes.v1.SubmitJokeRequest
+	0, // 6: dadjokes.v1.Jokes.GetRandomJoke:output_type -> dadjokes.v1.Joke
+	0, // 7: dadjokes.v1.Jokes.GetJoke:output_type -> dadjokes.v1.Joke
+	4, // 8: dadjokes.v1.Jokes.ListJokes:output_type -> dadjokes.v1.ListJokesResponse
+	0, // 9: dadjokes.v1.Jokes.SubmitJoke:output_type -> dadjokes.v1.Joke
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_jokes_proto_init() }
+func file_jokes_proto_init() {
+	if File_jokes_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_jokes_proto_rawDesc), len(file_jokes_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_jokes_proto_goTypes,
+		DependencyIndexes: file_jokes_proto_depIdxs,
+		MessageInfos:      file_jokes_proto_msgTypes,
+	}.Build()
+	File_jokes_proto = out.File
+	file_jokes_proto_goTypes = nil
+	file_jokes_proto_depIdxs = nil
+}
@@ -0,0 +1,257 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: jokes.proto
+
+package jokespb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Jokes_GetRandomJoke_FullMethodName = "/dadjokes.v1.Jokes/GetRandomJoke"
+	Jokes_GetJoke_FullMethodName       = "/dadjokes.v1.Jokes/GetJoke"
+	Jokes_ListJokes_FullMethodName     = "/dadjokes.v1.Jokes/ListJokes"
+	Jokes_SubmitJoke_FullMethodName    = "/dadjokes.v1.Jokes/SubmitJoke"
+)
+
+// JokesClient is the client API for Jokes service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Jokes mirrors the REST API's read and submit endpoints.
+type JokesClient interface {
+	// GetRandomJoke returns a randomly selected joke, like GET /random. It
+	// fails with INVALID_ARGUMENT when lang isn't a language tag and with
+	// NOT_FOUND when there are no jokes in it.
+	GetRandomJoke(ctx context.Context, in *GetRandomJokeRequest, opts ...grpc.CallOption) (*Joke, error)
+	// GetJoke returns a joke by id, like GET /jokes/{id}. It fails with
+	// NOT_FOUND when there is no such joke or the id isn't valid.
+	GetJoke(ctx context.Context, in *GetJokeRequest, opts ...grpc.CallOption) (*Joke, error)
+	// ListJokes pages through jokes newest first, like GET /jokes.
+	ListJokes(ctx context.Context, in *ListJokesRequest, opts ...grpc.CallOption) (*ListJokesResponse, error)
+	// SubmitJoke stores a new joke, like POST /write. It fails with
+	// INVALID_ARGUMENT when the joke breaks the submission limits and with
+	// ALREADY_EXISTS when the same joke has been submitted before.
+	SubmitJoke(ctx context.Context, in *SubmitJokeRequest, opts ...grpc.CallOption) (*Joke, error)
+}
+
+type jokesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewJokesClient(cc grpc.ClientConnInterface) JokesClient {
+	return &jokesClient{cc}
+}
+
+func (c *jokesClient) GetRandomJoke(ctx context.Context, in *GetRandomJokeRequest, opts ...grpc.CallOption) (*Joke, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Joke)
+	err := c.cc.Invoke(ctx, Jokes_GetRandomJoke_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jokesClient) GetJoke(ctx context.Context, in *GetJokeRequest, opts ...grpc.CallOption) (*Joke, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Joke)
+	err := c.cc.Invoke(ctx, Jokes_GetJoke_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jokesClient) ListJokes(ctx context.Context, in *ListJokesRequest, opts ...grpc.CallOption) (*ListJokesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListJokesResponse)
+	err := c.cc.Invoke(ctx, Jokes_ListJokes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jokesClient) SubmitJoke(ctx context.Context, in *SubmitJokeRequest, opts ...grpc.CallOption) (*Joke, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Joke)
+	err := c.cc.Invoke(ctx, Jokes_SubmitJoke_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// JokesServer is the server API for Jokes service.
+// All implementations must embed UnimplementedJokesServer
+// for forward compatibility.
+//
+// Jokes mirrors the REST API's read and submit endpoints.
+type JokesServer interface {
+	// GetRandomJoke returns a randomly selected joke, like GET /random. It
+	// fails with INVALID_ARGUMENT when lang isn't a language tag and with
+	// NOT_FOUND when there are no jokes in it.
+	GetRandomJoke(context.Context, *GetRandomJokeRequest) (*Joke, error)
+	// GetJoke returns a joke by id, like GET /jokes/{id}. It fails with
+	// NOT_FOUND when there is no such joke or the id isn't valid.
+	GetJoke(context.Context, *GetJokeRequest) (*Joke, error)
+	// ListJokes pages through jokes newest first, like GET /jokes.
+	ListJokes(context.Context, *ListJokesRequest) (*ListJokesResponse, error)
+	// SubmitJoke stores a new joke, like POST /write. It fails with
+	// INVALID_ARGUMENT when the joke breaks the submission limits and with
+	// ALREADY_EXISTS when the same joke has been submitted before.
+	SubmitJoke(context.Context, *SubmitJokeRequest) (*Joke, error)
+	mustEmbedUnimplementedJokesServer()
+}
+
+// UnimplementedJokesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedJokesServer struct{}
+
+func (UnimplementedJokesServer) GetRandomJoke(context.Context, *GetRandomJokeRequest) (*Joke, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRandomJoke not implemented")
+}
+func (UnimplementedJokesServer) GetJoke(context.Context, *GetJokeRequest) (*Joke, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetJoke not implemented")
+}
+func (UnimplementedJokesServer) ListJokes(context.Context, *ListJokesRequest) (*ListJokesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListJokes not implemented")
+}
+func (UnimplementedJokesServer) SubmitJoke(context.Context, *SubmitJokeRequest) (*Joke, error) {
+	return nil, status.Error(codes.Unimplemented, "method SubmitJoke not implemented")
+}
+func (UnimplementedJokesServer) mustEmbedUnimplementedJokesServer() {}
+func (UnimplementedJokesServer) testEmbeddedByValue()               {}
+
+// UnsafeJokesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to JokesServer will
+// result in compilation errors.
+type UnsafeJokesServer interface {
+	mustEmbedUnimplementedJokesServer()
+}
+
+func RegisterJokesServer(s grpc.ServiceRegistrar, srv JokesServer) {
+	// If the following call panics, it indicates UnimplementedJokesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Jokes_ServiceDesc, srv)
+}
+
+func _Jokes_GetRandomJoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRandomJokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JokesServer).GetRandomJoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Jokes_GetRandomJoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JokesServer).GetRandomJoke(ctx, req.(*GetRandomJokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Jokes_GetJoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JokesServer).GetJoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Jokes_GetJoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JokesServer).GetJoke(ctx, req.(*GetJokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Jokes_ListJokes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJokesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JokesServer).ListJokes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Jokes_ListJokes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JokesServer).ListJokes(ctx, req.(*ListJokesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Jokes_SubmitJoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitJokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JokesServer).SubmitJoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Jokes_SubmitJoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JokesServer).SubmitJoke(ctx, req.(*SubmitJokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Jokes_ServiceDesc is the grpc.ServiceDesc for Jokes service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Jokes_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dadjokes.v1.Jokes",
+	HandlerType: (*JokesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRandomJoke",
+			Handler:    _Jokes_GetRandomJoke_Handler,
+		},
+		{
+			MethodName: "GetJoke",
+			Handler:    _Jokes_GetJoke_Handler,
+		},
+		{
+			MethodName: "ListJokes",
+			Handler:    _Jokes_ListJokes_Handler,
+		},
+		{
+			MethodName: "SubmitJoke",
+			Handler:    _Jokes_SubmitJoke_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "jokes.proto",
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// dayLayout formats the calendar days jokes of the day are picked for. Days
+// are in UTC, so every instance switches at the same moment.
+const dayLayout = time.DateOnly
+
+// DailyJokeRepository stores the joke of the day history and the schedule
+// of upcoming picks.
+type DailyJokeRepository interface {
+    // DailyJoke returns the joke picked for day, or errJokeNotFound when none
+    // was or it has been hidden or deleted since.
+    DailyJoke(ctx context.Context, day string) (Joke, error)
+
+    // PickDailyJoke picks the joke for day and records it: the joke
+    // scheduled for day if there is one and it's still visible, otherwise one
+    // chosen using seed. If a joke was already recorded for day, by this or
+    // another instance, that one is returned instead, unless it has been
+    // hidden or deleted since; then the day is picked again.
+    PickDailyJoke(ctx context.Context, day string, seed uint64) (Joke, error)
+
+    // ScheduledJokes returns the schedule from day on, by day. Entries whose
+    // joke no longer exists or is hidden are marked Missing.
+    ScheduledJokes(ctx context.Context, day string) ([]ScheduledJoke, error)
+
+    // ScheduleJokes adds entries to the schedule, replacing whatever was
+    // scheduled for the same days.
+    ScheduleJokes(ctx context.Context, entries []ScheduledJoke) error
+
+    // UnscheduleJoke removes the entry for day, or returns
+    // errNotScheduled.
+    UnscheduleJoke(ctx context.Context, day string) error
+}
+
+var daily DailyJokeRepository
+
+// jokeOfTheDay is the response of GET /joke-of-the-day.
+type jokeOfTheDay struct {
+    Date string `json:"date"`
+    Joke Joke   `json:"joke"`
+}
+
+// todaysJoke caches the joke of the current day, so it's only looked up once
+// a day.
+var todaysJoke struct {
+    sync.Mutex
+    day  string
+    joke Joke
+}
+
+// daySeed derives the selection seed from the day alone, so instances that
+// share a database pick the same joke even if they race to record it.
+func daySeed(day string) uint64 {
+    sum := sha256.Sum256([]byte(day))
+    return binary.BigEndian.Uint64(sum[:8])
+}
+
+// dailyJoke returns the joke of the day for day, picking it when day is
+// today and it hasn't been picked yet.
+func dailyJoke(ctx context.Context, day string) (Joke, error) {
+    today := time.Now().UTC().Format(dayLayout)
+    if day != today {
+        return daily.DailyJoke(ctx, day)
+    }
+
+    todaysJoke.Lock()
+    defer todaysJoke.Unlock()
+    if todaysJoke.day == today {
+        return todaysJoke.joke, nil
+    }
+
+    joke, err := daily.PickDailyJoke(ctx, today, daySeed(today))
+    if err != nil {
+        return joke, err
+    }
+    todaysJoke.day, todaysJoke.joke = today, joke
+    return joke, nil
+}
+
+// forgetTodaysJoke drops today's joke from the cache if it's the joke with
+// the given id, so the day is picked again.
+func forgetTodaysJoke(id int) {
+    todaysJoke.Lock()
+    defer todaysJoke.Unlock()
+    if todaysJoke.joke.Id == id {
+        todaysJoke.day = ""
+    }
+}
+
+// getJokeOfTheDay serves today's joke, or with ?date=YYYY-MM-DD the joke of
+// a past day.
+func getJokeOfTheDay(response http.ResponseWriter, request *http.Request) {
+    day := time.Now().UTC().Format(dayLayout)
+    if param := request.URL.Query().Get("date"); param != "" {
+        date, err := time.Parse(dayLayout, param)
+        if err != nil {
+            writeError(response, http.StatusBadRequest, "date must be YYYY-MM-DD")
+            return
+        }
+        if date.Format(dayLayout) > day {
+            writeError(response, http.StatusBadRequest, "date is in the future")
+            return
+        }
+        day = date.Format(dayLayout)
+    }
+
+    joke, err := dailyJoke(request.Context(), day)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, "no joke of the day for "+day)
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    // The joke of a day doesn't change once picked, so clients and CDNs can
+    // keep it until the day is over. The ETag covers the query string too,
+    // since ?fields= changes the body.
+    value := jokeOfTheDay{Date: day, Joke: joke}
+    data, _ := json.Marshal(value)
+    sum := sha256.Sum256(append(data, request.URL.RawQuery...))
+    etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+    picked, _ := time.Parse(dayLayout, day)
+
+    maxAge := 24 * time.Hour
+    if day == time.Now().UTC().Format(dayLayout) {
+        maxAge = time.Until(picked.Add(24 * time.Hour))
+    }
+    response.Header().Set("ETag", etag)
+    response.Header().Set("Last-Modified", picked.Format(http.TimeFormat))
+    response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+
+    if notModified(request, etag, picked) {
+        response.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, value)
+}
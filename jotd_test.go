@@ -0,0 +1,65 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// TestJokeOfTheDayRemoved deletes today's joke and a scheduled one. Today is
+// picked again and the schedule entry is marked missing.
+func TestJokeOfTheDayRemoved(t *testing.T) {
+    server := newTestServer(t)
+    createTestJokes(t, repo, 3)
+
+    today := func() jokeRef {
+        t.Helper()
+        status, data := testRequest(t, server, "GET", "/v1/joke-of-the-day", "", false)
+        if status != http.StatusOK {
+            t.Fatalf("got status %d getting the joke of the day: %s", status, data)
+        }
+        var value struct {
+            Joke struct {
+                Id jokeRef `json:"id"`
+            } `json:"joke"`
+        }
+        if err := json.Unmarshal(data, &value); err != nil {
+            t.Fatal(err)
+        }
+        return value.Joke.Id
+    }
+    remove := func(id jokeRef) {
+        t.Helper()
+        if status, data := testRequest(t, server, "DELETE", "/v1/admin/jokes/"+id.String(), "", true); status != http.StatusNoContent {
+            t.Fatalf("got status %d deleting joke %s: %s", status, id, data)
+        }
+    }
+
+    picked := today()
+    remove(picked)
+    if repicked := today(); repicked == picked {
+        t.Errorf("joke %s is still the joke of the day after it was deleted", picked)
+    }
+
+    tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(dayLayout)
+    scheduled := today()
+    body := fmt.Sprintf(`[{"date": %q, "joke_id": %q}]`, tomorrow, scheduled)
+    if status, data := testRequest(t, server, "PUT", "/v1/admin/schedule", body, true); status != http.StatusOK {
+        t.Fatalf("got status %d scheduling joke %s: %s", status, scheduled, data)
+    }
+    remove(scheduled)
+
+    status, data := testRequest(t, server, "GET", "/v1/admin/schedule", "", true)
+    if status != http.StatusOK {
+        t.Fatalf("got status %d getting the schedule: %s", status, data)
+    }
+    var schedule []ScheduledJoke
+    if err := json.Unmarshal(data, &schedule); err != nil {
+        t.Fatal(err)
+    }
+    if len(schedule) != 1 || !schedule[0].Missing {
+        t.Errorf("schedule = %+v, want the deleted joke's entry marked missing", schedule)
+    }
+}
@@ -0,0 +1,153 @@
+//go:build lambda
+
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "io"
+    "mime"
+    "net/http"
+    "net/url"
+    "strings"
+
+    "github.com/aws/aws-lambda-go/events"
+    "github.com/aws/aws-lambda-go/lambda"
+)
+
+// serveLambda runs the HTTP API as an AWS Lambda function behind API
+// Gateway. Every invocation is one request, turned into an *http.Request and
+// passed through the same handler the server uses. Both the REST API proxy
+// events and the HTTP API payload format 2.0 are accepted.
+//
+// The background jobs (federation pulls, webhook deliveries, quality
+// reports) don't run: the function is frozen between invocations. Nor does
+// the joke stream, since responses are returned whole.
+func serveLambda(ctx context.Context, store storage) {
+    streamingEnabled = false
+    handler := newHandler(ctx, store)
+
+    lambda.StartWithOptions(func(ctx context.Context, event json.RawMessage) (any, error) {
+        var version struct {
+            Version string `json:"version"`
+        }
+        if err := json.Unmarshal(event, &version); err != nil {
+            return nil, err
+        }
+
+        if version.Version == "2.0" {
+            var request events.APIGatewayV2HTTPRequest
+            if err := json.Unmarshal(event, &request); err != nil {
+                return nil, err
+            }
+            return handleHTTPAPIEvent(ctx, handler, request)
+        }
+        var request events.APIGatewayProxyRequest
+        if err := json.Unmarshal(event, &request); err != nil {
+            return nil, err
+        }
+        return handleRESTAPIEvent(ctx, handler, request)
+    }, lambda.WithContext(ctx))
+}
+
+// handleHTTPAPIEvent serves an HTTP API (payload format 2.0) event.
+func handleHTTPAPIEvent(ctx context.Context, handler http.Handler, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+    // Stages other than $default are part of the path.
+    path := event.RawPath
+    if stage := event.RequestContext.Stage; stage != "" && stage != "$default" {
+        path = strings.TrimPrefix(path, "/"+stage)
+    }
+
+    body, err := lambdaBody(event.Body, event.IsBase64Encoded)
+    if err != nil {
+        return events.APIGatewayV2HTTPResponse{}, err
+    }
+    request, err := http.NewRequestWithContext(ctx, event.RequestContext.HTTP.Method, path+"?"+event.RawQueryString, body)
+    if err != nil {
+        return events.APIGatewayV2HTTPResponse{}, err
+    }
+    for name, value := range event.Headers {
+        request.Header.Set(name, value)
+    }
+    if len(event.Cookies) > 0 {
+        request.Header.Set("Cookie", strings.Join(event.Cookies, "; "))
+    }
+    request.Host = event.RequestContext.DomainName
+    request.RemoteAddr = event.RequestContext.HTTP.SourceIP + ":0"
+
+    recorder := newResponseRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    response := events.APIGatewayV2HTTPResponse{StatusCode: recorder.status, Headers: map[string]string{}}
+    for name, values := range recorder.header {
+        if name == "Set-Cookie" {
+            response.Cookies = values
+            continue
+        }
+        response.Headers[name] = strings.Join(values, ", ")
+    }
+    response.Body, response.IsBase64Encoded = lambdaResponseBody(recorder)
+    return response, nil
+}
+
+// handleRESTAPIEvent serves a REST API proxy integration event.
+func handleRESTAPIEvent(ctx context.Context, handler http.Handler, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+    body, err := lambdaBody(event.Body, event.IsBase64Encoded)
+    if err != nil {
+        return events.APIGatewayProxyResponse{}, err
+    }
+
+    query := url.Values(event.MultiValueQueryStringParameters)
+    if len(query) == 0 {
+        query = url.Values{}
+        for name, value := range event.QueryStringParameters {
+            query.Set(name, value)
+        }
+    }
+    request, err := http.NewRequestWithContext(ctx, event.HTTPMethod, event.Path+"?"+query.Encode(), body)
+    if err != nil {
+        return events.APIGatewayProxyResponse{}, err
+    }
+    for name, value := range event.Headers {
+        request.Header.Set(name, value)
+    }
+    for name, values := range event.MultiValueHeaders {
+        request.Header[http.CanonicalHeaderKey(name)] = values
+    }
+    request.Host = event.RequestContext.DomainName
+    request.RemoteAddr = event.RequestContext.Identity.SourceIP + ":0"
+
+    recorder := newResponseRecorder()
+    handler.ServeHTTP(recorder, request)
+
+    response := events.APIGatewayProxyResponse{StatusCode: recorder.status, MultiValueHeaders: recorder.header}
+    response.Body, response.IsBase64Encoded = lambdaResponseBody(recorder)
+    return response, nil
+}
+
+// lambdaBody returns the request body of an event, which API Gateway
+// base64 encodes when it isn't text.
+func lambdaBody(body string, base64Encoded bool) (io.Reader, error) {
+    if !base64Encoded {
+        return strings.NewReader(body), nil
+    }
+    data, err := base64.StdEncoding.DecodeString(body)
+    return bytes.NewReader(data), err
+}
+
+// lambdaResponseBody returns the recorded body for the response event,
+// base64 encoded unless it's text.
+func lambdaResponseBody(recorder *responseRecorder) (string, bool) {
+    mediaType, _, _ := mime.ParseMediaType(recorder.header.Get("Content-Type"))
+    textual := strings.HasPrefix(mediaType, "text/") ||
+        strings.HasSuffix(mediaType, "json") ||
+        strings.HasSuffix(mediaType, "xml") ||
+        strings.HasSuffix(mediaType, "yaml") ||
+        mediaType == "application/sql" || mediaType == "application/javascript"
+    if textual && recorder.header.Get("Content-Encoding") == "" {
+        return recorder.body.String(), false
+    }
+    return base64.StdEncoding.EncodeToString(recorder.body.Bytes()), true
+}
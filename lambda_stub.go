@@ -0,0 +1,11 @@
+//go:build !lambda
+
+package main
+
+import "context"
+
+// serveLambda is only available in binaries built with -tags lambda, so the
+// AWS runtime isn't linked into regular builds.
+func serveLambda(ctx context.Context, store storage) {
+    fatal("Error starting Lambda function", "error", "this binary was built without -tags lambda")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "sync"
+    "time"
+
+    "golang.org/x/text/language"
+)
+
+// defaultLanguage is the language of jokes submitted without one, and of
+// every joke stored before jokes had a language.
+const defaultLanguage = "en"
+
+// maxLanguageLength is the longest language tag stored; the column is sized
+// for it.
+const maxLanguageLength = 35
+
+// languagesTTL is how long the languages jokes are available in are cached
+// for Accept-Language negotiation. A joke in a new language is negotiated
+// once it expires; ?lang= finds it right away.
+const languagesTTL = time.Minute
+
+var errBadLanguage = errors.New("lang must be a BCP 47 language tag, like en or pt-BR")
+
+// canonicalLanguage returns the canonical form of a BCP 47 tag, e.g. pt-BR
+// for pt-br, and whether it's valid. An empty tag is the default language.
+func canonicalLanguage(tag string) (string, bool) {
+    if tag == "" {
+        return defaultLanguage, true
+    }
+    parsed, err := language.Parse(tag)
+    if err != nil || len(parsed.String()) > maxLanguageLength {
+        return tag, false
+    }
+    return parsed.String(), true
+}
+
+// requestLanguage returns the language the jokes served to request should be
+// in: the one named by ?lang=, or else the best match for Accept-Language
+// among the languages there are jokes in. It's empty, for any language, when
+// the client asks for none or none matches. A malformed ?lang= returns
+// errBadLanguage.
+func requestLanguage(request *http.Request) (string, error) {
+    if lang := request.URL.Query().Get("lang"); lang != "" {
+        canonical, ok := canonicalLanguage(lang)
+        if !ok {
+            return "", errBadLanguage
+        }
+        return canonical, nil
+    }
+
+    accept := request.Header.Get("Accept-Language")
+    if accept == "" {
+        return "", nil
+    }
+    desired, _, err := language.ParseAcceptLanguage(accept)
+    if err != nil || len(desired) == 0 {
+        return "", nil
+    }
+    available, err := jokeLanguages.get(request.Context())
+    if err != nil || len(available) == 0 {
+        return "", err
+    }
+
+    tags := make([]language.Tag, len(available))
+    for i, lang := range available {
+        tags[i] = language.Make(lang)
+    }
+    _, index, confidence := language.NewMatcher(tags).Match(desired...)
+    if confidence == language.No {
+        return "", nil
+    }
+    return available[index], nil
+}
+
+// languageCache holds the languages of the visible jokes, reloaded from the
+// repository once they're older than languagesTTL.
+type languageCache struct {
+    mu       sync.Mutex
+    langs    []string
+    loadedAt time.Time
+}
+
+var jokeLanguages = &languageCache{}
+
+func (c *languageCache) get(ctx context.Context) ([]string, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.langs != nil && time.Since(c.loadedAt) < languagesTTL {
+        return c.langs, nil
+    }
+    langs, err := repo.Languages(ctx)
+    if err != nil {
+        return nil, err
+    }
+    c.langs, c.loadedAt = langs, time.Now()
+    return langs, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// authorColumnLength is the size of the author columns; MAX_AUTHOR_LENGTH
+// can't go above it.
+const authorColumnLength = 255
+
+// Character policies for joke and author text.
+const (
+    // charactersAny accepts any valid UTF-8.
+    charactersAny = "any"
+
+    // charactersPrintable accepts letters, marks, numbers, punctuation,
+    // symbols and spaces, plus newlines in jokes and the joiners, direction
+    // marks and tags emoji and right-to-left text need. Control characters
+    // and the bidi embedding, override and isolate controls are rejected.
+    charactersPrintable = "printable"
+
+    // charactersASCII accepts printable ASCII, plus newlines in jokes.
+    charactersASCII = "ascii"
+)
+
+// jokeLimits are the rules submitted jokes are checked against. Lengths are
+// counted in characters, after surrounding whitespace is trimmed.
+type jokeLimits struct {
+    MaxAuthorLength   int    `json:"max_author_length"`
+    MinJokeLength     int    `json:"min_joke_length"`
+    MaxJokeLength     int    `json:"max_joke_length"`
+    AllowedCharacters string `json:"allowed_characters"`
+}
+
+// limits holds the limits loaded from the environment at startup.
+var limits jokeLimits
+
+// loadLimits reads the limits from MAX_AUTHOR_LENGTH, MIN_JOKE_LENGTH,
+// MAX_JOKE_LENGTH and JOKE_CHARACTERS.
+func loadLimits() (jokeLimits, error) {
+    var l jokeLimits
+    var err error
+
+    l.MaxAuthorLength, err = getEnvInt("MAX_AUTHOR_LENGTH", authorColumnLength)
+    if err != nil {
+        return l, err
+    }
+    l.MinJokeLength, err = getEnvInt("MIN_JOKE_LENGTH", 1)
+    if err != nil {
+        return l, err
+    }
+    l.MaxJokeLength, err = getEnvInt("MAX_JOKE_LENGTH", 2000)
+    if err != nil {
+        return l, err
+    }
+    l.AllowedCharacters = getEnv("JOKE_CHARACTERS", charactersPrintable)
+
+    switch {
+    case l.MaxAuthorLength < 1 || l.MaxAuthorLength > authorColumnLength:
+        return l, fmt.Errorf("MAX_AUTHOR_LENGTH must be between 1 and %d", authorColumnLength)
+    case l.MinJokeLength < 1:
+        return l, fmt.Errorf("MIN_JOKE_LENGTH must be at least 1")
+    case l.MaxJokeLength < l.MinJokeLength:
+        return l, fmt.Errorf("MAX_JOKE_LENGTH must be at least MIN_JOKE_LENGTH")
+    }
+    switch l.AllowedCharacters {
+    case charactersAny, charactersPrintable, charactersASCII:
+    default:
+        return l, fmt.Errorf("JOKE_CHARACTERS must be %s, %s or %s", charactersAny, charactersPrintable, charactersASCII)
+    }
+    return l, nil
+}
+
+// check returns every way joke breaks the limits as validationErrors, or nil
+// if it doesn't.
+func (l jokeLimits) check(joke Joke) error {
+    text := strings.TrimSpace(joke.Text)
+    author := strings.TrimSpace(joke.Author)
+
+    var problems validationErrors
+    switch length := utf8.RuneCountInString(text); {
+    case length == 0:
+        problems.add("joke_text", "is required")
+    case length < l.MinJokeLength:
+        problems.add("joke_text", "must be at least %d characters", l.MinJokeLength)
+    case length > l.MaxJokeLength:
+        problems.add("joke_text", "must be at most %d characters", l.MaxJokeLength)
+    case !l.allowed(text, true):
+        problems.add("joke_text", "contains characters that aren't allowed")
+    }
+
+    switch {
+    case utf8.RuneCountInString(author) > l.MaxAuthorLength:
+        problems.add("author", "must be at most %d characters", l.MaxAuthorLength)
+    case !l.allowed(author, false):
+        problems.add("author", "contains characters that aren't allowed")
+    }
+
+    if _, ok := canonicalLanguage(joke.Language); !ok {
+        problems.add("lang", "must be a BCP 47 language tag, like en or pt-BR")
+    }
+    return problems.err()
+}
+
+// allowed reports whether every character of s is allowed by the character
+// policy. Newlines are only allowed when multiline is set.
+func (l jokeLimits) allowed(s string, multiline bool) bool {
+    if !utf8.ValidString(s) {
+        return false
+    }
+    if l.AllowedCharacters == charactersAny {
+        return true
+    }
+
+    for _, r := range s {
+        switch {
+        case r == '\n' || r == '\r':
+            if !multiline {
+                return false
+            }
+        case l.AllowedCharacters == charactersASCII:
+            if r < ' ' || r > '~' {
+                return false
+            }
+        case isBidiControl(r):
+            return false
+        case !unicode.IsPrint(r) && !unicode.IsSpace(r) && !isInvisibleFormat(r):
+            return false
+        }
+    }
+    return true
+}
+
+// getLimits serves the limits so clients can check jokes before submitting
+// them.
+func getLimits(response http.ResponseWriter, request *http.Request) {
+    writeJSON(response, request, http.StatusOK, limits)
+}
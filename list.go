@@ -0,0 +1,130 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+const (
+    defaultPerPage = 20
+    maxPerPage     = 100
+)
+
+// jokePage is a page of a joke listing. Snapshot pins the listing to the
+// jokes that existed when the first page was fetched; passing it back with
+// later pages keeps jokes submitted mid-crawl from shifting rows between
+// pages and showing up twice.
+type jokePage struct {
+    Jokes    []Joke `json:"jokes"`
+    Page     int    `json:"page"`
+    PerPage  int    `json:"per_page"`
+    Snapshot string `json:"snapshot"`
+}
+
+var errBadSnapshot = errors.New("invalid snapshot token")
+
+// encodeSnapshot turns a max-id fence into an opaque token, so clients don't
+// come to rely on its contents.
+func encodeSnapshot(maxID int) string {
+    return base64.RawURLEncoding.EncodeToString([]byte("v1:" + strconv.Itoa(maxID)))
+}
+
+func decodeSnapshot(token string) (int, error) {
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return 0, errBadSnapshot
+    }
+
+    version, value, ok := strings.Cut(string(data), ":")
+    if !ok || version != "v1" {
+        return 0, errBadSnapshot
+    }
+
+    maxID, err := strconv.Atoi(value)
+    if err != nil || maxID < 0 {
+        return 0, errBadSnapshot
+    }
+    return maxID, nil
+}
+
+// pagination reads ?page= and ?per_page=, clamped to sensible values.
+func pagination(request *http.Request) (page, perPage int) {
+    page, _ = strconv.Atoi(request.URL.Query().Get("page"))
+    perPage, _ = strconv.Atoi(request.URL.Query().Get("per_page"))
+    return clampPagination(page, perPage)
+}
+
+// clampPagination defaults and caps a requested page and page size.
+func clampPagination(page, perPage int) (int, int) {
+    if page < 1 {
+        page = 1
+    }
+    if perPage < 1 {
+        perPage = defaultPerPage
+    }
+    if perPage > maxPerPage {
+        perPage = maxPerPage
+    }
+    return page, perPage
+}
+
+func listJokes(response http.ResponseWriter, request *http.Request) {
+    page, perPage := pagination(request)
+    lang, err := requestLanguage(request)
+    if errors.Is(err, errBadLanguage) {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    response.Header().Add("Vary", "Accept-Language")
+
+    result, err := listPage(request.Context(), page, perPage, request.URL.Query().Get("snapshot"), lang)
+    if errors.Is(err, errBadSnapshot) {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    if len(result.Jokes) > 0 {
+        response.Header().Set("Last-Modified", lastModified(result.Jokes).Format(http.TimeFormat))
+    }
+    writeJSON(response, request, http.StatusOK, result)
+}
+
+// listPage loads a page of the listing, pinned to snapshot when it's set and
+// to the current jokes otherwise, and only of jokes in lang unless it's
+// empty. It returns errBadSnapshot when the token can't be decoded.
+func listPage(ctx context.Context, page, perPage int, snapshot, lang string) (jokePage, error) {
+    var maxID int
+    var err error
+    if snapshot != "" {
+        maxID, err = decodeSnapshot(snapshot)
+    } else {
+        maxID, err = repo.MaxID(ctx)
+    }
+    if err != nil {
+        return jokePage{}, err
+    }
+
+    jokes, err := repo.List(ctx, (page-1)*perPage, perPage, maxID, lang)
+    if err != nil {
+        return jokePage{}, err
+    }
+
+    return jokePage{
+        Jokes:    jokes,
+        Page:     page,
+        PerPage:  perPage,
+        Snapshot: encodeSnapshot(maxID),
+    }, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "time"
+
+    "go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// maxRequestIDLength bounds client-supplied X-Request-ID values so they can't
+// be used to bloat the logs.
+const maxRequestIDLength = 128
+
+// setupLogging installs a JSON slog logger as the default, at the level set
+// by LOG_LEVEL (debug, info, warn or error).
+func setupLogging() {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+        level = slog.LevelInfo
+    }
+
+    handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+    slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// fatal logs msg at error level and exits, replacing log.Fatalf.
+func fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
+}
+
+// contextHandler adds the request ID and trace context from the context to
+// every record, so log lines written while serving a request can be tied back
+// to it and to its trace.
+type contextHandler struct {
+    slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+    if id, ok := ctx.Value(requestIDKey).(string); ok {
+        record.AddAttrs(slog.String("request_id", id))
+    }
+    if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+        record.AddAttrs(
+            slog.String("trace_id", span.TraceID().String()),
+            slog.String("span_id", span.SpanID().String()),
+        )
+    }
+    return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+    return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestID returns the ID assigned to the request by logRequests.
+func requestID(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+func newRequestID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// validRequestID accepts client-supplied IDs made of printable ASCII only.
+func validRequestID(id string) bool {
+    if id == "" || len(id) > maxRequestIDLength {
+        return false
+    }
+    for i := 0; i < len(id); i++ {
+        if id[i] < 0x21 || id[i] > 0x7e {
+            return false
+        }
+    }
+    return true
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(b)
+    r.bytes += n
+    return n, err
+}
+
+// Hijack hands the connection over to the handler, as WebSocket upgrades
+// do, and logs the request as switching protocols.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+    if err == nil {
+        r.status = http.StatusSwitchingProtocols
+    }
+    return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streaming responses.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// logRequests assigns every request an ID, reusing the caller's X-Request-ID
+// when it has one, echoes it in the response and logs the request once it
+// has been served.
+func logRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        start := time.Now()
+
+        id := request.Header.Get("X-Request-ID")
+        if !validRequestID(id) {
+            id = newRequestID()
+        }
+        response.Header().Set("X-Request-ID", id)
+
+        ctx := context.WithValue(request.Context(), requestIDKey, id)
+        recorder := &statusRecorder{ResponseWriter: response}
+        next.ServeHTTP(recorder, request.WithContext(ctx))
+
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+        countRequest()
+        slog.InfoContext(ctx, "Request served",
+            "method", request.Method,
+            "path", request.URL.Path,
+            "status", recorder.status,
+            "bytes", recorder.bytes,
+            "duration_ms", float64(time.Since(start).Microseconds())/1000,
+            "remote_addr", request.RemoteAddr,
+        )
+    })
+}
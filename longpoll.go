@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// longPollTimeout is how long /jokes/next waits by default, and
+// longPollMaxTimeout the most a client may ask for with ?timeout=.
+var (
+    longPollTimeout    = 30 * time.Second
+    longPollMaxTimeout = 2 * time.Minute
+)
+
+// loadLongPoll reads LONG_POLL_TIMEOUT and LONG_POLL_MAX_TIMEOUT.
+func loadLongPoll() error {
+    var err error
+    longPollTimeout, err = getEnvDuration("LONG_POLL_TIMEOUT", longPollTimeout)
+    if err != nil {
+        return err
+    }
+    longPollMaxTimeout, err = getEnvDuration("LONG_POLL_MAX_TIMEOUT", longPollMaxTimeout)
+    if err != nil {
+        return err
+    }
+    if longPollTimeout <= 0 || longPollMaxTimeout < longPollTimeout {
+        return fmt.Errorf("LONG_POLL_TIMEOUT must be positive and at most LONG_POLL_MAX_TIMEOUT")
+    }
+    return nil
+}
+
+// getNextJoke waits for the next joke submitted and returns it, or answers
+// 204 No Content if none arrives in time. Clients that pass the id of the
+// last joke they saw as ?after= get the jokes they missed in between, one
+// per request, before waiting.
+func getNextJoke(response http.ResponseWriter, request *http.Request) {
+    query := request.URL.Query()
+    timeout := longPollTimeout
+    if value := query.Get("timeout"); value != "" {
+        seconds, err := strconv.Atoi(value)
+        if err != nil || seconds < 0 {
+            writeError(response, http.StatusBadRequest, "timeout must be a number of seconds")
+            return
+        }
+        timeout = min(time.Duration(seconds)*time.Second, longPollMaxTimeout)
+    }
+
+    ctx := request.Context()
+    var last int
+    if value := query.Get("after"); value != "" {
+        var ok bool
+        last, ok = parseJokeRef(value)
+        if !ok {
+            writeError(response, http.StatusBadRequest, "after must be a joke id")
+            return
+        }
+    } else {
+        var err error
+        last, err = repo.MaxID(ctx)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+    }
+
+    // Leaves time to answer once the wait is over.
+    keepOpen(response, time.Now().Add(timeout+10*time.Second))
+    deadline := time.NewTimer(timeout)
+    defer deadline.Stop()
+    poll := time.NewTicker(streamPollInterval)
+    defer poll.Stop()
+
+    for {
+        // Taken before reading, so a joke stored meanwhile isn't missed.
+        wake := newJokes.wait()
+
+        jokes, err := repo.ListAfter(ctx, last, 1, "")
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+        if len(jokes) > 0 {
+            response.Header().Set("Cache-Control", "no-store")
+            writeJoke(response, request, jokes[0])
+            return
+        }
+
+        select {
+        case <-ctx.Done():
+            // The client's X-Request-Timeout ran out first.
+            if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+                response.Header().Set("Cache-Control", "no-store")
+                response.WriteHeader(http.StatusNoContent)
+            }
+            return
+        case <-streamsClosing:
+            response.Header().Set("Retry-After", "1")
+            writeError(response, http.StatusServiceUnavailable, "the server is shutting down")
+            return
+        case <-deadline.C:
+            response.Header().Set("Cache-Control", "no-store")
+            response.WriteHeader(http.StatusNoContent)
+            return
+        case <-wake:
+        case <-poll.C:
+        }
+    }
+}
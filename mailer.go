@@ -0,0 +1,214 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log/slog"
+    "mime"
+    "net"
+    "net/http"
+    "net/mail"
+    "net/smtp"
+    "os"
+    "strings"
+    "time"
+)
+
+// mailTimeout bounds sending a single email.
+const mailTimeout = 30 * time.Second
+
+// mailMessage is a plain text email.
+type mailMessage struct {
+    To      string
+    Subject string
+    Body    string
+    // Header holds extra headers, such as List-Unsubscribe.
+    Header map[string]string
+}
+
+// Mailer sends email. The implementation is picked with MAILER.
+type Mailer interface {
+    Send(ctx context.Context, message mailMessage) error
+}
+
+// mailer sends subscription emails. It's nil, and subscriptions are turned
+// off, unless MAILER is set.
+var mailer Mailer
+
+// loadMailer reads MAILER, which is smtp, sendgrid or log, and MAIL_FROM,
+// the sender address. It returns nil when MAILER is unset.
+func loadMailer() (Mailer, error) {
+    kind := os.Getenv("MAILER")
+    if kind == "" {
+        return nil, nil
+    }
+
+    from, err := mail.ParseAddress(os.Getenv("MAIL_FROM"))
+    if err != nil {
+        return nil, fmt.Errorf("MAIL_FROM must be an email address: %w", err)
+    }
+
+    switch kind {
+    case "smtp":
+        addr := os.Getenv("SMTP_ADDR")
+        host, _, err := net.SplitHostPort(addr)
+        if err != nil {
+            return nil, fmt.Errorf("SMTP_ADDR must be host:port: %w", err)
+        }
+        m := &smtpMailer{addr: addr, host: host, from: from}
+        if username := os.Getenv("SMTP_USERNAME"); username != "" {
+            m.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+        }
+        return m, nil
+    case "sendgrid":
+        key := os.Getenv("SENDGRID_API_KEY")
+        if key == "" {
+            return nil, fmt.Errorf("MAILER=sendgrid requires SENDGRID_API_KEY")
+        }
+        return &sendgridMailer{key: key, from: from, client: &http.Client{Timeout: mailTimeout}}, nil
+    case "log":
+        return logMailer{}, nil
+    default:
+        return nil, fmt.Errorf("MAILER must be smtp, sendgrid or log, not %q", kind)
+    }
+}
+
+// smtpMailer sends email through an SMTP server, upgrading to TLS when the
+// server offers STARTTLS.
+type smtpMailer struct {
+    addr string
+    host string
+    from *mail.Address
+    auth smtp.Auth
+}
+
+func (m *smtpMailer) Send(ctx context.Context, message mailMessage) error {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "From: %s\r\n", m.from)
+    fmt.Fprintf(&b, "To: %s\r\n", message.To)
+    fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+    fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+    for name, value := range message.Header {
+        fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+    }
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+    // smtp.SendMail has no timeout, so the conversation is spelled out here
+    // on a connection with a deadline.
+    dialer := &net.Dialer{Timeout: mailTimeout}
+    conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+    if err != nil {
+        return err
+    }
+    conn.SetDeadline(time.Now().Add(mailTimeout))
+    client, err := smtp.NewClient(conn, m.host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer client.Close()
+
+    if ok, _ := client.Extension("STARTTLS"); ok {
+        if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+            return err
+        }
+    }
+    if m.auth != nil {
+        if err := client.Auth(m.auth); err != nil {
+            return err
+        }
+    }
+    if err := client.Mail(m.from.Address); err != nil {
+        return err
+    }
+    if err := client.Rcpt(message.To); err != nil {
+        return err
+    }
+    w, err := client.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(b.Bytes()); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return client.Quit()
+}
+
+// sendgridMailer sends email through the SendGrid v3 API.
+type sendgridMailer struct {
+    key    string
+    from   *mail.Address
+    client *http.Client
+}
+
+type sendgridAddress struct {
+    Email string `json:"email"`
+    Name  string `json:"name,omitempty"`
+}
+
+type sendgridRequest struct {
+    Personalizations []sendgridPersonalization `json:"personalizations"`
+    From             sendgridAddress           `json:"from"`
+    Subject          string                    `json:"subject"`
+    Content          []sendgridContent         `json:"content"`
+    Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+type sendgridPersonalization struct {
+    To []sendgridAddress `json:"to"`
+}
+
+type sendgridContent struct {
+    Type  string `json:"type"`
+    Value string `json:"value"`
+}
+
+func (m *sendgridMailer) Send(ctx context.Context, message mailMessage) error {
+    data, err := json.Marshal(sendgridRequest{
+        Personalizations: []sendgridPersonalization{{To: []sendgridAddress{{Email: message.To}}}},
+        From:             sendgridAddress{Email: m.from.Address, Name: m.from.Name},
+        Subject:          message.Subject,
+        Content:          []sendgridContent{{Type: "text/plain", Value: message.Body}},
+        Headers:          message.Header,
+    })
+    if err != nil {
+        return err
+    }
+
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Authorization", "Bearer "+m.key)
+    request.Header.Set("Content-Type", "application/json")
+
+    response, err := m.client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return fmt.Errorf("SendGrid responded %s: %s", response.Status, bytes.TrimSpace(detail))
+    }
+    return nil
+}
+
+// logMailer logs emails instead of sending them, for development.
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, message mailMessage) error {
+    slog.InfoContext(ctx, "Email", "to", message.To, "subject", message.Subject, "body", message.Body)
+    return nil
+}
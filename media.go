@@ -0,0 +1,153 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io/fs"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+)
+
+// errMediaMiss is returned by a mediaStore that doesn't hold a key.
+var errMediaMiss = errors.New("not in the media cache")
+
+// mediaStore holds generated assets under content addressed keys. Keys are
+// hex SHA-256 digests, so stores can use them as file or object names.
+type mediaStore interface {
+    // load returns the asset stored under key, or errMediaMiss.
+    load(key string) ([]byte, error)
+
+    // save stores data under key.
+    save(key string, data []byte) error
+
+    // purge removes every asset and returns how many there were.
+    purge() (int, error)
+}
+
+// mediaCache keeps generated assets, such as joke cards, so each is rendered
+// once per joke and template. It's nil, and assets are rendered on every
+// request, unless MEDIA_CACHE_DIR is set.
+var mediaCache mediaStore
+
+// mediaKey addresses an asset by everything that goes into rendering it: the
+// kind of asset with its template version, and the inputs.
+func mediaKey(kind string, inputs ...string) string {
+    h := sha256.New()
+    h.Write([]byte(kind))
+    for _, input := range inputs {
+        h.Write([]byte{0})
+        h.Write([]byte(input))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedMedia returns the asset under key from the media cache, rendering
+// and storing it on a miss. Cache errors are logged and the asset rendered
+// anyway.
+func cachedMedia(key string, render func() []byte) []byte {
+    if mediaCache == nil {
+        return render()
+    }
+
+    data, err := mediaCache.load(key)
+    if err == nil {
+        return data
+    }
+    if !errors.Is(err, errMediaMiss) {
+        slog.Error("Error reading media cache", "key", key, "error", err)
+    }
+
+    data = render()
+    if err := mediaCache.save(key, data); err != nil {
+        slog.Error("Error writing media cache", "key", key, "error", err)
+    }
+    return data
+}
+
+// diskMediaStore keeps assets as files under dir, spread over subdirectories
+// named after the first two characters of their key.
+type diskMediaStore struct {
+    dir string
+}
+
+func (s diskMediaStore) path(key string) string {
+    return filepath.Join(s.dir, key[:2], key)
+}
+
+func (s diskMediaStore) load(key string) ([]byte, error) {
+    data, err := os.ReadFile(s.path(key))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, errMediaMiss
+    }
+    return data, err
+}
+
+// save writes to a temporary file and renames it into place, so concurrent
+// readers never see half an asset.
+func (s diskMediaStore) save(key string, data []byte) error {
+    path := s.path(key)
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+
+    file, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(file.Name(), path)
+}
+
+func (s diskMediaStore) purge() (int, error) {
+    entries, err := os.ReadDir(s.dir)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return 0, err
+    }
+
+    n := 0
+    for _, entry := range entries {
+        if !entry.IsDir() || len(entry.Name()) != 2 {
+            continue
+        }
+        files, err := os.ReadDir(filepath.Join(s.dir, entry.Name()))
+        if err != nil {
+            return n, err
+        }
+        if err := os.RemoveAll(filepath.Join(s.dir, entry.Name())); err != nil {
+            return n, err
+        }
+        n += len(files)
+    }
+    return n, nil
+}
+
+type purgeResult struct {
+    Purged int `json:"purged"`
+}
+
+// purgeMedia empties the media cache, e.g. after changing a template without
+// bumping its version.
+func purgeMedia(response http.ResponseWriter, request *http.Request) {
+    if mediaCache == nil {
+        writeJSON(response, request, http.StatusOK, purgeResult{})
+        return
+    }
+
+    n, err := mediaCache.purge()
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, purgeResult{Purged: n})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "embed"
+    "errors"
+    "fmt"
+    "io/fs"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// migrationFiles holds the schema for every dialect, one directory per
+// DB_DRIVER value. Files are named NNNN_description.sql and applied in order;
+// NNNN_description.down.sql next to one undoes it.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+    version    int
+    name       string
+    statements []string
+    // down undoes the migration. It's empty if it can't be undone.
+    down []string
+}
+
+// loadMigrations reads the migrations for a dialect, sorted by version.
+func loadMigrations(d *dialect) ([]migration, error) {
+    dir := path.Join("migrations", d.name)
+    entries, err := fs.ReadDir(migrationFiles, dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var migrations []migration
+    for _, entry := range entries {
+        name := entry.Name()
+        if !strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, ".down.sql") {
+            continue
+        }
+
+        prefix, _, _ := strings.Cut(name, "_")
+        version, err := strconv.Atoi(prefix)
+        if err != nil {
+            return nil, fmt.Errorf("migration %s: bad version prefix", name)
+        }
+
+        data, err := migrationFiles.ReadFile(path.Join(dir, name))
+        if err != nil {
+            return nil, err
+        }
+
+        m := migration{
+            version:    version,
+            name:       strings.TrimSuffix(name, ".sql"),
+            statements: splitStatements(string(data)),
+        }
+        down, err := migrationFiles.ReadFile(path.Join(dir, m.name+".down.sql"))
+        if err == nil {
+            m.down = splitStatements(string(down))
+        } else if !errors.Is(err, fs.ErrNotExist) {
+            return nil, err
+        }
+        migrations = append(migrations, m)
+    }
+
+    sort.Slice(migrations, func(i, j int) bool {
+        return migrations[i].version < migrations[j].version
+    })
+    return migrations, nil
+}
+
+// splitStatements splits a migration file into statements, since not every
+// driver accepts several statements in one Exec. Statements end with a
+// semicolon at the end of a line.
+func splitStatements(script string) []string {
+    var statements []string
+    var current strings.Builder
+    for _, line := range strings.Split(script, "\n") {
+        current.WriteString(line)
+        current.WriteString("\n")
+        if strings.HasSuffix(strings.TrimSpace(line), ";") {
+            statement := strings.TrimSuffix(strings.TrimSpace(current.String()), ";")
+            if statement != "" {
+                statements = append(statements, statement)
+            }
+            current.Reset()
+        }
+    }
+    if statement := strings.TrimSpace(current.String()); statement != "" {
+        statements = append(statements, statement)
+    }
+    return statements
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations,
+// creating the table on first use.
+func appliedMigrations(db *sql.DB) (map[int]bool, error) {
+    _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR(255) NOT NULL)")
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := db.Query("SELECT version FROM schema_migrations")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := map[int]bool{}
+    for rows.Next() {
+        var version int
+        if err := rows.Scan(&version); err != nil {
+            return nil, err
+        }
+        applied[version] = true
+    }
+    return applied, rows.Err()
+}
+
+// migrate applies every pending migration for the dialect. Each migration
+// runs in its own transaction together with its schema_migrations record;
+// note that MySQL commits DDL implicitly, so a failed MySQL migration may
+// need cleaning up by hand.
+func migrate(db *sql.DB, d *dialect) error {
+    migrations, err := loadMigrations(d)
+    if err != nil {
+        return err
+    }
+
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        return err
+    }
+
+    for _, m := range migrations {
+        if applied[m.version] {
+            continue
+        }
+        if err := applyMigration(db, d, m); err != nil {
+            return fmt.Errorf("migration %s: %w", m.name, err)
+        }
+    }
+    return nil
+}
+
+func applyMigration(db *sql.DB, d *dialect, m migration) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, statement := range m.statements {
+        if _, err := tx.Exec(statement); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec(d.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.version, m.name)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// rollback undoes the last steps applied migrations, newest first, each in
+// its own transaction with the removal of its schema_migrations record. As
+// with migrate, MySQL commits DDL implicitly. It returns the names of the
+// migrations it undid.
+func rollback(db *sql.DB, d *dialect, steps int) ([]string, error) {
+    migrations, err := loadMigrations(d)
+    if err != nil {
+        return nil, err
+    }
+
+    applied, err := appliedMigrations(db)
+    if err != nil {
+        return nil, err
+    }
+
+    var undone []string
+    for i := len(migrations) - 1; i >= 0 && len(undone) < steps; i-- {
+        m := migrations[i]
+        if !applied[m.version] {
+            continue
+        }
+        if len(m.down) == 0 {
+            return undone, fmt.Errorf("migration %s can't be undone", m.name)
+        }
+        if err := undoMigration(db, d, m); err != nil {
+            return undone, fmt.Errorf("migration %s: %w", m.name, err)
+        }
+        undone = append(undone, m.name)
+    }
+    return undone, nil
+}
+
+func undoMigration(db *sql.DB, d *dialect, m migration) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, statement := range m.down {
+        if _, err := tx.Exec(statement); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec(d.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.version)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// pendingMigrations returns the names of migrations that haven't been applied
+// yet, without creating anything.
+func pendingMigrations(ctx context.Context, db *sql.DB, d *dialect) ([]string, error) {
+    migrations, err := loadMigrations(d)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := map[int]bool{}
+    for rows.Next() {
+        var version int
+        if err := rows.Scan(&version); err != nil {
+            return nil, err
+        }
+        applied[version] = true
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    pending := []string{}
+    for _, m := range migrations {
+        if !applied[m.version] {
+            pending = append(pending, m.name)
+        }
+    }
+    return pending, nil
+}
@@ -0,0 +1,3 @@
+DROP INDEX author_aliases_author_id ON author_aliases;
+
+DROP INDEX jokes_author ON jokes;
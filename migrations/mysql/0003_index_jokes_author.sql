@@ -0,0 +1,3 @@
+CREATE INDEX jokes_author ON jokes (author);
+
+CREATE INDEX author_aliases_author_id ON author_aliases (author_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS federation_peers;
+
+DROP INDEX jokes_external_id ON jokes;
+
+ALTER TABLE jokes DROP COLUMN source;
+
+ALTER TABLE jokes DROP COLUMN external_id;
@@ -0,0 +1,11 @@
+ALTER TABLE jokes
+    ADD COLUMN external_id VARCHAR(512) NULL,
+    ADD COLUMN source VARCHAR(255) NULL;
+
+CREATE UNIQUE INDEX jokes_external_id ON jokes (external_id);
+
+CREATE TABLE IF NOT EXISTS federation_peers (
+    peer_url VARCHAR(255) PRIMARY KEY,
+    last_cursor INT NOT NULL DEFAULT 0,
+    last_synced_at TIMESTAMP NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX jokes_text_hash ON jokes;
+
+ALTER TABLE jokes DROP COLUMN text_hash;
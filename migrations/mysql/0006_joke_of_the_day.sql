@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS joke_of_the_day (
+    day CHAR(10) PRIMARY KEY,
+    joke_id INT NOT NULL
+);
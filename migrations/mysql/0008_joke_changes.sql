@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS joke_changes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    joke_id INT NOT NULL,
+    op VARCHAR(16) NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO joke_changes (joke_id, op, changed_at)
+SELECT id, 'create', COALESCE(entry_date, CURRENT_TIMESTAMP) FROM jokes ORDER BY id;
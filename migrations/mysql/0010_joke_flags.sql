@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS joke_flags (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    joke_id INT NOT NULL,
+    source VARCHAR(64) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP NULL,
+    INDEX joke_flags_open (resolved_at, id)
+);
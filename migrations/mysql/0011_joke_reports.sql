@@ -0,0 +1,3 @@
+ALTER TABLE jokes ADD COLUMN hidden_at TIMESTAMP NULL;
+
+ALTER TABLE joke_flags ADD COLUMN reporter VARCHAR(64) NULL;
@@ -0,0 +1,3 @@
+DROP INDEX jokes_deleted_at ON jokes;
+
+ALTER TABLE jokes DROP COLUMN deleted_at;
@@ -0,0 +1,2 @@
+DROP INDEX joke_shares_shared_at ON joke_shares;
+DROP TABLE IF EXISTS best_of;
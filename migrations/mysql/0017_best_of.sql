@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS best_of (
+    week CHAR(7) NOT NULL,
+    position INT NOT NULL,
+    joke_id INT NOT NULL,
+    shares INT NOT NULL,
+    compiled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (week, position)
+);
+
+CREATE INDEX joke_shares_shared_at ON joke_shares (shared_at);
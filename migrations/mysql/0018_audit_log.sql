@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    before_snapshot TEXT,
+    after_snapshot TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_log_actor ON audit_log (actor, id);
+CREATE INDEX audit_log_created_at ON audit_log (created_at);
@@ -0,0 +1 @@
+DROP INDEX jokes_source ON jokes;
@@ -0,0 +1 @@
+CREATE INDEX jokes_source ON jokes (source);
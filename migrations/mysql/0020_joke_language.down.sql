@@ -0,0 +1,3 @@
+DROP INDEX jokes_language ON jokes;
+
+ALTER TABLE jokes DROP COLUMN language;
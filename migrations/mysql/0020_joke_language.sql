@@ -0,0 +1,3 @@
+ALTER TABLE jokes ADD COLUMN language VARCHAR(35) NOT NULL DEFAULT 'en';
+
+CREATE INDEX jokes_language ON jokes (language);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS jokes (
+    id SERIAL PRIMARY KEY,
+    entry_date TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    author VARCHAR(255),
+    joke_text TEXT
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS author_aliases (
+    alias VARCHAR(255) PRIMARY KEY,
+    author_id INTEGER NOT NULL
+);
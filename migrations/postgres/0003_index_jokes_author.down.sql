@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS author_aliases_author_id;
+
+DROP INDEX IF EXISTS jokes_author;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS jokes_author ON jokes (author);
+
+CREATE INDEX IF NOT EXISTS author_aliases_author_id ON author_aliases (author_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS federation_peers;
+
+DROP INDEX IF EXISTS jokes_external_id;
+
+ALTER TABLE jokes DROP COLUMN source;
+
+ALTER TABLE jokes DROP COLUMN external_id;
@@ -0,0 +1,11 @@
+ALTER TABLE jokes
+    ADD COLUMN IF NOT EXISTS external_id VARCHAR(512),
+    ADD COLUMN IF NOT EXISTS source VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS jokes_external_id ON jokes (external_id);
+
+CREATE TABLE IF NOT EXISTS federation_peers (
+    peer_url VARCHAR(255) PRIMARY KEY,
+    last_cursor INTEGER NOT NULL DEFAULT 0,
+    last_synced_at TIMESTAMPTZ
+);
@@ -0,0 +1,3 @@
+ALTER TABLE jokes ADD COLUMN text_hash CHAR(64) NULL;
+
+CREATE UNIQUE INDEX jokes_text_hash ON jokes (text_hash);
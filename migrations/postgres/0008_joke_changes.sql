@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS joke_changes (
+    id SERIAL PRIMARY KEY,
+    joke_id INTEGER NOT NULL,
+    op VARCHAR(16) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO joke_changes (joke_id, op, changed_at)
+SELECT id, 'create', COALESCE(entry_date, CURRENT_TIMESTAMP) FROM jokes ORDER BY id;
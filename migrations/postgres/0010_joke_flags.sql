@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS joke_flags (
+    id SERIAL PRIMARY KEY,
+    joke_id INTEGER NOT NULL,
+    source VARCHAR(64) NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS joke_flags_open ON joke_flags (resolved_at, id);
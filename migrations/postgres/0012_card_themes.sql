@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS card_themes (
+    name VARCHAR(64) PRIMARY KEY,
+    background VARCHAR(7) NOT NULL,
+    border VARCHAR(7) NOT NULL,
+    text_color VARCHAR(7) NOT NULL,
+    author_color VARCHAR(7) NOT NULL,
+    font_family VARCHAR(255) NOT NULL,
+    font_size INTEGER NOT NULL,
+    width INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS joke_shares (
+    id SERIAL PRIMARY KEY,
+    joke_id INTEGER NOT NULL,
+    referrer VARCHAR(255) NOT NULL DEFAULT '',
+    utm_source VARCHAR(255) NOT NULL DEFAULT '',
+    utm_medium VARCHAR(255) NOT NULL DEFAULT '',
+    utm_campaign VARCHAR(255) NOT NULL DEFAULT '',
+    shared_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS joke_shares_joke_id ON joke_shares (joke_id);
@@ -0,0 +1,3 @@
+ALTER TABLE jokes ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS jokes_deleted_at ON jokes (deleted_at);
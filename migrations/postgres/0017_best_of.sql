@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS best_of (
+    week CHAR(7) NOT NULL,
+    position INTEGER NOT NULL,
+    joke_id INTEGER NOT NULL,
+    shares INTEGER NOT NULL,
+    compiled_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (week, position)
+);
+
+CREATE INDEX IF NOT EXISTS joke_shares_shared_at ON joke_shares (shared_at);
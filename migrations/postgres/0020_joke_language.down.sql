@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jokes_language;
+
+ALTER TABLE jokes DROP COLUMN language;
@@ -0,0 +1 @@
+ALTER TABLE joke_flags ADD COLUMN tag VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS jokes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    author VARCHAR(255),
+    joke_text TEXT
+);
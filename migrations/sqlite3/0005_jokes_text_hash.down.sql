@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jokes_text_hash;
+
+ALTER TABLE jokes DROP COLUMN text_hash;
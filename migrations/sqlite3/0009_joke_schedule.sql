@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS joke_schedule (
+    day CHAR(10) PRIMARY KEY,
+    joke_id INTEGER NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE joke_flags DROP COLUMN reporter;
+
+ALTER TABLE jokes DROP COLUMN hidden_at;
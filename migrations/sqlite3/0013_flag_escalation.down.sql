@@ -0,0 +1 @@
+ALTER TABLE joke_flags DROP COLUMN escalated_at;
@@ -0,0 +1 @@
+ALTER TABLE joke_flags ADD COLUMN escalated_at TIMESTAMP;
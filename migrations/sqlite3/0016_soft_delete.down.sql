@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jokes_deleted_at;
+
+ALTER TABLE jokes DROP COLUMN deleted_at;
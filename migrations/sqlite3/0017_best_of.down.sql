@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS joke_shares_shared_at;
+DROP TABLE IF EXISTS best_of;
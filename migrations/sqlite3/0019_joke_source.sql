@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS jokes_source ON jokes (source);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS joke_translations (
+    joke_id INTEGER NOT NULL,
+    language VARCHAR(35) NOT NULL,
+    joke_text TEXT NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (joke_id, language)
+);
@@ -0,0 +1,299 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/url"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// errFlagNotFound is returned when a flag id doesn't exist or the flag has
+// already been resolved.
+var errFlagNotFound = errors.New("flag not found")
+
+// JokeFlag puts a joke in the moderation queue for an admin to look at.
+// Source says what flagged it, such as a content filter.
+type JokeFlag struct {
+    Id         int        `json:"id"`
+    JokeID     jokeRef    `json:"joke_id"`
+    Source     string     `json:"source"`
+    Reason     string     `json:"reason"`
+    CreatedAt  time.Time  `json:"created_at"`
+    ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+
+    // EscalatedAt is when the flag was moved to the priority list for
+    // waiting too long.
+    EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+
+    // Tag is set by moderators to group flags, such as a spam wave.
+    Tag string `json:"tag,omitempty"`
+
+    // Joke is the flagged joke, when listing the queue, and JokeHidden says
+    // whether it has been hidden from clients.
+    Joke       *Joke `json:"joke,omitempty"`
+    JokeHidden bool  `json:"joke_hidden,omitempty"`
+}
+
+// ModerationRepository stores the moderation queue.
+type ModerationRepository interface {
+    // FlagJoke adds a flag to the queue.
+    FlagJoke(ctx context.Context, jokeID int, source, reason string) error
+
+    // OpenFlags returns the unresolved flags with their jokes, oldest first.
+    OpenFlags(ctx context.Context) ([]JokeFlag, error)
+
+    // CountOpenFlags returns how many flags are unresolved.
+    CountOpenFlags(ctx context.Context) (int, error)
+
+    // FlagTimes returns the flags that are open or were resolved since the
+    // given time, with only their timestamps set.
+    FlagTimes(ctx context.Context, since time.Time) ([]JokeFlag, error)
+
+    // EscalateFlags moves the open flags raised before the given time to the
+    // priority list and returns how many it moved.
+    EscalateFlags(ctx context.Context, before time.Time) (int, error)
+
+    // ResolveFlag takes a flag off the queue, or returns errFlagNotFound.
+    ResolveFlag(ctx context.Context, id int) error
+
+    // ReportJoke queues a report of a joke by reporter, unless reporter
+    // already has an open report of it, and returns how many open reports
+    // the joke has.
+    ReportJoke(ctx context.Context, jokeID int, reporter, reason string) (int, error)
+
+    // HideJoke hides a joke from clients, recording the change.
+    HideJoke(ctx context.Context, jokeID int) error
+
+    // ResolveReports takes the open reports of a joke off the queue and hides
+    // or shows the joke, or returns errFlagNotFound if it has none.
+    ResolveReports(ctx context.Context, jokeID int, hide bool) error
+
+    // ModerateFlags applies a bulk action to the open flags among ids, in a
+    // transaction where the database has them: approve resolves the flags
+    // and shows their jokes, reject resolves them and hides their jokes, and
+    // tag sets their tag. It returns the flags and jokes it changed.
+    ModerateFlags(ctx context.Context, ids []int, action, tag string) (flags, jokes []int, err error)
+}
+
+var moderation ModerationRepository
+
+// listFlags serves the moderation queue, or the flags in it matching the
+// filter in the query string.
+func listFlags(response http.ResponseWriter, request *http.Request) {
+    filter, err := parseFlagFilter(request.URL.Query())
+    if err != nil {
+        writeValidationError(response, "invalid_request", err)
+        return
+    }
+
+    flags, err := moderation.OpenFlags(request.Context())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    matching := []JokeFlag{}
+    for _, flag := range flags {
+        if filter.matches(flag) {
+            matching = append(matching, flag)
+        }
+    }
+    writeJSON(response, request, http.StatusOK, matching)
+}
+
+// resolveFlag takes a flag off the queue once an admin has dealt with it.
+func resolveFlag(response http.ResponseWriter, request *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(request)["id"])
+    err := moderation.ResolveFlag(request.Context(), id)
+    if errors.Is(err, errFlagNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.WriteHeader(http.StatusNoContent)
+}
+
+// Bulk moderation actions.
+const (
+    moderateApprove = "approve"
+    moderateReject  = "reject"
+    moderateTag     = "tag"
+)
+
+// maxModerationBatch is the most flags a bulk action changes at once. A
+// filter matching more changes the oldest and reports how many remain.
+const maxModerationBatch = 1000
+
+// maxFlagTagLength is the longest tag stored; the column is sized for it.
+const maxFlagTagLength = 64
+
+// flagFilter selects open flags, for listing and bulk actions. Zero fields
+// don't filter.
+type flagFilter struct {
+    Source string `json:"source,omitempty"`
+    Reason string `json:"reason,omitempty"`
+    Tag    string `json:"tag,omitempty"`
+    // Text matches the jokes whose text or author contains it, ignoring
+    // case.
+    Text      string     `json:"q,omitempty"`
+    Escalated *bool      `json:"escalated,omitempty"`
+    Before    *time.Time `json:"created_before,omitempty"`
+    After     *time.Time `json:"created_after,omitempty"`
+}
+
+func (f flagFilter) empty() bool {
+    return f == flagFilter{}
+}
+
+func (f flagFilter) matches(flag JokeFlag) bool {
+    switch {
+    case f.Source != "" && flag.Source != f.Source,
+        f.Reason != "" && flag.Reason != f.Reason,
+        f.Tag != "" && flag.Tag != f.Tag,
+        f.Escalated != nil && *f.Escalated != (flag.EscalatedAt != nil),
+        f.Before != nil && !flag.CreatedAt.Before(*f.Before),
+        f.After != nil && flag.CreatedAt.Before(*f.After):
+        return false
+    }
+    if f.Text != "" {
+        if flag.Joke == nil {
+            return false
+        }
+        text := strings.ToLower(f.Text)
+        return strings.Contains(strings.ToLower(flag.Joke.Text), text) || strings.Contains(strings.ToLower(flag.Joke.Author), text)
+    }
+    return true
+}
+
+// parseFlagFilter reads a flagFilter from the query string of a listing,
+// which uses the names of its JSON fields.
+func parseFlagFilter(query url.Values) (flagFilter, error) {
+    filter := flagFilter{Source: query.Get("source"), Reason: query.Get("reason"), Tag: query.Get("tag"), Text: query.Get("q")}
+    var problems validationErrors
+    if value := query.Get("escalated"); value != "" {
+        escalated, err := strconv.ParseBool(value)
+        if err != nil {
+            problems.add("escalated", "must be true or false")
+        }
+        filter.Escalated = &escalated
+    }
+    for _, param := range []struct {
+        name   string
+        target **time.Time
+    }{{"created_before", &filter.Before}, {"created_after", &filter.After}} {
+        value := query.Get(param.name)
+        if value == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            problems.add(param.name, "must be an RFC 3339 time")
+        }
+        *param.target = &t
+    }
+    return filter, problems.err()
+}
+
+type moderateFlagsRequest struct {
+    Action string `json:"action"`
+    // Tag is the tag set by the tag action.
+    Tag string `json:"tag,omitempty"`
+    // IDs picks the flags by id, and Filter by what they match; one of the
+    // two is required.
+    IDs    []int       `json:"ids,omitempty"`
+    Filter *flagFilter `json:"filter,omitempty"`
+}
+
+func (r *moderateFlagsRequest) validate() error {
+    var problems validationErrors
+    if !slices.Contains([]string{moderateApprove, moderateReject, moderateTag}, r.Action) {
+        problems.add("action", "must be %s, %s or %s", moderateApprove, moderateReject, moderateTag)
+    }
+    r.Tag = strings.TrimSpace(r.Tag)
+    switch {
+    case r.Action == moderateTag && r.Tag == "":
+        problems.add("tag", "is required to tag flags")
+    case len(r.Tag) > maxFlagTagLength:
+        problems.add("tag", "must be at most %d bytes", maxFlagTagLength)
+    }
+
+    empty := r.Filter == nil || r.Filter.empty()
+    switch {
+    case len(r.IDs) == 0 && empty:
+        problems.add("ids", "or a filter is required")
+    case len(r.IDs) > 0 && !empty:
+        problems.add("ids", "can't be combined with a filter")
+    case len(r.IDs) > maxModerationBatch:
+        problems.add("ids", "must have at most %d flags", maxModerationBatch)
+    }
+    return problems.err()
+}
+
+// moderationBatch is what a bulk action changed. Remaining counts the flags
+// the filter matched beyond maxModerationBatch.
+type moderationBatch struct {
+    Action    string    `json:"action"`
+    Flags     []int     `json:"flags"`
+    Jokes     []jokeRef `json:"jokes"`
+    Remaining int       `json:"remaining,omitempty"`
+}
+
+// moderateFlags applies an action to a set of flags at once, so a spam wave
+// doesn't have to be approved or rejected one flag at a time. Flags that
+// aren't open, or don't exist, are skipped.
+func moderateFlags(response http.ResponseWriter, request *http.Request) {
+    var body moderateFlagsRequest
+    if !decodeRequest(response, request, &body) {
+        return
+    }
+    ctx := request.Context()
+
+    ids, remaining := body.IDs, 0
+    if body.Filter != nil && !body.Filter.empty() {
+        open, err := moderation.OpenFlags(ctx)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+        ids = nil
+        for _, flag := range open {
+            if body.Filter.matches(flag) {
+                ids = append(ids, flag.Id)
+            }
+        }
+        if len(ids) > maxModerationBatch {
+            ids, remaining = ids[:maxModerationBatch], len(ids)-maxModerationBatch
+        }
+    }
+
+    flags, jokes, err := moderation.ModerateFlags(ctx, ids, body.Action, body.Tag)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    batch := moderationBatch{Action: body.Action, Flags: flags, Jokes: make([]jokeRef, len(jokes)), Remaining: remaining}
+    if batch.Flags == nil {
+        batch.Flags = []int{}
+    }
+    for i, id := range jokes {
+        batch.Jokes[i] = jokeRef(id)
+        if body.Action == moderateReject {
+            jokeHidden(id)
+        }
+    }
+    if body.Action == moderateApprove && len(jokes) > 0 {
+        responses.invalidate()
+    }
+    writeJSON(response, request, http.StatusOK, batch)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoAuditEntry is a document of the audit_log collection. Snapshots are
+// kept as the JSON the API returns.
+type mongoAuditEntry struct {
+    Id        int       `bson:"_id"`
+    Actor     string    `bson:"actor"`
+    Action    string    `bson:"action"`
+    Target    string    `bson:"target"`
+    Before    string    `bson:"before_snapshot,omitempty"`
+    After     string    `bson:"after_snapshot,omitempty"`
+    CreatedAt time.Time `bson:"created_at"`
+}
+
+func (r *mongoRepository) RecordAudit(ctx context.Context, entry AuditEntry) error {
+    id, err := r.nextID(ctx, "audit_log")
+    if err != nil {
+        return err
+    }
+    _, err = r.db.Collection("audit_log").InsertOne(ctx, mongoAuditEntry{
+        Id:        id,
+        Actor:     entry.Actor,
+        Action:    entry.Action,
+        Target:    entry.Target,
+        Before:    string(entry.Before),
+        After:     string(entry.After),
+        CreatedAt: entry.CreatedAt.UTC(),
+    })
+    return err
+}
+
+func (r *mongoRepository) AuditLog(ctx context.Context, filter auditFilter) ([]AuditEntry, error) {
+    query := bson.M{}
+    if filter.Actor != "" {
+        query["actor"] = filter.Actor
+    }
+    created := bson.M{}
+    if !filter.From.IsZero() {
+        created["$gte"] = filter.From.UTC()
+    }
+    if !filter.To.IsZero() {
+        created["$lte"] = filter.To.UTC()
+    }
+    if len(created) > 0 {
+        query["created_at"] = created
+    }
+    if filter.BeforeID > 0 {
+        query["_id"] = bson.M{"$lt": filter.BeforeID}
+    }
+
+    cursor, err := r.db.Collection("audit_log").Find(ctx, query, options.Find().SetSort(byIDDesc).SetLimit(int64(filter.Limit)))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoAuditEntry
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    entries := make([]AuditEntry, len(docs))
+    for i, doc := range docs {
+        entries[i] = AuditEntry{Id: doc.Id, Actor: doc.Actor, Action: doc.Action, Target: doc.Target, CreatedAt: doc.CreatedAt.UTC()}
+        if doc.Before != "" {
+            entries[i].Before = json.RawMessage(doc.Before)
+        }
+        if doc.After != "" {
+            entries[i].After = json.RawMessage(doc.After)
+        }
+    }
+    return entries, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoAuthor and mongoAlias are documents of the authors and
+// author_aliases collections. Aliases are keyed by the normalized name, so
+// the unique _id index keeps two authors from claiming the same one.
+type mongoAuthor struct {
+    Id   int    `bson:"_id"`
+    Name string `bson:"name"`
+}
+
+type mongoAlias struct {
+    Alias    string `bson:"_id"`
+    AuthorID int    `bson:"author_id"`
+}
+
+// findAuthor returns the author registered under the alias of name.
+func (r *mongoRepository) findAuthor(ctx context.Context, name string) (Author, error) {
+    var alias mongoAlias
+    err := r.db.Collection("author_aliases").FindOne(ctx, bson.M{"_id": normalizeAuthor(name)}).Decode(&alias)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Author{}, errAuthorNotFound
+    }
+    if err != nil {
+        return Author{}, err
+    }
+
+    var author mongoAuthor
+    err = r.db.Collection("authors").FindOne(ctx, bson.M{"_id": alias.AuthorID}).Decode(&author)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Author{}, errAuthorNotFound
+    }
+    return Author{Id: author.Id, Name: author.Name}, err
+}
+
+// resolveAuthor returns the author known as name, registering name as a new
+// author if nobody is.
+func (r *mongoRepository) resolveAuthor(ctx context.Context, name string) (Author, error) {
+    author, err := r.findAuthor(ctx, name)
+    if !errors.Is(err, errAuthorNotFound) {
+        return author, err
+    }
+
+    author.Name = name
+    author.Id, err = r.nextID(ctx, "authors")
+    if err != nil {
+        return author, err
+    }
+    _, err = r.db.Collection("authors").InsertOne(ctx, mongoAuthor{Id: author.Id, Name: name})
+    if err != nil {
+        return author, err
+    }
+
+    _, err = r.db.Collection("author_aliases").InsertOne(ctx, mongoAlias{Alias: normalizeAuthor(name), AuthorID: author.Id})
+    if mongo.IsDuplicateKeyError(err) {
+        // Somebody else registered the same name concurrently; use theirs.
+        r.db.Collection("authors").DeleteOne(ctx, bson.M{"_id": author.Id})
+        return r.findAuthor(ctx, name)
+    }
+    return author, err
+}
+
+func (r *mongoRepository) aliases(ctx context.Context, authorID int) ([]string, error) {
+    cursor, err := r.db.Collection("author_aliases").Find(ctx, bson.M{"author_id": authorID}, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoAlias
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    aliases := []string{}
+    for _, doc := range docs {
+        aliases = append(aliases, doc.Alias)
+    }
+    return aliases, nil
+}
+
+func (r *mongoRepository) MergeAuthors(ctx context.Context, into string, aliases []string) (Author, error) {
+    target, err := r.resolveAuthor(ctx, into)
+    if err != nil {
+        return target, err
+    }
+
+    for _, alias := range aliases {
+        key := normalizeAuthor(alias)
+        if key == "" {
+            continue
+        }
+
+        source, err := r.findAuthor(ctx, alias)
+        switch {
+        case errors.Is(err, errAuthorNotFound):
+            _, err = r.db.Collection("author_aliases").InsertOne(ctx, mongoAlias{Alias: key, AuthorID: target.Id})
+            if err != nil {
+                return target, err
+            }
+            err = r.renameAuthor(ctx, alias, target.Name)
+        case err != nil:
+            return target, err
+        case source.Id != target.Id:
+            err = r.mergeAuthor(ctx, source, target)
+        }
+        if err != nil {
+            return target, err
+        }
+    }
+
+    target.Aliases, err = r.aliases(ctx, target.Id)
+    return target, err
+}
+
+// mergeAuthor moves the jokes and aliases of source over to target and
+// removes source.
+func (r *mongoRepository) mergeAuthor(ctx context.Context, source, target Author) error {
+    if err := r.renameAuthor(ctx, source.Name, target.Name); err != nil {
+        return err
+    }
+
+    _, err := r.db.Collection("author_aliases").UpdateMany(ctx, bson.M{"author_id": source.Id}, bson.M{"$set": bson.M{"author_id": target.Id}})
+    if err != nil {
+        return err
+    }
+    _, err = r.db.Collection("authors").DeleteOne(ctx, bson.M{"_id": source.Id})
+    return err
+}
+
+func (r *mongoRepository) BackfillAuthors(ctx context.Context) error {
+    // Most frequent spellings first, so they become the canonical name.
+    cursor, err := r.db.Collection("jokes").Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"author": bson.M{"$nin": bson.A{nil, ""}}}}},
+        {{Key: "$group", Value: bson.M{"_id": "$author", "count": bson.M{"$sum": 1}}}},
+        {{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}}}},
+    })
+    if err != nil {
+        return err
+    }
+    var groups []struct {
+        Name string `bson:"_id"`
+    }
+    if err := cursor.All(ctx, &groups); err != nil {
+        return err
+    }
+
+    for _, group := range groups {
+        if normalizeAuthor(group.Name) == "" {
+            continue
+        }
+
+        author, err := r.resolveAuthor(ctx, group.Name)
+        if err != nil {
+            return err
+        }
+        if author.Name != group.Name {
+            if err := r.renameAuthor(ctx, group.Name, author.Name); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// renameAuthor moves the jokes credited to from over to to, recording each
+// of them as updated.
+func (r *mongoRepository) renameAuthor(ctx context.Context, from, to string) error {
+    cursor, err := r.db.Collection("jokes").Find(ctx, bson.M{"author": from}, options.Find().SetSort(byID).SetProjection(onlyJokeID))
+    if err != nil {
+        return err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return err
+    }
+    if len(docs) == 0 {
+        return nil
+    }
+
+    first, err := r.reserveIDs(ctx, "joke_changes", len(docs))
+    if err != nil {
+        return err
+    }
+    now := time.Now().UTC().Truncate(time.Second)
+    records := make([]mongoChange, len(docs))
+    for i, doc := range docs {
+        records[i] = mongoChange{Id: first + i, JokeID: doc.Id, Op: changeUpdate, ChangedAt: now}
+    }
+    if _, err := r.db.Collection("joke_changes").InsertMany(ctx, records); err != nil {
+        return err
+    }
+
+    _, err = r.db.Collection("jokes").UpdateMany(ctx, bson.M{"author": from}, bson.M{"$set": bson.M{"author": to}})
+    return err
+}
+
+func (r *mongoRepository) ListAuthors(ctx context.Context, offset, limit int) ([]AuthorSummary, error) {
+    cursor, err := r.db.Collection("jokes").Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: visibleOnly(bson.M{"author": bson.M{"$nin": bson.A{nil, ""}}})}},
+        {{Key: "$group", Value: bson.M{"_id": "$author", "count": bson.M{"$sum": 1}}}},
+        {{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+        {{Key: "$skip", Value: offset}},
+        {{Key: "$limit", Value: limit}},
+    })
+    if err != nil {
+        return nil, err
+    }
+    var groups []struct {
+        Name  string `bson:"_id"`
+        Count int    `bson:"count"`
+    }
+    if err := cursor.All(ctx, &groups); err != nil {
+        return nil, err
+    }
+
+    list := make([]AuthorSummary, 0, len(groups))
+    for _, group := range groups {
+        list = append(list, AuthorSummary{Name: group.Name, Jokes: group.Count})
+    }
+    return list, nil
+}
+
+func (r *mongoRepository) AuthorJokes(ctx context.Context, name string, offset, limit int) (Author, []Joke, error) {
+    author, err := r.findAuthor(ctx, name)
+    if err != nil {
+        return author, nil, err
+    }
+
+    jokes, err := r.findJokes(ctx, visibleOnly(bson.M{"author": author.Name}), options.Find().SetSort(byIDDesc).SetSkip(int64(offset)).SetLimit(int64(limit)))
+    return author, jokes, err
+}
+
+func (r *mongoRepository) AuthorStats(ctx context.Context, name string) (AuthorStats, error) {
+    author, err := r.findAuthor(ctx, name)
+    if err != nil {
+        return AuthorStats{}, err
+    }
+
+    stats := AuthorStats{Name: author.Name}
+    count, err := r.db.Collection("jokes").CountDocuments(ctx, visibleOnly(bson.M{"author": author.Name}))
+    if err != nil || count == 0 {
+        return stats, err
+    }
+    stats.TotalJokes = int(count)
+
+    first, err := r.findJoke(ctx, visibleOnly(bson.M{"author": author.Name}), options.FindOne().SetSort(bson.D{{Key: "entry_date", Value: 1}, {Key: "_id", Value: 1}}))
+    if err != nil {
+        return stats, err
+    }
+    last, err := r.findJoke(ctx, visibleOnly(bson.M{"author": author.Name}), options.FindOne().SetSort(bson.D{{Key: "entry_date", Value: -1}, {Key: "_id", Value: -1}}))
+    if err != nil {
+        return stats, err
+    }
+    stats.FirstSubmission, stats.LastSubmission = &first.Date, &last.Date
+    return stats, nil
+}
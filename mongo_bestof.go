@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoBestOf is a document of the best_of collection, keyed by week.
+type mongoBestOf struct {
+    Week       string             `bson:"_id"`
+    CompiledAt time.Time          `bson:"compiled_at"`
+    Jokes      []mongoBestOfEntry `bson:"jokes"`
+}
+
+type mongoBestOfEntry struct {
+    JokeID int `bson:"joke_id"`
+    Shares int `bson:"shares"`
+}
+
+func (r *mongoRepository) TopShared(ctx context.Context, from, to time.Time, limit int) ([]bestOfEntry, error) {
+    cursor, err := r.db.Collection("joke_shares").Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"shared_at": bson.M{"$gte": from.UTC(), "$lt": to.UTC()}}}},
+        {{Key: "$group", Value: bson.M{"_id": "$joke_id", "count": bson.M{"$sum": 1}}}},
+        {{Key: "$lookup", Value: bson.M{"from": "jokes", "localField": "_id", "foreignField": "_id", "as": "joke"}}},
+        {{Key: "$match", Value: bson.M{"joke": bson.M{"$ne": bson.A{}}, "joke.hidden_at": nil, "joke.deleted_at": nil}}},
+        {{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+        {{Key: "$limit", Value: limit}},
+    })
+    if err != nil {
+        return nil, err
+    }
+    var groups []struct {
+        JokeID int `bson:"_id"`
+        Count  int `bson:"count"`
+    }
+    if err := cursor.All(ctx, &groups); err != nil {
+        return nil, err
+    }
+
+    top := make([]bestOfEntry, len(groups))
+    for i, group := range groups {
+        top[i] = bestOfEntry{Joke: Joke{Id: group.JokeID}, Shares: group.Count}
+    }
+    return top, nil
+}
+
+func (r *mongoRepository) SaveBestOf(ctx context.Context, best BestOf) error {
+    doc := mongoBestOf{Week: best.Week, CompiledAt: best.CompiledAt.UTC()}
+    for _, entry := range best.Jokes {
+        doc.Jokes = append(doc.Jokes, mongoBestOfEntry{JokeID: entry.Joke.Id, Shares: entry.Shares})
+    }
+    _, err := r.db.Collection("best_of").InsertOne(ctx, doc)
+    if mongo.IsDuplicateKeyError(err) {
+        return errBestOfExists
+    }
+    return err
+}
+
+func (r *mongoRepository) BestOf(ctx context.Context, week string) (BestOf, error) {
+    best := BestOf{Week: week, Jokes: []bestOfEntry{}}
+    var doc mongoBestOf
+    err := r.db.Collection("best_of").FindOne(ctx, bson.M{"_id": week}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return best, errBestOfNotFound
+    }
+    if err != nil {
+        return best, err
+    }
+    best.CompiledAt = doc.CompiledAt.UTC()
+
+    ids := make(bson.A, len(doc.Jokes))
+    for i, entry := range doc.Jokes {
+        ids[i] = entry.JokeID
+    }
+    found, err := r.findJokes(ctx, visibleOnly(bson.M{"_id": bson.M{"$in": ids}}))
+    if err != nil {
+        return best, err
+    }
+    jokes := make(map[int]Joke, len(found))
+    for _, joke := range found {
+        jokes[joke.Id] = joke
+    }
+    for _, entry := range doc.Jokes {
+        if joke, ok := jokes[entry.JokeID]; ok {
+            best.Jokes = append(best.Jokes, bestOfEntry{Joke: joke, Shares: entry.Shares})
+        }
+    }
+    return best, nil
+}
+
+func (r *mongoRepository) BestOfWeeks(ctx context.Context, limit int) ([]string, error) {
+    cursor, err := r.db.Collection("best_of").Find(ctx, bson.M{}, options.Find().SetSort(byIDDesc).SetLimit(int64(limit)).SetProjection(bson.M{"_id": 1}))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoBestOf
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    weeks := make([]string, len(docs))
+    for i, doc := range docs {
+        weeks[i] = doc.Week
+    }
+    return weeks, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoChange is a document of the joke_changes collection. Its id is the
+// cursor of the change feed.
+type mongoChange struct {
+    Id        int       `bson:"_id"`
+    JokeID    int       `bson:"joke_id"`
+    Op        string    `bson:"op"`
+    ChangedAt time.Time `bson:"changed_at"`
+}
+
+// recordChange appends a change to jokeID to the change feed.
+func (r *mongoRepository) recordChange(ctx context.Context, jokeID int, op string) error {
+    id, err := r.nextID(ctx, "joke_changes")
+    if err != nil {
+        return err
+    }
+    _, err = r.db.Collection("joke_changes").InsertOne(ctx, mongoChange{Id: id, JokeID: jokeID, Op: op, ChangedAt: time.Now().UTC().Truncate(time.Second)})
+    return err
+}
+
+func (r *mongoRepository) Changes(ctx context.Context, since, limit int) ([]JokeChange, error) {
+    cursor, err := r.db.Collection("joke_changes").Find(ctx, bson.M{"_id": bson.M{"$gt": since}}, options.Find().SetSort(byID).SetLimit(int64(limit)))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoChange
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    // Look the jokes up in one query; a joke that's gone or hidden leaves
+    // its changes without one, like the SQL LEFT JOIN.
+    ids := make([]int, 0, len(docs))
+    for _, doc := range docs {
+        ids = append(ids, doc.JokeID)
+    }
+    found, err := r.findJokes(ctx, visibleOnly(bson.M{"_id": bson.M{"$in": ids}}))
+    if err != nil {
+        return nil, err
+    }
+    jokes := make(map[int]Joke, len(found))
+    for _, joke := range found {
+        jokes[joke.Id] = joke
+    }
+
+    list := []JokeChange{}
+    for _, doc := range docs {
+        change := JokeChange{Cursor: doc.Id, Op: doc.Op, JokeID: jokeRef(doc.JokeID), ChangedAt: doc.ChangedAt.UTC()}
+        if joke, ok := jokes[doc.JokeID]; ok {
+            change.Joke = &joke
+        }
+        list = append(list, change)
+    }
+    return list, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "context"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (r *mongoRepository) SourceJokes(ctx context.Context, source string) (map[string]sourceJoke, error) {
+    projection := bson.M{"_id": 1, "external_id": 1, "deleted_at": 1}
+    cursor, err := r.db.Collection("jokes").Find(ctx, bson.M{"source": source}, options.Find().SetProjection(projection))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    jokes := make(map[string]sourceJoke, len(docs))
+    for _, doc := range docs {
+        jokes[doc.ExternalID] = sourceJoke{Id: doc.Id, Deleted: doc.DeletedAt != nil}
+    }
+    return jokes, nil
+}
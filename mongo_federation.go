@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (r *mongoRepository) FederatedJokes(ctx context.Context, self string, since, limit int) ([]FederatedJoke, error) {
+    cursor, err := r.db.Collection("jokes").Find(ctx, visibleOnly(bson.M{"_id": bson.M{"$gt": since}}), options.Find().SetSort(byID).SetLimit(int64(limit)))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    jokes := []FederatedJoke{}
+    for _, doc := range docs {
+        joke := FederatedJoke{Cursor: doc.Id, ExternalID: doc.ExternalID, Source: doc.Source, Author: doc.Author, Text: doc.Text, Language: doc.joke().Language}
+        if doc.ExternalID == "" {
+            joke.ExternalID = fmt.Sprintf("%s/jokes/%d", self, doc.Id)
+            joke.Source = self
+        }
+        jokes = append(jokes, joke)
+    }
+    return jokes, nil
+}
+
+func (r *mongoRepository) ImportFederated(ctx context.Context, joke FederatedJoke) (bool, error) {
+    _, err := r.findJoke(ctx, bson.M{"external_id": joke.ExternalID}, options.FindOne().SetProjection(onlyJokeID))
+    if err == nil {
+        return false, nil
+    }
+    if !errors.Is(err, errJokeNotFound) {
+        return false, err
+    }
+
+    // The same joke submitted independently on two instances is a conflict;
+    // the copy already here wins.
+    hash := jokeTextHash(joke.Text)
+    err = r.checkDuplicate(ctx, hash)
+    var duplicate *duplicateJokeError
+    if errors.As(err, &duplicate) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if joke.Author != "" {
+        author, err := r.resolveAuthor(ctx, joke.Author)
+        if err != nil {
+            return false, err
+        }
+        joke.Author = author.Name
+    }
+
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+    id, err := r.insertJoke(ctx, mongoJoke{
+        Date:       time.Now().UTC().Truncate(time.Second),
+        Author:     joke.Author,
+        Text:       joke.Text,
+        TextHash:   hash,
+        ExternalID: joke.ExternalID,
+        Source:     joke.Source,
+        Language:   joke.Language,
+    })
+    if mongo.IsDuplicateKeyError(err) {
+        // Pulled concurrently from another peer, or by another instance.
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    return true, r.recordChange(ctx, id, changeCreate)
+}
+
+// mongoPeer is a document of the federation_peers collection, keyed by the
+// peer's URL.
+type mongoPeer struct {
+    Peer         string    `bson:"_id"`
+    LastCursor   int       `bson:"last_cursor"`
+    LastSyncedAt time.Time `bson:"last_synced_at"`
+}
+
+func (r *mongoRepository) PeerCursor(ctx context.Context, peer string) (int, error) {
+    var doc mongoPeer
+    err := r.db.Collection("federation_peers").FindOne(ctx, bson.M{"_id": peer}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return 0, nil
+    }
+    return doc.LastCursor, err
+}
+
+func (r *mongoRepository) SavePeerCursor(ctx context.Context, peer string, cursor int) error {
+    _, err := r.db.Collection("federation_peers").UpdateOne(ctx,
+        bson.M{"_id": peer},
+        bson.M{"$set": bson.M{"last_cursor": cursor, "last_synced_at": time.Now().UTC()}},
+        options.UpdateOne().SetUpsert(true),
+    )
+    return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoDailyPick is a document of the joke_of_the_day and joke_schedule
+// collections. Keying it by day makes the first instance to record a pick
+// win.
+type mongoDailyPick struct {
+    Day    string `bson:"_id"`
+    JokeID int    `bson:"joke_id"`
+}
+
+func (r *mongoRepository) DailyJoke(ctx context.Context, day string) (Joke, error) {
+    var pick mongoDailyPick
+    err := r.db.Collection("joke_of_the_day").FindOne(ctx, bson.M{"_id": day}).Decode(&pick)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Joke{}, errJokeNotFound
+    }
+    if err != nil {
+        return Joke{}, err
+    }
+    return r.findJoke(ctx, visibleOnly(bson.M{"_id": pick.JokeID}))
+}
+
+func (r *mongoRepository) PickDailyJoke(ctx context.Context, day string, seed uint64) (Joke, error) {
+    joke, err := r.DailyJoke(ctx, day)
+    if !errors.Is(err, errJokeNotFound) {
+        return joke, err
+    }
+    // A pick that has since been hidden or deleted is picked again.
+    var stale mongoDailyPick
+    err = r.db.Collection("joke_of_the_day").FindOne(ctx, bson.M{"_id": day}).Decode(&stale)
+    if err == nil {
+        _, err = r.db.Collection("joke_of_the_day").DeleteOne(ctx, bson.M{"_id": day, "joke_id": stale.JokeID})
+    }
+    if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+        return joke, err
+    }
+
+    // A joke scheduled for the day wins, unless it's gone or hidden.
+    var scheduled mongoDailyPick
+    err = r.db.Collection("joke_schedule").FindOne(ctx, bson.M{"_id": day}).Decode(&scheduled)
+    if err == nil {
+        joke, err = r.Get(ctx, scheduled.JokeID)
+    }
+    switch {
+    case err == nil:
+        return r.recordDailyJoke(ctx, day, joke)
+    case !errors.Is(err, mongo.ErrNoDocuments) && !errors.Is(err, errJokeNotFound):
+        return joke, err
+    }
+
+    // Only jokes from before the day are candidates, so jokes submitted
+    // during the day don't change the pick. A new collection with nothing
+    // that old picks from everything.
+    start, err := time.Parse(dayLayout, day)
+    if err != nil {
+        return joke, err
+    }
+    candidates := visibleOnly(bson.M{"entry_date": bson.M{"$lt": start}})
+
+    jokes := r.db.Collection("jokes")
+    count, err := jokes.CountDocuments(ctx, candidates)
+    if err != nil {
+        return joke, err
+    }
+    if count == 0 {
+        candidates = visibleOnly(bson.M{})
+        count, err = jokes.CountDocuments(ctx, candidates)
+        if err != nil {
+            return joke, err
+        }
+    }
+    if count == 0 {
+        return joke, errJokeNotFound
+    }
+
+    joke, err = r.findJoke(ctx, candidates, options.FindOne().SetSort(byID).SetSkip(int64(seed%uint64(count))))
+    if err != nil {
+        return joke, err
+    }
+
+    return r.recordDailyJoke(ctx, day, joke)
+}
+
+// recordDailyJoke records joke as the pick for day and returns it, or the
+// joke another instance recorded first.
+func (r *mongoRepository) recordDailyJoke(ctx context.Context, day string, joke Joke) (Joke, error) {
+    _, err := r.db.Collection("joke_of_the_day").InsertOne(ctx, mongoDailyPick{Day: day, JokeID: joke.Id})
+    if err != nil {
+        // Another instance recorded its pick first; use that one.
+        if recorded, getErr := r.DailyJoke(ctx, day); getErr == nil {
+            return recorded, nil
+        }
+        return joke, err
+    }
+    return joke, nil
+}
+
+func (r *mongoRepository) ScheduledJokes(ctx context.Context, day string) ([]ScheduledJoke, error) {
+    cursor, err := r.db.Collection("joke_schedule").Find(ctx, bson.M{"_id": bson.M{"$gte": day}}, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoDailyPick
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    ids := make([]int, 0, len(docs))
+    for _, doc := range docs {
+        ids = append(ids, doc.JokeID)
+    }
+    found, err := r.findJokes(ctx, visibleOnly(bson.M{"_id": bson.M{"$in": ids}}), options.Find().SetProjection(onlyJokeID))
+    if err != nil {
+        return nil, err
+    }
+    exists := make(map[int]bool, len(found))
+    for _, joke := range found {
+        exists[joke.Id] = true
+    }
+
+    schedule := []ScheduledJoke{}
+    for _, doc := range docs {
+        schedule = append(schedule, ScheduledJoke{Date: doc.Day, JokeID: jokeRef(doc.JokeID), Missing: !exists[doc.JokeID]})
+    }
+    return schedule, nil
+}
+
+func (r *mongoRepository) ScheduleJokes(ctx context.Context, entries []ScheduledJoke) error {
+    for _, entry := range entries {
+        _, err := r.db.Collection("joke_schedule").ReplaceOne(ctx,
+            bson.M{"_id": entry.Date},
+            mongoDailyPick{Day: entry.Date, JokeID: int(entry.JokeID)},
+            options.Replace().SetUpsert(true),
+        )
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *mongoRepository) UnscheduleJoke(ctx context.Context, day string) error {
+    result, err := r.db.Collection("joke_schedule").DeleteOne(ctx, bson.M{"_id": day})
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
+        return errNotScheduled
+    }
+    return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoFlag is a document of the joke_flags collection.
+type mongoFlag struct {
+    Id          int        `bson:"_id"`
+    JokeID      int        `bson:"joke_id"`
+    Source      string     `bson:"source"`
+    Reason      string     `bson:"reason"`
+    Reporter    string     `bson:"reporter,omitempty"`
+    CreatedAt   time.Time  `bson:"created_at"`
+    ResolvedAt  *time.Time `bson:"resolved_at"`
+    EscalatedAt *time.Time `bson:"escalated_at,omitempty"`
+    Tag         string     `bson:"tag,omitempty"`
+}
+
+func (r *mongoRepository) FlagJoke(ctx context.Context, jokeID int, source, reason string) error {
+    id, err := r.nextID(ctx, "joke_flags")
+    if err != nil {
+        return err
+    }
+    _, err = r.db.Collection("joke_flags").InsertOne(ctx, mongoFlag{Id: id, JokeID: jokeID, Source: source, Reason: reason, CreatedAt: time.Now().UTC().Truncate(time.Second)})
+    return err
+}
+
+func (r *mongoRepository) OpenFlags(ctx context.Context) ([]JokeFlag, error) {
+    cursor, err := r.db.Collection("joke_flags").Find(ctx, bson.M{"resolved_at": nil}, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoFlag
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    ids := make([]int, 0, len(docs))
+    for _, doc := range docs {
+        ids = append(ids, doc.JokeID)
+    }
+    // findJokes drops hidden_at, so read the documents directly.
+    cursor, err = r.db.Collection("jokes").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        return nil, err
+    }
+    var found []mongoJoke
+    if err := cursor.All(ctx, &found); err != nil {
+        return nil, err
+    }
+    jokes := make(map[int]mongoJoke, len(found))
+    for _, doc := range found {
+        jokes[doc.Id] = doc
+    }
+
+    flags := []JokeFlag{}
+    for _, doc := range docs {
+        flag := JokeFlag{Id: doc.Id, JokeID: jokeRef(doc.JokeID), Source: doc.Source, Reason: doc.Reason, CreatedAt: doc.CreatedAt.UTC(), EscalatedAt: utcTimePtr(doc.EscalatedAt), Tag: doc.Tag}
+        if found, ok := jokes[doc.JokeID]; ok {
+            joke := found.joke()
+            flag.Joke, flag.JokeHidden = &joke, found.HiddenAt != nil
+        }
+        flags = append(flags, flag)
+    }
+    return flags, nil
+}
+
+func (r *mongoRepository) CountOpenFlags(ctx context.Context) (int, error) {
+    n, err := r.db.Collection("joke_flags").CountDocuments(ctx, bson.M{"resolved_at": nil})
+    return int(n), err
+}
+
+func (r *mongoRepository) FlagTimes(ctx context.Context, since time.Time) ([]JokeFlag, error) {
+    filter := bson.M{"$or": bson.A{bson.M{"resolved_at": nil}, bson.M{"resolved_at": bson.M{"$gte": since.UTC()}}}}
+    cursor, err := r.db.Collection("joke_flags").Find(ctx, filter, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoFlag
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    flags := make([]JokeFlag, 0, len(docs))
+    for _, doc := range docs {
+        flags = append(flags, JokeFlag{Id: doc.Id, CreatedAt: doc.CreatedAt.UTC(), ResolvedAt: utcTimePtr(doc.ResolvedAt), EscalatedAt: utcTimePtr(doc.EscalatedAt)})
+    }
+    return flags, nil
+}
+
+func (r *mongoRepository) EscalateFlags(ctx context.Context, before time.Time) (int, error) {
+    filter := bson.M{"resolved_at": nil, "escalated_at": nil, "created_at": bson.M{"$lt": before.UTC()}}
+    result, err := r.db.Collection("joke_flags").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"escalated_at": time.Now().UTC().Truncate(time.Second)}})
+    if err != nil {
+        return 0, err
+    }
+    return int(result.ModifiedCount), nil
+}
+
+// utcTimePtr converts a stored time to UTC, since the driver decodes times
+// in the local zone.
+func utcTimePtr(t *time.Time) *time.Time {
+    if t == nil {
+        return nil
+    }
+    utc := t.UTC()
+    return &utc
+}
+
+func (r *mongoRepository) ResolveFlag(ctx context.Context, id int) error {
+    now := time.Now().UTC().Truncate(time.Second)
+    result, err := r.db.Collection("joke_flags").UpdateOne(ctx, bson.M{"_id": id, "resolved_at": nil}, bson.M{"$set": bson.M{"resolved_at": now}})
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return errFlagNotFound
+    }
+    return nil
+}
+
+// ReportJoke isn't atomic: reports arriving together may both see the count
+// before either was stored, delaying hiding until the next report.
+func (r *mongoRepository) ReportJoke(ctx context.Context, jokeID int, reporter, reason string) (int, error) {
+    flags := r.db.Collection("joke_flags")
+    open := bson.M{"joke_id": jokeID, "source": reportSource, "resolved_at": nil}
+
+    err := flags.FindOne(ctx, bson.M{"joke_id": jokeID, "source": reportSource, "reporter": reporter, "resolved_at": nil}).Err()
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        var id int
+        id, err = r.nextID(ctx, "joke_flags")
+        if err != nil {
+            return 0, err
+        }
+        _, err = flags.InsertOne(ctx, mongoFlag{Id: id, JokeID: jokeID, Source: reportSource, Reason: reason, Reporter: reporter, CreatedAt: time.Now().UTC().Truncate(time.Second)})
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    reports, err := flags.CountDocuments(ctx, open)
+    return int(reports), err
+}
+
+func (r *mongoRepository) HideJoke(ctx context.Context, jokeID int) error {
+    return r.setHidden(ctx, jokeID, true)
+}
+
+func (r *mongoRepository) ResolveReports(ctx context.Context, jokeID int, hide bool) error {
+    now := time.Now().UTC().Truncate(time.Second)
+    result, err := r.db.Collection("joke_flags").UpdateMany(ctx, bson.M{"joke_id": jokeID, "source": reportSource, "resolved_at": nil}, bson.M{"$set": bson.M{"resolved_at": now}})
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return errFlagNotFound
+    }
+    return r.setHidden(ctx, jokeID, hide)
+}
+
+// ModerateFlags isn't atomic: without a transaction, the flags are updated
+// first and then their jokes, so a failure part way leaves jokes to be
+// hidden or shown by hand.
+func (r *mongoRepository) ModerateFlags(ctx context.Context, ids []int, action, tag string) ([]int, []int, error) {
+    if len(ids) == 0 {
+        return nil, nil, nil
+    }
+    filter := bson.M{"_id": bson.M{"$in": ids}, "resolved_at": nil}
+    cursor, err := r.db.Collection("joke_flags").Find(ctx, filter, options.Find().SetSort(byID).SetProjection(bson.M{"_id": 1, "joke_id": 1}))
+    if err != nil {
+        return nil, nil, err
+    }
+    var docs []mongoFlag
+    if err := cursor.All(ctx, &docs); err != nil || len(docs) == 0 {
+        return nil, nil, err
+    }
+    var flags, jokes []int
+    for _, doc := range docs {
+        flags = append(flags, doc.Id)
+        if !slices.Contains(jokes, doc.JokeID) {
+            jokes = append(jokes, doc.JokeID)
+        }
+    }
+
+    update := bson.M{"$set": bson.M{"resolved_at": time.Now().UTC().Truncate(time.Second)}}
+    if action == moderateTag {
+        update = bson.M{"$set": bson.M{"tag": tag}}
+    }
+    if _, err := r.db.Collection("joke_flags").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": flags}}, update); err != nil {
+        return nil, nil, err
+    }
+    if action == moderateTag {
+        return flags, nil, nil
+    }
+    for _, jokeID := range jokes {
+        if err := r.setHidden(ctx, jokeID, action == moderateReject); err != nil {
+            return nil, nil, err
+        }
+    }
+    return flags, jokes, nil
+}
+
+// setHidden hides or shows a joke, recording the change if there was one.
+func (r *mongoRepository) setHidden(ctx context.Context, jokeID int, hide bool) error {
+    filter, update, op := bson.M{"_id": jokeID, "hidden_at": bson.M{"$ne": nil}}, bson.M{"$unset": bson.M{"hidden_at": ""}}, changeUnhide
+    if hide {
+        filter, update, op = bson.M{"_id": jokeID, "hidden_at": nil}, bson.M{"$set": bson.M{"hidden_at": time.Now().UTC().Truncate(time.Second)}}, changeHide
+    }
+
+    result, err := r.db.Collection("jokes").UpdateOne(ctx, filter, update)
+    if err != nil || result.ModifiedCount == 0 {
+        return err
+    }
+    return r.recordChange(ctx, jokeID, op)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand/v2"
+    "slices"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+    "go.mongodb.org/mongo-driver/v2/x/mongo/driver/connstring"
+)
+
+// defaultMongoDatabase is used when the connection string doesn't name a
+// database.
+const defaultMongoDatabase = "dadjokes"
+
+// mongoRepository implements storage on top of MongoDB, for deployments
+// without a relational database. It keeps one collection per SQL table and
+// the same integer ids, so cursors, links and public ids work the same on
+// both backends.
+//
+// It doesn't use transactions, since a standalone server doesn't support
+// them: a batch that fails halfway keeps the jokes created before the
+// failure, and a crash between storing a joke and recording its change
+// leaves the joke out of the change feed.
+type mongoRepository struct {
+    client *mongo.Client
+    db     *mongo.Database
+}
+
+// mongoJoke is a joke as stored in the jokes collection.
+type mongoJoke struct {
+    Id         int        `bson:"_id"`
+    Date       time.Time  `bson:"entry_date"`
+    Author     string     `bson:"author"`
+    Text       string     `bson:"joke_text"`
+    TextHash   string     `bson:"text_hash,omitempty"`
+    ExternalID string     `bson:"external_id,omitempty"`
+    Source     string     `bson:"source,omitempty"`
+    HiddenAt   *time.Time `bson:"hidden_at,omitempty"`
+    DeletedAt  *time.Time `bson:"deleted_at,omitempty"`
+
+    // Language is missing from jokes stored before jokes had one, which
+    // are in the default language.
+    Language string `bson:"language,omitempty"`
+}
+
+// joke normalizes dates to UTC with second precision, like scanJoke.
+func (j mongoJoke) joke() Joke {
+    joke := Joke{Id: j.Id, Date: j.Date.UTC().Truncate(time.Second), Author: j.Author, Text: j.Text, Language: j.Language}
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+    return joke
+}
+
+// mongoIndexes are created at startup; they take the place of migrations.
+var mongoIndexes = map[string][]mongo.IndexModel{
+    "jokes": {
+        // Sparse, so jokes without an external id, or without a hash yet,
+        // don't collide.
+        {Keys: bson.D{{Key: "text_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+        {Keys: bson.D{{Key: "external_id", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+        {Keys: bson.D{{Key: "author", Value: 1}}},
+        {Keys: bson.D{{Key: "entry_date", Value: 1}}},
+        {Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+        {Keys: bson.D{{Key: "source", Value: 1}}},
+        {Keys: bson.D{{Key: "language", Value: 1}}},
+    },
+    "author_aliases": {
+        {Keys: bson.D{{Key: "author_id", Value: 1}}},
+    },
+    "joke_flags": {
+        {Keys: bson.D{{Key: "resolved_at", Value: 1}, {Key: "_id", Value: 1}}},
+    },
+    "webhook_deliveries": {
+        {Keys: bson.D{{Key: "next_attempt_at", Value: 1}}},
+        {Keys: bson.D{{Key: "webhook_id", Value: 1}}},
+    },
+    "subscriptions": {
+        {Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+        {Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+    },
+    "audit_log": {
+        {Keys: bson.D{{Key: "actor", Value: 1}, {Key: "_id", Value: -1}}},
+        {Keys: bson.D{{Key: "created_at", Value: 1}}},
+    },
+    "joke_shares": {
+        {Keys: bson.D{{Key: "joke_id", Value: 1}, {Key: "shared_at", Value: -1}}},
+        {Keys: bson.D{{Key: "shared_at", Value: 1}}},
+    },
+    "joke_translations": {
+        {Keys: bson.D{{Key: "joke_id", Value: 1}, {Key: "language", Value: 1}}, Options: options.Index().SetUnique(true)},
+    },
+}
+
+// openMongoRepository connects to the MongoDB server at uri and creates the
+// indexes. The database is the one named in uri, or dadjokes.
+func openMongoRepository(ctx context.Context, uri string) (*mongoRepository, error) {
+    cs, err := connstring.ParseAndValidate(uri)
+    if err != nil {
+        return nil, err
+    }
+    name := cs.Database
+    if name == "" {
+        name = defaultMongoDatabase
+    }
+
+    pool, err := loadPoolConfig()
+    if err != nil {
+        return nil, err
+    }
+    opts := options.Client().ApplyURI(uri)
+    pool.applyMongo(opts)
+    client, err := mongo.Connect(opts)
+    if err != nil {
+        return nil, err
+    }
+    err = pool.connect(ctx, func(ctx context.Context) error { return client.Ping(ctx, nil) })
+    if err != nil {
+        client.Disconnect(ctx)
+        return nil, fmt.Errorf("connecting: %w", err)
+    }
+    r := &mongoRepository{client: client, db: client.Database(name)}
+
+    for collection, indexes := range mongoIndexes {
+        if _, err := r.db.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+            client.Disconnect(ctx)
+            return nil, err
+        }
+    }
+    return r, nil
+}
+
+// readyChecks are the /readyz checks of MongoDB. There are no migrations to
+// wait for.
+func (r *mongoRepository) readyChecks() map[string]readyCheck {
+    return map[string]readyCheck{
+        "database": func(ctx context.Context) healthCheck {
+            if err := r.client.Ping(ctx, nil); err != nil {
+                return failedCheck(ctx, "database", errDatabaseUnreachable, "error", err)
+            }
+            return healthCheck{Status: "ok"}
+        },
+    }
+}
+
+// schemaDrift reports no drift: documents have no fixed schema, and every
+// field this build doesn't find reads as empty.
+func (r *mongoRepository) schemaDrift(ctx context.Context) (schemaDrift, error) {
+    return schemaDrift{}, nil
+}
+
+func (r *mongoRepository) Close() error {
+    return r.client.Disconnect(context.Background())
+}
+
+// reserveIDs allocates n consecutive ids for collection from the counters
+// collection and returns the first. MongoDB has no auto increment.
+func (r *mongoRepository) reserveIDs(ctx context.Context, collection string, n int) (int, error) {
+    var counter struct {
+        Seq int `bson:"seq"`
+    }
+    err := r.db.Collection("counters").FindOneAndUpdate(ctx,
+        bson.M{"_id": collection},
+        bson.M{"$inc": bson.M{"seq": n}},
+        options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+    ).Decode(&counter)
+    return counter.Seq - n + 1, err
+}
+
+func (r *mongoRepository) nextID(ctx context.Context, collection string) (int, error) {
+    return r.reserveIDs(ctx, collection, 1)
+}
+
+// findJoke returns the first joke matching filter in the order given by
+// opts, or errJokeNotFound.
+func (r *mongoRepository) findJoke(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) (Joke, error) {
+    var doc mongoJoke
+    err := r.db.Collection("jokes").FindOne(ctx, filter, opts...).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Joke{}, errJokeNotFound
+    }
+    return doc.joke(), err
+}
+
+// findJokes returns every joke matching filter.
+func (r *mongoRepository) findJokes(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) ([]Joke, error) {
+    cursor, err := r.db.Collection("jokes").Find(ctx, filter, opts...)
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    jokes := make([]Joke, 0, len(docs))
+    for _, doc := range docs {
+        jokes = append(jokes, doc.joke())
+    }
+    return jokes, nil
+}
+
+var (
+    byID       = bson.D{{Key: "_id", Value: 1}}
+    byIDDesc   = bson.D{{Key: "_id", Value: -1}}
+    onlyJokeID = bson.M{"_id": 1}
+)
+
+// visibleOnly adds the condition that leaves out jokes hidden by moderation
+// or deleted to filter, like visible does for SQL.
+func visibleOnly(filter bson.M) bson.M {
+    filter["hidden_at"] = nil
+    filter["deleted_at"] = nil
+    return filter
+}
+
+// languageOnly adds the condition that keeps only jokes in lang to filter,
+// unless lang is empty, like inLanguage does for SQL. Jokes without a
+// language are in the default one.
+func languageOnly(filter bson.M, lang string) bson.M {
+    switch lang {
+    case "":
+    case defaultLanguage:
+        filter["language"] = bson.M{"$in": bson.A{lang, nil}}
+    default:
+        filter["language"] = lang
+    }
+    return filter
+}
+
+// Random uses the same strategy as the SQL repository: pick an id between
+// the lowest and highest and take the first joke at or after it. Each step
+// is a lookup on _id, where $sample would read the whole collection on
+// servers that can't sample from an index.
+func (r *mongoRepository) Random(ctx context.Context, lang string) (Joke, error) {
+    first, err := r.findJoke(ctx, languageOnly(visibleOnly(bson.M{}), lang), options.FindOne().SetSort(byID))
+    if err != nil {
+        return first, err
+    }
+    last, err := r.findJoke(ctx, languageOnly(visibleOnly(bson.M{}), lang), options.FindOne().SetSort(byIDDesc))
+    if err != nil {
+        return last, err
+    }
+
+    target := first.Id + rand.IntN(last.Id-first.Id+1)
+    joke, err := r.findJoke(ctx, languageOnly(visibleOnly(bson.M{"_id": bson.M{"$gte": target}}), lang), options.FindOne().SetSort(byID))
+    if errors.Is(err, errJokeNotFound) {
+        return first, nil
+    }
+    return joke, err
+}
+
+func (r *mongoRepository) Get(ctx context.Context, id int) (Joke, error) {
+    return r.findJoke(ctx, visibleOnly(bson.M{"_id": id}))
+}
+
+func (r *mongoRepository) List(ctx context.Context, offset, limit, maxID int, lang string) ([]Joke, error) {
+    filter := languageOnly(visibleOnly(bson.M{}), lang)
+    if maxID > 0 {
+        filter["_id"] = bson.M{"$lte": maxID}
+    }
+    return r.findJokes(ctx, filter, options.Find().SetSort(byIDDesc).SetSkip(int64(offset)).SetLimit(int64(limit)))
+}
+
+func (r *mongoRepository) ListAfter(ctx context.Context, afterID, limit int, lang string) ([]Joke, error) {
+    return r.findJokes(ctx, languageOnly(visibleOnly(bson.M{"_id": bson.M{"$gt": afterID}}), lang), options.Find().SetSort(byID).SetLimit(int64(limit)))
+}
+
+func (r *mongoRepository) Languages(ctx context.Context) ([]string, error) {
+    var langs []string
+    err := r.db.Collection("jokes").Distinct(ctx, "language", visibleOnly(bson.M{})).Decode(&langs)
+    if err != nil {
+        return nil, err
+    }
+
+    // Distinct skips jokes without a language, which are in the default
+    // one.
+    if !slices.Contains(langs, defaultLanguage) {
+        _, err := r.findJoke(ctx, visibleOnly(bson.M{"language": nil}), options.FindOne().SetProjection(onlyJokeID))
+        if err == nil {
+            langs = append(langs, defaultLanguage)
+            slices.Sort(langs)
+        } else if !errors.Is(err, errJokeNotFound) {
+            return nil, err
+        }
+    }
+    if langs == nil {
+        langs = []string{}
+    }
+    return langs, nil
+}
+
+func (r *mongoRepository) MaxID(ctx context.Context) (int, error) {
+    joke, err := r.findJoke(ctx, bson.M{}, options.FindOne().SetSort(byIDDesc).SetProjection(onlyJokeID))
+    if errors.Is(err, errJokeNotFound) {
+        return 0, nil
+    }
+    return joke.Id, err
+}
+
+// Create stores joke under the canonical name of its author, like the SQL
+// repository. A joke whose text matches an existing one fails with a
+// *duplicateJokeError carrying the existing joke.
+func (r *mongoRepository) Create(ctx context.Context, joke *Joke) error {
+    return r.create(ctx, joke)
+}
+
+// CreateBatch creates the jokes one at a time. Unlike the SQL repository it
+// isn't atomic: an error stops the batch but keeps the jokes already
+// created.
+func (r *mongoRepository) CreateBatch(ctx context.Context, jokes []Joke) ([]error, error) {
+    errs := make([]error, len(jokes))
+    for i := range jokes {
+        err := r.create(ctx, &jokes[i])
+        var duplicate *duplicateJokeError
+        if errors.As(err, &duplicate) {
+            errs[i] = err
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    return errs, nil
+}
+
+func (r *mongoRepository) create(ctx context.Context, joke *Joke) error {
+    hash := jokeTextHash(joke.Text)
+    if err := r.checkDuplicate(ctx, hash); err != nil {
+        return err
+    }
+
+    if joke.Author != "" {
+        author, err := r.resolveAuthor(ctx, joke.Author)
+        if err != nil {
+            return err
+        }
+        joke.Author = author.Name
+    }
+
+    joke.Date = time.Now().UTC().Truncate(time.Second)
+    if joke.OriginalDate != nil {
+        joke.Date = joke.OriginalDate.UTC().Truncate(time.Second)
+        joke.OriginalDate = nil
+    }
+
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+    id, err := r.insertJoke(ctx, mongoJoke{Date: joke.Date, Author: joke.Author, Text: joke.Text, TextHash: hash, Language: joke.Language})
+    if mongo.IsDuplicateKeyError(err) {
+        // A concurrent submission of the same joke got in between the check
+        // and the insert. Report the winner.
+        if dupErr := r.checkDuplicate(ctx, hash); dupErr != nil {
+            return dupErr
+        }
+    }
+    if err != nil {
+        return err
+    }
+
+    joke.Id = id
+    return r.recordChange(ctx, id, changeCreate)
+}
+
+// insertJoke stores doc under a new id and returns it.
+func (r *mongoRepository) insertJoke(ctx context.Context, doc mongoJoke) (int, error) {
+    id, err := r.nextID(ctx, "jokes")
+    if err != nil {
+        return 0, err
+    }
+    doc.Id = id
+    _, err = r.db.Collection("jokes").InsertOne(ctx, doc)
+    return id, err
+}
+
+// checkDuplicate returns a *duplicateJokeError if a joke with the given text
+// hash exists. Hidden and deleted jokes still hold on to their text, but
+// aren't given away in the error.
+func (r *mongoRepository) checkDuplicate(ctx context.Context, hash string) error {
+    var doc mongoJoke
+    err := r.db.Collection("jokes").FindOne(ctx, bson.M{"text_hash": hash}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if doc.HiddenAt != nil || doc.DeletedAt != nil {
+        return &duplicateJokeError{}
+    }
+    return &duplicateJokeError{Existing: doc.joke()}
+}
+
+// BackfillTextHashes hashes jokes stored without a hash, such as jokes
+// loaded into the collection by hand. Jokes that duplicate an already
+// hashed one are left without, for a moderator to clean up.
+func (r *mongoRepository) BackfillTextHashes(ctx context.Context) error {
+    cursor, err := r.db.Collection("jokes").Find(ctx, bson.M{"text_hash": bson.M{"$exists": false}}, options.Find().SetSort(byID))
+    if err != nil {
+        return err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return err
+    }
+
+    for _, doc := range docs {
+        hash := jokeTextHash(doc.Text)
+        err := r.checkDuplicate(ctx, hash)
+        var duplicate *duplicateJokeError
+        if errors.As(err, &duplicate) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        _, err = r.db.Collection("jokes").UpdateOne(ctx, bson.M{"_id": doc.Id}, bson.M{"$set": bson.M{"text_hash": hash}})
+        if err != nil && !mongo.IsDuplicateKeyError(err) {
+            return err
+        }
+    }
+    return nil
+}
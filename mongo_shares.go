@@ -0,0 +1,88 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type mongoShare struct {
+    JokeId      int       `bson:"joke_id"`
+    Referrer    string    `bson:"referrer"`
+    UTMSource   string    `bson:"utm_source"`
+    UTMMedium   string    `bson:"utm_medium"`
+    UTMCampaign string    `bson:"utm_campaign"`
+    SharedAt    time.Time `bson:"shared_at"`
+}
+
+func (r *mongoRepository) RecordShares(ctx context.Context, shares []Share) error {
+    docs := make([]mongoShare, len(shares))
+    for i, s := range shares {
+        docs[i] = mongoShare{JokeId: s.JokeId, Referrer: s.Referrer, UTMSource: s.UTMSource, UTMMedium: s.UTMMedium, UTMCampaign: s.UTMCampaign, SharedAt: s.SharedAt}
+    }
+    _, err := r.db.Collection("joke_shares").InsertMany(ctx, docs)
+    return err
+}
+
+func (r *mongoRepository) ShareStats(ctx context.Context, jokeID, limit int) (ShareStats, error) {
+    stats := ShareStats{JokeId: jokeRef(jokeID)}
+    collection := r.db.Collection("joke_shares")
+    total, err := collection.CountDocuments(ctx, bson.M{"joke_id": jokeID})
+    if err != nil {
+        return stats, err
+    }
+    stats.Total = int(total)
+
+    var last mongoShare
+    err = collection.FindOne(ctx, bson.M{"joke_id": jokeID}, options.FindOne().SetSort(bson.D{{Key: "shared_at", Value: -1}})).Decode(&last)
+    if err == nil {
+        sharedAt := last.SharedAt.UTC()
+        stats.LastSharedAt = &sharedAt
+    } else if !errors.Is(err, mongo.ErrNoDocuments) {
+        return stats, err
+    }
+
+    for field, counts := range map[string]*[]shareCount{
+        "referrer":     &stats.Referrers,
+        "utm_source":   &stats.Sources,
+        "utm_medium":   &stats.Mediums,
+        "utm_campaign": &stats.Campaigns,
+    } {
+        *counts, err = r.shareCounts(ctx, jokeID, field, limit)
+        if err != nil {
+            return stats, err
+        }
+    }
+    return stats, nil
+}
+
+// shareCounts returns the most common non-empty values of field among the
+// shares of a joke.
+func (r *mongoRepository) shareCounts(ctx context.Context, jokeID int, field string, limit int) ([]shareCount, error) {
+    cursor, err := r.db.Collection("joke_shares").Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"joke_id": jokeID, field: bson.M{"$ne": ""}}}},
+        {{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+        {{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+        {{Key: "$limit", Value: limit}},
+    })
+    if err != nil {
+        return nil, err
+    }
+    var groups []struct {
+        Value string `bson:"_id"`
+        Count int    `bson:"count"`
+    }
+    if err := cursor.All(ctx, &groups); err != nil {
+        return nil, err
+    }
+
+    counts := make([]shareCount, len(groups))
+    for i, group := range groups {
+        counts[i] = shareCount{Value: group.Value, Count: group.Count}
+    }
+    return counts, nil
+}
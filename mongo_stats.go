@@ -0,0 +1,36 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (r *mongoRepository) CountJokes(ctx context.Context) (visible, hidden int, err error) {
+    jokes := r.db.Collection("jokes")
+    total, err := jokes.CountDocuments(ctx, bson.M{"deleted_at": nil})
+    if err != nil {
+        return 0, 0, err
+    }
+    n, err := jokes.CountDocuments(ctx, bson.M{"hidden_at": bson.M{"$ne": nil}, "deleted_at": nil})
+    return int(total - n), int(n), err
+}
+
+func (r *mongoRepository) SubmissionDates(ctx context.Context, since time.Time) ([]time.Time, error) {
+    cursor, err := r.db.Collection("jokes").Find(ctx, bson.M{"entry_date": bson.M{"$gte": since}}, options.Find().SetProjection(bson.M{"entry_date": 1}))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    dates := make([]time.Time, 0, len(docs))
+    for _, doc := range docs {
+        dates = append(dates, doc.Date)
+    }
+    return dates, nil
+}
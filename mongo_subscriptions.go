@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type mongoSubscription struct {
+    Id          int        `bson:"_id"`
+    Email       string     `bson:"email"`
+    Frequency   string     `bson:"frequency"`
+    Token       string     `bson:"token"`
+    CreatedAt   time.Time  `bson:"created_at"`
+    ConfirmedAt *time.Time `bson:"confirmed_at"`
+    LastSentAt  *time.Time `bson:"last_sent_at"`
+}
+
+func (s mongoSubscription) subscription() Subscription {
+    return Subscription{
+        Id:          s.Id,
+        Email:       s.Email,
+        Frequency:   s.Frequency,
+        Token:       s.Token,
+        CreatedAt:   s.CreatedAt.UTC(),
+        ConfirmedAt: utcTimePtr(s.ConfirmedAt),
+        LastSentAt:  utcTimePtr(s.LastSentAt),
+    }
+}
+
+// subscriptionDueFilter matches confirmed subscriptions that haven't been
+// sent anything since dailyBefore, or weeklyBefore for weekly ones.
+func subscriptionDueFilter(dailyBefore, weeklyBefore time.Time) bson.M {
+    return bson.M{
+        "confirmed_at": bson.M{"$ne": nil},
+        "$or": bson.A{
+            bson.M{"last_sent_at": nil},
+            bson.M{"frequency": "daily", "last_sent_at": bson.M{"$lt": dailyBefore}},
+            bson.M{"frequency": "weekly", "last_sent_at": bson.M{"$lt": weeklyBefore}},
+        },
+    }
+}
+
+func (r *mongoRepository) subscriptionBy(ctx context.Context, filter bson.M) (Subscription, error) {
+    var doc mongoSubscription
+    err := r.db.Collection("subscriptions").FindOne(ctx, filter).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Subscription{}, errSubscriptionNotFound
+    }
+    return doc.subscription(), err
+}
+
+func (r *mongoRepository) Subscribe(ctx context.Context, email, frequency, token string) (Subscription, error) {
+    now := time.Now().UTC().Truncate(time.Second)
+    s, err := r.subscriptionBy(ctx, bson.M{"email": email})
+    if errors.Is(err, errSubscriptionNotFound) {
+        id, err := r.nextID(ctx, "subscriptions")
+        if err != nil {
+            return Subscription{}, err
+        }
+        s = Subscription{Id: id, Email: email, Frequency: frequency, Token: token, CreatedAt: now}
+        _, err = r.db.Collection("subscriptions").InsertOne(ctx, mongoSubscription{Id: id, Email: email, Frequency: frequency, Token: token, CreatedAt: now})
+        return s, err
+    }
+    if err != nil || s.ConfirmedAt != nil {
+        return s, err
+    }
+
+    s.Frequency, s.CreatedAt = frequency, now
+    _, err = r.db.Collection("subscriptions").UpdateOne(ctx,
+        bson.M{"_id": s.Id, "confirmed_at": nil},
+        bson.M{"$set": bson.M{"frequency": frequency, "created_at": now}},
+    )
+    return s, err
+}
+
+func (r *mongoRepository) ConfirmSubscription(ctx context.Context, token string, since time.Time) (Subscription, error) {
+    now := time.Now().UTC().Truncate(time.Second)
+    _, err := r.db.Collection("subscriptions").UpdateOne(ctx,
+        bson.M{"token": token, "confirmed_at": nil, "created_at": bson.M{"$gte": since}},
+        bson.M{"$set": bson.M{"confirmed_at": now}},
+    )
+    if err != nil {
+        return Subscription{}, err
+    }
+
+    s, err := r.subscriptionBy(ctx, bson.M{"token": token})
+    if err == nil && s.ConfirmedAt == nil {
+        return s, errSubscriptionNotFound
+    }
+    return s, err
+}
+
+func (r *mongoRepository) Unsubscribe(ctx context.Context, token string) (Subscription, error) {
+    var doc mongoSubscription
+    err := r.db.Collection("subscriptions").FindOneAndDelete(ctx, bson.M{"token": token}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Subscription{}, errSubscriptionNotFound
+    }
+    return doc.subscription(), err
+}
+
+func (r *mongoRepository) ClaimDigests(ctx context.Context, dailyBefore, weeklyBefore, now time.Time, limit int) ([]Subscription, error) {
+    due := subscriptionDueFilter(dailyBefore, weeklyBefore)
+    cursor, err := r.db.Collection("subscriptions").Find(ctx, due, options.Find().SetSort(byID).SetLimit(int64(limit)))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoSubscription
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    // Repeating the due condition makes the update a compare-and-swap, so
+    // when two instances race for a subscription only one of them gets it.
+    var claimed []Subscription
+    for _, doc := range docs {
+        filter := subscriptionDueFilter(dailyBefore, weeklyBefore)
+        filter["_id"] = doc.Id
+        result, err := r.db.Collection("subscriptions").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_sent_at": now}})
+        if err != nil {
+            return claimed, err
+        }
+        if result.ModifiedCount == 1 {
+            claimed = append(claimed, doc.subscription())
+        }
+    }
+    return claimed, nil
+}
+
+func (r *mongoRepository) ReleaseDigest(ctx context.Context, id int, lastSentAt *time.Time) error {
+    _, err := r.db.Collection("subscriptions").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_sent_at": lastSentAt}})
+    return err
+}
+
+func (r *mongoRepository) PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error) {
+    result, err := r.db.Collection("subscriptions").DeleteMany(ctx, bson.M{"confirmed_at": nil, "created_at": bson.M{"$lt": before}})
+    if err != nil {
+        return 0, err
+    }
+    return int(result.DeletedCount), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoTheme is a document of the card_themes collection, keyed by name.
+type mongoTheme struct {
+    Name        string    `bson:"_id"`
+    Background  string    `bson:"background"`
+    Border      string    `bson:"border"`
+    TextColor   string    `bson:"text_color"`
+    AuthorColor string    `bson:"author_color"`
+    FontFamily  string    `bson:"font_family"`
+    FontSize    int       `bson:"font_size"`
+    Width       int       `bson:"width"`
+    UpdatedAt   time.Time `bson:"updated_at"`
+}
+
+func (t mongoTheme) theme() Theme {
+    return Theme(t)
+}
+
+func (r *mongoRepository) Themes(ctx context.Context) ([]Theme, error) {
+    cursor, err := r.db.Collection("card_themes").Find(ctx, bson.M{}, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoTheme
+    if err := cursor.All(ctx, &docs); err != nil {
+        return nil, err
+    }
+
+    list := make([]Theme, 0, len(docs))
+    for _, doc := range docs {
+        list = append(list, doc.theme())
+    }
+    return list, nil
+}
+
+func (r *mongoRepository) Theme(ctx context.Context, name string) (Theme, error) {
+    var doc mongoTheme
+    err := r.db.Collection("card_themes").FindOne(ctx, bson.M{"_id": name}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return Theme{}, errThemeNotFound
+    }
+    return doc.theme(), err
+}
+
+func (r *mongoRepository) SaveTheme(ctx context.Context, theme Theme) error {
+    _, err := r.db.Collection("card_themes").ReplaceOne(ctx, bson.M{"_id": theme.Name}, mongoTheme(theme), options.Replace().SetUpsert(true))
+    return err
+}
+
+func (r *mongoRepository) DeleteTheme(ctx context.Context, name string) error {
+    result, err := r.db.Collection("card_themes").DeleteOne(ctx, bson.M{"_id": name})
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
+        return errThemeNotFound
+    }
+    return nil
+}
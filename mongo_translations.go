@@ -0,0 +1,38 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoTranslation is a document of the joke_translations collection, unique
+// by joke and language.
+type mongoTranslation struct {
+    JokeID    int       `bson:"joke_id"`
+    Language  string    `bson:"language"`
+    Text      string    `bson:"joke_text"`
+    Provider  string    `bson:"provider"`
+    CreatedAt time.Time `bson:"created_at"`
+}
+
+func (r *mongoRepository) Translation(ctx context.Context, jokeID int, lang string) (JokeTranslation, error) {
+    var doc mongoTranslation
+    err := r.db.Collection("joke_translations").FindOne(ctx, bson.M{"joke_id": jokeID, "language": lang}).Decode(&doc)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return JokeTranslation{JokeID: jokeID, Language: lang}, errTranslationNotFound
+    }
+    doc.CreatedAt = doc.CreatedAt.UTC()
+    return JokeTranslation(doc), err
+}
+
+func (r *mongoRepository) SaveTranslation(ctx context.Context, t JokeTranslation) error {
+    doc := mongoTranslation(t)
+    doc.CreatedAt = doc.CreatedAt.UTC()
+    _, err := r.db.Collection("joke_translations").ReplaceOne(ctx, bson.M{"joke_id": t.JokeID, "language": t.Language}, doc, options.Replace().SetUpsert(true))
+    return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (r *mongoRepository) Delete(ctx context.Context, id int) error {
+    return r.setDeleted(ctx, id, true)
+}
+
+func (r *mongoRepository) Restore(ctx context.Context, id int) error {
+    return r.setDeleted(ctx, id, false)
+}
+
+// setDeleted deletes or restores a joke and records the change. It returns
+// errJokeNotFound when there's no joke to change.
+func (r *mongoRepository) setDeleted(ctx context.Context, id int, deleted bool) error {
+    filter, update, op := bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}}, bson.M{"$unset": bson.M{"deleted_at": ""}}, changeRestore
+    if deleted {
+        filter, update, op = bson.M{"_id": id, "deleted_at": nil}, bson.M{"$set": bson.M{"deleted_at": time.Now().UTC().Truncate(time.Second)}}, changeDelete
+    }
+
+    result, err := r.db.Collection("jokes").UpdateOne(ctx, filter, update)
+    if err != nil {
+        return err
+    }
+    if result.ModifiedCount == 0 {
+        return errJokeNotFound
+    }
+    return r.recordChange(ctx, id, op)
+}
+
+func (r *mongoRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+    cursor, err := r.db.Collection("jokes").Find(ctx, bson.M{"deleted_at": bson.M{"$lt": before.UTC()}}, options.Find().SetProjection(onlyJokeID))
+    if err != nil {
+        return 0, err
+    }
+    var docs []mongoJoke
+    if err := cursor.All(ctx, &docs); err != nil {
+        return 0, err
+    }
+    if len(docs) == 0 {
+        return 0, nil
+    }
+    ids := make(bson.A, len(docs))
+    for i, doc := range docs {
+        ids[i] = doc.Id
+    }
+
+    // Without a transaction, remove what belongs to the jokes first, so a
+    // failure leaves the jokes to be purged again next time.
+    for _, collection := range purgeTables {
+        if _, err := r.db.Collection(collection).DeleteMany(ctx, bson.M{"joke_id": bson.M{"$in": ids}}); err != nil {
+            return 0, err
+        }
+    }
+    result, err := r.db.Collection("jokes").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        return 0, err
+    }
+    return int(result.DeletedCount), nil
+}
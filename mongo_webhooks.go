@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type mongoWebhook struct {
+    Id        int       `bson:"_id"`
+    URL       string    `bson:"url"`
+    Secret    string    `bson:"secret"`
+    CreatedAt time.Time `bson:"created_at"`
+}
+
+// mongoDelivery is a document of the webhook_deliveries collection. A nil
+// NextAttemptAt means the delivery is done with, delivered or not.
+type mongoDelivery struct {
+    Id            int        `bson:"_id"`
+    WebhookID     int        `bson:"webhook_id"`
+    Event         string     `bson:"event"`
+    Payload       string     `bson:"payload"`
+    Attempts      int        `bson:"attempts"`
+    NextAttemptAt *time.Time `bson:"next_attempt_at"`
+    DeliveredAt   *time.Time `bson:"delivered_at"`
+    LastError     *string    `bson:"last_error"`
+}
+
+func (r *mongoRepository) CreateWebhook(ctx context.Context, url, secret string) (Webhook, error) {
+    webhook := Webhook{URL: url, Secret: secret, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+    id, err := r.nextID(ctx, "webhooks")
+    if err != nil {
+        return webhook, err
+    }
+    webhook.Id = id
+    _, err = r.db.Collection("webhooks").InsertOne(ctx, mongoWebhook{Id: id, URL: url, Secret: secret, CreatedAt: webhook.CreatedAt})
+    return webhook, err
+}
+
+// webhookDocs returns the webhooks matching filter, oldest first.
+func (r *mongoRepository) webhookDocs(ctx context.Context, filter any) ([]mongoWebhook, error) {
+    cursor, err := r.db.Collection("webhooks").Find(ctx, filter, options.Find().SetSort(byID))
+    if err != nil {
+        return nil, err
+    }
+    var docs []mongoWebhook
+    return docs, cursor.All(ctx, &docs)
+}
+
+func (r *mongoRepository) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+    docs, err := r.webhookDocs(ctx, bson.M{})
+    if err != nil {
+        return nil, err
+    }
+
+    list := []Webhook{}
+    for _, doc := range docs {
+        list = append(list, Webhook{Id: doc.Id, URL: doc.URL, CreatedAt: doc.CreatedAt.UTC()})
+    }
+    return list, nil
+}
+
+func (r *mongoRepository) DeleteWebhook(ctx context.Context, id int) error {
+    result, err := r.db.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": id})
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
+        return errWebhookNotFound
+    }
+
+    _, err = r.db.Collection("webhook_deliveries").DeleteMany(ctx, bson.M{"webhook_id": id, "delivered_at": nil})
+    return err
+}
+
+func (r *mongoRepository) EnqueueWebhooks(ctx context.Context, event string, payload []byte) error {
+    hooks, err := r.webhookDocs(ctx, bson.M{})
+    if err != nil || len(hooks) == 0 {
+        return err
+    }
+
+    first, err := r.reserveIDs(ctx, "webhook_deliveries", len(hooks))
+    if err != nil {
+        return err
+    }
+    now := time.Now().UTC().Truncate(time.Second)
+    deliveries := make([]mongoDelivery, len(hooks))
+    for i, hook := range hooks {
+        deliveries[i] = mongoDelivery{Id: first + i, WebhookID: hook.Id, Event: event, Payload: string(payload), NextAttemptAt: &now}
+    }
+    _, err = r.db.Collection("webhook_deliveries").InsertMany(ctx, deliveries)
+    return err
+}
+
+func (r *mongoRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhookDelivery, error) {
+    now := time.Now().UTC().Truncate(time.Second)
+    cursor, err := r.db.Collection("webhook_deliveries").Find(ctx,
+        bson.M{"next_attempt_at": bson.M{"$lte": now}},
+        options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit)),
+    )
+    if err != nil {
+        return nil, err
+    }
+    var due []mongoDelivery
+    if err := cursor.All(ctx, &due); err != nil {
+        return nil, err
+    }
+
+    ids := make([]int, 0, len(due))
+    for _, delivery := range due {
+        ids = append(ids, delivery.WebhookID)
+    }
+    hooks, err := r.webhookDocs(ctx, bson.M{"_id": bson.M{"$in": ids}})
+    if err != nil {
+        return nil, err
+    }
+    byWebhook := make(map[int]mongoWebhook, len(hooks))
+    for _, hook := range hooks {
+        byWebhook[hook.Id] = hook
+    }
+
+    // Pushing next_attempt_at out is the lease. The attempts check makes it
+    // a compare-and-swap, so when two instances race for a delivery only
+    // one of them gets it.
+    var claimed []webhookDelivery
+    for _, delivery := range due {
+        hook, ok := byWebhook[delivery.WebhookID]
+        if !ok {
+            continue
+        }
+        result, err := r.db.Collection("webhook_deliveries").UpdateOne(ctx,
+            bson.M{"_id": delivery.Id, "attempts": delivery.Attempts, "next_attempt_at": bson.M{"$lte": now}},
+            bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+        )
+        if err != nil {
+            return claimed, err
+        }
+        if result.ModifiedCount == 1 {
+            claimed = append(claimed, webhookDelivery{
+                Id:       delivery.Id,
+                URL:      hook.URL,
+                Secret:   hook.Secret,
+                Event:    delivery.Event,
+                Payload:  []byte(delivery.Payload),
+                Attempts: delivery.Attempts,
+            })
+        }
+    }
+    return claimed, nil
+}
+
+func (r *mongoRepository) FinishDelivery(ctx context.Context, id int, retryAt *time.Time, attemptErr string) error {
+    var deliveredAt *time.Time
+    var lastError *string
+    if attemptErr == "" {
+        now := time.Now().UTC().Truncate(time.Second)
+        deliveredAt = &now
+    } else {
+        lastError = &attemptErr
+    }
+
+    _, err := r.db.Collection("webhook_deliveries").UpdateOne(ctx,
+        bson.M{"_id": id},
+        bson.M{
+            "$inc": bson.M{"attempts": 1},
+            "$set": bson.M{"next_attempt_at": retryAt, "delivered_at": deliveredAt, "last_error": lastError},
+        },
+    )
+    return err
+}
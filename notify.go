@@ -0,0 +1,366 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "slices"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// notifyTimeout bounds sending a single notification.
+const notifyTimeout = 30 * time.Second
+
+// Events that notifications are sent for.
+const (
+    eventJokeFlagged    = "joke.flagged"
+    eventJokeHidden     = "joke.hidden"
+    eventFlagsEscalated = "flags.escalated"
+    eventQueueBacklog   = "queue.backlog"
+    // eventDigest stands for several notifications batched into one.
+    eventDigest = "digest"
+)
+
+// notification is an event that the people running the service are told
+// about. It's what the channels' templates are executed with.
+type notification struct {
+    Event   string    `json:"event"`
+    Title   string    `json:"title"`
+    Message string    `json:"message"`
+    JokeId  jokeRef   `json:"joke_id,omitempty"`
+    Joke    string    `json:"joke,omitempty"`
+    Time    time.Time `json:"time"`
+}
+
+// notifyMessage is a notification as rendered by a channel's template.
+type notifyMessage struct {
+    Subject string
+    Text    string
+}
+
+// Notifier sends notifications to one channel, such as a chat room or a
+// phone number.
+type Notifier interface {
+    Notify(ctx context.Context, message notifyMessage) error
+}
+
+// notifierKinds builds the notifiers NOTIFY_CHANNELS can name, each from
+// its own environment variables. A new kind of channel only needs an entry
+// here.
+var notifierKinds = map[string]func() (Notifier, error){
+    "email":   newEmailNotifier,
+    "slack":   newSlackNotifier,
+    "discord": newDiscordNotifier,
+    "sms":     newSMSNotifier,
+}
+
+// defaultNotifyTemplate renders a notification when the channel has no
+// NOTIFY_<CHANNEL>_TEMPLATE.
+const defaultNotifyTemplate = `{{.Message}}{{with .Joke}}
+
+> {{.}}{{end}}`
+
+// notifyChannel is a configured channel: its notifier, the events it wants
+// and the template it renders them with.
+type notifyChannel struct {
+    notifier Notifier
+    // events is empty when the channel wants every event.
+    events   []string
+    template *template.Template
+}
+
+func (c *notifyChannel) wants(event string) bool {
+    return len(c.events) == 0 || slices.Contains(c.events, event)
+}
+
+func (c *notifyChannel) render(n notification) (notifyMessage, error) {
+    var text strings.Builder
+    if err := c.template.Execute(&text, n); err != nil {
+        return notifyMessage{}, err
+    }
+    return notifyMessage{Subject: n.Title, Text: text.String()}, nil
+}
+
+// notifyChannels are the channels named in NOTIFY_CHANNELS, by name.
+var notifyChannels = map[string]*notifyChannel{}
+
+// loadNotifiers reads NOTIFY_CHANNELS, a comma separated list of channels,
+// and for each channel NOTIFY_<CHANNEL>_EVENTS, the events it's sent, and
+// NOTIFY_<CHANNEL>_TEMPLATE, a text/template for its messages.
+func loadNotifiers() error {
+    for _, name := range splitList(os.Getenv("NOTIFY_CHANNELS")) {
+        newNotifier, ok := notifierKinds[name]
+        if !ok {
+            return fmt.Errorf("NOTIFY_CHANNELS: unknown channel %q", name)
+        }
+        notifier, err := newNotifier()
+        if err != nil {
+            return err
+        }
+
+        prefix := "NOTIFY_" + strings.ToUpper(name) + "_"
+        tmpl, err := template.New(name).Parse(getEnv(prefix+"TEMPLATE", defaultNotifyTemplate))
+        if err != nil {
+            return fmt.Errorf("%sTEMPLATE: %w", prefix, err)
+        }
+        notifyChannels[name] = &notifyChannel{
+            notifier: notifier,
+            events:   splitList(os.Getenv(prefix + "EVENTS")),
+            template: tmpl,
+        }
+    }
+    return nil
+}
+
+// notify queues n for every channel that wants it, or adds it to the
+// channel's next digest when notifications are batched. Notifications are
+// sent by the job runner, which retries them; one that can't be queued is
+// only logged.
+func notify(ctx context.Context, n notification) {
+    n.Time = time.Now().UTC().Truncate(time.Second)
+    for name, channel := range notifyChannels {
+        if !channel.wants(n.Event) {
+            continue
+        }
+        if notifyDigest != nil {
+            notifyDigest.add(name, n)
+            continue
+        }
+        sendNotification(ctx, name, n)
+    }
+}
+
+func sendNotification(ctx context.Context, channel string, n notification) {
+    if err := enqueueJob(ctx, "notify.send", notifyJob{Channel: channel, Notification: n}); err != nil {
+        slog.ErrorContext(ctx, "Error queueing notification", "channel", channel, "event", n.Event, "error", err)
+    }
+}
+
+// notifyDigest batches notifications when NOTIFY_DIGEST_INTERVAL is set, so
+// a burst of reports is one message per channel rather than a storm of
+// them. It's nil, and notifications are sent right away, otherwise.
+var notifyDigest *notificationDigest
+
+// notificationDigest holds each channel's notifications until the next
+// send.
+type notificationDigest struct {
+    interval time.Duration
+    mu       sync.Mutex
+    pending  map[string][]notification
+}
+
+// loadNotifyDigest reads NOTIFY_DIGEST_INTERVAL, how long notifications are
+// held to be sent together. It returns nil when it's unset or 0.
+func loadNotifyDigest() (*notificationDigest, error) {
+    interval, err := getEnvDuration("NOTIFY_DIGEST_INTERVAL", 0)
+    if err != nil || interval <= 0 {
+        return nil, err
+    }
+    return &notificationDigest{interval: interval, pending: map[string][]notification{}}, nil
+}
+
+func (d *notificationDigest) add(channel string, n notification) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.pending[channel] = append(d.pending[channel], n)
+}
+
+// run sends the held notifications every interval until ctx is cancelled.
+// Whatever is held then is sent by flush on shutdown.
+func (d *notificationDigest) run(ctx context.Context) {
+    ticker := time.NewTicker(d.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            d.flush(ctx)
+        }
+    }
+}
+
+// flush queues the held notifications, one per channel: a notification held
+// alone is sent as it is, several as a digest listing them.
+func (d *notificationDigest) flush(ctx context.Context) {
+    if d == nil {
+        return
+    }
+    d.mu.Lock()
+    pending := d.pending
+    d.pending = map[string][]notification{}
+    d.mu.Unlock()
+
+    for channel, held := range pending {
+        sendNotification(ctx, channel, digestOf(held))
+    }
+}
+
+// digestOf combines notifications into one, with a line per notification.
+func digestOf(held []notification) notification {
+    if len(held) == 1 {
+        return held[0]
+    }
+    var lines strings.Builder
+    for i, n := range held {
+        if i > 0 {
+            lines.WriteByte('\n')
+        }
+        fmt.Fprintf(&lines, "- %s %s", n.Time.Format(time.TimeOnly), n.Message)
+    }
+    return notification{
+        Event:   eventDigest,
+        Title:   fmt.Sprintf("%d moderation notifications", len(held)),
+        Message: fmt.Sprintf("%d moderation notifications since %s:\n%s", len(held), held[0].Time.Format(time.RFC3339), lines.String()),
+        Time:    time.Now().UTC().Truncate(time.Second),
+    }
+}
+
+type notifyJob struct {
+    Channel      string       `json:"channel"`
+    Notification notification `json:"notification"`
+}
+
+// sendNotificationJob renders a notification and sends it to its channel.
+func sendNotificationJob(ctx context.Context, payload json.RawMessage) error {
+    var job notifyJob
+    if err := json.Unmarshal(payload, &job); err != nil {
+        return err
+    }
+    channel, ok := notifyChannels[job.Channel]
+    if !ok {
+        return fmt.Errorf("no notification channel %q", job.Channel)
+    }
+    message, err := channel.render(job.Notification)
+    if err != nil {
+        return err
+    }
+    return channel.notifier.Notify(ctx, message)
+}
+
+// emailNotifier emails notifications to NOTIFY_EMAIL_TO with the mailer.
+type emailNotifier struct {
+    to string
+}
+
+func newEmailNotifier() (Notifier, error) {
+    to := os.Getenv("NOTIFY_EMAIL_TO")
+    switch {
+    case to == "":
+        return nil, errors.New("the email channel requires NOTIFY_EMAIL_TO")
+    case mailer == nil:
+        return nil, errors.New("the email channel requires MAILER")
+    }
+    return emailNotifier{to: to}, nil
+}
+
+func (n emailNotifier) Notify(ctx context.Context, message notifyMessage) error {
+    return mailer.Send(ctx, mailMessage{To: n.to, Subject: message.Subject, Body: message.Text})
+}
+
+// chatNotifier posts notifications to a Slack or Discord incoming webhook,
+// which differ only in the field that holds the text and how long it may be.
+type chatNotifier struct {
+    url    string
+    field  string
+    limit  int
+    client *http.Client
+}
+
+func newSlackNotifier() (Notifier, error) {
+    return newChatNotifier("NOTIFY_SLACK_WEBHOOK_URL", "text", 40000)
+}
+
+func newDiscordNotifier() (Notifier, error) {
+    return newChatNotifier("NOTIFY_DISCORD_WEBHOOK_URL", "content", 2000)
+}
+
+func newChatNotifier(env, field string, limit int) (Notifier, error) {
+    webhook := os.Getenv(env)
+    if u, err := url.Parse(webhook); err != nil || u.Scheme != "https" || u.Host == "" {
+        return nil, fmt.Errorf("%s must be an https URL", env)
+    }
+    return &chatNotifier{url: webhook, field: field, limit: limit, client: &http.Client{Timeout: notifyTimeout}}, nil
+}
+
+func (n *chatNotifier) Notify(ctx context.Context, message notifyMessage) error {
+    data, err := json.Marshal(map[string]string{n.field: truncate(message.Text, n.limit)})
+    if err != nil {
+        return err
+    }
+    return postNotification(ctx, n.client, n.url, "application/json", bytes.NewReader(data), nil)
+}
+
+// smsNotifier texts notifications to NOTIFY_SMS_TO through Twilio.
+type smsNotifier struct {
+    accountSID, authToken string
+    from, to              string
+    client                *http.Client
+}
+
+func newSMSNotifier() (Notifier, error) {
+    n := &smsNotifier{
+        accountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+        authToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+        from:       os.Getenv("TWILIO_FROM"),
+        to:         os.Getenv("NOTIFY_SMS_TO"),
+        client:     &http.Client{Timeout: notifyTimeout},
+    }
+    if n.accountSID == "" || n.authToken == "" || n.from == "" || n.to == "" {
+        return nil, errors.New("the sms channel requires TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM and NOTIFY_SMS_TO")
+    }
+    return n, nil
+}
+
+func (n *smsNotifier) Notify(ctx context.Context, message notifyMessage) error {
+    form := url.Values{"From": {n.from}, "To": {n.to}, "Body": {truncate(message.Text, 1600)}}
+    endpoint := "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(n.accountSID) + "/Messages.json"
+    return postNotification(ctx, n.client, endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), func(request *http.Request) {
+        request.SetBasicAuth(n.accountSID, n.authToken)
+    })
+}
+
+// postNotification posts body to endpoint and fails unless the response is
+// a 2xx. prepare, if not nil, can add to the request, e.g. credentials.
+func postNotification(ctx context.Context, client *http.Client, endpoint, contentType string, body io.Reader, prepare func(*http.Request)) error {
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", contentType)
+    if prepare != nil {
+        prepare(request)
+    }
+
+    response, err := client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return fmt.Errorf("%s responded %s: %s", request.URL.Host, response.Status, bytes.TrimSpace(detail))
+    }
+    return nil
+}
+
+// truncate shortens text to at most limit characters, marking the cut with
+// an ellipsis.
+func truncate(text string, limit int) string {
+    runes := []rune(text)
+    if len(runes) <= limit {
+        return text
+    }
+    return string(runes[:limit-1]) + "…"
+}
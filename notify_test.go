@@ -0,0 +1,59 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "text/template"
+)
+
+// recordingNotifier keeps the messages it's sent.
+type recordingNotifier struct {
+    messages []notifyMessage
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, message notifyMessage) error {
+    n.messages = append(n.messages, message)
+    return nil
+}
+
+// TestNotifyDigest holds notifications until the digest is flushed, then
+// sends them as one message.
+func TestNotifyDigest(t *testing.T) {
+    recorder := &recordingNotifier{}
+    notifyChannels = map[string]*notifyChannel{
+        "test": {notifier: recorder, template: template.Must(template.New("test").Parse(defaultNotifyTemplate))},
+    }
+    notifyDigest = &notificationDigest{pending: map[string][]notification{}}
+    t.Cleanup(func() {
+        notifyChannels, notifyDigest = map[string]*notifyChannel{}, nil
+    })
+
+    ctx := context.Background()
+    for _, message := range []string{"Joke 1 was hidden.", "Joke 2 was hidden.", "Joke 3 was hidden."} {
+        notify(ctx, notification{Event: eventJokeHidden, Title: "Joke hidden after reports", Message: message})
+    }
+    if len(recorder.messages) != 0 {
+        t.Fatalf("got %d messages before the digest was flushed, want none", len(recorder.messages))
+    }
+
+    notifyDigest.flush(ctx)
+    if len(recorder.messages) != 1 {
+        t.Fatalf("got %d messages, want 1 digest", len(recorder.messages))
+    }
+    digest := recorder.messages[0]
+    if digest.Subject != "3 moderation notifications" {
+        t.Errorf("got subject %q, want 3 moderation notifications", digest.Subject)
+    }
+    for _, message := range []string{"Joke 1 was hidden.", "Joke 2 was hidden.", "Joke 3 was hidden."} {
+        if !strings.Contains(digest.Text, message) {
+            t.Errorf("the digest leaves out %q: %s", message, digest.Text)
+        }
+    }
+
+    notify(ctx, notification{Event: eventJokeHidden, Title: "Joke hidden after reports", Message: "Joke 4 was hidden."})
+    notifyDigest.flush(ctx)
+    if len(recorder.messages) != 2 || recorder.messages[1].Subject != "Joke hidden after reports" {
+        t.Errorf("a notification held alone isn't sent as it is: %+v", recorder.messages[1:])
+    }
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+    "log/slog"
+    "net/http"
+    "reflect"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/gorilla/mux"
+    swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// apiOperation documents one route. Paths, methods and path parameters come
+// from the router itself; request and response schemas are derived from the
+// Go types the handlers encode, so only the prose is maintained by hand.
+type apiOperation struct {
+    summary string
+    query   []apiParam
+
+    // request and response are zero values of the types decoded from the
+    // body and encoded into the response, or nil.
+    request  any
+    response any
+    status   int
+
+    // contentType is set for responses that aren't JSON.
+    contentType string
+
+    admin bool
+
+    // deprecation is set on routes that are going to change or go away.
+    deprecation *apiDeprecation
+}
+
+type apiParam struct {
+    name        string
+    typ         string
+    description string
+}
+
+// apiOperations documents every route, keyed by method and path template as
+// registered with the router, without the version prefix. checkAPIDocs warns about routes missing from
+// here at startup.
+var apiOperations = map[string]apiOperation{
+    "GET /random": {summary: "Get a random joke", response: Joke{}, query: []apiParam{
+        {"lang", "string", "BCP 47 language tag; negotiated from Accept-Language if left out"},
+        {"format", "string", "slack for a Slack Block Kit payload, ssml for speech"},
+        {"fields", "string", "comma separated fields to include"},
+    }},
+    "GET /joke-of-the-day": {summary: "Get the joke of the day", response: jokeOfTheDay{}, query: []apiParam{
+        {"date", "string", "a past day, YYYY-MM-DD"},
+    }},
+    "GET /jokes": {summary: "List jokes, newest first", response: jokePage{}, query: []apiParam{
+        {"page", "integer", "page number, from 1"},
+        {"per_page", "integer", "jokes per page, at most 100"},
+        {"snapshot", "string", "token from a previous page"},
+        {"lang", "string", "BCP 47 language tag; negotiated from Accept-Language if left out"},
+        {"fields", "string", "comma separated fields to include"},
+    }},
+    "GET /jokes/{id}": {summary: "Get a joke by id", response: Joke{}, query: []apiParam{
+        {"format", "string", "slack for a Slack Block Kit payload, ssml for speech"},
+        {"fields", "string", "comma separated fields to include"},
+        {"translate", "string", "BCP 47 language to machine translate the joke into"},
+    }},
+    "GET /jokes/{id}/image": {summary: "Joke rendered as a PNG card for link previews", contentType: "image/png", query: []apiParam{
+        {"theme", "string", "name of the card theme, e.g. light or dark"},
+    }},
+    "POST /jokes/{id}/report": {summary: "Report an inappropriate joke", request: reportRequest{}, status: http.StatusAccepted},
+    "GET /jokes/stream":       {summary: "Server-Sent Events stream of new jokes", contentType: "text/event-stream"},
+    "GET /ws":                 {summary: "WebSocket for random jokes and new-joke broadcasts", status: http.StatusSwitchingProtocols},
+    "GET /jokes/next": {summary: "Wait for the next joke submitted", response: Joke{}, query: []apiParam{
+        {"after", "string", "id of the last joke seen; jokes after it are returned first"},
+        {"timeout", "integer", "seconds to wait before answering 204"},
+    }},
+    "GET /jokes/export": {summary: "Export every joke", contentType: "application/x-ndjson", query: []apiParam{
+        {"format", "string", "jsonl, csv or sql"},
+        {"since", "integer", "only export jokes with a greater id"},
+        {"lang", "string", "only export jokes in this BCP 47 language"},
+    }},
+    "POST /jokes/batch":        {summary: "Import jokes", request: []Joke{}, response: batchResponse{}, admin: true},
+    "POST /write": {summary: "Submit a joke", request: Joke{}, response: Joke{}, status: http.StatusCreated, query: []apiParam{
+        {"force", "boolean", "true to store a joke similar to an existing one; admin only"},
+    }},
+    "GET /pow/challenge": {summary: "Get a proof-of-work challenge for submitting a joke", response: powChallenge{}},
+    "POST /subscribe":    {summary: "Subscribe an email address to the joke of the day", request: subscribeRequest{}, response: subscriptionMessage{}, status: http.StatusAccepted},
+    "GET /subscribe/confirm": {summary: "Confirm an email subscription", response: Subscription{}, query: []apiParam{
+        {"token", "string", "token from the confirmation email"},
+    }},
+    "GET /unsubscribe": {summary: "Unsubscribe from the joke of the day", response: subscriptionMessage{}, query: []apiParam{
+        {"token", "string", "token from the unsubscribe link"},
+    }},
+    "POST /unsubscribe": {summary: "One-click unsubscribe from mail clients", response: subscriptionMessage{}, query: []apiParam{
+        {"token", "string", "token from the unsubscribe link"},
+    }},
+    "GET /feed.rss":            {summary: "RSS feed of the latest jokes", contentType: "application/rss+xml"},
+    "GET /feed.atom":           {summary: "Atom feed of the latest jokes", contentType: "application/atom+xml"},
+    "POST /integrations/slack": {summary: "Slack slash command and interactivity endpoint"},
+    "GET /embed.js":            {summary: "Embeddable widget script", contentType: "application/javascript"},
+    "GET /embed.svg": {summary: "Random joke rendered as an SVG card", contentType: "image/svg+xml", query: []apiParam{
+        {"theme", "string", "name of the card theme"},
+    }},
+    "GET /themes":        {summary: "List card themes", response: []Theme{}},
+    "GET /themes/{name}": {summary: "Get a card theme", response: Theme{}},
+    "GET /changes": {summary: "Change feed", response: changesPage{}, query: []apiParam{
+        {"since", "integer", "cursor from the previous page"},
+        {"limit", "integer", "changes per page, at most 1000"},
+    }},
+    "GET /limits": {summary: "Submission limits", response: jokeLimits{}},
+    "GET /authors": {summary: "List authors, most jokes first", response: authorPage{}, query: []apiParam{
+        {"page", "integer", "page number, from 1"},
+        {"per_page", "integer", "authors per page, at most 100"},
+    }},
+    "GET /authors/{name}/jokes": {summary: "List the jokes of an author, newest first", response: authorJokesPage{}, query: []apiParam{
+        {"page", "integer", "page number, from 1"},
+        {"per_page", "integer", "jokes per page, at most 100"},
+    }},
+    "GET /authors/{name}/stats":                  {summary: "Author statistics", response: AuthorStats{}},
+    "GET /.well-known/dadjokes.json":             {summary: "Instance metadata", response: Instance{}},
+    "GET /.well-known/dadjokes-signing-key.json": {summary: "Public key response signatures verify against", response: signingKey{}},
+    "GET /federation/jokes": {summary: "Federation pull feed", response: federationPage{}, query: []apiParam{
+        {"since", "integer", "cursor from the previous page"},
+        {"limit", "integer", "jokes per page, at most 100"},
+    }},
+    "GET /healthz":                  {summary: "Liveness probe", response: healthStatus{}},
+    "GET /readyz":                   {summary: "Readiness probe", response: healthStatus{}},
+    "GET /deprecations":             {summary: "Deprecated routes and when they go away", response: deprecationList{}},
+    "GET /debug/vars":               {summary: "Runtime and application counters", contentType: "application/json", admin: true},
+    "POST /admin/authors/merge":     {summary: "Merge author aliases", request: mergeAuthorsRequest{}, response: Author{}, admin: true},
+    "GET /admin/quality":            {summary: "Corpus quality report", response: qualityReport{}, admin: true},
+    "GET /admin/stats":              {summary: "Aggregate statistics for an admin dashboard", response: adminStats{}, admin: true},
+    "GET /admin/schedule":           {summary: "List scheduled jokes of the day", response: []ScheduledJoke{}, admin: true},
+    "PUT /admin/schedule":           {summary: "Schedule jokes of the day", request: scheduleRequest{}, response: []ScheduledJoke{}, admin: true},
+    "DELETE /admin/schedule/{date}": {summary: "Remove a scheduled joke", status: http.StatusNoContent, admin: true},
+    "GET /admin/flags": {summary: "List the moderation queue", response: []JokeFlag{}, admin: true, query: []apiParam{
+        {"source", "string", "only flags raised by this source"},
+        {"reason", "string", "only flags with this reason"},
+        {"tag", "string", "only flags with this tag"},
+        {"q", "string", "only flags of jokes whose text or author contains this"},
+        {"escalated", "boolean", "only escalated flags, or only the others"},
+        {"created_before", "string", "only flags raised before this RFC 3339 time"},
+        {"created_after", "string", "only flags raised at or after this RFC 3339 time"},
+    }},
+    "POST /admin/flags/{id}/resolve":   {summary: "Take a flag off the moderation queue", status: http.StatusNoContent, admin: true},
+    "GET /admin/queue/stats":           {summary: "How long flags wait in the moderation queue", response: queueStats{}, admin: true},
+    "GET /admin/queue/priority":        {summary: "List the flags escalated for waiting too long", response: []JokeFlag{}, admin: true},
+    "GET /admin/jokes/{id}/shares":     {summary: "Share link visits of a joke", response: ShareStats{}, admin: true},
+    "GET /admin/reports":               {summary: "List reported jokes", response: []jokeReports{}, admin: true},
+    "POST /admin/reports/{id}/resolve": {summary: "Dismiss the reports of a joke or hide it", request: resolveReportsRequest{}, status: http.StatusNoContent, admin: true},
+    "DELETE /admin/jokes/{id}":         {summary: "Delete a joke, restorable until it's purged", status: http.StatusNoContent, admin: true},
+    "POST /admin/jokes/{id}/restore":   {summary: "Restore a deleted joke", status: http.StatusNoContent, admin: true},
+    "DELETE /admin/media":              {summary: "Empty the media cache", response: purgeResult{}, admin: true},
+    "PUT /admin/themes/{name}":         {summary: "Create or replace a card theme", request: Theme{}, response: Theme{}, admin: true},
+    "DELETE /admin/themes/{name}":      {summary: "Remove a card theme", status: http.StatusNoContent, admin: true},
+    "GET /admin/webhooks":              {summary: "List webhooks", response: []Webhook{}, admin: true},
+    "POST /admin/webhooks":             {summary: "Register a webhook", request: createWebhookRequest{}, response: Webhook{}, status: http.StatusCreated, admin: true},
+    "DELETE /admin/webhooks/{id}":      {summary: "Remove a webhook", status: http.StatusNoContent, admin: true},
+    "POST /admin/seed": {summary: "Add the bundled starter jokes, if SEED_ENDPOINT is set", response: seedResult{}, admin: true, query: []apiParam{
+        {"count", "integer", "how many starter jokes to add, all of them by default"},
+    }},
+    "GET /j/{slug}": {summary: "Share link, redirecting to the joke", status: http.StatusFound, query: []apiParam{
+        {"utm_source", "string", "recorded in the share stats"},
+        {"utm_medium", "string", "recorded in the share stats"},
+        {"utm_campaign", "string", "recorded in the share stats"},
+    }},
+    "GET /collections/best-of":          {summary: "List the weeks with a best of, newest first", response: []string{}},
+    "GET /collections/best-of/{week}":   {summary: "Get the most shared jokes of an ISO week, like 2024-07", response: BestOf{}},
+    "GET /collections/best-of/feed.rss": {summary: "RSS feed of the weekly best of", contentType: "application/rss+xml"},
+    "GET /admin/audit": {summary: "List the audit log of writes, newest first", response: auditPage{}, admin: true, query: []apiParam{
+        {"actor", "string", "only writes by this actor, admin, system or client:<hash>"},
+        {"from", "string", "only writes at or after this RFC 3339 time or date"},
+        {"to", "string", "only writes at or before this RFC 3339 time, or during this date"},
+        {"before", "integer", "next_before of the previous page"},
+        {"limit", "integer", "entries per page, 100 by default, at most 1000"},
+    }},
+    "POST /admin/corpus/sync": {summary: "Pull the git joke corpus and sync it now", response: corpusSyncResult{}, admin: true},
+    "GET /rate-limits":        {summary: "The caller's rate limits and remaining quota on every route", response: rateLimitReport{}},
+    "POST /admin/flags/bulk":  {summary: "Approve, reject or tag a set of flags at once", request: moderateFlagsRequest{}, response: moderationBatch{}, admin: true},
+}
+
+// undocumentedRoutes are served but left out of the spec.
+var undocumentedRoutes = map[string]bool{
+    "GET /openapi.json": true,
+    "GET /docs":         true,
+    "GET /docs/":        true,
+}
+
+// pathVariable matches a gorilla/mux path variable, with its optional
+// pattern.
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// apiRoute is a method and OpenAPI path served by the router.
+type apiRoute struct {
+    method, path string
+    // legacy is set for the unprefixed aliases of versioned routes.
+    legacy bool
+}
+
+// listRoutes returns every route of router, in the order they were added.
+func listRoutes(router *mux.Router) []apiRoute {
+    var routes []apiRoute
+    seen := map[string]bool{}
+
+    router.Walk(func(r *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+        template, err := r.GetPathTemplate()
+        if err != nil {
+            return nil
+        }
+        methods, err := r.GetMethods()
+        if err != nil {
+            return nil
+        }
+        path := pathVariable.ReplaceAllString(template, "{$1}")
+        for _, method := range methods {
+            routes = append(routes, apiRoute{method: method, path: path})
+            seen[method+" "+path] = true
+        }
+        return nil
+    })
+
+    for i, r := range routes {
+        routes[i].legacy = seen[r.method+" "+apiV1+r.path]
+    }
+    return routes
+}
+
+// walkRoutes calls fn with the method and OpenAPI path of every route.
+// Legacy aliases of versioned routes are skipped, so each operation is
+// described once, under its version prefix.
+func walkRoutes(router *mux.Router, fn func(method, path string)) {
+    for _, r := range listRoutes(router) {
+        if !r.legacy {
+            fn(r.method, r.path)
+        }
+    }
+}
+
+// apiDocDrift returns the routes of router that have no entry in
+// apiOperations, and the entries for routes that don't exist. Both are
+// sorted. TestAPIDocs fails on either, so the spec doesn't drift from the
+// router.
+func apiDocDrift(router *mux.Router) (missing, stale []string) {
+    routed := map[string]bool{}
+    walkRoutes(router, func(method, path string) {
+        key := method + " " + unversioned(path)
+        if routed[key] {
+            return
+        }
+        routed[key] = true
+        if _, ok := apiOperations[key]; !ok && !undocumentedRoutes[key] {
+            missing = append(missing, key)
+        }
+    })
+    for key := range apiOperations {
+        if !routed[key] {
+            stale = append(stale, key)
+        }
+    }
+    slices.Sort(missing)
+    slices.Sort(stale)
+    return missing, stale
+}
+
+// checkAPIDocs logs the drift between the spec and router, for builds that
+// were changed without running the tests.
+func checkAPIDocs(router *mux.Router) {
+    missing, stale := apiDocDrift(router)
+    for _, key := range missing {
+        slog.Warn("Route missing from the OpenAPI spec", "route", key)
+    }
+    for _, key := range stale {
+        slog.Warn("OpenAPI spec documents a route that doesn't exist", "route", key)
+    }
+}
+
+// openAPISpec builds the OpenAPI 3 document for the routes of router.
+func openAPISpec(router *mux.Router, request *http.Request) map[string]any {
+    schemas := map[string]any{}
+    paths := map[string]map[string]any{}
+
+    walkRoutes(router, func(method, path string) {
+        key := method + " " + unversioned(path)
+        if undocumentedRoutes[key] {
+            return
+        }
+        doc, ok := apiOperations[key]
+        if !ok {
+            doc.summary = "Undocumented"
+        }
+
+        var parameters []any
+        for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+            parameters = append(parameters, map[string]any{
+                "name": match[1], "in": "path", "required": true,
+                "schema": map[string]any{"type": "string"},
+            })
+        }
+        for _, param := range doc.query {
+            parameters = append(parameters, map[string]any{
+                "name": param.name, "in": "query", "description": param.description,
+                "schema": map[string]any{"type": param.typ},
+            })
+        }
+
+        status := doc.status
+        if status == 0 {
+            status = http.StatusOK
+        }
+        success := map[string]any{"description": http.StatusText(status)}
+        switch {
+        case doc.response != nil:
+            success["content"] = map[string]any{
+                "application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(doc.response), schemas)},
+            }
+        case doc.contentType != "":
+            success["content"] = map[string]any{doc.contentType: map[string]any{}}
+        }
+
+        operation := map[string]any{
+            "summary": doc.summary,
+            "responses": map[string]any{
+                strconv.Itoa(status): success,
+                "default": map[string]any{
+                    "description": "Error",
+                    "content": map[string]any{
+                        "application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(errorResponse{}), schemas)},
+                    },
+                },
+            },
+        }
+        if len(parameters) > 0 {
+            operation["parameters"] = parameters
+        }
+        if doc.request != nil {
+            operation["requestBody"] = map[string]any{
+                "required": true,
+                "content": map[string]any{
+                    "application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(doc.request), schemas)},
+                },
+            }
+        }
+        if doc.deprecation != nil {
+            operation["deprecated"] = true
+        }
+        if doc.admin {
+            operation["security"] = []any{map[string]any{"adminToken": []string{}}}
+        }
+
+        if paths[path] == nil {
+            paths[path] = map[string]any{}
+        }
+        paths[path][strings.ToLower(method)] = operation
+    })
+
+    return map[string]any{
+        "openapi": "3.0.3",
+        "info": map[string]any{
+            "title":   instanceInfo(request).Name,
+            "version": "1.0",
+        },
+        "servers": []any{map[string]any{"url": baseURL(request)}},
+        "paths":   paths,
+        "components": map[string]any{
+            "schemas": schemas,
+            "securitySchemes": map[string]any{
+                "adminToken": map[string]any{"type": "http", "scheme": "bearer"},
+            },
+        },
+    }
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of t. Named structs are added to schemas
+// and referenced, so each is described once.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+    if t.Kind() == reflect.Pointer {
+        t = t.Elem()
+    }
+
+    switch {
+    case t == timeType:
+        return map[string]any{"type": "string", "format": "date-time"}
+    case t.Kind() == reflect.Struct:
+        name := []rune(t.Name())
+        if len(name) == 0 {
+            return structSchema(t, schemas)
+        }
+        name[0] = unicode.ToUpper(name[0])
+        if _, ok := schemas[string(name)]; !ok {
+            schemas[string(name)] = map[string]any{}
+            schemas[string(name)] = structSchema(t, schemas)
+        }
+        return map[string]any{"$ref": "#/components/schemas/" + string(name)}
+    case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+        return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+    case t.Kind() == reflect.Map:
+        return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+    case t.Kind() == reflect.String:
+        return map[string]any{"type": "string"}
+    case t.Kind() == reflect.Bool:
+        return map[string]any{"type": "boolean"}
+    case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+        return map[string]any{"type": "integer"}
+    case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+        return map[string]any{"type": "number"}
+    }
+    return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+    properties := map[string]any{}
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        if !field.IsExported() {
+            continue
+        }
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        if name == "-" {
+            continue
+        }
+        if name == "" {
+            name = field.Name
+        }
+        properties[name] = schemaFor(field.Type, schemas)
+    }
+    return map[string]any{"type": "object", "properties": properties}
+}
+
+// newOpenAPIHandler serves the spec for router.
+func newOpenAPIHandler(router *mux.Router) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        writeJSON(response, request, http.StatusOK, openAPISpec(router, request))
+    }
+}
+
+// swaggerInitializer points the bundled Swagger UI at our spec. The URL is
+// relative so it keeps working behind a reverse proxy that adds a prefix.
+const swaggerInitializer = `window.onload = function () {
+    window.ui = SwaggerUIBundle({
+        url: "../openapi.json",
+        dom_id: "#swagger-ui",
+        deepLinking: true,
+        presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+        plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+        layout: "StandaloneLayout"
+    });
+};
+`
+
+// docsHandler serves the embedded Swagger UI under /docs/.
+func docsHandler() http.Handler {
+    files := http.StripPrefix("/docs/", http.FileServer(http.FS(swaggerFiles.FS)))
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        if request.URL.Path == "/docs/swagger-initializer.js" {
+            response.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+            response.Write([]byte(swaggerInitializer))
+            return
+        }
+        files.ServeHTTP(response, request)
+    })
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// TestAPIDocs checks that apiOperations documents every route, and only
+// routes that exist.
+func TestAPIDocs(t *testing.T) {
+    router := mux.NewRouter()
+    registerRoutes(router, openTestRepository(t), rateLimitPolicy{}, nil, time.Time{})
+
+    missing, stale := apiDocDrift(router)
+    for _, key := range missing {
+        t.Errorf("%s is missing from apiOperations", key)
+    }
+    for _, key := range stale {
+        t.Errorf("apiOperations documents %s, which isn't routed", key)
+    }
+}
+
+// TestOpenAPISpec builds the spec for a router with a couple of documented
+// routes and checks their paths, parameters, bodies and schemas.
+func TestOpenAPISpec(t *testing.T) {
+    router := mux.NewRouter()
+    router.HandleFunc("/jokes/{id:[0-9]+}", getJoke).Methods("GET")
+    router.HandleFunc("/write", saveJoke).Methods("POST")
+
+    spec := openAPISpec(router, httptest.NewRequest("GET", "/openapi.json", nil))
+    paths := spec["paths"].(map[string]map[string]any)
+
+    getOperation, ok := paths["/jokes/{id}"]["get"].(map[string]any)
+    if !ok {
+        t.Fatalf("GET /jokes/{id} is missing from the spec, got paths %v", paths)
+    }
+    parameters, _ := getOperation["parameters"].([]any)
+    if len(parameters) == 0 || parameters[0].(map[string]any)["name"] != "id" || parameters[0].(map[string]any)["in"] != "path" {
+        t.Errorf("GET /jokes/{id} doesn't start with the id path parameter: %v", parameters)
+    }
+
+    postOperation, ok := paths["/write"]["post"].(map[string]any)
+    if !ok {
+        t.Fatalf("POST /write is missing from the spec, got paths %v", paths)
+    }
+    if _, ok := postOperation["requestBody"]; !ok {
+        t.Error("POST /write has no request body")
+    }
+    if _, ok := postOperation["responses"].(map[string]any)["201"]; !ok {
+        t.Errorf("POST /write doesn't answer 201: %v", postOperation["responses"])
+    }
+
+    schemas := spec["components"].(map[string]any)["schemas"].(map[string]any)
+    joke, ok := schemas["Joke"].(map[string]any)
+    if !ok {
+        t.Fatalf("the Joke schema is missing, got %v", schemas)
+    }
+    for _, field := range []string{"id", "joke_text", "author"} {
+        if _, ok := joke["properties"].(map[string]any)[field]; !ok {
+            t.Errorf("the Joke schema has no %s property", field)
+        }
+    }
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "expvar"
+    "errors"
+    "log/slog"
+    "sync/atomic"
+    "time"
+
+    "go.mongodb.org/mongo-driver/v2/event"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// poolConfig sizes the database connection pool, read by loadPoolConfig.
+type poolConfig struct {
+    maxOpen, maxIdle         int
+    maxLifetime, maxIdleTime time.Duration
+
+    // connectAttempts is how often the database is pinged at startup
+    // before giving up, waiting connectBackoff after the first failure and
+    // twice as long after every one after that.
+    connectAttempts int
+    connectBackoff  time.Duration
+}
+
+// loadPoolConfig reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME, DB_CONNECT_ATTEMPTS and
+// DB_CONNECT_BACKOFF.
+func loadPoolConfig() (poolConfig, error) {
+    var c poolConfig
+    var err error
+    if c.maxOpen, err = getEnvInt("DB_MAX_OPEN_CONNS", 25); err != nil {
+        return c, err
+    }
+    if c.maxIdle, err = getEnvInt("DB_MAX_IDLE_CONNS", 10); err != nil {
+        return c, err
+    }
+    if c.maxLifetime, err = getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+        return c, err
+    }
+    if c.maxIdleTime, err = getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+        return c, err
+    }
+    if c.connectAttempts, err = getEnvInt("DB_CONNECT_ATTEMPTS", 5); err != nil {
+        return c, err
+    }
+    if c.connectBackoff, err = getEnvDuration("DB_CONNECT_BACKOFF", time.Second); err != nil {
+        return c, err
+    }
+    if c.maxOpen < 0 || c.maxIdle < 0 {
+        return c, errors.New("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS can't be negative")
+    }
+    if c.connectAttempts < 1 {
+        return c, errors.New("DB_CONNECT_ATTEMPTS must be at least 1")
+    }
+    return c, nil
+}
+
+// applySQL sizes the pool of db. Zero leaves a limit off, as database/sql
+// does.
+func (c poolConfig) applySQL(db *sql.DB) {
+    db.SetMaxOpenConns(c.maxOpen)
+    db.SetMaxIdleConns(c.maxIdle)
+    db.SetConnMaxLifetime(c.maxLifetime)
+    db.SetConnMaxIdleTime(c.maxIdleTime)
+}
+
+// applyMongo sizes the pool of a MongoDB client and counts its connections
+// for the metrics. MongoDB keeps no idle connections beyond the ones in use
+// and has no connection lifetime, so only the open and idle time limits
+// apply.
+func (c poolConfig) applyMongo(opts *options.ClientOptions) {
+    opts.SetMaxPoolSize(uint64(c.maxOpen))
+    opts.SetMaxConnIdleTime(c.maxIdleTime)
+
+    stats := &mongoPoolStats{maxOpen: c.maxOpen}
+    opts.SetPoolMonitor(&event.PoolMonitor{Event: stats.record})
+    dbPoolStats = stats.snapshot
+}
+
+// connect pings the database until it answers, backing off between
+// attempts, so the server can start before the database is up.
+func (c poolConfig) connect(ctx context.Context, ping func(context.Context) error) error {
+    backoff := c.connectBackoff
+    for attempt := 1; ; attempt++ {
+        pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+        err := ping(pingCtx)
+        cancel()
+        if err == nil || attempt == c.connectAttempts {
+            return err
+        }
+
+        slog.Warn("Database isn't answering, retrying", "attempt", attempt, "retry_in", backoff.String(), "error", err)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+    }
+}
+
+// poolStats are the connection pool statistics published as db_pool.
+type poolStats struct {
+    MaxOpen int `json:"max_open"`
+    Open    int `json:"open"`
+    InUse   int `json:"in_use"`
+    Idle    int `json:"idle"`
+    // Waits and WaitMs are how often and how long requests have waited for
+    // a connection since startup, on SQL databases only.
+    Waits  int64   `json:"waits,omitempty"`
+    WaitMs float64 `json:"wait_ms,omitempty"`
+    // Failed counts the connections that couldn't be checked out, on
+    // MongoDB only.
+    Failed int64 `json:"failed,omitempty"`
+}
+
+// dbPoolStats returns the statistics of the connection pool of the open
+// database. It's set when the database is opened.
+var dbPoolStats = func() any { return nil }
+
+func init() {
+    expvar.Publish("db_pool", expvar.Func(func() any { return dbPoolStats() }))
+}
+
+// sqlPoolStats returns the pool statistics of db.
+func sqlPoolStats(db *sql.DB) func() any {
+    return func() any {
+        s := db.Stats()
+        return poolStats{
+            MaxOpen: s.MaxOpenConnections,
+            Open:    s.OpenConnections,
+            InUse:   s.InUse,
+            Idle:    s.Idle,
+            Waits:   s.WaitCount,
+            WaitMs:  float64(s.WaitDuration.Microseconds()) / 1000,
+        }
+    }
+}
+
+// mongoPoolStats counts the connections of a MongoDB client from its pool
+// events. The driver reports no statistics of its own.
+type mongoPoolStats struct {
+    maxOpen             int
+    open, inUse, failed atomic.Int64
+}
+
+func (s *mongoPoolStats) record(e *event.PoolEvent) {
+    switch e.Type {
+    case event.ConnectionCreated:
+        s.open.Add(1)
+    case event.ConnectionClosed:
+        s.open.Add(-1)
+    case event.ConnectionCheckedOut:
+        s.inUse.Add(1)
+    case event.ConnectionCheckedIn:
+        s.inUse.Add(-1)
+    case event.ConnectionCheckOutFailed:
+        s.failed.Add(1)
+    }
+}
+
+func (s *mongoPoolStats) snapshot() any {
+    open, inUse := int(s.open.Load()), int(s.inUse.Load())
+    return poolStats{
+        MaxOpen: s.maxOpen,
+        Open:    open,
+        InUse:   inUse,
+        Idle:    max(open-inUse, 0),
+        Failed:  s.failed.Load(),
+    }
+}
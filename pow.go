@@ -0,0 +1,192 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/bits"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Errors returned by powIssuer.verify.
+var (
+    errPowRequired = errors.New("submissions need a proof of work: get a challenge from /pow/challenge")
+    errPowInvalid  = errors.New("proof of work is invalid, expired or already used")
+)
+
+// maxPowDifficulty caps POW_DIFFICULTY; every bit doubles the work.
+const maxPowDifficulty = 32
+
+// maxPowSolutionLength bounds the solutions hashed when verifying.
+const maxPowSolutionLength = 64
+
+// powChallenges guards anonymous submissions with a proof of work. It's nil,
+// and submissions need none, unless POW_DIFFICULTY is set.
+var powChallenges *powIssuer
+
+// powIssuer hands out challenges and checks solutions. Challenges carry
+// their difficulty and expiry and are signed with key, so any instance
+// sharing POW_SECRET can check them without storing them. Used challenges
+// are remembered until they expire, per instance, so each is good for one
+// submission.
+type powIssuer struct {
+    key        []byte
+    difficulty int
+    ttl        time.Duration
+
+    mu    sync.Mutex
+    spent map[string]time.Time
+}
+
+// powChallenge is the response of /pow/challenge.
+type powChallenge struct {
+    Challenge  string    `json:"challenge"`
+    Algorithm  string    `json:"algorithm"`
+    Difficulty int       `json:"difficulty"`
+    ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// loadProofOfWork reads POW_DIFFICULTY, the number of leading zero bits a
+// solution's hash needs, POW_TTL and POW_SECRET. Without POW_SECRET a random
+// key is used, which only works with a single instance.
+func loadProofOfWork() (*powIssuer, error) {
+    difficulty, err := getEnvInt("POW_DIFFICULTY", 0)
+    if err != nil {
+        return nil, err
+    }
+    if difficulty < 0 || difficulty > maxPowDifficulty {
+        return nil, fmt.Errorf("POW_DIFFICULTY must be between 0 and %d", maxPowDifficulty)
+    }
+    if difficulty == 0 {
+        return nil, nil
+    }
+    ttl, err := getEnvDuration("POW_TTL", 5*time.Minute)
+    if err != nil {
+        return nil, err
+    }
+
+    key := []byte(os.Getenv("POW_SECRET"))
+    if len(key) == 0 {
+        key = make([]byte, 32)
+        rand.Read(key)
+    }
+    return &powIssuer{key: key, difficulty: difficulty, ttl: ttl, spent: map[string]time.Time{}}, nil
+}
+
+func (p *powIssuer) sign(payload string) string {
+    mac := hmac.New(sha256.New, p.key)
+    mac.Write([]byte(payload))
+    return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// issue returns a new challenge: the difficulty, the expiry and a random
+// nonce, signed.
+func (p *powIssuer) issue(now time.Time) powChallenge {
+    nonce := make([]byte, 16)
+    rand.Read(nonce)
+    expires := now.Add(p.ttl).UTC().Truncate(time.Second)
+    payload := fmt.Sprintf("%d.%d.%s", p.difficulty, expires.Unix(), hex.EncodeToString(nonce))
+    return powChallenge{
+        Challenge:  payload + "." + p.sign(payload),
+        Algorithm:  "sha256",
+        Difficulty: p.difficulty,
+        ExpiresAt:  expires,
+    }
+}
+
+// verify checks that solution solves challenge: that the SHA-256 hash of
+// the challenge, a colon and the solution starts with the challenge's
+// number of zero bits. The challenge is used up if it does.
+func (p *powIssuer) verify(challenge, solution string, now time.Time) error {
+    if challenge == "" || solution == "" {
+        return errPowRequired
+    }
+    if len(solution) > maxPowSolutionLength {
+        return errPowInvalid
+    }
+
+    i := strings.LastIndex(challenge, ".")
+    if i < 0 || !hmac.Equal([]byte(challenge[i+1:]), []byte(p.sign(challenge[:i]))) {
+        return errPowInvalid
+    }
+    fields := strings.Split(challenge[:i], ".")
+    if len(fields) != 3 {
+        return errPowInvalid
+    }
+    difficulty, err1 := strconv.Atoi(fields[0])
+    unix, err2 := strconv.ParseInt(fields[1], 10, 64)
+    if err1 != nil || err2 != nil {
+        return errPowInvalid
+    }
+    expires := time.Unix(unix, 0)
+    if now.After(expires) {
+        return errPowInvalid
+    }
+
+    sum := sha256.Sum256([]byte(challenge + ":" + solution))
+    if leadingZeroBits(sum[:]) < difficulty {
+        return errPowInvalid
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    for used, until := range p.spent {
+        if now.After(until) {
+            delete(p.spent, used)
+        }
+    }
+    if _, used := p.spent[challenge]; used {
+        return errPowInvalid
+    }
+    p.spent[challenge] = expires
+    return nil
+}
+
+func leadingZeroBits(b []byte) int {
+    n := 0
+    for _, c := range b {
+        n += bits.LeadingZeros8(c)
+        if c != 0 {
+            break
+        }
+    }
+    return n
+}
+
+// checkProofOfWork verifies the X-Pow-Challenge and X-Pow-Solution headers
+// of a submission. Admins don't need them. If the check fails it writes the
+// error response and returns false.
+func checkProofOfWork(response http.ResponseWriter, request *http.Request) bool {
+    if powChallenges == nil || isAdmin(request) {
+        return true
+    }
+
+    err := powChallenges.verify(request.Header.Get("X-Pow-Challenge"), request.Header.Get("X-Pow-Solution"), time.Now())
+    if errors.Is(err, errPowRequired) {
+        writeAPIError(response, http.StatusForbidden, apiError{Code: "proof_of_work_required", Message: err.Error()})
+        return false
+    }
+    if err != nil {
+        writeAPIError(response, http.StatusForbidden, apiError{Code: "proof_of_work_invalid", Message: err.Error()})
+        return false
+    }
+    return true
+}
+
+func getPowChallenge(response http.ResponseWriter, request *http.Request) {
+    if powChallenges == nil {
+        writeError(response, http.StatusNotFound, "submissions don't need a proof of work")
+        return
+    }
+
+    response.Header().Set("Cache-Control", "no-store")
+    writeJSON(response, request, http.StatusOK, powChallenges.issue(time.Now()))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+const (
+    // qualityMinLength and qualityMaxLength bound what counts as a normal
+    // joke length in the quality report. They're looser than a reader would
+    // mind but tighter than the submission limits.
+    qualityMinLength = 20
+    qualityMaxLength = 500
+
+    // qualitySimilarity is how similar two jokes' words have to be for them
+    // to be reported as likely duplicates.
+    qualitySimilarity = 0.8
+
+    // qualityMaxIssues caps each list in the report.
+    qualityMaxIssues = 100
+)
+
+// qualityReport lists jokes that likely need an admin's attention.
+type qualityReport struct {
+    GeneratedAt      time.Time          `json:"generated_at"`
+    JokesScanned     int                `json:"jokes_scanned"`
+    LikelyDuplicates []likelyDuplicate  `json:"likely_duplicates"`
+    TooShort         []qualityIssue     `json:"too_short"`
+    TooLong          []qualityIssue     `json:"too_long"`
+    BreaksLimits     []qualityIssue     `json:"breaks_limits"`
+    Fixes            map[string]fixLink `json:"fixes"`
+}
+
+// likelyDuplicate is a pair of jokes with mostly the same words.
+type likelyDuplicate struct {
+    Jokes      [2]jokeRef `json:"jokes"`
+    Similarity float64    `json:"similarity"`
+    Links      []string   `json:"links"`
+}
+
+type qualityIssue struct {
+    Id      jokeRef `json:"id"`
+    Length  int     `json:"length"`
+    Problem string  `json:"problem,omitempty"`
+    Link    string  `json:"link"`
+}
+
+// fixLink points at an endpoint that can fix a kind of issue.
+type fixLink struct {
+    Method      string `json:"method"`
+    URL         string `json:"url"`
+    Description string `json:"description"`
+}
+
+// latestQuality holds the most recent report.
+var latestQuality struct {
+    sync.Mutex
+    report *qualityReport
+}
+
+// analyzeQuality scans every joke and builds a report. Links are left
+// relative; getQualityReport makes them absolute.
+func analyzeQuality(ctx context.Context) (*qualityReport, error) {
+    report := &qualityReport{
+        GeneratedAt:      time.Now().UTC().Truncate(time.Second),
+        LikelyDuplicates: []likelyDuplicate{},
+        TooShort:         []qualityIssue{},
+        TooLong:          []qualityIssue{},
+        BreaksLimits:     []qualityIssue{},
+    }
+
+    // Each joke is compared with the ones scanned before it, through an
+    // index, to find pairs without comparing every joke with every other.
+    index := newSimilarityIndex()
+
+    afterID := 0
+    for {
+        jokes, err := repo.ListAfter(ctx, afterID, exportChunkSize, "")
+        if err != nil {
+            return nil, err
+        }
+        if len(jokes) == 0 {
+            break
+        }
+
+        for _, joke := range jokes {
+            report.JokesScanned++
+            checkJokeQuality(report, joke)
+
+            set := jokeWords(joke.Text)
+            for _, match := range index.match(set, qualitySimilarity) {
+                if len(report.LikelyDuplicates) < qualityMaxIssues {
+                    report.LikelyDuplicates = append(report.LikelyDuplicates, likelyDuplicate{
+                        Jokes:      [2]jokeRef{jokeRef(match.Id), jokeRef(joke.Id)},
+                        Similarity: float64(int(match.Similarity*100)) / 100,
+                        Links:      []string{jokeLink(match.Id), jokeLink(joke.Id)},
+                    })
+                }
+            }
+            index.add(joke.Id, set)
+        }
+        afterID = jokes[len(jokes)-1].Id
+    }
+    return report, nil
+}
+
+func checkJokeQuality(report *qualityReport, joke Joke) {
+    length := utf8.RuneCountInString(strings.TrimSpace(joke.Text))
+    issue := qualityIssue{Id: jokeRef(joke.Id), Length: length, Link: jokeLink(joke.Id)}
+
+    switch {
+    case length < qualityMinLength && len(report.TooShort) < qualityMaxIssues:
+        report.TooShort = append(report.TooShort, issue)
+    case length > qualityMaxLength && len(report.TooLong) < qualityMaxIssues:
+        report.TooLong = append(report.TooLong, issue)
+    }
+
+    // Jokes stored before the limits were introduced or tightened.
+    if err := limits.check(joke); err != nil && len(report.BreaksLimits) < qualityMaxIssues {
+        issue.Problem = err.Error()
+        report.BreaksLimits = append(report.BreaksLimits, issue)
+    }
+}
+
+func jokeLink(id int) string {
+    return fmt.Sprintf("/jokes/%s", jokeRef(id))
+}
+
+// runQualityReports regenerates the quality report every interval until ctx
+// is cancelled.
+func runQualityReports(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        report, err := analyzeQuality(ctx)
+        if err != nil {
+            slog.Error("Error generating quality report", "error", err)
+        } else {
+            latestQuality.Lock()
+            latestQuality.report = report
+            latestQuality.Unlock()
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// getQualityReport serves the latest quality report. Until the first one
+// has been generated it answers 503 with a Retry-After.
+func getQualityReport(response http.ResponseWriter, request *http.Request) {
+    latestQuality.Lock()
+    report := latestQuality.report
+    latestQuality.Unlock()
+
+    if report == nil {
+        response.Header().Set("Retry-After", "10")
+        writeError(response, http.StatusServiceUnavailable, "the first quality report is still being generated")
+        return
+    }
+
+    // Work on a copy so the shared report keeps relative links.
+    base := apiURL(request)
+    absolute := *report
+    absolute.LikelyDuplicates = make([]likelyDuplicate, len(report.LikelyDuplicates))
+    for i, duplicate := range report.LikelyDuplicates {
+        duplicate.Links = []string{base + duplicate.Links[0], base + duplicate.Links[1]}
+        absolute.LikelyDuplicates[i] = duplicate
+    }
+    for _, list := range []*[]qualityIssue{&absolute.TooShort, &absolute.TooLong, &absolute.BreaksLimits} {
+        issues := make([]qualityIssue, len(*list))
+        for i, issue := range *list {
+            issue.Link = base + issue.Link
+            issues[i] = issue
+        }
+        *list = issues
+    }
+    absolute.Fixes = map[string]fixLink{
+        "authors": {
+            Method:      http.MethodPost,
+            URL:         base + "/admin/authors/merge",
+            Description: "Fold misspelled author names into one author",
+        },
+    }
+
+    writeJSON(response, request, http.StatusOK, absolute)
+}
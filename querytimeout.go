@@ -0,0 +1,365 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// errQueryTimeout wraps the errors of repository calls that ran out of time.
+// Handlers answer them with 504 Gateway Timeout.
+var errQueryTimeout = errors.New("database query timed out")
+
+// loadQueryTimeout reads DB_QUERY_TIMEOUT, the most a single repository call
+// may take. Zero turns the limit off.
+func loadQueryTimeout() (time.Duration, error) {
+    timeout, err := getEnvDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+    if err != nil {
+        return 0, err
+    }
+    if timeout < 0 {
+        return 0, errors.New("DB_QUERY_TIMEOUT can't be negative")
+    }
+    return timeout, nil
+}
+
+// withQueryTimeout returns store with a deadline on every repository call,
+// so a slow database fails requests instead of piling them up. The backfills
+// go through a whole table at startup and aren't limited.
+func withQueryTimeout(store storage, timeout time.Duration) storage {
+    if timeout == 0 {
+        return store
+    }
+    return timedStorage{storage: store, timeout: timeout}
+}
+
+// timedStorage runs the calls of storage with a context that expires after
+// timeout, derived from the caller's.
+type timedStorage struct {
+    storage
+    timeout time.Duration
+}
+
+// timed runs query with the deadline of s. If the deadline passes before it
+// returns, its error is wrapped in errQueryTimeout, whatever the driver made
+// of the cancellation.
+func timed[T any](s timedStorage, ctx context.Context, query func(context.Context) (T, error)) (T, error) {
+    ctx, cancel := context.WithTimeout(ctx, s.timeout)
+    defer cancel()
+    result, err := query(ctx)
+    if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        err = fmt.Errorf("%w after %s: %w", errQueryTimeout, s.timeout, err)
+    }
+    return result, err
+}
+
+// timedExec is timed for calls that only return an error.
+func timedExec(s timedStorage, ctx context.Context, query func(context.Context) error) error {
+    _, err := timed(s, ctx, func(ctx context.Context) (struct{}, error) {
+        return struct{}{}, query(ctx)
+    })
+    return err
+}
+
+// JokeRepository
+
+func (s timedStorage) Random(ctx context.Context, lang string) (Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) (Joke, error) { return s.storage.Random(ctx, lang) })
+}
+
+func (s timedStorage) Get(ctx context.Context, id int) (Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) (Joke, error) { return s.storage.Get(ctx, id) })
+}
+
+func (s timedStorage) List(ctx context.Context, offset, limit, maxID int, lang string) ([]Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]Joke, error) { return s.storage.List(ctx, offset, limit, maxID, lang) })
+}
+
+func (s timedStorage) Languages(ctx context.Context) ([]string, error) {
+    return timed(s, ctx, s.storage.Languages)
+}
+
+func (s timedStorage) ListAfter(ctx context.Context, afterID, limit int, lang string) ([]Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]Joke, error) { return s.storage.ListAfter(ctx, afterID, limit, lang) })
+}
+
+func (s timedStorage) MaxID(ctx context.Context) (int, error) {
+    return timed(s, ctx, s.storage.MaxID)
+}
+
+func (s timedStorage) Create(ctx context.Context, joke *Joke) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.Create(ctx, joke) })
+}
+
+func (s timedStorage) CreateBatch(ctx context.Context, jokes []Joke) ([]error, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]error, error) { return s.storage.CreateBatch(ctx, jokes) })
+}
+
+func (s timedStorage) Delete(ctx context.Context, id int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.Delete(ctx, id) })
+}
+
+func (s timedStorage) Restore(ctx context.Context, id int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.Restore(ctx, id) })
+}
+
+func (s timedStorage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+    return timed(s, ctx, func(ctx context.Context) (int, error) { return s.storage.PurgeDeleted(ctx, before) })
+}
+
+// AuthorRepository
+
+func (s timedStorage) MergeAuthors(ctx context.Context, into string, aliases []string) (Author, error) {
+    return timed(s, ctx, func(ctx context.Context) (Author, error) { return s.storage.MergeAuthors(ctx, into, aliases) })
+}
+
+func (s timedStorage) ListAuthors(ctx context.Context, offset, limit int) ([]AuthorSummary, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]AuthorSummary, error) { return s.storage.ListAuthors(ctx, offset, limit) })
+}
+
+func (s timedStorage) AuthorJokes(ctx context.Context, name string, offset, limit int) (Author, []Joke, error) {
+    var jokes []Joke
+    author, err := timed(s, ctx, func(ctx context.Context) (author Author, err error) {
+        author, jokes, err = s.storage.AuthorJokes(ctx, name, offset, limit)
+        return author, err
+    })
+    return author, jokes, err
+}
+
+func (s timedStorage) AuthorStats(ctx context.Context, name string) (AuthorStats, error) {
+    return timed(s, ctx, func(ctx context.Context) (AuthorStats, error) { return s.storage.AuthorStats(ctx, name) })
+}
+
+// ChangeRepository
+
+func (s timedStorage) Changes(ctx context.Context, since, limit int) ([]JokeChange, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]JokeChange, error) { return s.storage.Changes(ctx, since, limit) })
+}
+
+// FederationRepository
+
+func (s timedStorage) FederatedJokes(ctx context.Context, self string, since, limit int) ([]FederatedJoke, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]FederatedJoke, error) { return s.storage.FederatedJokes(ctx, self, since, limit) })
+}
+
+func (s timedStorage) ImportFederated(ctx context.Context, joke FederatedJoke) (bool, error) {
+    return timed(s, ctx, func(ctx context.Context) (bool, error) { return s.storage.ImportFederated(ctx, joke) })
+}
+
+func (s timedStorage) PeerCursor(ctx context.Context, peer string) (int, error) {
+    return timed(s, ctx, func(ctx context.Context) (int, error) { return s.storage.PeerCursor(ctx, peer) })
+}
+
+func (s timedStorage) SavePeerCursor(ctx context.Context, peer string, cursor int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.SavePeerCursor(ctx, peer, cursor) })
+}
+
+// DailyJokeRepository
+
+func (s timedStorage) DailyJoke(ctx context.Context, day string) (Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) (Joke, error) { return s.storage.DailyJoke(ctx, day) })
+}
+
+func (s timedStorage) PickDailyJoke(ctx context.Context, day string, seed uint64) (Joke, error) {
+    return timed(s, ctx, func(ctx context.Context) (Joke, error) { return s.storage.PickDailyJoke(ctx, day, seed) })
+}
+
+func (s timedStorage) ScheduledJokes(ctx context.Context, day string) ([]ScheduledJoke, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]ScheduledJoke, error) { return s.storage.ScheduledJokes(ctx, day) })
+}
+
+func (s timedStorage) ScheduleJokes(ctx context.Context, entries []ScheduledJoke) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.ScheduleJokes(ctx, entries) })
+}
+
+func (s timedStorage) UnscheduleJoke(ctx context.Context, day string) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.UnscheduleJoke(ctx, day) })
+}
+
+// ModerationRepository
+
+func (s timedStorage) FlagJoke(ctx context.Context, jokeID int, source, reason string) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.FlagJoke(ctx, jokeID, source, reason) })
+}
+
+func (s timedStorage) OpenFlags(ctx context.Context) ([]JokeFlag, error) {
+    return timed(s, ctx, s.storage.OpenFlags)
+}
+
+func (s timedStorage) CountOpenFlags(ctx context.Context) (int, error) {
+    return timed(s, ctx, s.storage.CountOpenFlags)
+}
+
+func (s timedStorage) FlagTimes(ctx context.Context, since time.Time) ([]JokeFlag, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]JokeFlag, error) { return s.storage.FlagTimes(ctx, since) })
+}
+
+func (s timedStorage) EscalateFlags(ctx context.Context, before time.Time) (int, error) {
+    return timed(s, ctx, func(ctx context.Context) (int, error) { return s.storage.EscalateFlags(ctx, before) })
+}
+
+func (s timedStorage) ResolveFlag(ctx context.Context, id int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.ResolveFlag(ctx, id) })
+}
+
+func (s timedStorage) ReportJoke(ctx context.Context, jokeID int, reporter, reason string) (int, error) {
+    return timed(s, ctx, func(ctx context.Context) (int, error) { return s.storage.ReportJoke(ctx, jokeID, reporter, reason) })
+}
+
+func (s timedStorage) HideJoke(ctx context.Context, jokeID int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.HideJoke(ctx, jokeID) })
+}
+
+func (s timedStorage) ResolveReports(ctx context.Context, jokeID int, hide bool) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.ResolveReports(ctx, jokeID, hide) })
+}
+
+func (s timedStorage) ModerateFlags(ctx context.Context, ids []int, action, tag string) ([]int, []int, error) {
+    var jokes []int
+    flags, err := timed(s, ctx, func(ctx context.Context) (flags []int, err error) {
+        flags, jokes, err = s.storage.ModerateFlags(ctx, ids, action, tag)
+        return flags, err
+    })
+    return flags, jokes, err
+}
+
+// ShareRepository
+
+func (s timedStorage) RecordShares(ctx context.Context, shares []Share) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.RecordShares(ctx, shares) })
+}
+
+func (s timedStorage) ShareStats(ctx context.Context, jokeID, limit int) (ShareStats, error) {
+    return timed(s, ctx, func(ctx context.Context) (ShareStats, error) { return s.storage.ShareStats(ctx, jokeID, limit) })
+}
+
+// BestOfRepository
+
+func (s timedStorage) TopShared(ctx context.Context, from, to time.Time, limit int) ([]bestOfEntry, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]bestOfEntry, error) { return s.storage.TopShared(ctx, from, to, limit) })
+}
+
+func (s timedStorage) SaveBestOf(ctx context.Context, best BestOf) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.SaveBestOf(ctx, best) })
+}
+
+func (s timedStorage) BestOf(ctx context.Context, week string) (BestOf, error) {
+    return timed(s, ctx, func(ctx context.Context) (BestOf, error) { return s.storage.BestOf(ctx, week) })
+}
+
+func (s timedStorage) BestOfWeeks(ctx context.Context, limit int) ([]string, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]string, error) { return s.storage.BestOfWeeks(ctx, limit) })
+}
+
+// StatsRepository
+
+func (s timedStorage) CountJokes(ctx context.Context) (visible, hidden int, err error) {
+    visible, err = timed(s, ctx, func(ctx context.Context) (visible int, err error) {
+        visible, hidden, err = s.storage.CountJokes(ctx)
+        return visible, err
+    })
+    return visible, hidden, err
+}
+
+func (s timedStorage) SubmissionDates(ctx context.Context, since time.Time) ([]time.Time, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]time.Time, error) { return s.storage.SubmissionDates(ctx, since) })
+}
+
+// SubscriptionRepository
+
+func (s timedStorage) Subscribe(ctx context.Context, email, frequency, token string) (Subscription, error) {
+    return timed(s, ctx, func(ctx context.Context) (Subscription, error) { return s.storage.Subscribe(ctx, email, frequency, token) })
+}
+
+func (s timedStorage) ConfirmSubscription(ctx context.Context, token string, since time.Time) (Subscription, error) {
+    return timed(s, ctx, func(ctx context.Context) (Subscription, error) { return s.storage.ConfirmSubscription(ctx, token, since) })
+}
+
+func (s timedStorage) Unsubscribe(ctx context.Context, token string) (Subscription, error) {
+    return timed(s, ctx, func(ctx context.Context) (Subscription, error) { return s.storage.Unsubscribe(ctx, token) })
+}
+
+func (s timedStorage) ClaimDigests(ctx context.Context, dailyBefore, weeklyBefore, now time.Time, limit int) ([]Subscription, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]Subscription, error) {
+        return s.storage.ClaimDigests(ctx, dailyBefore, weeklyBefore, now, limit)
+    })
+}
+
+func (s timedStorage) ReleaseDigest(ctx context.Context, id int, lastSentAt *time.Time) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.ReleaseDigest(ctx, id, lastSentAt) })
+}
+
+func (s timedStorage) PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error) {
+    return timed(s, ctx, func(ctx context.Context) (int, error) { return s.storage.PurgeUnconfirmed(ctx, before) })
+}
+
+// ThemeRepository
+
+func (s timedStorage) Themes(ctx context.Context) ([]Theme, error) {
+    return timed(s, ctx, s.storage.Themes)
+}
+
+func (s timedStorage) Theme(ctx context.Context, name string) (Theme, error) {
+    return timed(s, ctx, func(ctx context.Context) (Theme, error) { return s.storage.Theme(ctx, name) })
+}
+
+func (s timedStorage) SaveTheme(ctx context.Context, theme Theme) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.SaveTheme(ctx, theme) })
+}
+
+func (s timedStorage) DeleteTheme(ctx context.Context, name string) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.DeleteTheme(ctx, name) })
+}
+
+// WebhookRepository
+
+func (s timedStorage) CreateWebhook(ctx context.Context, url, secret string) (Webhook, error) {
+    return timed(s, ctx, func(ctx context.Context) (Webhook, error) { return s.storage.CreateWebhook(ctx, url, secret) })
+}
+
+func (s timedStorage) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+    return timed(s, ctx, s.storage.ListWebhooks)
+}
+
+func (s timedStorage) DeleteWebhook(ctx context.Context, id int) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.DeleteWebhook(ctx, id) })
+}
+
+func (s timedStorage) EnqueueWebhooks(ctx context.Context, event string, payload []byte) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.EnqueueWebhooks(ctx, event, payload) })
+}
+
+func (s timedStorage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhookDelivery, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]webhookDelivery, error) { return s.storage.ClaimDeliveries(ctx, limit, lease) })
+}
+
+func (s timedStorage) FinishDelivery(ctx context.Context, id int, retryAt *time.Time, attemptErr string) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.FinishDelivery(ctx, id, retryAt, attemptErr) })
+}
+
+// AuditRepository
+
+func (s timedStorage) RecordAudit(ctx context.Context, entry AuditEntry) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.RecordAudit(ctx, entry) })
+}
+
+func (s timedStorage) AuditLog(ctx context.Context, filter auditFilter) ([]AuditEntry, error) {
+    return timed(s, ctx, func(ctx context.Context) ([]AuditEntry, error) { return s.storage.AuditLog(ctx, filter) })
+}
+
+// CorpusRepository
+
+func (s timedStorage) SourceJokes(ctx context.Context, source string) (map[string]sourceJoke, error) {
+    return timed(s, ctx, func(ctx context.Context) (map[string]sourceJoke, error) { return s.storage.SourceJokes(ctx, source) })
+}
+
+// TranslationRepository
+
+func (s timedStorage) Translation(ctx context.Context, jokeID int, lang string) (JokeTranslation, error) {
+    return timed(s, ctx, func(ctx context.Context) (JokeTranslation, error) { return s.storage.Translation(ctx, jokeID, lang) })
+}
+
+func (s timedStorage) SaveTranslation(ctx context.Context, translation JokeTranslation) error {
+    return timedExec(s, ctx, func(ctx context.Context) error { return s.storage.SaveTranslation(ctx, translation) })
+}
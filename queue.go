@@ -0,0 +1,221 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "slices"
+    "time"
+)
+
+// escalateAfter is how long a flag waits in the moderation queue before it's
+// moved to the priority list. Zero turns escalation off.
+var escalateAfter = 24 * time.Hour
+
+// queueBacklog is how many open flags the moderation queue may hold before
+// moderators are notified. Zero turns the notification off.
+var queueBacklog = 0
+
+// backlogNotified is set once moderators have been told about the backlog,
+// so they're told again only after the queue has dropped below
+// queueBacklog and grown past it again.
+var backlogNotified bool
+
+// queueInterval is how often the queue is checked for flags to escalate and
+// its metrics refreshed.
+const queueInterval = time.Minute
+
+// queueStatsDays is how many days of resolved flags the resolution times
+// cover.
+const queueStatsDays = 30
+
+// queueAgeBuckets are the upper bounds of the histogram buckets for how long
+// flags wait.
+var queueAgeBuckets = []time.Duration{time.Hour, 4 * time.Hour, 24 * time.Hour, 72 * time.Hour, 168 * time.Hour}
+
+// queueMetrics publishes the queue statistics at /debug/vars. They're
+// refreshed every queueInterval.
+var queueMetrics = expvar.NewMap("moderation_queue")
+
+// ageBucket counts the flags that waited up to Le, as in a Prometheus
+// histogram; the last bucket, +Inf, counts them all.
+type ageBucket struct {
+    Le    string `json:"le"`
+    Count int    `json:"count"`
+}
+
+type queueStats struct {
+    Open                    int         `json:"open"`
+    Escalated               int         `json:"escalated"`
+    EscalateAfterSeconds    int64       `json:"escalate_after_seconds"`
+    OldestAgeSeconds        int64       `json:"oldest_age_seconds"`
+    OpenAges                []ageBucket `json:"open_ages"`
+    Resolved                int         `json:"resolved"`
+    MedianResolutionSeconds int64       `json:"median_resolution_seconds"`
+    ResolutionTimes         []ageBucket `json:"resolution_times"`
+}
+
+// ageHistogram counts durations into the queueAgeBuckets.
+func ageHistogram(durations []time.Duration) []ageBucket {
+    buckets := make([]ageBucket, 0, len(queueAgeBuckets)+1)
+    for _, bound := range queueAgeBuckets {
+        bucket := ageBucket{Le: fmt.Sprintf("%dh", int(bound.Hours()))}
+        for _, d := range durations {
+            if d <= bound {
+                bucket.Count++
+            }
+        }
+        buckets = append(buckets, bucket)
+    }
+    return append(buckets, ageBucket{Le: "+Inf", Count: len(durations)})
+}
+
+// readQueueStats measures the open flags and the flags resolved in the last
+// queueStatsDays days.
+func readQueueStats(ctx context.Context, now time.Time) (queueStats, error) {
+    flags, err := moderation.FlagTimes(ctx, now.AddDate(0, 0, -queueStatsDays))
+    if err != nil {
+        return queueStats{}, err
+    }
+
+    var waiting, resolution []time.Duration
+    stats := queueStats{EscalateAfterSeconds: int64(escalateAfter.Seconds())}
+    for _, flag := range flags {
+        if flag.ResolvedAt != nil {
+            resolution = append(resolution, flag.ResolvedAt.Sub(flag.CreatedAt))
+            continue
+        }
+        age := now.Sub(flag.CreatedAt)
+        waiting = append(waiting, age)
+        stats.OldestAgeSeconds = max(stats.OldestAgeSeconds, int64(age.Seconds()))
+        if flag.EscalatedAt != nil {
+            stats.Escalated++
+        }
+    }
+
+    stats.Open, stats.Resolved = len(waiting), len(resolution)
+    stats.OpenAges, stats.ResolutionTimes = ageHistogram(waiting), ageHistogram(resolution)
+    if len(resolution) > 0 {
+        slices.Sort(resolution)
+        stats.MedianResolutionSeconds = int64(resolution[len(resolution)/2].Seconds())
+    }
+    return stats, nil
+}
+
+// publish shows s at /debug/vars.
+func (s queueStats) publish() {
+    queueMetrics.Set("open", expvarInt(s.Open))
+    queueMetrics.Set("escalated", expvarInt(s.Escalated))
+    queueMetrics.Set("oldest_age_seconds", expvarInt(int(s.OldestAgeSeconds)))
+    queueMetrics.Set("open_ages", expvarHistogram(s.OpenAges))
+    queueMetrics.Set("resolved", expvarInt(s.Resolved))
+    queueMetrics.Set("median_resolution_seconds", expvarInt(int(s.MedianResolutionSeconds)))
+    queueMetrics.Set("resolution_times", expvarHistogram(s.ResolutionTimes))
+}
+
+func expvarInt(n int) *expvar.Int {
+    v := new(expvar.Int)
+    v.Set(int64(n))
+    return v
+}
+
+func expvarHistogram(buckets []ageBucket) *expvar.Map {
+    m := new(expvar.Map)
+    for _, bucket := range buckets {
+        m.Set(bucket.Le, expvarInt(bucket.Count))
+    }
+    return m
+}
+
+// runModerationQueue escalates flags and refreshes the queue metrics every
+// queueInterval until ctx is cancelled.
+func runModerationQueue(ctx context.Context) {
+    ticker := time.NewTicker(queueInterval)
+    defer ticker.Stop()
+
+    for {
+        checkModerationQueue(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func checkModerationQueue(ctx context.Context) {
+    now := time.Now().UTC()
+    if escalateAfter > 0 {
+        n, err := moderation.EscalateFlags(ctx, now.Add(-escalateAfter))
+        if err != nil {
+            slog.Error("Error escalating flags", "error", err)
+        } else if n > 0 {
+            slog.Info("Escalated flags", "count", n)
+            notify(ctx, notification{
+                Event:   eventFlagsEscalated,
+                Title:   "Flags escalated",
+                Message: fmt.Sprintf("%d flags waited longer than %s and were escalated.", n, escalateAfter),
+            })
+        }
+    }
+
+    stats, err := readQueueStats(ctx, now)
+    if err != nil {
+        slog.Error("Error reading moderation queue statistics", "error", err)
+        return
+    }
+    stats.publish()
+    checkQueueBacklog(ctx, stats)
+}
+
+// checkQueueBacklog notifies moderators when the queue grows to queueBacklog
+// open flags.
+func checkQueueBacklog(ctx context.Context, stats queueStats) {
+    if queueBacklog <= 0 {
+        return
+    }
+    if stats.Open < queueBacklog {
+        backlogNotified = false
+        return
+    }
+    if backlogNotified {
+        return
+    }
+    backlogNotified = true
+    notify(ctx, notification{
+        Event:   eventQueueBacklog,
+        Title:   "Moderation queue backlog",
+        Message: fmt.Sprintf("%d flags are waiting for moderation, %d of them escalated; the oldest has waited %s.", stats.Open, stats.Escalated, time.Duration(stats.OldestAgeSeconds)*time.Second),
+    })
+}
+
+// getQueueStats serves how long flags wait in the moderation queue.
+func getQueueStats(response http.ResponseWriter, request *http.Request) {
+    stats, err := readQueueStats(request.Context(), time.Now().UTC())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, stats)
+}
+
+// listPriorityFlags serves the escalated flags, oldest first.
+func listPriorityFlags(response http.ResponseWriter, request *http.Request) {
+    flags, err := moderation.OpenFlags(request.Context())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    priority := []JokeFlag{}
+    for _, flag := range flags {
+        if flag.EscalatedAt != nil {
+            priority = append(priority, flag)
+        }
+    }
+    writeJSON(response, request, http.StatusOK, priority)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "log/slog"
+    "math/rand/v2"
+    "net/http"
+    "slices"
+    "sync"
+    "time"
+)
+
+// randomPoolSize is how many recently served jokes /random can fall back on
+// when the database misses the latency budget.
+const randomPoolSize = 100
+
+// randomBudget is how long /random waits for the database before answering
+// from the pool. Zero disables the budget.
+var randomBudget = 50 * time.Millisecond
+
+var randomPool = &jokePool{}
+
+// randomBudgetMisses counts /random requests that went over the budget. It's
+// published with the other counters at /debug/vars.
+var randomBudgetMisses = expvar.NewInt("random_budget_misses")
+
+// jokePool is a ring of recently served random jokes. Refilling it from
+// regular /random traffic keeps it varied without extra queries.
+type jokePool struct {
+    mu    sync.Mutex
+    jokes []Joke
+    next  int
+}
+
+func (p *jokePool) add(joke Joke) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if len(p.jokes) < randomPoolSize {
+        p.jokes = append(p.jokes, joke)
+        return
+    }
+    p.jokes[p.next] = joke
+    p.next = (p.next + 1) % randomPoolSize
+}
+
+// remove drops the joke with the given id, e.g. once it has been hidden.
+func (p *jokePool) remove(id int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.jokes = slices.DeleteFunc(p.jokes, func(joke Joke) bool { return joke.Id == id })
+    p.next %= max(len(p.jokes), 1)
+}
+
+// pick returns a random joke of the pool in lang, or in any language when
+// lang is empty.
+func (p *jokePool) pick(lang string) (Joke, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    jokes := p.jokes
+    if lang != "" {
+        jokes = slices.DeleteFunc(slices.Clone(jokes), func(joke Joke) bool { return joke.Language != lang })
+    }
+    if len(jokes) == 0 {
+        return Joke{}, false
+    }
+    return jokes[rand.IntN(len(jokes))], true
+}
+
+// fillRandomPool seeds the pool with the newest jokes, so the budget can be
+// met before /random has served anything.
+func fillRandomPool(ctx context.Context) {
+    jokes, err := repo.List(ctx, 0, randomPoolSize, 0, "")
+    if err != nil {
+        slog.Error("Error filling the random joke pool", "error", err)
+        return
+    }
+    for _, joke := range jokes {
+        randomPool.add(joke)
+    }
+}
+
+// randomJoke returns a random joke in lang, or in any language when lang is
+// empty, from the database, or from the pool when the database takes longer
+// than randomBudget. Without a joke in lang in the pool it waits for the
+// database regardless.
+func randomJoke(ctx context.Context, lang string) (Joke, error) {
+    type result struct {
+        joke Joke
+        err  error
+    }
+    done := make(chan result, 1)
+    go func() {
+        joke, err := repo.Random(ctx, lang)
+        if err == nil {
+            randomPool.add(joke)
+        }
+        done <- result{joke, err}
+    }()
+
+    if randomBudget <= 0 {
+        r := <-done
+        return r.joke, r.err
+    }
+
+    timer := time.NewTimer(randomBudget)
+    defer timer.Stop()
+
+    select {
+    case r := <-done:
+        return r.joke, r.err
+    case <-timer.C:
+        randomBudgetMisses.Add(1)
+        if joke, ok := randomPool.pick(lang); ok {
+            return joke, nil
+        }
+        r := <-done
+        return r.joke, r.err
+    }
+}
+
+// getMetrics serves the expvar counters, including the Go runtime's memory
+// statistics.
+func getMetrics(response http.ResponseWriter, request *http.Request) {
+    expvar.Handler().ServeHTTP(response, request)
+}
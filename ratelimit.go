@@ -0,0 +1,377 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "math"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// rateLimit allows Requests per Window for each client.
+type rateLimit struct {
+    Requests int
+    Window   time.Duration
+}
+
+// rateLimitResult is the state of a client's window after a request.
+type rateLimitResult struct {
+    Allowed   bool
+    Remaining int
+    Reset     time.Time
+}
+
+// RateLimitStore counts requests per key in fixed windows. Implementations
+// must be safe for concurrent use; the Redis store lets several instances
+// share the same counters.
+type RateLimitStore interface {
+    Allow(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error)
+
+    // Peek returns the state of key's window without counting a request.
+    // Reset is zero when no window is open.
+    Peek(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error)
+}
+
+// rateLimitPolicy maps route templates to limits. Routes without an entry
+// use the default, if there is one.
+type rateLimitPolicy struct {
+    routes   map[string]rateLimit
+    fallback *rateLimit
+    // apiKeys holds the SHA-256 digests of the keys clients are counted by
+    // instead of their address. It's nil unless RATE_LIMIT_BY=api_key.
+    apiKeys map[string]bool
+}
+
+// parseRateLimits reads RATE_LIMITS, a comma separated list of
+// route=requests/window entries such as "default=60/1m,/write=5/1m". The
+// route is a path template without the /v1 prefix, or "default".
+func parseRateLimits(spec string) (rateLimitPolicy, error) {
+    policy := rateLimitPolicy{routes: map[string]rateLimit{}}
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        route, value, ok := strings.Cut(entry, "=")
+        requests, window, ok2 := strings.Cut(value, "/")
+        if !ok || !ok2 {
+            return policy, fmt.Errorf("RATE_LIMITS: %q is not route=requests/window", entry)
+        }
+
+        n, err := strconv.Atoi(requests)
+        if err != nil || n < 1 {
+            return policy, fmt.Errorf("RATE_LIMITS: %q: bad request count", entry)
+        }
+        d, err := time.ParseDuration(window)
+        if err != nil || d <= 0 {
+            return policy, fmt.Errorf("RATE_LIMITS: %q: bad window", entry)
+        }
+
+        limit := rateLimit{Requests: n, Window: d}
+        if route == "default" {
+            policy.fallback = &limit
+        } else {
+            policy.routes[routeKey(route)] = limit
+        }
+    }
+    return policy, nil
+}
+
+// loadRateLimitKeys reads RATE_LIMIT_API_KEYS, the comma separated API keys
+// clients may be counted by with RATE_LIMIT_BY=api_key.
+func loadRateLimitKeys() (map[string]bool, error) {
+    keys := map[string]bool{}
+    for _, key := range splitList(os.Getenv("RATE_LIMIT_API_KEYS")) {
+        keys[apiKeyDigest(key)] = true
+    }
+    if len(keys) == 0 {
+        return nil, errors.New("RATE_LIMIT_BY=api_key requires RATE_LIMIT_API_KEYS")
+    }
+    return keys, nil
+}
+
+// apiKeyDigest is what API keys are known and counted by, so the keys
+// themselves don't end up in the rate limit store.
+func apiKeyDigest(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+func (p rateLimitPolicy) limitFor(route string) (rateLimit, bool) {
+    if limit, ok := p.routes[route]; ok {
+        return limit, true
+    }
+    if p.fallback != nil {
+        return *p.fallback, true
+    }
+    return rateLimit{}, false
+}
+
+// clientIP returns the address of the client. X-Real-IP and X-Forwarded-For
+// are only trusted with TRUST_PROXY=true, since anybody can send them.
+func clientIP(request *http.Request) string {
+    if trust, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY")); trust {
+        if ip := request.Header.Get("X-Real-IP"); ip != "" {
+            return ip
+        }
+        if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+            first, _, _ := strings.Cut(forwarded, ",")
+            return strings.TrimSpace(first)
+        }
+    }
+
+    host, _, err := net.SplitHostPort(request.RemoteAddr)
+    if err != nil {
+        return request.RemoteAddr
+    }
+    return host
+}
+
+// clientKey identifies the client a request counts against: its API key when
+// keying by key is enabled and it sent one of RATE_LIMIT_API_KEYS, its IP
+// address otherwise. Unknown keys count as no key, so a client can't dodge
+// its limit by making up a new key for every request.
+func (p rateLimitPolicy) clientKey(request *http.Request) string {
+    if key := request.Header.Get("X-API-Key"); p.apiKeys != nil && key != "" {
+        if digest := apiKeyDigest(key); p.apiKeys[digest] {
+            return "key:" + digest
+        }
+    }
+    return "ip:" + clientIP(request)
+}
+
+// counterKey is the store key counting the requests of the client of request
+// to route.
+func (p rateLimitPolicy) counterKey(route string, request *http.Request) string {
+    return "ratelimit:" + route + ":" + p.clientKey(request)
+}
+
+// rateLimiter returns router middleware enforcing policy on every route it
+// has a limit for. Store failures let requests through rather than take the
+// API down with the store.
+func rateLimiter(policy rateLimitPolicy, store RateLimitStore) mux.MiddlewareFunc {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+            // Legacy aliases share the limits and counters of their /v1
+            // routes, so they can't be used to double a client's quota.
+            route := routeKey(routeTemplate(request))
+            limit, ok := policy.limitFor(route)
+            if !ok {
+                next.ServeHTTP(response, request)
+                return
+            }
+
+            result, err := store.Allow(request.Context(), policy.counterKey(route, request), limit)
+            if err != nil {
+                slog.ErrorContext(request.Context(), "Error checking rate limit", "error", err)
+                next.ServeHTTP(response, request)
+                return
+            }
+
+            header := response.Header()
+            header.Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+            header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+            header.Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+
+            if !result.Allowed {
+                retryAfter := int(math.Ceil(time.Until(result.Reset).Seconds()))
+                if retryAfter < 1 {
+                    retryAfter = 1
+                }
+                header.Set("Retry-After", strconv.Itoa(retryAfter))
+                writeError(response, http.StatusTooManyRequests, "rate limit exceeded")
+                return
+            }
+
+            next.ServeHTTP(response, request)
+        })
+    }
+}
+
+// routeTemplate returns the path template of the route that matched request,
+// or its path when no route did.
+func routeTemplate(request *http.Request) string {
+    if route := mux.CurrentRoute(request); route != nil {
+        if template, err := route.GetPathTemplate(); err == nil {
+            return template
+        }
+    }
+    return request.URL.Path
+}
+
+// memoryRateLimitStore keeps counters in process memory. It's the default,
+// and only correct when a single instance serves the API.
+type memoryRateLimitStore struct {
+    mu      sync.Mutex
+    windows map[string]*rateLimitWindow
+}
+
+type rateLimitWindow struct {
+    count int
+    reset time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+    return &memoryRateLimitStore{windows: map[string]*rateLimitWindow{}}
+}
+
+func (s *memoryRateLimitStore) Allow(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    window, ok := s.windows[key]
+    if !ok || !now.Before(window.reset) {
+        window = &rateLimitWindow{reset: now.Add(limit.Window)}
+        s.windows[key] = window
+    }
+    window.count++
+
+    return rateLimitResult{
+        Allowed:   window.count <= limit.Requests,
+        Remaining: max(limit.Requests-window.count, 0),
+        Reset:     window.reset,
+    }, nil
+}
+
+func (s *memoryRateLimitStore) Peek(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    window, ok := s.windows[key]
+    if !ok || !time.Now().Before(window.reset) {
+        return rateLimitResult{Allowed: true, Remaining: limit.Requests}, nil
+    }
+    return rateLimitResult{
+        Allowed:   window.count < limit.Requests,
+        Remaining: max(limit.Requests-window.count, 0),
+        Reset:     window.reset,
+    }, nil
+}
+
+// sweep drops expired windows every interval until ctx is cancelled, so the
+// map doesn't grow with every client ever seen.
+func (s *memoryRateLimitStore) sweep(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            s.mu.Lock()
+            for key, window := range s.windows {
+                if !now.Before(window.reset) {
+                    delete(s.windows, key)
+                }
+            }
+            s.mu.Unlock()
+        }
+    }
+}
+
+// newRateLimitStore returns the store selected by RATE_LIMIT_STORE.
+func newRateLimitStore(ctx context.Context) (RateLimitStore, error) {
+    switch store := getEnv("RATE_LIMIT_STORE", "memory"); store {
+    case "memory":
+        s := newMemoryRateLimitStore()
+        go s.sweep(ctx, time.Minute)
+        return s, nil
+    case "redis":
+        return newRedisRateLimitStore(os.Getenv("REDIS_URL"))
+    default:
+        return nil, fmt.Errorf("unsupported RATE_LIMIT_STORE %q", store)
+    }
+}
+
+// rateLimitStatus is a client's quota on one route, as reported by
+// /rate-limits. Reset is missing when the client has no open window, which
+// starts with its next request.
+type rateLimitStatus struct {
+    Route         string `json:"route"`
+    Default       bool   `json:"default,omitempty"`
+    Limit         int    `json:"limit"`
+    WindowSeconds int64  `json:"window_seconds"`
+    Remaining     int    `json:"remaining"`
+    Reset         int64  `json:"reset,omitempty"`
+}
+
+// rateLimitReport is the body of /rate-limits. Client is what the caller is
+// counted by, ip or api_key.
+type rateLimitReport struct {
+    Enabled bool              `json:"enabled"`
+    Client  string            `json:"client,omitempty"`
+    Limits  []rateLimitStatus `json:"limits"`
+}
+
+// newRateLimitsHandler serves the caller's quota on every route of router
+// that has a limit, so clients can pace themselves rather than wait for a
+// 429. store is nil when rate limiting is off.
+func newRateLimitsHandler(router *mux.Router, policy rateLimitPolicy, store RateLimitStore) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        report := rateLimitReport{Enabled: store != nil, Limits: []rateLimitStatus{}}
+        if store == nil {
+            writeJSON(response, request, http.StatusOK, report)
+            return
+        }
+        report.Client = "ip"
+        if strings.HasPrefix(policy.clientKey(request), "key:") {
+            report.Client = "api_key"
+        }
+
+        // A route and its legacy alias share counters, so each is listed
+        // once, without the version prefix.
+        seen := map[string]bool{}
+        var walkErr error
+        router.Walk(func(r *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+            template, err := r.GetPathTemplate()
+            if err != nil {
+                return nil
+            }
+            route := routeKey(template)
+            limit, ok := policy.limitFor(route)
+            if !ok || seen[route] {
+                return nil
+            }
+            seen[route] = true
+
+            result, err := store.Peek(request.Context(), policy.counterKey(route, request), limit)
+            if err != nil {
+                walkErr = err
+                return err
+            }
+            _, own := policy.routes[route]
+            status := rateLimitStatus{
+                Route:         route,
+                Default:       !own,
+                Limit:         limit.Requests,
+                WindowSeconds: int64(limit.Window / time.Second),
+                Remaining:     result.Remaining,
+            }
+            if !result.Reset.IsZero() {
+                status.Reset = result.Reset.Unix()
+            }
+            report.Limits = append(report.Limits, status)
+            return nil
+        })
+        if walkErr != nil {
+            writeInternalError(response, request, walkErr)
+            return
+        }
+
+        writeJSON(response, request, http.StatusOK, report)
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// rateLimitScript increments the counter for a window and starts the window
+// on the first request, atomically, returning the count and the
+// milliseconds until the window resets.
+var rateLimitScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+    redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// redisRateLimitStore shares counters between instances through Redis.
+type redisRateLimitStore struct {
+    client *redis.Client
+}
+
+func newRedisRateLimitStore(url string) (*redisRateLimitStore, error) {
+    options, err := redis.ParseURL(url)
+    if err != nil {
+        return nil, err
+    }
+    return &redisRateLimitStore{client: redis.NewClient(options)}, nil
+}
+
+func (s *redisRateLimitStore) Allow(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+    values, err := rateLimitScript.Run(ctx, s.client, []string{key}, limit.Window.Milliseconds()).Int64Slice()
+    if err != nil {
+        return rateLimitResult{}, err
+    }
+
+    count, ttl := int(values[0]), time.Duration(values[1])*time.Millisecond
+    if ttl < 0 {
+        ttl = limit.Window
+    }
+
+    return rateLimitResult{
+        Allowed:   count <= limit.Requests,
+        Remaining: max(limit.Requests-count, 0),
+        Reset:     time.Now().Add(ttl),
+    }, nil
+}
+
+func (s *redisRateLimitStore) Peek(ctx context.Context, key string, limit rateLimit) (rateLimitResult, error) {
+    pipe := s.client.Pipeline()
+    get, pttl := pipe.Get(ctx, key), pipe.PTTL(ctx, key)
+    if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+        return rateLimitResult{}, err
+    }
+
+    count, err := get.Int()
+    ttl := pttl.Val()
+    if errors.Is(err, redis.Nil) || ttl <= 0 {
+        return rateLimitResult{Allowed: true, Remaining: limit.Requests}, nil
+    }
+    if err != nil {
+        return rateLimitResult{}, err
+    }
+    return rateLimitResult{
+        Allowed:   count < limit.Requests,
+        Remaining: max(limit.Requests-count, 0),
+        Reset:     time.Now().Add(ttl),
+    }, nil
+}
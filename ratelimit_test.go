@@ -0,0 +1,26 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+// TestRateLimitRouteKey limits a route with a variable by its documented
+// name, which the /v1 route and its legacy alias count against together.
+func TestRateLimitRouteKey(t *testing.T) {
+    t.Setenv("RATE_LIMITS", "/jokes/{id}=2/1m")
+    server := newTestServer(t)
+    createTestJokes(t, repo, 1)
+
+    for i, path := range []string{"/v1/jokes/1", "/jokes/1"} {
+        if status, data := testRequest(t, server, "GET", path, "", false); status != http.StatusOK {
+            t.Fatalf("request %d got status %d, want 200: %s", i+1, status, data)
+        }
+    }
+    if status, data := testRequest(t, server, "GET", "/v1/jokes/1", "", false); status != http.StatusTooManyRequests {
+        t.Errorf("request 3 got status %d, want 429: %s", status, data)
+    }
+    if status, data := testRequest(t, server, "GET", "/v1/random", "", false); status != http.StatusOK {
+        t.Errorf("a route without a limit got status %d, want 200: %s", status, data)
+    }
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// reportSource is the source of flags raised by reports from clients.
+const reportSource = "report"
+
+// reportReasons are the reasons a joke can be reported for.
+var reportReasons = []string{"offensive", "spam", "duplicate", "not_a_joke", "other"}
+
+// reportThreshold is how many open reports from different clients hide a
+// joke until an admin has looked at it. Zero leaves hiding to admins.
+var reportThreshold = 3
+
+type reportRequest struct {
+    Reason string `json:"reason"`
+}
+
+func (r *reportRequest) validate() error {
+    var problems validationErrors
+    switch {
+    case r.Reason == "":
+        problems.add("reason", "is required")
+    case !slices.Contains(reportReasons, r.Reason):
+        problems.add("reason", "must be one of %s", strings.Join(reportReasons, ", "))
+    }
+    return problems.err()
+}
+
+// reporterKey identifies a client in reports without storing its address.
+func reporterKey(request *http.Request) string {
+    sum := sha256.Sum256([]byte(clientIP(request)))
+    return hex.EncodeToString(sum[:16])
+}
+
+// reportJoke records a report of a joke. Reporting the same joke again from
+// the same client is accepted but only counts once.
+func reportJoke(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+
+    var report reportRequest
+    if !decodeRequest(response, request, &report) {
+        return
+    }
+
+    ctx := request.Context()
+    _, err := repo.Get(ctx, id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    reports, err := moderation.ReportJoke(ctx, id, reporterKey(request), report.Reason)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    if reportThreshold > 0 && reports >= reportThreshold {
+        if err := moderation.HideJoke(ctx, id); err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+        slog.InfoContext(ctx, "Joke hidden after reports", "joke_id", id, "reports", reports)
+        jokeHidden(id)
+        notify(ctx, notification{
+            Event:   eventJokeHidden,
+            Title:   "Joke hidden after reports",
+            Message: fmt.Sprintf("Joke %s was hidden after %d reports, the last one for %s.", jokeRef(id), reports, report.Reason),
+            JokeId:  jokeRef(id),
+        })
+    }
+
+    response.WriteHeader(http.StatusAccepted)
+}
+
+// jokeHidden drops a hidden joke from the caches that could still serve it.
+func jokeHidden(id int) {
+    responses.invalidate()
+    randomPool.remove(id)
+    forgetTodaysJoke(id)
+}
+
+// jokeReports are the open reports of a joke, as listed for admins.
+type jokeReports struct {
+    JokeID          jokeRef        `json:"joke_id"`
+    Joke            *Joke          `json:"joke,omitempty"`
+    Hidden          bool           `json:"hidden"`
+    Reports         int            `json:"reports"`
+    Reasons         map[string]int `json:"reasons"`
+    FirstReportedAt time.Time      `json:"first_reported_at"`
+}
+
+// listReports serves the reported jokes, the one reported first first.
+func listReports(response http.ResponseWriter, request *http.Request) {
+    flags, err := moderation.OpenFlags(request.Context())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    list := []jokeReports{}
+    index := map[jokeRef]int{}
+    for _, flag := range flags {
+        if flag.Source != reportSource {
+            continue
+        }
+        i, ok := index[flag.JokeID]
+        if !ok {
+            i = len(list)
+            index[flag.JokeID] = i
+            list = append(list, jokeReports{JokeID: flag.JokeID, Joke: flag.Joke, Hidden: flag.JokeHidden, Reasons: map[string]int{}, FirstReportedAt: flag.CreatedAt})
+        }
+        list[i].Reports++
+        list[i].Reasons[flag.Reason]++
+    }
+
+    writeJSON(response, request, http.StatusOK, list)
+}
+
+type resolveReportsRequest struct {
+    Action string `json:"action"`
+}
+
+func (r *resolveReportsRequest) validate() error {
+    var problems validationErrors
+    if r.Action != "dismiss" && r.Action != "hide" {
+        problems.add("action", "must be dismiss or hide")
+    }
+    return problems.err()
+}
+
+// resolveReports closes the reports of a joke. dismiss shows the joke again
+// if the reports hid it; hide keeps it hidden.
+func resolveReports(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errFlagNotFound.Error())
+        return
+    }
+
+    var resolve resolveReportsRequest
+    if !decodeRequest(response, request, &resolve) {
+        return
+    }
+
+    err := moderation.ResolveReports(request.Context(), id, resolve.Action == "hide")
+    if errors.Is(err, errFlagNotFound) {
+        writeError(response, http.StatusNotFound, "joke has no open reports")
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    if resolve.Action == "hide" {
+        jokeHidden(id)
+    } else {
+        responses.invalidate()
+    }
+    response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "time"
+)
+
+// errJokeNotFound is returned by repositories when a requested joke doesn't
+// exist.
+var errJokeNotFound = errors.New("joke not found")
+
+// JokeRepository is the storage interface used by the HTTP handlers. It keeps
+// SQL out of the handlers so they can be exercised against a fake and so
+// other backends can be added without touching handler code.
+type JokeRepository interface {
+    // Random returns a single randomly selected joke in lang, or in any
+    // language when lang is empty. It returns errJokeNotFound when there
+    // are no such jokes.
+    Random(ctx context.Context, lang string) (Joke, error)
+
+    // Get returns the joke with the given id, or errJokeNotFound.
+    Get(ctx context.Context, id int) (Joke, error)
+
+    // List returns jokes newest first, skipping offset and returning at most
+    // limit. When maxID is positive, jokes with a greater id are left out,
+    // and when lang isn't empty, jokes in other languages.
+    List(ctx context.Context, offset, limit, maxID int, lang string) ([]Joke, error)
+
+    // Languages returns the languages there are visible jokes in.
+    Languages(ctx context.Context) ([]string, error)
+
+    // ListAfter returns up to limit jokes with an id greater than afterID,
+    // oldest first, in lang unless it's empty. It's used to walk the whole
+    // table in chunks.
+    ListAfter(ctx context.Context, afterID, limit int, lang string) ([]Joke, error)
+
+    // MaxID returns the highest joke id, or 0 when there are no jokes.
+    MaxID(ctx context.Context) (int, error)
+
+    // Create stores a new joke and sets its Id. If the same joke already
+    // exists, it returns a *duplicateJokeError.
+    Create(ctx context.Context, joke *Joke) error
+
+    // CreateBatch stores several jokes atomically and sets their Ids. The
+    // returned slice holds a *duplicateJokeError for each joke that wasn't
+    // created because it already exists, and nil for the others.
+    CreateBatch(ctx context.Context, jokes []Joke) ([]error, error)
+
+    // BackfillTextHashes computes the duplicate detection hash for jokes
+    // stored before it existed.
+    BackfillTextHashes(ctx context.Context) error
+
+    // Delete marks a joke as deleted, which takes it out of every read
+    // until it's restored or purged. It returns errJokeNotFound when there's
+    // no joke with the id that isn't deleted already.
+    Delete(ctx context.Context, id int) error
+
+    // Restore brings back a deleted joke, or returns errJokeNotFound when
+    // there's no deleted joke with the id.
+    Restore(ctx context.Context, id int) error
+
+    // PurgeDeleted permanently removes the jokes deleted before the given
+    // time, with their flags, shares and schedule, and returns how many
+    // there were.
+    PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+}
+
+// storage is a storage backend: every repository, plus what the server
+// needs to check on and shut down the database behind them.
+type storage interface {
+    JokeRepository
+    AuthorRepository
+    FederationRepository
+    DailyJokeRepository
+    WebhookRepository
+    ChangeRepository
+    ModerationRepository
+    ThemeRepository
+    StatsRepository
+    SubscriptionRepository
+    ShareRepository
+    BestOfRepository
+    AuditRepository
+    CorpusRepository
+    TranslationRepository
+
+    // readyChecks are reported by /readyz.
+    readyChecks() map[string]readyCheck
+
+    // schemaDrift compares the database with what this build expects.
+    schemaDrift(ctx context.Context) (schemaDrift, error)
+
+    Close() error
+}
+
+// openStorage connects to the database selected by DB_DRIVER and brings its
+// schema up to date.
+func openStorage(ctx context.Context, driver string) (storage, error) {
+    if driver == "mongodb" {
+        return openMongoRepository(ctx, os.Getenv("DB_CONN_STRING"))
+    }
+    return openSQLRepository(ctx, driver)
+}
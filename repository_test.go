@@ -0,0 +1,219 @@
+package main
+
+import (
+    "context"
+    "math/rand/v2"
+    "net/http"
+    "net/http/httptest"
+    "slices"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// memoryRepository is an in-memory JokeRepository, so handlers can be tested
+// without a database. Jokes are kept in id order.
+type memoryRepository struct {
+    mu      sync.Mutex
+    jokes   []Joke
+    deleted map[int]time.Time
+}
+
+func newMemoryRepository(jokes ...Joke) *memoryRepository {
+    r := &memoryRepository{deleted: map[int]time.Time{}}
+    for i := range jokes {
+        r.Create(context.Background(), &jokes[i])
+    }
+    return r
+}
+
+// visible returns the jokes that aren't deleted, in lang unless it's empty.
+func (r *memoryRepository) visible(lang string) []Joke {
+    var jokes []Joke
+    for _, joke := range r.jokes {
+        if _, ok := r.deleted[joke.Id]; !ok && (lang == "" || joke.Language == lang) {
+            jokes = append(jokes, joke)
+        }
+    }
+    return jokes
+}
+
+func (r *memoryRepository) Random(ctx context.Context, lang string) (Joke, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    jokes := r.visible(lang)
+    if len(jokes) == 0 {
+        return Joke{}, errJokeNotFound
+    }
+    return jokes[rand.IntN(len(jokes))], nil
+}
+
+func (r *memoryRepository) Get(ctx context.Context, id int) (Joke, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for _, joke := range r.visible("") {
+        if joke.Id == id {
+            return joke, nil
+        }
+    }
+    return Joke{}, errJokeNotFound
+}
+
+func (r *memoryRepository) List(ctx context.Context, offset, limit, maxID int, lang string) ([]Joke, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var jokes []Joke
+    for _, joke := range slices.Backward(r.visible(lang)) {
+        if maxID <= 0 || joke.Id <= maxID {
+            jokes = append(jokes, joke)
+        }
+    }
+    jokes = jokes[min(offset, len(jokes)):]
+    return jokes[:min(limit, len(jokes))], nil
+}
+
+func (r *memoryRepository) Languages(ctx context.Context) ([]string, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var langs []string
+    for _, joke := range r.visible("") {
+        if !slices.Contains(langs, joke.Language) {
+            langs = append(langs, joke.Language)
+        }
+    }
+    slices.Sort(langs)
+    return langs, nil
+}
+
+func (r *memoryRepository) ListAfter(ctx context.Context, afterID, limit int, lang string) ([]Joke, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var jokes []Joke
+    for _, joke := range r.visible(lang) {
+        if joke.Id > afterID && len(jokes) < limit {
+            jokes = append(jokes, joke)
+        }
+    }
+    return jokes, nil
+}
+
+func (r *memoryRepository) MaxID(ctx context.Context) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if len(r.jokes) == 0 {
+        return 0, nil
+    }
+    return r.jokes[len(r.jokes)-1].Id, nil
+}
+
+func (r *memoryRepository) Create(ctx context.Context, joke *Joke) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.create(joke)
+}
+
+func (r *memoryRepository) create(joke *Joke) error {
+    hash := jokeTextHash(joke.Text)
+    for _, existing := range r.jokes {
+        if jokeTextHash(existing.Text) == hash {
+            return &duplicateJokeError{Existing: existing}
+        }
+    }
+    joke.Id = len(r.jokes) + 1
+    joke.Date = time.Now().UTC().Truncate(time.Second)
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+    r.jokes = append(r.jokes, *joke)
+    return nil
+}
+
+func (r *memoryRepository) CreateBatch(ctx context.Context, jokes []Joke) ([]error, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    errs := make([]error, len(jokes))
+    for i := range jokes {
+        errs[i] = r.create(&jokes[i])
+    }
+    return errs, nil
+}
+
+func (r *memoryRepository) BackfillTextHashes(ctx context.Context) error {
+    return nil
+}
+
+func (r *memoryRepository) Delete(ctx context.Context, id int) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    _, deleted := r.deleted[id]
+    if id < 1 || id > len(r.jokes) || deleted {
+        return errJokeNotFound
+    }
+    r.deleted[id] = time.Now()
+    return nil
+}
+
+func (r *memoryRepository) Restore(ctx context.Context, id int) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if _, ok := r.deleted[id]; !ok {
+        return errJokeNotFound
+    }
+    delete(r.deleted, id)
+    return nil
+}
+
+func (r *memoryRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    purged := 0
+    for id, at := range r.deleted {
+        if at.Before(before) {
+            r.jokes = slices.DeleteFunc(r.jokes, func(joke Joke) bool { return joke.Id == id })
+            delete(r.deleted, id)
+            purged++
+        }
+    }
+    return purged, nil
+}
+
+// useMemoryRepository serves the handlers from r until the test ends.
+func useMemoryRepository(t *testing.T, r *memoryRepository) {
+    t.Helper()
+    previous := repo
+    repo = r
+    t.Cleanup(func() { repo = previous })
+}
+
+// TestReadHandlers serves /random and /jokes/{id} from a memory repository,
+// with and without a joke in it.
+func TestReadHandlers(t *testing.T) {
+    router := mux.NewRouter()
+    router.HandleFunc("/random", getRandomJoke)
+    router.HandleFunc("/jokes/{id}", getJoke)
+
+    tests := []struct {
+        name   string
+        jokes  []Joke
+        path   string
+        status int
+    }{
+        {"random", []Joke{{Text: "I used to hate facial hair, but then it grew on me.", Author: "Tester"}}, "/random", http.StatusOK},
+        {"random from nothing", nil, "/random", http.StatusNotFound},
+        {"joke", []Joke{{Text: "I used to hate facial hair, but then it grew on me.", Author: "Tester"}}, "/jokes/1", http.StatusOK},
+        {"missing joke", []Joke{{Text: "I used to hate facial hair, but then it grew on me.", Author: "Tester"}}, "/jokes/2", http.StatusNotFound},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            useMemoryRepository(t, newMemoryRepository(test.jokes...))
+
+            recorder := httptest.NewRecorder()
+            router.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+            if recorder.Code != test.status {
+                t.Errorf("GET %s = %d, want %d: %s", test.path, recorder.Code, test.status, recorder.Body)
+            }
+        })
+    }
+}
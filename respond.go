@@ -0,0 +1,192 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "slices"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Response formats chosen by negotiate.
+const (
+    formatJSON = "json"
+    formatXML  = "xml"
+    formatYAML = "yaml"
+    formatText = "text"
+)
+
+// plainTexter is implemented by values that have a text/plain form.
+type plainTexter interface {
+    plainText() string
+}
+
+func (j Joke) plainText() string {
+    return stripBidiControls(j.Text)
+}
+
+// negotiate picks the response format from the Accept header. The first
+// supported media type listed wins, and JSON is used when none is.
+func negotiate(request *http.Request) string {
+    for _, accepted := range strings.Split(request.Header.Get("Accept"), ",") {
+        mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accepted))
+        switch mediaType {
+        case "application/json":
+            return formatJSON
+        case "application/xml", "text/xml":
+            return formatXML
+        case "application/yaml", "application/x-yaml", "text/yaml":
+            return formatYAML
+        case "text/plain":
+            return formatText
+        }
+    }
+    return formatJSON
+}
+
+// respond writes value in the format the client asked for with the Accept
+// header: JSON, XML, YAML, or for values that have one, plain text. Like
+// writeJSON it applies ?fields= and the instance envelope; the XML and YAML
+// documents use the same field names as the JSON.
+func respond(response http.ResponseWriter, request *http.Request, status int, value any) {
+    format := negotiate(request)
+    response.Header().Add("Vary", "Accept")
+
+    if format == formatText {
+        if texter, ok := value.(plainTexter); ok {
+            response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+            response.WriteHeader(status)
+            io.WriteString(response, texter.plainText()+"\n")
+            return
+        }
+        format = formatJSON
+    }
+    if format == formatJSON {
+        writeJSON(response, request, status, value)
+        return
+    }
+
+    root := "response"
+    if _, ok := value.(Joke); ok && !instanceInResponses() {
+        root = "joke"
+    }
+    value, err := shapeResponse(request, value)
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    // Going through JSON gives XML and YAML the JSON field names, and works
+    // the same for structs and the maps ?fields= produces.
+    generic, err := toGeneric(value)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    var body bytes.Buffer
+    switch format {
+    case formatXML:
+        response.Header().Set("Content-Type", "application/xml; charset=utf-8")
+        body.WriteString(xml.Header)
+        encoder := xml.NewEncoder(&body)
+        err = writeXML(encoder, root, generic)
+        if err == nil {
+            err = encoder.Flush()
+        }
+        body.WriteString("\n")
+    case formatYAML:
+        response.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+        err = yaml.NewEncoder(&body).Encode(generic)
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.WriteHeader(status)
+    response.Write(body.Bytes())
+}
+
+// toGeneric converts value to the maps, slices and scalars its JSON encoding
+// decodes to.
+func toGeneric(value any) (any, error) {
+    data, err := json.Marshal(value)
+    if err != nil {
+        return nil, err
+    }
+
+    var generic any
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    err = decoder.Decode(&generic)
+    return convertNumbers(generic), err
+}
+
+// convertNumbers replaces the json.Numbers in a generic value with int64s,
+// or float64s when they aren't integers, so they're encoded as numbers and
+// not as strings.
+func convertNumbers(value any) any {
+    switch v := value.(type) {
+    case map[string]any:
+        for key, item := range v {
+            v[key] = convertNumbers(item)
+        }
+    case []any:
+        for i, item := range v {
+            v[i] = convertNumbers(item)
+        }
+    case json.Number:
+        if n, err := v.Int64(); err == nil {
+            return n
+        }
+        f, _ := v.Float64()
+        return f
+    }
+    return value
+}
+
+// writeXML writes a generic value as an element called name. Object keys
+// become child elements in sorted order, and array items are written as
+// <item> elements.
+func writeXML(encoder *xml.Encoder, name string, value any) error {
+    start := xml.StartElement{Name: xml.Name{Local: name}}
+
+    switch v := value.(type) {
+    case map[string]any:
+        if err := encoder.EncodeToken(start); err != nil {
+            return err
+        }
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        slices.Sort(keys)
+        for _, key := range keys {
+            if err := writeXML(encoder, key, v[key]); err != nil {
+                return err
+            }
+        }
+        return encoder.EncodeToken(start.End())
+    case []any:
+        if err := encoder.EncodeToken(start); err != nil {
+            return err
+        }
+        for _, item := range v {
+            if err := writeXML(encoder, "item", item); err != nil {
+                return err
+            }
+        }
+        return encoder.EncodeToken(start.End())
+    case nil:
+        return encoder.EncodeElement("", start)
+    default:
+        return encoder.EncodeElement(fmt.Sprint(v), start)
+    }
+}
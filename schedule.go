@@ -0,0 +1,122 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// errNotScheduled is returned when no joke is scheduled for a day.
+var errNotScheduled = errors.New("no joke scheduled for that day")
+
+// ScheduledJoke schedules a joke as the joke of the day for Date, such as a
+// holiday themed joke for a holiday. Missing is set when the joke no longer
+// exists or is hidden; the day then falls back to the usual pick.
+type ScheduledJoke struct {
+    Date    string  `json:"date"`
+    JokeID  jokeRef `json:"joke_id"`
+    Missing bool    `json:"missing,omitempty"`
+}
+
+// scheduleRequest is the body of PUT /admin/schedule.
+type scheduleRequest []ScheduledJoke
+
+// validate checks the dates. Whether the jokes exist is checked by
+// putSchedule, since that needs the database.
+func (s *scheduleRequest) validate() error {
+    var problems validationErrors
+    if len(*s) == 0 {
+        problems.add("schedule", "is empty")
+    }
+
+    today := time.Now().UTC().Format(dayLayout)
+    seen := map[string]bool{}
+    for i, entry := range *s {
+        field := fmt.Sprintf("[%d].date", i)
+        date, err := time.Parse(dayLayout, entry.Date)
+        switch {
+        case err != nil:
+            problems.add(field, "must be YYYY-MM-DD")
+        case date.Format(dayLayout) < today:
+            problems.add(field, "is in the past")
+        case seen[entry.Date]:
+            problems.add(field, "is scheduled twice")
+        }
+        seen[entry.Date] = true
+
+        if entry.JokeID == 0 {
+            problems.add(fmt.Sprintf("[%d].joke_id", i), "is required")
+        }
+    }
+    return problems.err()
+}
+
+// getSchedule lists the jokes scheduled from today on.
+func getSchedule(response http.ResponseWriter, request *http.Request) {
+    today := time.Now().UTC().Format(dayLayout)
+    schedule, err := daily.ScheduledJokes(request.Context(), today)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, schedule)
+}
+
+// putSchedule adds entries to the schedule, replacing what was scheduled for
+// the same days. Nothing is stored unless every joke exists and no day has
+// had its joke picked already.
+func putSchedule(response http.ResponseWriter, request *http.Request) {
+    var entries scheduleRequest
+    if !decodeRequest(response, request, &entries) {
+        return
+    }
+
+    var problems validationErrors
+    for i, entry := range entries {
+        _, err := repo.Get(request.Context(), int(entry.JokeID))
+        if errors.Is(err, errJokeNotFound) {
+            problems.add(fmt.Sprintf("[%d].joke_id", i), "doesn't exist")
+        } else if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+
+        _, err = daily.DailyJoke(request.Context(), entry.Date)
+        if err == nil {
+            problems.add(fmt.Sprintf("[%d].date", i), "already has its joke of the day")
+        } else if !errors.Is(err, errJokeNotFound) {
+            writeInternalError(response, request, err)
+            return
+        }
+    }
+    if len(problems) > 0 {
+        writeValidationError(response, "invalid_request", problems)
+        return
+    }
+
+    err := daily.ScheduleJokes(request.Context(), entries)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    getSchedule(response, request)
+}
+
+func deleteScheduledJoke(response http.ResponseWriter, request *http.Request) {
+    err := daily.UnscheduleJoke(request.Context(), mux.Vars(request)["date"])
+    if errors.Is(err, errNotScheduled) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.WriteHeader(http.StatusNoContent)
+}
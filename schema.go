@@ -0,0 +1,178 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "slices"
+)
+
+// expectedColumns lists the columns of every table, as the migrations create
+// them. Update it together with migrations that add or drop columns.
+var expectedColumns = map[string][]string{
+    "jokes":              {"id", "entry_date", "author", "joke_text", "external_id", "source", "text_hash", "hidden_at", "deleted_at", "language"},
+    "authors":            {"id", "name"},
+    "author_aliases":     {"alias", "author_id"},
+    "federation_peers":   {"peer_url", "last_cursor", "last_synced_at"},
+    "joke_of_the_day":    {"day", "joke_id"},
+    "joke_schedule":      {"day", "joke_id"},
+    "webhooks":           {"id", "url", "secret", "created_at"},
+    "webhook_deliveries": {"id", "webhook_id", "event", "payload", "attempts", "next_attempt_at", "delivered_at", "last_error"},
+    "joke_changes":       {"id", "joke_id", "op", "changed_at"},
+    "joke_flags":         {"id", "joke_id", "source", "reason", "created_at", "resolved_at", "reporter", "escalated_at", "tag"},
+    "card_themes":        {"name", "background", "border", "text_color", "author_color", "font_family", "font_size", "width", "updated_at"},
+    "subscriptions":      {"id", "email", "frequency", "token", "created_at", "confirmed_at", "last_sent_at"},
+    "joke_shares":        {"id", "joke_id", "referrer", "utm_source", "utm_medium", "utm_campaign", "shared_at"},
+    "best_of":            {"week", "position", "joke_id", "shares", "compiled_at"},
+    "audit_log":          {"id", "actor", "action", "target", "before_snapshot", "after_snapshot", "created_at"},
+    "joke_translations":  {"joke_id", "language", "joke_text", "provider", "created_at"},
+    "schema_migrations":  {"version", "name"},
+}
+
+// schemaDrift describes how the live database differs from what this build
+// expects. Extra columns are harmless, since every query names its columns;
+// the rest means queries will fail.
+type schemaDrift struct {
+    // UnknownMigrations were applied by a newer build, typically before a
+    // rollback.
+    UnknownMigrations []string `json:"unknown_migrations,omitempty"`
+    MissingTables     []string `json:"missing_tables,omitempty"`
+    MissingColumns    []string `json:"missing_columns,omitempty"`
+    ExtraColumns      []string `json:"extra_columns,omitempty"`
+}
+
+func (s schemaDrift) incompatible() bool {
+    return len(s.UnknownMigrations) > 0 || len(s.MissingTables) > 0 || len(s.MissingColumns) > 0
+}
+
+// incompatibleSchema is set at startup when the database doesn't match this
+// build. Reads are still served, on a best effort basis, but writes are
+// refused so they can't half succeed.
+var incompatibleSchema *schemaDrift
+
+// checkSchema compares the applied migrations and the columns of every table
+// with what this build expects. It runs after migrate, so pending migrations
+// have already been applied.
+func checkSchema(ctx context.Context, db *sql.DB, d *dialect) (schemaDrift, error) {
+    var drift schemaDrift
+
+    migrations, err := loadMigrations(d)
+    if err != nil {
+        return drift, err
+    }
+    known := map[int]bool{}
+    for _, m := range migrations {
+        known[m.version] = true
+    }
+
+    rows, err := db.QueryContext(ctx, "SELECT version, name FROM schema_migrations ORDER BY version")
+    if err != nil {
+        return drift, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var version int
+        var name string
+        if err := rows.Scan(&version, &name); err != nil {
+            return drift, err
+        }
+        if !known[version] {
+            drift.UnknownMigrations = append(drift.UnknownMigrations, name)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return drift, err
+    }
+
+    tables := make([]string, 0, len(expectedColumns))
+    for table := range expectedColumns {
+        tables = append(tables, table)
+    }
+    slices.Sort(tables)
+
+    for _, table := range tables {
+        columns, err := tableColumns(ctx, db, d, table)
+        if err != nil {
+            return drift, fmt.Errorf("reading the columns of %s: %w", table, err)
+        }
+        if len(columns) == 0 {
+            drift.MissingTables = append(drift.MissingTables, table)
+            continue
+        }
+        for _, column := range expectedColumns[table] {
+            if !slices.Contains(columns, column) {
+                drift.MissingColumns = append(drift.MissingColumns, table+"."+column)
+            }
+        }
+        for _, column := range columns {
+            if !slices.Contains(expectedColumns[table], column) {
+                drift.ExtraColumns = append(drift.ExtraColumns, table+"."+column)
+            }
+        }
+    }
+    return drift, nil
+}
+
+// tableColumns returns the column names of table, or none when it doesn't
+// exist.
+func tableColumns(ctx context.Context, db *sql.DB, d *dialect, table string) ([]string, error) {
+    var query string
+    switch d.name {
+    case "sqlite3":
+        query = "SELECT name FROM pragma_table_info(?)"
+    case "postgres":
+        query = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?"
+    default:
+        query = "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+    }
+
+    rows, err := db.QueryContext(ctx, d.rebind(query), table)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var columns []string
+    for rows.Next() {
+        var column string
+        if err := rows.Scan(&column); err != nil {
+            return nil, err
+        }
+        columns = append(columns, column)
+    }
+    return columns, rows.Err()
+}
+
+// logSchemaDrift reports drift found at startup. Extra columns are only
+// worth a warning.
+func logSchemaDrift(drift schemaDrift) {
+    if drift.incompatible() {
+        slog.Error("Database schema doesn't match this build, refusing writes",
+            "unknown_migrations", drift.UnknownMigrations,
+            "missing_tables", drift.MissingTables,
+            "missing_columns", drift.MissingColumns,
+            "extra_columns", drift.ExtraColumns)
+        return
+    }
+    if len(drift.ExtraColumns) > 0 {
+        slog.Warn("Database has columns this build doesn't know about", "extra_columns", drift.ExtraColumns)
+    }
+}
+
+// requireSchema guards handlers that write, answering 503 with the drift
+// while the schema is incompatible.
+func requireSchema(next http.HandlerFunc) http.HandlerFunc {
+    return func(response http.ResponseWriter, request *http.Request) {
+        if incompatibleSchema != nil {
+            writeAPIError(response, http.StatusServiceUnavailable, apiError{
+                Code:    "schema_incompatible",
+                Message: "the database schema doesn't match this version of the service",
+                Details: incompatibleSchema,
+            })
+            return
+        }
+        next(response, request)
+    }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    _ "embed"
+    "encoding/json"
+    "net/http"
+    "os"
+    "strconv"
+)
+
+// starterJokes is the bundled set the seed command adds, so a new deployment
+// or a demo isn't empty.
+//
+//go:embed seed/jokes.json
+var starterJokes []byte
+
+type seedResult struct {
+    Added   int `json:"added"`
+    Skipped int `json:"skipped"`
+}
+
+// seedJokes adds the first count starter jokes, or all of them when count is
+// 0. Jokes that already exist are skipped, so it can be run again safely.
+func seedJokes(ctx context.Context, count int) (seedResult, error) {
+    var jokes []Joke
+    if err := json.Unmarshal(starterJokes, &jokes); err != nil {
+        return seedResult{}, err
+    }
+    if count > 0 && count < len(jokes) {
+        jokes = jokes[:count]
+    }
+    for i := range jokes {
+        normalizeJoke(&jokes[i])
+    }
+
+    errs, err := repo.CreateBatch(ctx, jokes)
+    if err != nil {
+        return seedResult{}, err
+    }
+    var result seedResult
+    for _, err := range errs {
+        if err == nil {
+            result.Added++
+        } else {
+            result.Skipped++
+        }
+    }
+    return result, nil
+}
+
+// seedEnabled reports whether SEED_ENDPOINT turns on /admin/seed. It's meant
+// for development and demo instances, so it's off by default.
+func seedEnabled() bool {
+    enabled, _ := strconv.ParseBool(os.Getenv("SEED_ENDPOINT"))
+    return enabled
+}
+
+// seed adds the starter jokes, the first ?count= of them if given. Like the
+// command, it doesn't send webhooks.
+func seed(response http.ResponseWriter, request *http.Request) {
+    if !seedEnabled() {
+        writeError(response, http.StatusNotFound, "seeding isn't enabled")
+        return
+    }
+
+    count := 0
+    if value := request.URL.Query().Get("count"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            writeError(response, http.StatusBadRequest, "count must be a positive integer")
+            return
+        }
+        count = n
+    }
+
+    result, err := seedJokes(request.Context(), count)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    if result.Added > 0 {
+        responses.invalidate()
+        newJokes.notify()
+    }
+    writeJSON(response, request, http.StatusOK, result)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// selfTestTimeout bounds each probe, so a hung database fails the self-test
+// instead of stalling a deploy.
+const selfTestTimeout = 10 * time.Second
+
+// selfTestProbe is one request made by the self-test. check, when set,
+// inspects the response body.
+type selfTestProbe struct {
+    method string
+    path   string
+    body   string
+    admin  bool
+    status int
+    check  func(body []byte) error
+}
+
+// runSelfTest starts the API on a random local port against the configured
+// database and probes every public endpoint through it. It only reads: the
+// submission probe sends an invalid joke, since stored jokes can't be
+// removed again.
+func runSelfTest(ctx context.Context, store storage) error {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return err
+    }
+    server := &http.Server{Handler: newHandler(ctx, store)}
+    go server.Serve(listener)
+    defer server.Close()
+
+    base := "http://" + listener.Addr().String()
+    client := &http.Client{Timeout: selfTestTimeout}
+
+    probes := []selfTestProbe{
+        {method: "GET", path: "/healthz", status: http.StatusOK},
+        {method: "GET", path: "/readyz", status: http.StatusOK},
+        {method: "GET", path: "/.well-known/dadjokes.json", status: http.StatusOK},
+        {method: "GET", path: "/openapi.json", status: http.StatusOK},
+        {method: "GET", path: "/v1/limits", status: http.StatusOK},
+        {method: "GET", path: "/v1/jokes?per_page=1", status: http.StatusOK},
+        {method: "GET", path: "/v1/changes?limit=1", status: http.StatusOK},
+        {method: "GET", path: "/v1/federation/jokes?limit=1", status: http.StatusOK},
+        {method: "GET", path: "/v1/feed.rss", status: http.StatusOK},
+        {method: "GET", path: "/v1/feed.atom", status: http.StatusOK},
+        {method: "POST", path: "/v1/write", body: `{"author": "selftest", "joke_text": ""}`, status: http.StatusBadRequest},
+    }
+
+    maxID, err := repo.MaxID(ctx)
+    if err != nil {
+        return err
+    }
+    if maxID > 0 {
+        probes = append(probes,
+            selfTestProbe{method: "GET", path: "/v1/random", status: http.StatusOK, check: func(body []byte) error {
+                // The random joke must also be reachable by its id.
+                var joke Joke
+                if err := json.Unmarshal(body, &joke); err != nil {
+                    return err
+                }
+                fetch := selfTestProbe{method: "GET", path: "/v1/jokes/" + jokeRef(joke.Id).String(), status: http.StatusOK, check: func(body []byte) error {
+                    var fetched Joke
+                    if err := json.Unmarshal(body, &fetched); err != nil {
+                        return err
+                    }
+                    if fetched.Text != joke.Text {
+                        return errors.New("/v1/jokes/{id} returned a different joke")
+                    }
+                    return nil
+                }}
+                return fetch.run(ctx, client, base)
+            }},
+            selfTestProbe{method: "GET", path: "/v1/joke-of-the-day", status: http.StatusOK},
+            selfTestProbe{method: "GET", path: "/v1/embed.js", status: http.StatusOK},
+            selfTestProbe{method: "GET", path: "/v1/embed.svg", status: http.StatusOK},
+        )
+    } else {
+        slog.Warn("No jokes stored, skipping the probes that need one")
+    }
+    if os.Getenv("ADMIN_TOKEN") != "" {
+        probes = append(probes, selfTestProbe{method: "GET", path: "/v1/admin/webhooks", admin: true, status: http.StatusOK})
+    }
+
+    failed := 0
+    for _, probe := range probes {
+        err := probe.run(ctx, client, base)
+        if err != nil {
+            failed++
+            slog.Error("Probe failed", "method", probe.method, "path", probe.path, "error", err)
+            continue
+        }
+        slog.Info("Probe passed", "method", probe.method, "path", probe.path)
+    }
+
+    if failed > 0 {
+        return fmt.Errorf("%d of the probes failed", failed)
+    }
+    return nil
+}
+
+func (p selfTestProbe) run(ctx context.Context, client *http.Client, base string) error {
+    var body io.Reader
+    if p.body != "" {
+        body = strings.NewReader(p.body)
+    }
+    request, err := http.NewRequestWithContext(ctx, p.method, base+p.path, body)
+    if err != nil {
+        return err
+    }
+    if p.body != "" {
+        request.Header.Set("Content-Type", "application/json")
+    }
+    if p.admin {
+        request.Header.Set("Authorization", "Bearer "+os.Getenv("ADMIN_TOKEN"))
+    }
+
+    response, err := client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+
+    data, err := io.ReadAll(response.Body)
+    if err != nil {
+        return err
+    }
+    if response.StatusCode != p.status {
+        return fmt.Errorf("status %d, want %d", response.StatusCode, p.status)
+    }
+    if p.check != nil {
+        return p.check(data)
+    }
+    return nil
+}
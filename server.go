@@ -0,0 +1,91 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// bodyLimits bound the JSON request bodies the API reads, in bytes. batch is
+// for bulk imports, which take up to maxBatchSize jokes at once.
+var bodyLimits = struct {
+    json, batch int64
+}{json: 1 << 20, batch: 8 << 20}
+
+// loadBodyLimits reads MAX_BODY_BYTES and MAX_BATCH_BODY_BYTES.
+func loadBodyLimits() error {
+    json, err := getEnvInt("MAX_BODY_BYTES", int(bodyLimits.json))
+    if err != nil {
+        return err
+    }
+    batch, err := getEnvInt("MAX_BATCH_BODY_BYTES", int(bodyLimits.batch))
+    if err != nil {
+        return err
+    }
+    if json < 1 || batch < 1 {
+        return errors.New("MAX_BODY_BYTES and MAX_BATCH_BODY_BYTES must be positive")
+    }
+    bodyLimits.json, bodyLimits.batch = int64(json), int64(batch)
+    return nil
+}
+
+// configureServer sets the timeouts and header limit of server from
+// READ_HEADER_TIMEOUT, READ_TIMEOUT, WRITE_TIMEOUT, IDLE_TIMEOUT and
+// MAX_HEADER_BYTES. Zero turns a timeout off. Streamed responses lift the
+// read and write timeouts for themselves with keepOpen.
+func configureServer(server *http.Server) error {
+    var err error
+    server.ReadHeaderTimeout, err = getEnvDuration("READ_HEADER_TIMEOUT", 5*time.Second)
+    if err != nil {
+        return err
+    }
+    server.ReadTimeout, err = getEnvDuration("READ_TIMEOUT", 30*time.Second)
+    if err != nil {
+        return err
+    }
+    server.WriteTimeout, err = getEnvDuration("WRITE_TIMEOUT", 30*time.Second)
+    if err != nil {
+        return err
+    }
+    server.IdleTimeout, err = getEnvDuration("IDLE_TIMEOUT", 2*time.Minute)
+    if err != nil {
+        return err
+    }
+    server.MaxHeaderBytes, err = getEnvInt("MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes)
+    if err != nil {
+        return err
+    }
+    if server.MaxHeaderBytes < 1 {
+        return errors.New("MAX_HEADER_BYTES must be positive")
+    }
+    return nil
+}
+
+// keepOpen lifts the server's read and write timeouts for a response that
+// is streamed for longer than they allow, until deadline, or for good when
+// deadline is zero. The read deadline matters too: once it passes, the
+// server takes the connection for closed and cancels the request.
+func keepOpen(response http.ResponseWriter, deadline time.Time) {
+    controller := http.NewResponseController(response)
+    controller.SetReadDeadline(deadline)
+    controller.SetWriteDeadline(deadline)
+}
+
+// decodeBody decodes the JSON request body into value, reading at most
+// limit bytes. It answers 400, or 413 when the body is too large, and
+// returns false when the body can't be decoded.
+func decodeBody(response http.ResponseWriter, request *http.Request, limit int64, value any) bool {
+    err := json.NewDecoder(http.MaxBytesReader(response, request.Body, limit)).Decode(value)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        writeError(response, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+        return false
+    }
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return false
+    }
+    return true
+}
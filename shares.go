@@ -0,0 +1,249 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "expvar"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    // shareFlushInterval is how often recorded shares are written to the
+    // database, at the latest.
+    shareFlushInterval = 5 * time.Second
+
+    // shareBatchSize is how many shares are written at once. A full batch
+    // is written right away.
+    shareBatchSize = 100
+
+    // shareBufferSize is how many shares can wait for a write. Shares past
+    // that are dropped rather than slowing down redirects.
+    shareBufferSize = 10000
+
+    // shareTopValues is how many referrers and UTM values the stats list.
+    shareTopValues = 20
+
+    // maxShareValue bounds the stored referrer and UTM values.
+    maxShareValue = 255
+)
+
+// Share is a visit to a share link: the joke, the host of the referring page
+// and the UTM parameters of the link, if any.
+type Share struct {
+    JokeId      int
+    Referrer    string
+    UTMSource   string
+    UTMMedium   string
+    UTMCampaign string
+    SharedAt    time.Time
+}
+
+// ShareStats counts the visits to a joke's share link.
+type ShareStats struct {
+    JokeId       jokeRef      `json:"joke_id"`
+    Total        int          `json:"total"`
+    LastSharedAt *time.Time   `json:"last_shared_at,omitempty"`
+    Referrers    []shareCount `json:"referrers"`
+    Sources      []shareCount `json:"utm_sources"`
+    Mediums      []shareCount `json:"utm_mediums"`
+    Campaigns    []shareCount `json:"utm_campaigns"`
+}
+
+// shareCount is how many visits had a referrer or UTM value.
+type shareCount struct {
+    Value string `json:"value"`
+    Count int    `json:"count"`
+}
+
+// ShareRepository stores share link visits.
+type ShareRepository interface {
+    // RecordShares stores shares.
+    RecordShares(ctx context.Context, shares []Share) error
+
+    // ShareStats counts the shares of a joke, with its most common
+    // referrers and UTM values, up to limit of each.
+    ShareStats(ctx context.Context, jokeID, limit int) (ShareStats, error)
+}
+
+var shares ShareRepository
+
+var shareMetrics = expvar.NewMap("shares")
+
+// shareBuffer collects shares for the server and writes them in batches, so
+// redirects don't wait for the database. It's nil in the other commands and
+// on Lambda, where recordShare writes shares right away instead.
+var shareBuffer *shareRecorder
+
+type shareRecorder struct {
+    pending chan Share
+    stop    chan struct{}
+    done    chan struct{}
+}
+
+func newShareRecorder() *shareRecorder {
+    return &shareRecorder{
+        pending: make(chan Share, shareBufferSize),
+        stop:    make(chan struct{}),
+        done:    make(chan struct{}),
+    }
+}
+
+// recordShare stores share, or queues it for the recorder.
+func recordShare(ctx context.Context, share Share) {
+    if shareBuffer == nil {
+        if err := shares.RecordShares(ctx, []Share{share}); err != nil {
+            slog.ErrorContext(ctx, "Error recording share", "joke", share.JokeId, "error", err)
+            return
+        }
+        shareMetrics.Add("recorded", 1)
+        return
+    }
+
+    select {
+    case shareBuffer.pending <- share:
+    default:
+        shareMetrics.Add("dropped", 1)
+    }
+}
+
+// run writes queued shares until close is called, then writes what's left.
+func (r *shareRecorder) run() {
+    defer close(r.done)
+    ticker := time.NewTicker(shareFlushInterval)
+    defer ticker.Stop()
+
+    batch := make([]Share, 0, shareBatchSize)
+    flush := func() {
+        if len(batch) == 0 {
+            return
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), shareFlushInterval)
+        defer cancel()
+        if err := shares.RecordShares(ctx, batch); err != nil {
+            shareMetrics.Add("dropped", int64(len(batch)))
+            slog.Error("Error recording shares", "shares", len(batch), "error", err)
+        } else {
+            shareMetrics.Add("recorded", int64(len(batch)))
+        }
+        batch = batch[:0]
+    }
+
+    for {
+        select {
+        case share := <-r.pending:
+            batch = append(batch, share)
+            if len(batch) == shareBatchSize {
+                flush()
+            }
+        case <-ticker.C:
+            flush()
+        case <-r.stop:
+            for {
+                select {
+                case share := <-r.pending:
+                    batch = append(batch, share)
+                    if len(batch) == shareBatchSize {
+                        flush()
+                    }
+                default:
+                    flush()
+                    return
+                }
+            }
+        }
+    }
+}
+
+// close writes the queued shares and stops the recorder. Shares recorded
+// after it's called are dropped.
+func (r *shareRecorder) close() {
+    close(r.stop)
+    <-r.done
+}
+
+// shareValue trims a referrer or UTM value to what's stored.
+func shareValue(value string) string {
+    value = strings.TrimSpace(value)
+    if len(value) > maxShareValue {
+        value = strings.ToValidUTF8(value[:maxShareValue], "")
+    }
+    return value
+}
+
+// shareRedirectURL is where the share link of joke leads: SHARE_REDIRECT_URL
+// with {id} replaced by the joke's public id, or the joke in the API.
+func shareRedirectURL(request *http.Request, joke Joke) string {
+    ref := jokeRef(joke.Id).String()
+    if target := os.Getenv("SHARE_REDIRECT_URL"); target != "" {
+        return strings.ReplaceAll(target, "{id}", url.PathEscape(ref))
+    }
+    return apiURL(request) + "/jokes/" + ref
+}
+
+// followShareLink redirects a share link to the joke and records the visit.
+// Only the host of the referrer is kept.
+func followShareLink(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["slug"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+    joke, err := repo.Get(request.Context(), id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    // Writes are refused while the schema is incompatible, visits included,
+    // but the link still works.
+    if incompatibleSchema == nil {
+        share := Share{JokeId: joke.Id, SharedAt: time.Now().UTC().Truncate(time.Second)}
+        if referrer, err := url.Parse(request.Referer()); err == nil {
+            share.Referrer = shareValue(strings.ToLower(referrer.Hostname()))
+        }
+        query := request.URL.Query()
+        share.UTMSource = shareValue(query.Get("utm_source"))
+        share.UTMMedium = shareValue(query.Get("utm_medium"))
+        share.UTMCampaign = shareValue(query.Get("utm_campaign"))
+        recordShare(request.Context(), share)
+    }
+
+    response.Header().Set("Cache-Control", "no-store")
+    http.Redirect(response, request, shareRedirectURL(request, joke), http.StatusFound)
+}
+
+// getShareStats reports the share link visits of a joke. Visits are written
+// in batches, so the last few seconds may be missing.
+func getShareStats(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+    if _, err := repo.Get(request.Context(), id); err != nil {
+        if errors.Is(err, errJokeNotFound) {
+            writeError(response, http.StatusNotFound, err.Error())
+            return
+        }
+        writeInternalError(response, request, err)
+        return
+    }
+
+    stats, err := shares.ShareStats(request.Context(), id, shareTopValues)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    writeJSON(response, request, http.StatusOK, stats)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "os"
+
+    "github.com/gorilla/websocket"
+)
+
+// responseSigner signs response bodies so mirrors and bots can check they
+// weren't altered on the way. It's nil, and responses aren't signed, unless
+// SIGNING_KEY is set.
+var responseSigner *signer
+
+type signer struct {
+    key   ed25519.PrivateKey
+    keyID string
+}
+
+// signingKey is the public half of the signing key, as published at
+// /.well-known/dadjokes-signing-key.json.
+type signingKey struct {
+    KeyID     string `json:"key_id"`
+    Algorithm string `json:"algorithm"`
+    PublicKey string `json:"public_key"`
+}
+
+// loadSigner reads SIGNING_KEY, a base64 encoded 32 byte Ed25519 seed such
+// as the output of `openssl rand -base64 32`.
+func loadSigner() (*signer, error) {
+    value := os.Getenv("SIGNING_KEY")
+    if value == "" {
+        return nil, nil
+    }
+    seed, err := base64.StdEncoding.DecodeString(value)
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return nil, errors.New("SIGNING_KEY must be 32 bytes, base64 encoded")
+    }
+
+    key := ed25519.NewKeyFromSeed(seed)
+    sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+    return &signer{key: key, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+func (s *signer) public() signingKey {
+    return signingKey{
+        KeyID:     s.keyID,
+        Algorithm: "ed25519",
+        PublicKey: base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+    }
+}
+
+func getSigningKey(response http.ResponseWriter, request *http.Request) {
+    if responseSigner == nil {
+        writeError(response, http.StatusNotFound, "responses aren't signed")
+        return
+    }
+    response.Header().Set("Content-Type", "application/json")
+    response.Header().Set("Cache-Control", "public, max-age=3600")
+    json.NewEncoder(response).Encode(responseSigner.public())
+}
+
+// signResponses holds each response back until the handler is done and adds
+// a detached signature of the body in X-Signature, with the id of the key in
+// X-Signature-Key-Id. Streamed responses, such as exports, go out unsigned
+// from their first flush on, since the headers can't wait for the end.
+func (s *signer) signResponses(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        // A WebSocket connection has no response body to sign.
+        if websocket.IsWebSocketUpgrade(request) {
+            next.ServeHTTP(response, request)
+            return
+        }
+
+        w := &signingWriter{ResponseWriter: response, status: http.StatusOK}
+        next.ServeHTTP(w, request)
+        if w.streaming {
+            return
+        }
+
+        header := response.Header()
+        if w.body.Len() > 0 && request.Method != http.MethodHead {
+            header.Set("X-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, w.body.Bytes())))
+            header.Set("X-Signature-Key-Id", s.keyID)
+        }
+        response.WriteHeader(w.status)
+        response.Write(w.body.Bytes())
+    })
+}
+
+// signingWriter buffers a response for signResponses.
+type signingWriter struct {
+    http.ResponseWriter
+    status    int
+    body      bytes.Buffer
+    streaming bool
+}
+
+func (w *signingWriter) WriteHeader(status int) {
+    if w.streaming {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.status = status
+}
+
+func (w *signingWriter) Write(b []byte) (int, error) {
+    if w.streaming {
+        return w.ResponseWriter.Write(b)
+    }
+    return w.body.Write(b)
+}
+
+// FlushError gives up on signing and sends what has been buffered.
+func (w *signingWriter) FlushError() error {
+    if !w.streaming {
+        w.streaming = true
+        w.ResponseWriter.WriteHeader(w.status)
+        if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+            return err
+        }
+        w.body.Reset()
+    }
+    return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *signingWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// slackMessage is a Slack Block Kit payload. Text is the fallback shown in
+// notifications and by clients that can't render blocks.
+type slackMessage struct {
+    Text   string       `json:"text"`
+    Blocks []slackBlock `json:"blocks"`
+
+    // ResponseType and ReplaceOriginal are only used in replies to slash
+    // commands and button clicks.
+    ResponseType    string `json:"response_type,omitempty"`
+    ReplaceOriginal bool   `json:"replace_original,omitempty"`
+}
+
+type slackBlock struct {
+    Type     string         `json:"type"`
+    Text     *slackText     `json:"text,omitempty"`
+    Elements []slackElement `json:"elements,omitempty"`
+}
+
+// slackElement covers both context elements (text objects) and action
+// elements (buttons), which share the element list in Block Kit.
+type slackElement struct {
+    Type     string `json:"type"`
+    Text     any    `json:"text,omitempty"`
+    URL      string `json:"url,omitempty"`
+    Style    string `json:"style,omitempty"`
+    ActionID string `json:"action_id,omitempty"`
+}
+
+type slackText struct {
+    Type string `json:"type"`
+    Text string `json:"text"`
+}
+
+// slackEscape escapes the control characters Slack's mrkdwn format reserves.
+func slackEscape(text string) string {
+    return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// newSlackMessage renders a joke as a Block Kit message: the joke itself, a
+// context line with the author and a permalink, and a button for another one.
+func newSlackMessage(joke Joke, request *http.Request) slackMessage {
+    base := apiURL(request)
+    permalink := fmt.Sprintf("%s/jokes/%s", base, jokeRef(joke.Id))
+
+    author := joke.Author
+    if author == "" {
+        author = "Anonymous"
+    }
+
+    return slackMessage{
+        Text: joke.Text,
+        Blocks: []slackBlock{
+            {
+                Type: "section",
+                Text: &slackText{Type: "mrkdwn", Text: slackEscape(joke.Text)},
+            },
+            {
+                Type: "context",
+                Elements: []slackElement{
+                    {Type: "mrkdwn", Text: fmt.Sprintf("Submitted by *%s* | <%s|Joke #%s>", slackEscape(author), permalink, jokeRef(joke.Id))},
+                },
+            },
+            {
+                Type: "actions",
+                Elements: []slackElement{
+                    {
+                        Type:  "button",
+                        Text:  slackText{Type: "plain_text", Text: "Another one"},
+                        URL:   base + "/random",
+                        Style: "primary",
+                    },
+                },
+            },
+        },
+    }
+}
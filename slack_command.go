@@ -0,0 +1,173 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    // slackMaxSkew is how old a signed Slack request may be before it's
+    // rejected as a possible replay.
+    slackMaxSkew = 5 * time.Minute
+
+    // slackAnotherAction identifies the "Another one" button.
+    slackAnotherAction = "another_joke"
+
+    // slackResponseURLPrefix is where Slack's response_url values point.
+    // Anything else is refused, so the endpoint can't be used to make the
+    // server POST to arbitrary URLs.
+    slackResponseURLPrefix = "https://hooks.slack.com/"
+)
+
+// slackInteraction is the part of an interactive payload we use.
+type slackInteraction struct {
+    Type        string `json:"type"`
+    ResponseURL string `json:"response_url"`
+    Actions     []struct {
+        ActionID string `json:"action_id"`
+    } `json:"actions"`
+}
+
+// verifySlackSignature checks the X-Slack-Signature header, an HMAC-SHA256
+// of the timestamp and body keyed with the app's signing secret.
+func verifySlackSignature(secret string, header http.Header, body []byte) bool {
+    timestamp, err := strconv.ParseInt(header.Get("X-Slack-Request-Timestamp"), 10, 64)
+    if err != nil {
+        return false
+    }
+    if age := time.Since(time.Unix(timestamp, 0)); age > slackMaxSkew || age < -slackMaxSkew {
+        return false
+    }
+
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte("v0:" + strconv.FormatInt(timestamp, 10) + ":"))
+    mac.Write(body)
+    expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+    return hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature")))
+}
+
+// newSlackReply renders a joke for a slash command reply. The "Another one"
+// button is interactive, swapping in a new joke instead of opening a link.
+func newSlackReply(joke Joke, request *http.Request) slackMessage {
+    message := newSlackMessage(joke, request)
+    message.ResponseType = "in_channel"
+    for _, block := range message.Blocks {
+        for i, element := range block.Elements {
+            if element.Type == "button" {
+                block.Elements[i].URL = ""
+                block.Elements[i].ActionID = slackAnotherAction
+            }
+        }
+    }
+    return message
+}
+
+// slackIntegration handles both the slash command and the clicks on its
+// "Another one" button; point the app's slash command and interactivity
+// request URLs here. Requests must be signed with SLACK_SIGNING_SECRET, and
+// the endpoint is disabled without one.
+func slackIntegration(response http.ResponseWriter, request *http.Request) {
+    secret := os.Getenv("SLACK_SIGNING_SECRET")
+    if secret == "" {
+        writeError(response, http.StatusNotFound, "not found")
+        return
+    }
+
+    body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+    if !verifySlackSignature(secret, request.Header, body) {
+        writeError(response, http.StatusUnauthorized, "invalid signature")
+        return
+    }
+
+    form, err := url.ParseQuery(string(body))
+    if err != nil {
+        writeError(response, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if payload := form.Get("payload"); payload != "" {
+        var interaction slackInteraction
+        if err := json.Unmarshal([]byte(payload), &interaction); err != nil {
+            writeError(response, http.StatusBadRequest, err.Error())
+            return
+        }
+        handleSlackInteraction(request, interaction)
+        response.WriteHeader(http.StatusOK)
+        return
+    }
+
+    joke, err := repo.Random(request.Context(), "")
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(response).Encode(newSlackReply(joke, request))
+}
+
+// handleSlackInteraction replaces the message whose "Another one" button was
+// clicked with a new joke. Slack ignores the response to the click itself,
+// so the new message is posted to the response_url in the background and the
+// click is acknowledged straight away.
+func handleSlackInteraction(request *http.Request, interaction slackInteraction) {
+    if interaction.Type != "block_actions" || !strings.HasPrefix(interaction.ResponseURL, slackResponseURLPrefix) {
+        return
+    }
+    clicked := false
+    for _, action := range interaction.Actions {
+        clicked = clicked || action.ActionID == slackAnotherAction
+    }
+    if !clicked {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(request.Context()), 10*time.Second)
+    request = request.WithContext(ctx)
+    go func() {
+        defer cancel()
+
+        joke, err := repo.Random(ctx, "")
+        if err != nil {
+            slog.ErrorContext(ctx, "Error loading joke for Slack", "error", err)
+            return
+        }
+
+        message := newSlackReply(joke, request)
+        message.ReplaceOriginal = true
+        data, _ := json.Marshal(message)
+
+        reply, err := http.NewRequestWithContext(ctx, http.MethodPost, interaction.ResponseURL, bytes.NewReader(data))
+        if err != nil {
+            slog.ErrorContext(ctx, "Error replying to Slack", "error", err)
+            return
+        }
+        reply.Header.Set("Content-Type", "application/json")
+
+        res, err := http.DefaultClient.Do(reply)
+        if err != nil {
+            slog.ErrorContext(ctx, "Error replying to Slack", "error", err)
+            return
+        }
+        res.Body.Close()
+        if res.StatusCode != http.StatusOK {
+            slog.ErrorContext(ctx, "Error replying to Slack", "status", res.Status)
+        }
+    }()
+}
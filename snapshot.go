@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "time"
+)
+
+// snapshotVersion is the format version of snapshot files.
+const snapshotVersion = 1
+
+// corpusSnapshot is a manifest of every visible joke, written by `dadjokes
+// snapshot`. Two snapshots can be compared with `dadjokes diff`, also when
+// they were taken on different instances, since jokes are matched by
+// content rather than id.
+type corpusSnapshot struct {
+    Version   int       `json:"version"`
+    CreatedAt time.Time `json:"created_at"`
+    Count     int       `json:"count"`
+    // Digest hashes every entry's hash, in id order. Snapshots of the same
+    // corpus have the same digest.
+    Digest string          `json:"digest"`
+    Jokes  []snapshotEntry `json:"jokes"`
+}
+
+// snapshotEntry is a joke in a snapshot. TextHash is the duplicate detection
+// hash of the text, which identifies the joke across snapshots; Hash covers
+// everything else too, so it changes when the joke is renamed or redated.
+type snapshotEntry struct {
+    Id       int       `json:"id"`
+    TextHash string    `json:"text_hash"`
+    Hash     string    `json:"hash"`
+    Date     time.Time `json:"entry_date"`
+    Author   string    `json:"author"`
+    Text     string    `json:"joke_text"`
+}
+
+func newSnapshotEntry(joke Joke) snapshotEntry {
+    date := joke.Date.UTC()
+    sum := sha256.Sum256([]byte(joke.Text + "\x00" + joke.Author + "\x00" + date.Format(time.RFC3339)))
+    return snapshotEntry{
+        Id:       joke.Id,
+        TextHash: jokeTextHash(joke.Text),
+        Hash:     hex.EncodeToString(sum[:]),
+        Date:     date,
+        Author:   joke.Author,
+        Text:     joke.Text,
+    }
+}
+
+// takeSnapshot reads every visible joke into a snapshot.
+func takeSnapshot(ctx context.Context) (corpusSnapshot, error) {
+    snapshot := corpusSnapshot{Version: snapshotVersion, CreatedAt: time.Now().UTC().Truncate(time.Second), Jokes: []snapshotEntry{}}
+    digest := sha256.New()
+    afterID := 0
+    for {
+        jokes, err := repo.ListAfter(ctx, afterID, exportChunkSize, "")
+        if err != nil {
+            return corpusSnapshot{}, err
+        }
+        for _, joke := range jokes {
+            entry := newSnapshotEntry(joke)
+            snapshot.Jokes = append(snapshot.Jokes, entry)
+            fmt.Fprintf(digest, "%d %s\n", entry.Id, entry.Hash)
+            afterID = joke.Id
+        }
+        if len(jokes) < exportChunkSize {
+            break
+        }
+    }
+    snapshot.Count = len(snapshot.Jokes)
+    snapshot.Digest = hex.EncodeToString(digest.Sum(nil))
+    return snapshot, nil
+}
+
+// runSnapshot implements `dadjokes snapshot [FILE]`. Without a file it
+// writes snapshot-TIMESTAMP.json in the working directory.
+func runSnapshot(ctx context.Context, args []string) (string, error) {
+    snapshot, err := takeSnapshot(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    name := "snapshot-" + snapshot.CreatedAt.Format("20060102T150405Z") + ".json"
+    if len(args) == 1 {
+        name = args[0]
+    }
+    data, err := json.MarshalIndent(snapshot, "", "    ")
+    if err != nil {
+        return "", err
+    }
+    return name, os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+func readSnapshot(name string) (corpusSnapshot, error) {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return corpusSnapshot{}, err
+    }
+    var snapshot corpusSnapshot
+    if err := json.Unmarshal(data, &snapshot); err != nil {
+        return corpusSnapshot{}, fmt.Errorf("%s: %w", name, err)
+    }
+    if snapshot.Version != snapshotVersion {
+        return corpusSnapshot{}, fmt.Errorf("%s: unsupported snapshot version %d", name, snapshot.Version)
+    }
+    return snapshot, nil
+}
+
+// snapshotDiff is what changed between two snapshots.
+type snapshotDiff struct {
+    Added   []snapshotEntry  `json:"added"`
+    Removed []snapshotEntry  `json:"removed"`
+    Changed []snapshotChange `json:"changed"`
+}
+
+type snapshotChange struct {
+    Before snapshotEntry `json:"before"`
+    After  snapshotEntry `json:"after"`
+}
+
+func (d snapshotDiff) empty() bool {
+    return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffSnapshots compares a and b, matching jokes by their text hash. Each
+// list is in the id order of the snapshot its entries come from.
+func diffSnapshots(a, b corpusSnapshot) snapshotDiff {
+    diff := snapshotDiff{Added: []snapshotEntry{}, Removed: []snapshotEntry{}, Changed: []snapshotChange{}}
+    before := map[string]snapshotEntry{}
+    for _, entry := range a.Jokes {
+        before[entry.TextHash] = entry
+    }
+    after := map[string]bool{}
+    for _, entry := range b.Jokes {
+        after[entry.TextHash] = true
+        old, ok := before[entry.TextHash]
+        switch {
+        case !ok:
+            diff.Added = append(diff.Added, entry)
+        case old.Hash != entry.Hash:
+            diff.Changed = append(diff.Changed, snapshotChange{Before: old, After: entry})
+        }
+    }
+    for _, entry := range a.Jokes {
+        if !after[entry.TextHash] {
+            diff.Removed = append(diff.Removed, entry)
+        }
+    }
+    sort.SliceStable(diff.Changed, func(i, j int) bool { return diff.Changed[i].After.Id < diff.Changed[j].After.Id })
+    return diff
+}
+
+// writeDiff writes diff for people: a line per joke, marked + for added,
+// - for removed and ~ for changed, and a summary.
+func writeDiff(w io.Writer, diff snapshotDiff) {
+    for _, entry := range diff.Removed {
+        fmt.Fprintf(w, "- %d %q by %s\n", entry.Id, entry.Text, authorOrAnonymous(entry.Author))
+    }
+    for _, entry := range diff.Added {
+        fmt.Fprintf(w, "+ %d %q by %s\n", entry.Id, entry.Text, authorOrAnonymous(entry.Author))
+    }
+    for _, change := range diff.Changed {
+        before, after := change.Before, change.After
+        fmt.Fprintf(w, "~ %d %q\n", after.Id, after.Text)
+        if before.Id != after.Id {
+            fmt.Fprintf(w, "    id: %d -> %d\n", before.Id, after.Id)
+        }
+        if before.Author != after.Author {
+            fmt.Fprintf(w, "    author: %s -> %s\n", authorOrAnonymous(before.Author), authorOrAnonymous(after.Author))
+        }
+        if !before.Date.Equal(after.Date) {
+            fmt.Fprintf(w, "    entry_date: %s -> %s\n", before.Date.Format(time.RFC3339), after.Date.Format(time.RFC3339))
+        }
+        if before.Text != after.Text {
+            fmt.Fprintf(w, "    joke_text: %q -> %q\n", before.Text, after.Text)
+        }
+    }
+    fmt.Fprintf(w, "%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+func authorOrAnonymous(author string) string {
+    if author == "" {
+        return "Anonymous"
+    }
+    return author
+}
+
+// runDiff implements `dadjokes diff A B`, writing the differences to w as
+// text or, with asJSON, as a snapshotDiff. It reports whether there were
+// any.
+func runDiff(w io.Writer, a, b string, asJSON bool) (bool, error) {
+    before, err := readSnapshot(a)
+    if err != nil {
+        return false, err
+    }
+    after, err := readSnapshot(b)
+    if err != nil {
+        return false, err
+    }
+
+    diff := diffSnapshots(before, after)
+    if asJSON {
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "    ")
+        return !diff.empty(), encoder.Encode(diff)
+    }
+    writeDiff(w, diff)
+    return !diff.empty(), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "strings"
+)
+
+func (r *sqlRepository) RecordAudit(ctx context.Context, entry AuditEntry) error {
+    _, err := r.insert(ctx, r.db, "INSERT INTO audit_log (actor, action, target, before_snapshot, after_snapshot, created_at) VALUES (?, ?, ?, ?, ?, ?)", entry.Actor, entry.Action, entry.Target, nullJSON(entry.Before), nullJSON(entry.After), entry.CreatedAt.UTC())
+    return err
+}
+
+// nullJSON stores an empty snapshot as NULL.
+func nullJSON(data json.RawMessage) sql.NullString {
+    return sql.NullString{String: string(data), Valid: len(data) > 0}
+}
+
+func (r *sqlRepository) AuditLog(ctx context.Context, filter auditFilter) ([]AuditEntry, error) {
+    var conditions []string
+    var args []any
+    if filter.Actor != "" {
+        conditions, args = append(conditions, "actor = ?"), append(args, filter.Actor)
+    }
+    if !filter.From.IsZero() {
+        conditions, args = append(conditions, "created_at >= ?"), append(args, filter.From.UTC())
+    }
+    if !filter.To.IsZero() {
+        conditions, args = append(conditions, "created_at <= ?"), append(args, filter.To.UTC())
+    }
+    if filter.BeforeID > 0 {
+        conditions, args = append(conditions, "id < ?"), append(args, filter.BeforeID)
+    }
+    query := "SELECT id, actor, action, target, before_snapshot, after_snapshot, created_at FROM audit_log"
+    if len(conditions) > 0 {
+        query += " WHERE " + strings.Join(conditions, " AND ")
+    }
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query+" ORDER BY id DESC LIMIT ?"), append(args, filter.Limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := []AuditEntry{}
+    for rows.Next() {
+        var entry AuditEntry
+        var before, after sql.NullString
+        if err := rows.Scan(&entry.Id, &entry.Actor, &entry.Action, &entry.Target, &before, &after, &entry.CreatedAt); err != nil {
+            return nil, err
+        }
+        if before.Valid {
+            entry.Before = json.RawMessage(before.String)
+        }
+        if after.Valid {
+            entry.After = json.RawMessage(after.String)
+        }
+        entry.CreatedAt = entry.CreatedAt.UTC()
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
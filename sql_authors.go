@@ -0,0 +1,259 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// findAuthor returns the author registered under the alias of name.
+func (r *sqlRepository) findAuthor(ctx context.Context, q querier, name string) (Author, error) {
+    return r.queryAuthor(ctx, q, name, "")
+}
+
+// queryAuthor is findAuthor with suffix appended to the query, such as the
+// dialect's locking read.
+func (r *sqlRepository) queryAuthor(ctx context.Context, q querier, name, suffix string) (Author, error) {
+    var author Author
+    err := q.QueryRowContext(ctx, r.dialect.rebind("SELECT a.id, a.name FROM authors a JOIN author_aliases aa ON aa.author_id = a.id WHERE aa.alias = ?"+suffix), normalizeAuthor(name)).Scan(&author.Id, &author.Name)
+    if errors.Is(err, sql.ErrNoRows) {
+        return author, errAuthorNotFound
+    }
+    return author, err
+}
+
+// resolveAuthor returns the author known as name, registering name as a new
+// author if nobody is.
+func (r *sqlRepository) resolveAuthor(ctx context.Context, q querier, name string) (Author, error) {
+    author, err := r.findAuthor(ctx, q, name)
+    if !errors.Is(err, errAuthorNotFound) {
+        return author, err
+    }
+
+    author.Name = name
+    author.Id, err = r.insert(ctx, q, "INSERT INTO authors (name) VALUES (?)", name)
+    if err != nil {
+        return author, err
+    }
+
+    // q may be the transaction of the joke being created, so the alias is
+    // inserted in a way that doesn't fail when it's taken.
+    result, err := q.ExecContext(ctx, r.dialect.rebind(r.dialect.ignoreDuplicates("INSERT INTO author_aliases (alias, author_id) VALUES (?, ?)")), normalizeAuthor(name), author.Id)
+    if err != nil {
+        return author, err
+    }
+    if n, err := result.RowsAffected(); err != nil || n > 0 {
+        return author, err
+    }
+
+    // Somebody else registered the same name concurrently; use theirs.
+    _, err = q.ExecContext(ctx, r.dialect.rebind("DELETE FROM authors WHERE id = ?"), author.Id)
+    if err != nil {
+        return author, err
+    }
+    return r.queryAuthor(ctx, q, name, r.dialect.lockingRead)
+}
+
+func (r *sqlRepository) aliases(ctx context.Context, q querier, authorID int) ([]string, error) {
+    rows, err := q.QueryContext(ctx, r.dialect.rebind("SELECT alias FROM author_aliases WHERE author_id = ? ORDER BY alias"), authorID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    aliases := []string{}
+    for rows.Next() {
+        var alias string
+        if err := rows.Scan(&alias); err != nil {
+            return nil, err
+        }
+        aliases = append(aliases, alias)
+    }
+    return aliases, rows.Err()
+}
+
+func (r *sqlRepository) MergeAuthors(ctx context.Context, into string, aliases []string) (Author, error) {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return Author{}, err
+    }
+    defer tx.Rollback()
+
+    target, err := r.resolveAuthor(ctx, tx, into)
+    if err != nil {
+        return target, err
+    }
+
+    for _, alias := range aliases {
+        key := normalizeAuthor(alias)
+        if key == "" {
+            continue
+        }
+
+        source, err := r.findAuthor(ctx, tx, alias)
+        switch {
+        case errors.Is(err, errAuthorNotFound):
+            _, err = tx.ExecContext(ctx, r.dialect.rebind("INSERT INTO author_aliases (alias, author_id) VALUES (?, ?)"), key, target.Id)
+            if err != nil {
+                return target, err
+            }
+            err = r.renameAuthor(ctx, tx, alias, target.Name)
+        case err != nil:
+            return target, err
+        case source.Id != target.Id:
+            err = r.mergeAuthor(ctx, tx, source, target)
+        }
+        if err != nil {
+            return target, err
+        }
+    }
+
+    target.Aliases, err = r.aliases(ctx, tx, target.Id)
+    if err != nil {
+        return target, err
+    }
+    return target, tx.Commit()
+}
+
+// mergeAuthor moves the jokes and aliases of source over to target and
+// removes source.
+func (r *sqlRepository) mergeAuthor(ctx context.Context, tx *sql.Tx, source, target Author) error {
+    if err := r.renameAuthor(ctx, tx, source.Name, target.Name); err != nil {
+        return err
+    }
+
+    statements := []struct {
+        query string
+        args  []any
+    }{
+        {"UPDATE author_aliases SET author_id = ? WHERE author_id = ?", []any{target.Id, source.Id}},
+        {"DELETE FROM authors WHERE id = ?", []any{source.Id}},
+    }
+    for _, statement := range statements {
+        if _, err := tx.ExecContext(ctx, r.dialect.rebind(statement.query), statement.args...); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *sqlRepository) BackfillAuthors(ctx context.Context) error {
+    // Most frequent spellings first, so they become the canonical name.
+    rows, err := r.db.QueryContext(ctx, "SELECT author, COUNT(*) FROM jokes WHERE author IS NOT NULL AND author <> '' GROUP BY author ORDER BY COUNT(*) DESC")
+    if err != nil {
+        return err
+    }
+
+    var names []string
+    for rows.Next() {
+        var name string
+        var count int
+        if err := rows.Scan(&name, &count); err != nil {
+            rows.Close()
+            return err
+        }
+        names = append(names, name)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, name := range names {
+        if normalizeAuthor(name) == "" {
+            continue
+        }
+
+        author, err := r.resolveAuthor(ctx, r.db, name)
+        if err != nil {
+            return err
+        }
+        if author.Name != name {
+            if err := r.renameAuthor(ctx, r.db, name, author.Name); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// renameAuthor moves the jokes credited to from over to to, recording each
+// of them as updated.
+func (r *sqlRepository) renameAuthor(ctx context.Context, q querier, from, to string) error {
+    _, err := q.ExecContext(ctx, r.dialect.rebind("INSERT INTO joke_changes (joke_id, op, changed_at) SELECT id, ?, ? FROM jokes WHERE author = ? ORDER BY id"),
+        changeUpdate, time.Now().UTC().Truncate(time.Second), from)
+    if err != nil {
+        return err
+    }
+
+    _, err = q.ExecContext(ctx, r.dialect.rebind("UPDATE jokes SET author = ? WHERE author = ?"), to, from)
+    return err
+}
+
+func (r *sqlRepository) ListAuthors(ctx context.Context, offset, limit int) ([]AuthorSummary, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT author, COUNT(*) FROM jokes WHERE author IS NOT NULL AND author <> '' AND "+visible+" GROUP BY author ORDER BY COUNT(*) DESC, author LIMIT ? OFFSET ?"), limit, offset)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    list := []AuthorSummary{}
+    for rows.Next() {
+        var summary AuthorSummary
+        if err := rows.Scan(&summary.Name, &summary.Jokes); err != nil {
+            return nil, err
+        }
+        list = append(list, summary)
+    }
+    return list, rows.Err()
+}
+
+func (r *sqlRepository) AuthorJokes(ctx context.Context, name string, offset, limit int) (Author, []Joke, error) {
+    author, err := r.findAuthor(ctx, r.db, name)
+    if err != nil {
+        return author, nil, err
+    }
+
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE author = ? AND "+visible+" ORDER BY id DESC LIMIT ? OFFSET ?"), author.Name, limit, offset)
+    if err != nil {
+        return author, nil, err
+    }
+    defer rows.Close()
+
+    jokes := []Joke{}
+    for rows.Next() {
+        joke, err := scanJoke(rows)
+        if err != nil {
+            return author, nil, err
+        }
+        jokes = append(jokes, joke)
+    }
+    return author, jokes, rows.Err()
+}
+
+// AuthorStats reads the first and last jokes rather than MIN and MAX of their
+// dates, which some drivers return as strings.
+func (r *sqlRepository) AuthorStats(ctx context.Context, name string) (AuthorStats, error) {
+    author, err := r.findAuthor(ctx, r.db, name)
+    if err != nil {
+        return AuthorStats{}, err
+    }
+
+    stats := AuthorStats{Name: author.Name}
+    err = r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT COUNT(*) FROM jokes WHERE author = ? AND "+visible), author.Name).Scan(&stats.TotalJokes)
+    if err != nil || stats.TotalJokes == 0 {
+        return stats, err
+    }
+
+    first, err := scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE author = ? AND "+visible+" ORDER BY entry_date, id LIMIT 1"), author.Name))
+    if err != nil {
+        return stats, err
+    }
+    last, err := scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE author = ? AND "+visible+" ORDER BY entry_date DESC, id DESC LIMIT 1"), author.Name))
+    if err != nil {
+        return stats, err
+    }
+    stats.FirstSubmission, stats.LastSubmission = &first.Date, &last.Date
+    return stats, nil
+}
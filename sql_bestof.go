@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "time"
+)
+
+func (r *sqlRepository) TopShared(ctx context.Context, from, to time.Time, limit int) ([]bestOfEntry, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT s.joke_id, COUNT(*) FROM joke_shares s JOIN jokes j ON j.id = s.joke_id AND j.hidden_at IS NULL AND j.deleted_at IS NULL WHERE s.shared_at >= ? AND s.shared_at < ? GROUP BY s.joke_id ORDER BY COUNT(*) DESC, s.joke_id LIMIT ?"), from.UTC(), to.UTC(), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    top := []bestOfEntry{}
+    for rows.Next() {
+        var entry bestOfEntry
+        if err := rows.Scan(&entry.Joke.Id, &entry.Shares); err != nil {
+            return nil, err
+        }
+        top = append(top, entry)
+    }
+    return top, rows.Err()
+}
+
+func (r *sqlRepository) SaveBestOf(ctx context.Context, best BestOf) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var n int
+    if err := tx.QueryRowContext(ctx, r.dialect.rebind("SELECT COUNT(*) FROM best_of WHERE week = ?"), best.Week).Scan(&n); err != nil {
+        return err
+    }
+    if n > 0 {
+        return errBestOfExists
+    }
+
+    insert := r.dialect.rebind("INSERT INTO best_of (week, position, joke_id, shares, compiled_at) VALUES (?, ?, ?, ?, ?)")
+    for i, entry := range best.Jokes {
+        if _, err := tx.ExecContext(ctx, insert, best.Week, i+1, entry.Joke.Id, entry.Shares, best.CompiledAt.UTC()); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) BestOf(ctx context.Context, week string) (BestOf, error) {
+    best := BestOf{Week: week, Jokes: []bestOfEntry{}}
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT b.compiled_at, b.shares, j.id, j.entry_date, j.author, j.joke_text, j.language, j.hidden_at, j.deleted_at FROM best_of b LEFT JOIN jokes j ON j.id = b.joke_id WHERE b.week = ? ORDER BY b.position"), week)
+    if err != nil {
+        return best, err
+    }
+    defer rows.Close()
+
+    found := false
+    for rows.Next() {
+        var entry bestOfEntry
+        var id sql.NullInt64
+        var date, hiddenAt, deletedAt sql.NullTime
+        var author, text, lang sql.NullString
+        if err := rows.Scan(&best.CompiledAt, &entry.Shares, &id, &date, &author, &text, &lang, &hiddenAt, &deletedAt); err != nil {
+            return best, err
+        }
+        found = true
+        if !id.Valid || hiddenAt.Valid || deletedAt.Valid {
+            continue
+        }
+        entry.Joke = Joke{Id: int(id.Int64), Date: date.Time.UTC().Truncate(time.Second), Author: author.String, Text: text.String, Language: lang.String}
+        best.Jokes = append(best.Jokes, entry)
+    }
+    if err := rows.Err(); err != nil {
+        return best, err
+    }
+    if !found {
+        return best, errBestOfNotFound
+    }
+    best.CompiledAt = best.CompiledAt.UTC()
+    return best, nil
+}
+
+func (r *sqlRepository) BestOfWeeks(ctx context.Context, limit int) ([]string, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT DISTINCT week FROM best_of ORDER BY week DESC LIMIT ?"), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    weeks := []string{}
+    for rows.Next() {
+        var week string
+        if err := rows.Scan(&week); err != nil {
+            return nil, err
+        }
+        weeks = append(weeks, week)
+    }
+    return weeks, rows.Err()
+}
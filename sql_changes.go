@@ -0,0 +1,40 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "time"
+)
+
+// recordChange appends a change to jokeID to the change feed.
+func (r *sqlRepository) recordChange(ctx context.Context, q querier, jokeID int, op string) error {
+    _, err := q.ExecContext(ctx, r.dialect.rebind("INSERT INTO joke_changes (joke_id, op, changed_at) VALUES (?, ?, ?)"), jokeID, op, time.Now().UTC().Truncate(time.Second))
+    return err
+}
+
+func (r *sqlRepository) Changes(ctx context.Context, since, limit int) ([]JokeChange, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind(`SELECT c.id, c.op, c.joke_id, c.changed_at, j.id, j.entry_date, j.author, j.joke_text, j.language
+        FROM joke_changes c LEFT JOIN jokes j ON j.id = c.joke_id AND j.hidden_at IS NULL AND j.deleted_at IS NULL
+        WHERE c.id > ? ORDER BY c.id LIMIT ?`), since, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    list := []JokeChange{}
+    for rows.Next() {
+        var change JokeChange
+        var id sql.NullInt64
+        var date sql.NullTime
+        var author, text, lang sql.NullString
+        if err := rows.Scan(&change.Cursor, &change.Op, &change.JokeID, &change.ChangedAt, &id, &date, &author, &text, &lang); err != nil {
+            return nil, err
+        }
+        change.ChangedAt = change.ChangedAt.UTC()
+        if id.Valid {
+            change.Joke = &Joke{Id: int(id.Int64), Date: date.Time.UTC().Truncate(time.Second), Author: author.String, Text: text.String, Language: lang.String}
+        }
+        list = append(list, change)
+    }
+    return list, rows.Err()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+)
+
+func (r *sqlRepository) SourceJokes(ctx context.Context, source string) (map[string]sourceJoke, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT id, external_id, deleted_at FROM jokes WHERE source = ?"), source)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    jokes := map[string]sourceJoke{}
+    for rows.Next() {
+        var (
+            joke       sourceJoke
+            externalID sql.NullString
+            deletedAt  sql.NullTime
+        )
+        if err := rows.Scan(&joke.Id, &externalID, &deletedAt); err != nil {
+            return nil, err
+        }
+        joke.Deleted = deletedAt.Valid
+        jokes[externalID.String] = joke
+    }
+    return jokes, rows.Err()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+)
+
+func (r *sqlRepository) FederatedJokes(ctx context.Context, self string, since, limit int) ([]FederatedJoke, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT id, external_id, source, author, joke_text, language FROM jokes WHERE id > ? AND "+visible+" ORDER BY id LIMIT ?"), since, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    jokes := []FederatedJoke{}
+    for rows.Next() {
+        var joke FederatedJoke
+        var externalID, source, author sql.NullString
+        if err := rows.Scan(&joke.Cursor, &externalID, &source, &author, &joke.Text, &joke.Language); err != nil {
+            return nil, err
+        }
+
+        joke.Author = author.String
+        joke.ExternalID, joke.Source = externalID.String, source.String
+        if !externalID.Valid {
+            joke.ExternalID = fmt.Sprintf("%s/jokes/%d", self, joke.Cursor)
+            joke.Source = self
+        }
+        jokes = append(jokes, joke)
+    }
+    return jokes, rows.Err()
+}
+
+func (r *sqlRepository) ImportFederated(ctx context.Context, joke FederatedJoke) (bool, error) {
+    var exists int
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT 1 FROM jokes WHERE external_id = ?"), joke.ExternalID).Scan(&exists)
+    if err == nil {
+        return false, nil
+    }
+    if !errors.Is(err, sql.ErrNoRows) {
+        return false, err
+    }
+
+    // The same joke submitted independently on two instances is a conflict;
+    // the copy already here wins.
+    hash := jokeTextHash(joke.Text)
+    err = r.checkDuplicate(ctx, r.db, hash)
+    var duplicate *duplicateJokeError
+    if errors.As(err, &duplicate) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if joke.Author != "" {
+        author, err := r.resolveAuthor(ctx, r.db, joke.Author)
+        if err != nil {
+            return false, err
+        }
+        joke.Author = author.Name
+    }
+
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    id, err := r.insert(ctx, tx, "INSERT INTO jokes (author, joke_text, text_hash, external_id, source, language) VALUES (?, ?, ?, ?, ?, ?)", joke.Author, joke.Text, hash, joke.ExternalID, joke.Source, joke.Language)
+    if err != nil {
+        return false, err
+    }
+    if err := r.recordChange(ctx, tx, id, changeCreate); err != nil {
+        return false, err
+    }
+    return true, tx.Commit()
+}
+
+func (r *sqlRepository) PeerCursor(ctx context.Context, peer string) (int, error) {
+    var cursor int
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT last_cursor FROM federation_peers WHERE peer_url = ?"), peer).Scan(&cursor)
+    if errors.Is(err, sql.ErrNoRows) {
+        return 0, nil
+    }
+    return cursor, err
+}
+
+func (r *sqlRepository) SavePeerCursor(ctx context.Context, peer string, cursor int) error {
+    query := "UPDATE federation_peers SET last_cursor = ?, last_synced_at = CURRENT_TIMESTAMP WHERE peer_url = ?"
+
+    var exists int
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT 1 FROM federation_peers WHERE peer_url = ?"), peer).Scan(&exists)
+    if errors.Is(err, sql.ErrNoRows) {
+        query = "INSERT INTO federation_peers (last_cursor, last_synced_at, peer_url) VALUES (?, CURRENT_TIMESTAMP, ?)"
+    } else if err != nil {
+        return err
+    }
+
+    _, err = r.db.ExecContext(ctx, r.dialect.rebind(query), cursor, peer)
+    return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+func (r *sqlRepository) DailyJoke(ctx context.Context, day string) (Joke, error) {
+    row := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE id = (SELECT joke_id FROM joke_of_the_day WHERE day = ?) AND "+visible), day)
+    joke, err := scanJoke(row)
+    if errors.Is(err, sql.ErrNoRows) {
+        return joke, errJokeNotFound
+    }
+    return joke, err
+}
+
+func (r *sqlRepository) PickDailyJoke(ctx context.Context, day string, seed uint64) (Joke, error) {
+    joke, err := r.DailyJoke(ctx, day)
+    if !errors.Is(err, errJokeNotFound) {
+        return joke, err
+    }
+    // A pick that has since been hidden or deleted is picked again.
+    _, err = r.db.ExecContext(ctx, r.dialect.rebind("DELETE FROM joke_of_the_day WHERE day = ? AND joke_id NOT IN (SELECT id FROM jokes WHERE "+visible+")"), day)
+    if err != nil {
+        return joke, err
+    }
+
+    // A joke scheduled for the day wins, unless it's gone or hidden.
+    joke, err = scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE id = (SELECT joke_id FROM joke_schedule WHERE day = ?) AND "+visible), day))
+    if err == nil {
+        return r.recordDailyJoke(ctx, day, joke)
+    }
+    if !errors.Is(err, sql.ErrNoRows) {
+        return joke, err
+    }
+
+    // Only jokes from before the day are candidates, so jokes submitted
+    // during the day don't change the pick. A new collection with nothing
+    // that old picks from everything.
+    start, err := time.Parse(dayLayout, day)
+    if err != nil {
+        return joke, err
+    }
+    candidates := " FROM jokes WHERE entry_date < ? AND " + visible
+    args := []any{start}
+
+    var count int
+    err = r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT COUNT(*)"+candidates), args...).Scan(&count)
+    if err != nil {
+        return joke, err
+    }
+    if count == 0 {
+        candidates, args = " FROM jokes WHERE "+visible, nil
+        err = r.db.QueryRowContext(ctx, "SELECT COUNT(*)"+candidates).Scan(&count)
+        if err != nil {
+            return joke, err
+        }
+    }
+    if count == 0 {
+        return joke, errJokeNotFound
+    }
+
+    args = append(args, int(seed%uint64(count)))
+    joke, err = scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+candidates+" ORDER BY id LIMIT 1 OFFSET ?"), args...))
+    if err != nil {
+        return joke, err
+    }
+    return r.recordDailyJoke(ctx, day, joke)
+}
+
+// recordDailyJoke records joke as the pick for day and returns it, or the
+// joke another instance recorded first.
+func (r *sqlRepository) recordDailyJoke(ctx context.Context, day string, joke Joke) (Joke, error) {
+    _, err := r.db.ExecContext(ctx, r.dialect.rebind("INSERT INTO joke_of_the_day (day, joke_id) VALUES (?, ?)"), day, joke.Id)
+    if err != nil {
+        // Another instance recorded its pick first; use that one.
+        if recorded, getErr := r.DailyJoke(ctx, day); getErr == nil {
+            return recorded, nil
+        }
+        return joke, err
+    }
+    return joke, nil
+}
+
+func (r *sqlRepository) ScheduledJokes(ctx context.Context, day string) ([]ScheduledJoke, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT s.day, s.joke_id, j.id FROM joke_schedule s LEFT JOIN jokes j ON j.id = s.joke_id AND j.hidden_at IS NULL AND j.deleted_at IS NULL WHERE s.day >= ? ORDER BY s.day"), day)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    schedule := []ScheduledJoke{}
+    for rows.Next() {
+        var entry ScheduledJoke
+        var found sql.NullInt64
+        if err := rows.Scan(&entry.Date, &entry.JokeID, &found); err != nil {
+            return nil, err
+        }
+        entry.Missing = !found.Valid
+        schedule = append(schedule, entry)
+    }
+    return schedule, rows.Err()
+}
+
+func (r *sqlRepository) ScheduleJokes(ctx context.Context, entries []ScheduledJoke) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, entry := range entries {
+        _, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM joke_schedule WHERE day = ?"), entry.Date)
+        if err != nil {
+            return err
+        }
+        _, err = tx.ExecContext(ctx, r.dialect.rebind("INSERT INTO joke_schedule (day, joke_id) VALUES (?, ?)"), entry.Date, int(entry.JokeID))
+        if err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) UnscheduleJoke(ctx context.Context, day string) error {
+    result, err := r.db.ExecContext(ctx, r.dialect.rebind("DELETE FROM joke_schedule WHERE day = ?"), day)
+    if err != nil {
+        return err
+    }
+    n, err := result.RowsAffected()
+    if err == nil && n == 0 {
+        return errNotScheduled
+    }
+    return err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "slices"
+    "strings"
+    "time"
+)
+
+func (r *sqlRepository) FlagJoke(ctx context.Context, jokeID int, source, reason string) error {
+    _, err := r.insert(ctx, r.db, "INSERT INTO joke_flags (joke_id, source, reason, created_at) VALUES (?, ?, ?, ?)", jokeID, source, reason, time.Now().UTC().Truncate(time.Second))
+    return err
+}
+
+func (r *sqlRepository) OpenFlags(ctx context.Context) ([]JokeFlag, error) {
+    rows, err := r.db.QueryContext(ctx, "SELECT f.id, f.joke_id, f.source, f.reason, f.created_at, f.escalated_at, f.tag, j.id, j.entry_date, j.author, j.joke_text, j.language, j.hidden_at FROM joke_flags f LEFT JOIN jokes j ON j.id = f.joke_id WHERE f.resolved_at IS NULL ORDER BY f.id")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    flags := []JokeFlag{}
+    for rows.Next() {
+        var flag JokeFlag
+        var jokeID sql.NullInt64
+        var escalatedAt, date, hiddenAt sql.NullTime
+        var author, text, lang sql.NullString
+        if err := rows.Scan(&flag.Id, &flag.JokeID, &flag.Source, &flag.Reason, &flag.CreatedAt, &escalatedAt, &flag.Tag, &jokeID, &date, &author, &text, &lang, &hiddenAt); err != nil {
+            return nil, err
+        }
+        flag.CreatedAt = flag.CreatedAt.UTC()
+        flag.EscalatedAt = utcTime(escalatedAt)
+        flag.JokeHidden = hiddenAt.Valid
+        if jokeID.Valid {
+            flag.Joke = &Joke{Id: int(jokeID.Int64), Date: date.Time.UTC().Truncate(time.Second), Author: author.String, Text: text.String, Language: lang.String}
+        }
+        flags = append(flags, flag)
+    }
+    return flags, rows.Err()
+}
+
+func (r *sqlRepository) CountOpenFlags(ctx context.Context) (int, error) {
+    var n int
+    err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM joke_flags WHERE resolved_at IS NULL").Scan(&n)
+    return n, err
+}
+
+func (r *sqlRepository) FlagTimes(ctx context.Context, since time.Time) ([]JokeFlag, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT id, created_at, resolved_at, escalated_at FROM joke_flags WHERE resolved_at IS NULL OR resolved_at >= ? ORDER BY id"), since.UTC())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    flags := []JokeFlag{}
+    for rows.Next() {
+        var flag JokeFlag
+        var resolvedAt, escalatedAt sql.NullTime
+        if err := rows.Scan(&flag.Id, &flag.CreatedAt, &resolvedAt, &escalatedAt); err != nil {
+            return nil, err
+        }
+        flag.CreatedAt = flag.CreatedAt.UTC()
+        flag.ResolvedAt, flag.EscalatedAt = utcTime(resolvedAt), utcTime(escalatedAt)
+        flags = append(flags, flag)
+    }
+    return flags, rows.Err()
+}
+
+func (r *sqlRepository) EscalateFlags(ctx context.Context, before time.Time) (int, error) {
+    result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE joke_flags SET escalated_at = ? WHERE resolved_at IS NULL AND escalated_at IS NULL AND created_at < ?"), time.Now().UTC().Truncate(time.Second), before.UTC())
+    if err != nil {
+        return 0, err
+    }
+    n, err := result.RowsAffected()
+    return int(n), err
+}
+
+// utcTime converts a nullable column to the *time.Time of a JSON field.
+func utcTime(t sql.NullTime) *time.Time {
+    if !t.Valid {
+        return nil
+    }
+    utc := t.Time.UTC()
+    return &utc
+}
+
+func (r *sqlRepository) ResolveFlag(ctx context.Context, id int) error {
+    result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE joke_flags SET resolved_at = ? WHERE id = ? AND resolved_at IS NULL"), time.Now().UTC().Truncate(time.Second), id)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errFlagNotFound
+    }
+    return nil
+}
+
+// ReportJoke stores the report and counts the open reports in a transaction,
+// so concurrent reports can't both miss the threshold.
+func (r *sqlRepository) ReportJoke(ctx context.Context, jokeID int, reporter, reason string) (int, error) {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    var exists int
+    err = tx.QueryRowContext(ctx, r.dialect.rebind("SELECT 1 FROM joke_flags WHERE joke_id = ? AND source = ? AND reporter = ? AND resolved_at IS NULL"), jokeID, reportSource, reporter).Scan(&exists)
+    if errors.Is(err, sql.ErrNoRows) {
+        _, err = r.insert(ctx, tx, "INSERT INTO joke_flags (joke_id, source, reason, reporter, created_at) VALUES (?, ?, ?, ?, ?)", jokeID, reportSource, reason, reporter, time.Now().UTC().Truncate(time.Second))
+    }
+    if err != nil {
+        return 0, err
+    }
+
+    var reports int
+    err = tx.QueryRowContext(ctx, r.dialect.rebind("SELECT COUNT(*) FROM joke_flags WHERE joke_id = ? AND source = ? AND resolved_at IS NULL"), jokeID, reportSource).Scan(&reports)
+    if err != nil {
+        return 0, err
+    }
+    return reports, tx.Commit()
+}
+
+func (r *sqlRepository) HideJoke(ctx context.Context, jokeID int) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := r.setHidden(ctx, tx, jokeID, true); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) ResolveReports(ctx context.Context, jokeID int, hide bool) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, r.dialect.rebind("UPDATE joke_flags SET resolved_at = ? WHERE joke_id = ? AND source = ? AND resolved_at IS NULL"), time.Now().UTC().Truncate(time.Second), jokeID, reportSource)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errFlagNotFound
+    }
+
+    if err := r.setHidden(ctx, tx, jokeID, hide); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) ModerateFlags(ctx context.Context, ids []int, action, tag string) ([]int, []int, error) {
+    if len(ids) == 0 {
+        return nil, nil, nil
+    }
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer tx.Rollback()
+
+    in := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+    args := make([]any, len(ids))
+    for i, id := range ids {
+        args[i] = id
+    }
+    rows, err := tx.QueryContext(ctx, r.dialect.rebind("SELECT id, joke_id FROM joke_flags WHERE resolved_at IS NULL AND id IN ("+in+") ORDER BY id"), args...)
+    if err != nil {
+        return nil, nil, err
+    }
+    var flags, jokes []int
+    for rows.Next() {
+        var id, jokeID int
+        if err := rows.Scan(&id, &jokeID); err != nil {
+            rows.Close()
+            return nil, nil, err
+        }
+        flags = append(flags, id)
+        if !slices.Contains(jokes, jokeID) {
+            jokes = append(jokes, jokeID)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil || len(flags) == 0 {
+        return nil, nil, err
+    }
+
+    in = strings.TrimSuffix(strings.Repeat("?, ", len(flags)), ", ")
+    args = make([]any, 0, len(flags)+1)
+    if action == moderateTag {
+        args = append(args, tag)
+        for _, id := range flags {
+            args = append(args, id)
+        }
+        if _, err := tx.ExecContext(ctx, r.dialect.rebind("UPDATE joke_flags SET tag = ? WHERE id IN ("+in+")"), args...); err != nil {
+            return nil, nil, err
+        }
+        return flags, nil, tx.Commit()
+    }
+
+    args = append(args, time.Now().UTC().Truncate(time.Second))
+    for _, id := range flags {
+        args = append(args, id)
+    }
+    if _, err := tx.ExecContext(ctx, r.dialect.rebind("UPDATE joke_flags SET resolved_at = ? WHERE id IN ("+in+")"), args...); err != nil {
+        return nil, nil, err
+    }
+    for _, jokeID := range jokes {
+        if err := r.setHidden(ctx, tx, jokeID, action == moderateReject); err != nil {
+            return nil, nil, err
+        }
+    }
+    return flags, jokes, tx.Commit()
+}
+
+// setHidden hides or shows a joke, recording the change if there was one.
+func (r *sqlRepository) setHidden(ctx context.Context, q querier, jokeID int, hide bool) error {
+    query, args, op := "UPDATE jokes SET hidden_at = NULL WHERE id = ? AND hidden_at IS NOT NULL", []any{jokeID}, changeUnhide
+    if hide {
+        query, args, op = "UPDATE jokes SET hidden_at = ? WHERE id = ? AND hidden_at IS NULL", []any{time.Now().UTC().Truncate(time.Second), jokeID}, changeHide
+    }
+
+    result, err := q.ExecContext(ctx, r.dialect.rebind(query), args...)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil || n == 0 {
+        return err
+    }
+    return r.recordChange(ctx, q, jokeID, op)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "math/rand/v2"
+    "os"
+    "time"
+
+    "github.com/XSAM/otelsql"
+    "go.opentelemetry.io/otel/attribute"
+)
+
+// sqlRepository implements storage on top of database/sql. Queries are
+// written once and adapted to each database through its dialect.
+type sqlRepository struct {
+    db      *sql.DB
+    dialect *dialect
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by helpers that run
+// either inside or outside a transaction.
+type querier interface {
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// jokeColumns are the columns scanJoke expects, in order.
+const jokeColumns = "id, entry_date, author, joke_text, language"
+
+// visible is the condition that leaves out jokes hidden by moderation or
+// deleted. Reads served to clients include it; duplicate checks don't, so a
+// hidden or deleted joke can't be submitted again.
+const visible = "hidden_at IS NULL AND deleted_at IS NULL"
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+    Scan(dest ...any) error
+}
+
+// scanJoke reads a row selected with jokeColumns. Dates are normalized to
+// UTC with second precision, whatever the database's session time zone.
+func scanJoke(row scanner) (Joke, error) {
+    var joke Joke
+    var author sql.NullString
+    err := row.Scan(&joke.Id, &joke.Date, &author, &joke.Text, &joke.Language)
+    joke.Author = author.String
+    joke.Date = joke.Date.UTC().Truncate(time.Second)
+    return joke, err
+}
+
+// newSQLRepository returns a repository for db. The schema is expected to be
+// up to date; see migrate.
+func newSQLRepository(db *sql.DB, d *dialect) *sqlRepository {
+    return &sqlRepository{db: db, dialect: d}
+}
+
+// openSQLRepository opens the database for driver at DB_CONN_STRING and
+// applies pending migrations.
+func openSQLRepository(ctx context.Context, driver string) (*sqlRepository, error) {
+    db, d, err := openSQLDB(ctx, driver)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := migrate(db, d); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("migrating: %w", err)
+    }
+    return newSQLRepository(db, d), nil
+}
+
+// openSQLDB opens the database in DB_CONN_STRING with driver and waits for
+// it to answer, without migrating it.
+func openSQLDB(ctx context.Context, driver string) (*sql.DB, *dialect, error) {
+    d, err := lookupDialect(driver)
+    if err != nil {
+        return nil, nil, err
+    }
+    pool, err := loadPoolConfig()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    // otelsql records a span for every query; they're dropped unless tracing
+    // is enabled.
+    dsn, err := d.dsn(os.Getenv("DB_CONN_STRING"))
+    if err != nil {
+        return nil, nil, err
+    }
+    db, err := otelsql.Open(d.name, dsn, otelsql.WithAttributes(attribute.String("db.system", d.name)))
+    if err != nil {
+        return nil, nil, err
+    }
+    pool.applySQL(db)
+    dbPoolStats = sqlPoolStats(db)
+
+    if err := pool.connect(ctx, db.PingContext); err != nil {
+        db.Close()
+        return nil, nil, fmt.Errorf("connecting: %w", err)
+    }
+    return db, d, nil
+}
+
+func (r *sqlRepository) schemaDrift(ctx context.Context) (schemaDrift, error) {
+    return checkSchema(ctx, r.db, r.dialect)
+}
+
+func (r *sqlRepository) Close() error {
+    return r.db.Close()
+}
+
+// insert runs an INSERT statement and returns the id of the new row.
+func (r *sqlRepository) insert(ctx context.Context, q querier, query string, args ...any) (int, error) {
+    query = r.dialect.rebind(query)
+    if r.dialect.returningID {
+        var id int
+        err := q.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+        return id, err
+    }
+
+    result, err := q.ExecContext(ctx, query, args...)
+    if err != nil {
+        return 0, err
+    }
+
+    id, err := result.LastInsertId()
+    return int(id), err
+}
+
+// Random picks a random id between the lowest and highest and returns the
+// first joke at or after it, wrapping around to the lowest when the pick lands
+// past the last row. Unlike ORDER BY RAND() this is two index lookups rather
+// than a scan and sort of the whole table. Jokes following a gap in the ids
+// are proportionally more likely to be picked, which is an acceptable
+// trade-off for the occasional deleted row.
+func (r *sqlRepository) Random(ctx context.Context, lang string) (Joke, error) {
+    where, args := inLanguage(visible, lang)
+    var minID, maxID sql.NullInt64
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT MIN(id), MAX(id) FROM jokes WHERE "+where), args...).Scan(&minID, &maxID)
+    if err != nil {
+        return Joke{}, err
+    }
+    if !maxID.Valid {
+        return Joke{}, errJokeNotFound
+    }
+
+    target := minID.Int64 + rand.Int64N(maxID.Int64-minID.Int64+1)
+    joke, err := scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE id >= ? AND "+where+" ORDER BY id LIMIT 1"), append([]any{target}, args...)...))
+    if errors.Is(err, sql.ErrNoRows) {
+        joke, err = scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE "+where+" ORDER BY id LIMIT 1"), args...))
+    }
+    if errors.Is(err, sql.ErrNoRows) {
+        return joke, errJokeNotFound
+    }
+    return joke, err
+}
+
+// inLanguage adds the condition that keeps only jokes in lang to where,
+// unless lang is empty, and returns the arguments it takes.
+func inLanguage(where, lang string) (string, []any) {
+    if lang == "" {
+        return where, nil
+    }
+    return where + " AND language = ?", []any{lang}
+}
+
+func (r *sqlRepository) Get(ctx context.Context, id int) (Joke, error) {
+    joke, err := scanJoke(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE id = ? AND "+visible), id))
+    if errors.Is(err, sql.ErrNoRows) {
+        return joke, errJokeNotFound
+    }
+    return joke, err
+}
+
+func (r *sqlRepository) List(ctx context.Context, offset, limit, maxID int, lang string) ([]Joke, error) {
+    where, args := inLanguage(visible, lang)
+    query := "SELECT " + jokeColumns + " FROM jokes WHERE " + where
+    if maxID > 0 {
+        query += " AND id <= ?"
+        args = append(args, maxID)
+    }
+    query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+    args = append(args, limit, offset)
+
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind(query), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    jokes := []Joke{}
+    for rows.Next() {
+        joke, err := scanJoke(rows)
+        if err != nil {
+            return nil, err
+        }
+        jokes = append(jokes, joke)
+    }
+    return jokes, rows.Err()
+}
+
+func (r *sqlRepository) ListAfter(ctx context.Context, afterID, limit int, lang string) ([]Joke, error) {
+    where, args := inLanguage(visible, lang)
+    args = append([]any{afterID}, append(args, limit)...)
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE id > ? AND "+where+" ORDER BY id LIMIT ?"), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    jokes := []Joke{}
+    for rows.Next() {
+        joke, err := scanJoke(rows)
+        if err != nil {
+            return nil, err
+        }
+        jokes = append(jokes, joke)
+    }
+    return jokes, rows.Err()
+}
+
+func (r *sqlRepository) Languages(ctx context.Context) ([]string, error) {
+    rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT language FROM jokes WHERE "+visible+" ORDER BY language")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    langs := []string{}
+    for rows.Next() {
+        var lang string
+        if err := rows.Scan(&lang); err != nil {
+            return nil, err
+        }
+        langs = append(langs, lang)
+    }
+    return langs, rows.Err()
+}
+
+func (r *sqlRepository) MaxID(ctx context.Context) (int, error) {
+    var id sql.NullInt64
+    err := r.db.QueryRowContext(ctx, "SELECT MAX(id) FROM jokes").Scan(&id)
+    return int(id.Int64), err
+}
+
+// Create stores joke under the canonical name of its author, registering the
+// author first if this is their first joke. A joke whose text matches an
+// existing one fails with a *duplicateJokeError carrying the existing joke.
+func (r *sqlRepository) Create(ctx context.Context, joke *Joke) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    err = r.create(ctx, tx, joke)
+    var duplicate *duplicateJokeError
+    if err != nil && !errors.As(err, &duplicate) {
+        // A concurrent submission of the same joke got in between the check
+        // and the insert, and the unique index on text_hash turned this one
+        // away. Report the winner. The failed insert may have aborted the
+        // transaction (Postgres), so look outside it.
+        tx.Rollback()
+        if dupErr := r.checkDuplicate(ctx, r.db, jokeTextHash(joke.Text)); dupErr != nil {
+            return dupErr
+        }
+    }
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// CreateBatch stores jokes in a single transaction. Duplicates are reported
+// per joke and don't stop the others from being created.
+func (r *sqlRepository) CreateBatch(ctx context.Context, jokes []Joke) ([]error, error) {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    errs := make([]error, len(jokes))
+    for i := range jokes {
+        err := r.create(ctx, tx, &jokes[i])
+        var duplicate *duplicateJokeError
+        if errors.As(err, &duplicate) {
+            errs[i] = err
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    return errs, tx.Commit()
+}
+
+func (r *sqlRepository) create(ctx context.Context, q querier, joke *Joke) error {
+    hash := jokeTextHash(joke.Text)
+    if err := r.checkDuplicate(ctx, q, hash); err != nil {
+        return err
+    }
+
+    if joke.Author != "" {
+        author, err := r.resolveAuthor(ctx, q, joke.Author)
+        if err != nil {
+            return err
+        }
+        joke.Author = author.Name
+    }
+
+    // The date is set here rather than left to the column default, so it's
+    // known without reading the row back.
+    joke.Date = time.Now().UTC().Truncate(time.Second)
+    if joke.OriginalDate != nil {
+        joke.Date = joke.OriginalDate.UTC().Truncate(time.Second)
+        joke.OriginalDate = nil
+    }
+
+    if joke.Language == "" {
+        joke.Language = defaultLanguage
+    }
+    id, err := r.insert(ctx, q, "INSERT INTO jokes (entry_date, author, joke_text, text_hash, language) VALUES (?, ?, ?, ?, ?)", joke.Date, joke.Author, joke.Text, hash, joke.Language)
+    if err != nil {
+        return err
+    }
+
+    joke.Id = id
+    return r.recordChange(ctx, q, id, changeCreate)
+}
+
+// checkDuplicate returns a *duplicateJokeError if a joke with the given text
+// hash exists. Hidden and deleted jokes still hold on to their text, but
+// aren't given away in the error.
+func (r *sqlRepository) checkDuplicate(ctx context.Context, q querier, hash string) error {
+    existing, err := scanJoke(q.QueryRowContext(ctx, r.dialect.rebind("SELECT "+jokeColumns+" FROM jokes WHERE text_hash = ? AND "+visible), hash))
+    if err == nil {
+        return &duplicateJokeError{Existing: existing}
+    }
+    if !errors.Is(err, sql.ErrNoRows) {
+        return err
+    }
+
+    var exists int
+    err = q.QueryRowContext(ctx, r.dialect.rebind("SELECT 1 FROM jokes WHERE text_hash = ?"), hash).Scan(&exists)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    return &duplicateJokeError{}
+}
+
+// BackfillTextHashes hashes jokes created before text_hash existed, oldest
+// first. Jokes that duplicate an already hashed one keep a NULL hash, since
+// the unique index won't take it; they're left for a moderator to clean up.
+// Going by id, the one that keeps the hash is the same on every run.
+func (r *sqlRepository) BackfillTextHashes(ctx context.Context) error {
+    rows, err := r.db.QueryContext(ctx, "SELECT id, joke_text FROM jokes WHERE text_hash IS NULL ORDER BY id")
+    if err != nil {
+        return err
+    }
+
+    type unhashed struct {
+        id   int
+        hash string
+    }
+    var jokes []unhashed
+    for rows.Next() {
+        var id int
+        var text string
+        if err := rows.Scan(&id, &text); err != nil {
+            rows.Close()
+            return err
+        }
+        jokes = append(jokes, unhashed{id, jokeTextHash(text)})
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, joke := range jokes {
+        var exists int
+        err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT 1 FROM jokes WHERE text_hash = ?"), joke.hash).Scan(&exists)
+        if err == nil {
+            continue
+        }
+        if !errors.Is(err, sql.ErrNoRows) {
+            return err
+        }
+        _, err = r.db.ExecContext(ctx, r.dialect.rebind("UPDATE jokes SET text_hash = ? WHERE id = ?"), joke.hash, joke.id)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "context"
+    "testing"
+)
+
+// benchmarkJokes is how many jokes the random selection benchmarks pick
+// from, enough for ORDER BY RANDOM() to have to sort a real table.
+const benchmarkJokes = 10000
+
+// BenchmarkRandom picks jokes the way Random does, by id range.
+func BenchmarkRandom(b *testing.B) {
+    r := openTestRepository(b)
+    createTestJokes(b, r, benchmarkJokes)
+    ctx := context.Background()
+
+    for b.Loop() {
+        if _, err := r.Random(ctx, ""); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkRandomOrderByRandom picks jokes the way Random used to, by
+// shuffling the whole table, for comparison.
+func BenchmarkRandomOrderByRandom(b *testing.B) {
+    r := openTestRepository(b)
+    createTestJokes(b, r, benchmarkJokes)
+    ctx := context.Background()
+
+    for b.Loop() {
+        _, err := scanJoke(r.db.QueryRowContext(ctx, "SELECT "+jokeColumns+" FROM jokes WHERE "+visible+" ORDER BY RANDOM() LIMIT 1"))
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+}
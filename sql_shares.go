@@ -0,0 +1,72 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+)
+
+func (r *sqlRepository) RecordShares(ctx context.Context, shares []Share) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    insert := r.dialect.rebind("INSERT INTO joke_shares (joke_id, referrer, utm_source, utm_medium, utm_campaign, shared_at) VALUES (?, ?, ?, ?, ?, ?)")
+    for _, s := range shares {
+        _, err := tx.ExecContext(ctx, insert, s.JokeId, s.Referrer, s.UTMSource, s.UTMMedium, s.UTMCampaign, s.SharedAt)
+        if err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) ShareStats(ctx context.Context, jokeID, limit int) (ShareStats, error) {
+    stats := ShareStats{JokeId: jokeRef(jokeID)}
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT COUNT(*) FROM joke_shares WHERE joke_id = ?"), jokeID).Scan(&stats.Total)
+    if err != nil {
+        return stats, err
+    }
+
+    var last sql.NullTime
+    err = r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT shared_at FROM joke_shares WHERE joke_id = ? ORDER BY id DESC LIMIT 1"), jokeID).Scan(&last)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return stats, err
+    }
+    stats.LastSharedAt = utcTime(last)
+
+    for column, counts := range map[string]*[]shareCount{
+        "referrer":     &stats.Referrers,
+        "utm_source":   &stats.Sources,
+        "utm_medium":   &stats.Mediums,
+        "utm_campaign": &stats.Campaigns,
+    } {
+        *counts, err = r.shareCounts(ctx, jokeID, column, limit)
+        if err != nil {
+            return stats, err
+        }
+    }
+    return stats, nil
+}
+
+// shareCounts returns the most common non-empty values of column among the
+// shares of a joke.
+func (r *sqlRepository) shareCounts(ctx context.Context, jokeID int, column string, limit int) ([]shareCount, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT "+column+", COUNT(*) FROM joke_shares WHERE joke_id = ? AND "+column+" <> '' GROUP BY "+column+" ORDER BY COUNT(*) DESC, "+column+" LIMIT ?"), jokeID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    counts := []shareCount{}
+    for rows.Next() {
+        var c shareCount
+        if err := rows.Scan(&c.Value, &c.Count); err != nil {
+            return nil, err
+        }
+        counts = append(counts, c)
+    }
+    return counts, rows.Err()
+}
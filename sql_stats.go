@@ -0,0 +1,30 @@
+package main
+
+import (
+    "context"
+    "time"
+)
+
+func (r *sqlRepository) CountJokes(ctx context.Context) (visible, hidden int, err error) {
+    var total int
+    err = r.db.QueryRowContext(ctx, "SELECT COUNT(*), COUNT(hidden_at) FROM jokes WHERE deleted_at IS NULL").Scan(&total, &hidden)
+    return total - hidden, hidden, err
+}
+
+func (r *sqlRepository) SubmissionDates(ctx context.Context, since time.Time) ([]time.Time, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT entry_date FROM jokes WHERE entry_date >= ?"), since)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var dates []time.Time
+    for rows.Next() {
+        var date time.Time
+        if err := rows.Scan(&date); err != nil {
+            return nil, err
+        }
+        dates = append(dates, date)
+    }
+    return dates, rows.Err()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+const subscriptionColumns = "id, email, frequency, token, created_at, confirmed_at, last_sent_at"
+
+// subscriptionDue matches confirmed subscriptions that haven't been sent
+// anything since the first argument, or the second for weekly ones.
+const subscriptionDue = `confirmed_at IS NOT NULL AND (last_sent_at IS NULL
+    OR (frequency = 'daily' AND last_sent_at < ?) OR (frequency = 'weekly' AND last_sent_at < ?))`
+
+func scanSubscription(row interface{ Scan(...any) error }) (Subscription, error) {
+    var s Subscription
+    var confirmedAt, lastSentAt sql.NullTime
+    err := row.Scan(&s.Id, &s.Email, &s.Frequency, &s.Token, &s.CreatedAt, &confirmedAt, &lastSentAt)
+    s.CreatedAt = s.CreatedAt.UTC()
+    s.ConfirmedAt, s.LastSentAt = utcTime(confirmedAt), utcTime(lastSentAt)
+    return s, err
+}
+
+// subscriptionBy returns the subscription whose column equals value.
+func (r *sqlRepository) subscriptionBy(ctx context.Context, q querier, column string, value any) (Subscription, error) {
+    s, err := scanSubscription(q.QueryRowContext(ctx, r.dialect.rebind("SELECT "+subscriptionColumns+" FROM subscriptions WHERE "+column+" = ?"), value))
+    if errors.Is(err, sql.ErrNoRows) {
+        return s, errSubscriptionNotFound
+    }
+    return s, err
+}
+
+func (r *sqlRepository) Subscribe(ctx context.Context, email, frequency, token string) (Subscription, error) {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return Subscription{}, err
+    }
+    defer tx.Rollback()
+
+    now := time.Now().UTC().Truncate(time.Second)
+    s, err := r.subscriptionBy(ctx, tx, "email", email)
+    switch {
+    case errors.Is(err, errSubscriptionNotFound):
+        s = Subscription{Email: email, Frequency: frequency, Token: token, CreatedAt: now}
+        s.Id, err = r.insert(ctx, tx, "INSERT INTO subscriptions (email, frequency, token, created_at) VALUES (?, ?, ?, ?)", email, frequency, token, now)
+    case err == nil && s.ConfirmedAt == nil:
+        s.Frequency, s.CreatedAt = frequency, now
+        _, err = tx.ExecContext(ctx, r.dialect.rebind("UPDATE subscriptions SET frequency = ?, created_at = ? WHERE id = ?"), frequency, now, s.Id)
+    }
+    if err != nil {
+        return Subscription{}, err
+    }
+    return s, tx.Commit()
+}
+
+func (r *sqlRepository) ConfirmSubscription(ctx context.Context, token string, since time.Time) (Subscription, error) {
+    now := time.Now().UTC().Truncate(time.Second)
+    _, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE subscriptions SET confirmed_at = ? WHERE token = ? AND confirmed_at IS NULL AND created_at >= ?"), now, token, since)
+    if err != nil {
+        return Subscription{}, err
+    }
+
+    s, err := r.subscriptionBy(ctx, r.db, "token", token)
+    if err == nil && s.ConfirmedAt == nil {
+        return s, errSubscriptionNotFound
+    }
+    return s, err
+}
+
+func (r *sqlRepository) Unsubscribe(ctx context.Context, token string) (Subscription, error) {
+    s, err := r.subscriptionBy(ctx, r.db, "token", token)
+    if err != nil {
+        return s, err
+    }
+    _, err = r.db.ExecContext(ctx, r.dialect.rebind("DELETE FROM subscriptions WHERE id = ?"), s.Id)
+    return s, err
+}
+
+func (r *sqlRepository) ClaimDigests(ctx context.Context, dailyBefore, weeklyBefore, now time.Time, limit int) ([]Subscription, error) {
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind("SELECT "+subscriptionColumns+" FROM subscriptions WHERE "+subscriptionDue+" ORDER BY id LIMIT ?"),
+        dailyBefore, weeklyBefore, limit)
+    if err != nil {
+        return nil, err
+    }
+
+    var due []Subscription
+    for rows.Next() {
+        s, err := scanSubscription(rows)
+        if err != nil {
+            rows.Close()
+            return nil, err
+        }
+        due = append(due, s)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    // Repeating the due condition makes the update a compare-and-swap, so
+    // when two instances race for a subscription only one of them gets it.
+    var claimed []Subscription
+    for _, s := range due {
+        result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE subscriptions SET last_sent_at = ? WHERE id = ? AND "+subscriptionDue),
+            now, s.Id, dailyBefore, weeklyBefore)
+        if err != nil {
+            return claimed, err
+        }
+        if n, _ := result.RowsAffected(); n == 1 {
+            claimed = append(claimed, s)
+        }
+    }
+    return claimed, nil
+}
+
+func (r *sqlRepository) ReleaseDigest(ctx context.Context, id int, lastSentAt *time.Time) error {
+    var previous any
+    if lastSentAt != nil {
+        previous = *lastSentAt
+    }
+    _, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE subscriptions SET last_sent_at = ? WHERE id = ?"), previous, id)
+    return err
+}
+
+func (r *sqlRepository) PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error) {
+    result, err := r.db.ExecContext(ctx, r.dialect.rebind("DELETE FROM subscriptions WHERE confirmed_at IS NULL AND created_at < ?"), before)
+    if err != nil {
+        return 0, err
+    }
+    n, err := result.RowsAffected()
+    return int(n), err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+)
+
+// themeColumns are the columns scanTheme expects, in order.
+const themeColumns = "name, background, border, text_color, author_color, font_family, font_size, width, updated_at"
+
+func scanTheme(row scanner) (Theme, error) {
+    var theme Theme
+    err := row.Scan(&theme.Name, &theme.Background, &theme.Border, &theme.TextColor, &theme.AuthorColor, &theme.FontFamily, &theme.FontSize, &theme.Width, &theme.UpdatedAt)
+    theme.UpdatedAt = theme.UpdatedAt.UTC()
+    return theme, err
+}
+
+func (r *sqlRepository) Themes(ctx context.Context) ([]Theme, error) {
+    rows, err := r.db.QueryContext(ctx, "SELECT "+themeColumns+" FROM card_themes ORDER BY name")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    list := []Theme{}
+    for rows.Next() {
+        theme, err := scanTheme(rows)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, theme)
+    }
+    return list, rows.Err()
+}
+
+func (r *sqlRepository) Theme(ctx context.Context, name string) (Theme, error) {
+    theme, err := scanTheme(r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT "+themeColumns+" FROM card_themes WHERE name = ?"), name))
+    if errors.Is(err, sql.ErrNoRows) {
+        return theme, errThemeNotFound
+    }
+    return theme, err
+}
+
+// SaveTheme replaces the theme by deleting and inserting it in a transaction,
+// which every dialect supports, unlike upserts.
+func (r *sqlRepository) SaveTheme(ctx context.Context, theme Theme) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM card_themes WHERE name = ?"), theme.Name); err != nil {
+        return err
+    }
+    _, err = tx.ExecContext(ctx, r.dialect.rebind("INSERT INTO card_themes ("+themeColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+        theme.Name, theme.Background, theme.Border, theme.TextColor, theme.AuthorColor, theme.FontFamily, theme.FontSize, theme.Width, theme.UpdatedAt)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) DeleteTheme(ctx context.Context, name string) error {
+    result, err := r.db.ExecContext(ctx, r.dialect.rebind("DELETE FROM card_themes WHERE name = ?"), name)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errThemeNotFound
+    }
+    return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+)
+
+func (r *sqlRepository) Translation(ctx context.Context, jokeID int, lang string) (JokeTranslation, error) {
+    t := JokeTranslation{JokeID: jokeID, Language: lang}
+    err := r.db.QueryRowContext(ctx, r.dialect.rebind("SELECT joke_text, provider, created_at FROM joke_translations WHERE joke_id = ? AND language = ?"), jokeID, lang).
+        Scan(&t.Text, &t.Provider, &t.CreatedAt)
+    if errors.Is(err, sql.ErrNoRows) {
+        return t, errTranslationNotFound
+    }
+    t.CreatedAt = t.CreatedAt.UTC()
+    return t, err
+}
+
+// SaveTranslation replaces the translation by deleting and inserting it in a
+// transaction, which every dialect supports, unlike upserts.
+func (r *sqlRepository) SaveTranslation(ctx context.Context, t JokeTranslation) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM joke_translations WHERE joke_id = ? AND language = ?"), t.JokeID, t.Language); err != nil {
+        return err
+    }
+    _, err = tx.ExecContext(ctx, r.dialect.rebind("INSERT INTO joke_translations (joke_id, language, joke_text, provider, created_at) VALUES (?, ?, ?, ?, ?)"),
+        t.JokeID, t.Language, t.Text, t.Provider, t.CreatedAt)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
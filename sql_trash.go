@@ -0,0 +1,71 @@
+package main
+
+import (
+    "context"
+    "time"
+)
+
+func (r *sqlRepository) Delete(ctx context.Context, id int) error {
+    return r.setDeleted(ctx, id, true)
+}
+
+func (r *sqlRepository) Restore(ctx context.Context, id int) error {
+    return r.setDeleted(ctx, id, false)
+}
+
+// setDeleted deletes or restores a joke and records the change. It returns
+// errJokeNotFound when there's no joke to change.
+func (r *sqlRepository) setDeleted(ctx context.Context, id int, deleted bool) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    query, args, op := "UPDATE jokes SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", []any{id}, changeRestore
+    if deleted {
+        query, args, op = "UPDATE jokes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", []any{time.Now().UTC().Truncate(time.Second), id}, changeDelete
+    }
+    result, err := tx.ExecContext(ctx, r.dialect.rebind(query), args...)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errJokeNotFound
+    }
+
+    if err := r.recordChange(ctx, tx, id, op); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// purgeTables hold rows that belong to a joke and go when it's purged. The
+// change feed keeps its entries, which no longer carry the joke.
+var purgeTables = []string{"joke_flags", "joke_shares", "joke_schedule", "joke_of_the_day", "joke_translations"}
+
+func (r *sqlRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    purged := "SELECT id FROM jokes WHERE deleted_at < ?"
+    for _, table := range purgeTables {
+        if _, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM "+table+" WHERE joke_id IN ("+purged+")"), before.UTC()); err != nil {
+            return 0, err
+        }
+    }
+    result, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM jokes WHERE deleted_at < ?"), before.UTC())
+    if err != nil {
+        return 0, err
+    }
+    n, err := result.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+    return int(n), tx.Commit()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "time"
+)
+
+func (r *sqlRepository) CreateWebhook(ctx context.Context, url, secret string) (Webhook, error) {
+    webhook := Webhook{URL: url, Secret: secret, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+    id, err := r.insert(ctx, r.db, "INSERT INTO webhooks (url, secret, created_at) VALUES (?, ?, ?)", url, secret, webhook.CreatedAt)
+    webhook.Id = id
+    return webhook, err
+}
+
+func (r *sqlRepository) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+    rows, err := r.db.QueryContext(ctx, "SELECT id, url, created_at FROM webhooks ORDER BY id")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    list := []Webhook{}
+    for rows.Next() {
+        var webhook Webhook
+        if err := rows.Scan(&webhook.Id, &webhook.URL, &webhook.CreatedAt); err != nil {
+            return nil, err
+        }
+        webhook.CreatedAt = webhook.CreatedAt.UTC()
+        list = append(list, webhook)
+    }
+    return list, rows.Err()
+}
+
+func (r *sqlRepository) DeleteWebhook(ctx context.Context, id int) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM webhooks WHERE id = ?"), id)
+    if err != nil {
+        return err
+    }
+    if n, err := result.RowsAffected(); err != nil {
+        return err
+    } else if n == 0 {
+        return errWebhookNotFound
+    }
+
+    _, err = tx.ExecContext(ctx, r.dialect.rebind("DELETE FROM webhook_deliveries WHERE webhook_id = ? AND delivered_at IS NULL"), id)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (r *sqlRepository) EnqueueWebhooks(ctx context.Context, event string, payload []byte) error {
+    now := time.Now().UTC().Truncate(time.Second)
+    _, err := r.db.ExecContext(ctx, r.dialect.rebind("INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at) SELECT id, ?, ?, ? FROM webhooks"), event, string(payload), now)
+    return err
+}
+
+func (r *sqlRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhookDelivery, error) {
+    now := time.Now().UTC().Truncate(time.Second)
+    rows, err := r.db.QueryContext(ctx, r.dialect.rebind(`SELECT d.id, w.url, w.secret, d.event, d.payload, d.attempts
+        FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.next_attempt_at <= ? ORDER BY d.next_attempt_at, d.id LIMIT ?`), now, limit)
+    if err != nil {
+        return nil, err
+    }
+
+    var due []webhookDelivery
+    for rows.Next() {
+        var delivery webhookDelivery
+        var payload string
+        if err := rows.Scan(&delivery.Id, &delivery.URL, &delivery.Secret, &delivery.Event, &payload, &delivery.Attempts); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        delivery.Payload = []byte(payload)
+        due = append(due, delivery)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    // Pushing next_attempt_at out is the lease. The attempts check makes it
+    // a compare-and-swap, so when two instances race for a delivery only
+    // one of them gets it.
+    var claimed []webhookDelivery
+    for _, delivery := range due {
+        result, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND attempts = ? AND next_attempt_at <= ?"),
+            now.Add(lease), delivery.Id, delivery.Attempts, now)
+        if err != nil {
+            return claimed, err
+        }
+        if n, _ := result.RowsAffected(); n == 1 {
+            claimed = append(claimed, delivery)
+        }
+    }
+    return claimed, nil
+}
+
+func (r *sqlRepository) FinishDelivery(ctx context.Context, id int, retryAt *time.Time, attemptErr string) error {
+    var deliveredAt any
+    if attemptErr == "" {
+        deliveredAt = time.Now().UTC().Truncate(time.Second)
+    }
+    lastError := sql.NullString{String: attemptErr, Valid: attemptErr != ""}
+
+    var next any
+    if retryAt != nil {
+        next = *retryAt
+    }
+    _, err := r.db.ExecContext(ctx, r.dialect.rebind("UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = ?, delivered_at = ?, last_error = ? WHERE id = ?"),
+        next, deliveredAt, lastError, id)
+    return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "bytes"
+    "encoding/xml"
+    "net/http"
+    "regexp"
+    "strings"
+    "time"
+)
+
+const (
+    // ssmlPause is the pause between the lines or sentences of the setup.
+    ssmlPause = 400 * time.Millisecond
+
+    // ssmlPunchlinePause is the beat before the punchline.
+    ssmlPunchlinePause = 1200 * time.Millisecond
+)
+
+// sentenceEnd matches the end of a sentence inside a line: its closing
+// punctuation, and any quotes or brackets after it, followed by a space.
+var sentenceEnd = regexp.MustCompile(`[.?!…]+["'”’)\]]*\s+`)
+
+// jokeBeats splits a joke into what's said between pauses. Jokes written
+// over several lines, like knock-knock jokes and dialogues, are split into
+// their lines; others into their sentences. The last beat is the punchline.
+func jokeBeats(text string) []string {
+    var beats []string
+    for _, line := range strings.Split(text, "\n") {
+        if line = strings.TrimSpace(line); line != "" {
+            beats = append(beats, line)
+        }
+    }
+    if len(beats) != 1 {
+        return beats
+    }
+
+    line := beats[0]
+    beats = nil
+    start := 0
+    for _, end := range sentenceEnd.FindAllStringIndex(line, -1) {
+        beats = append(beats, strings.TrimSpace(line[start:end[1]]))
+        start = end[1]
+    }
+    if rest := strings.TrimSpace(line[start:]); rest != "" {
+        beats = append(beats, rest)
+    }
+    return beats
+}
+
+// jokeSSML renders joke as SSML for voice assistants and screen readers,
+// pausing between the beats of the setup and a little longer before the
+// punchline.
+func jokeSSML(joke Joke) []byte {
+    var b bytes.Buffer
+    b.WriteString(xml.Header)
+    b.WriteString(`<speak version="1.1" xmlns="http://www.w3.org/2001/10/synthesis" xml:lang="`)
+    xml.EscapeText(&b, []byte(joke.Language))
+    b.WriteString("\">\n<p>\n")
+
+    beats := jokeBeats(stripBidiControls(joke.Text))
+    for i, beat := range beats {
+        if i > 0 {
+            pause := ssmlPause
+            if i == len(beats)-1 {
+                pause = ssmlPunchlinePause
+            }
+            b.WriteString(`<break time="` + pause.String() + "\"/>\n")
+        }
+        b.WriteString("<s>")
+        xml.EscapeText(&b, []byte(beat))
+        b.WriteString("</s>\n")
+    }
+    b.WriteString("</p>\n</speak>\n")
+    return b.Bytes()
+}
+
+// writeJokeSSML answers with joke as SSML, for ?format=ssml.
+func writeJokeSSML(response http.ResponseWriter, joke Joke) {
+    response.Header().Set("Content-Type", "application/ssml+xml; charset=utf-8")
+    response.WriteHeader(http.StatusOK)
+    response.Write(jokeSSML(joke))
+}
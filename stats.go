@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "expvar"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// statsDays is how many days of submissions /admin/stats covers.
+const statsDays = 30
+
+// statsTopAuthors is how many authors /admin/stats lists.
+const statsTopAuthors = 10
+
+// StatsRepository answers the aggregate queries of /admin/stats.
+type StatsRepository interface {
+    // CountJokes returns how many jokes are visible and how many hidden.
+    CountJokes(ctx context.Context) (visible, hidden int, err error)
+
+    // SubmissionDates returns the entry dates of the jokes stored since the
+    // given time, hidden or not.
+    SubmissionDates(ctx context.Context, since time.Time) ([]time.Time, error)
+}
+
+var stats StatsRepository
+
+// requestsServed counts the requests served since startup. It's published
+// with the other counters at /debug/vars.
+var requestsServed = expvar.NewInt("requests_served")
+
+// requestVolume counts requests per hour over the last day.
+var requestVolume = &hourlyCounter{}
+
+// hourlyCounter is a ring of 24 hourly request counts.
+type hourlyCounter struct {
+    mu     sync.Mutex
+    hours  [24]int64
+    starts [24]time.Time
+}
+
+func (c *hourlyCounter) add(now time.Time) {
+    hour := now.Truncate(time.Hour)
+    i := hour.Hour()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if !c.starts[i].Equal(hour) {
+        c.starts[i], c.hours[i] = hour, 0
+    }
+    c.hours[i]++
+}
+
+// lastDay returns the requests counted in the current hour and the 23
+// before it.
+func (c *hourlyCounter) lastDay(now time.Time) int64 {
+    cutoff := now.Truncate(time.Hour).Add(-23 * time.Hour)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    var total int64
+    for i, start := range c.starts {
+        if !start.Before(cutoff) {
+            total += c.hours[i]
+        }
+    }
+    return total
+}
+
+// countRequest records a served request for the statistics.
+func countRequest() {
+    requestsServed.Add(1)
+    requestVolume.add(time.Now())
+}
+
+// startedAt is when the process started, the start of requests_served.
+var startedAt = time.Now().UTC().Truncate(time.Second)
+
+type adminStats struct {
+    Jokes             jokeCounts      `json:"jokes"`
+    SubmissionsPerDay []dayCount      `json:"submissions_per_day"`
+    TopAuthors        []AuthorSummary `json:"top_authors"`
+    PendingModeration int             `json:"pending_moderation"`
+    Requests          requestCounts   `json:"requests"`
+}
+
+type jokeCounts struct {
+    Total  int `json:"total"`
+    Hidden int `json:"hidden"`
+}
+
+type dayCount struct {
+    Date  string `json:"date"`
+    Count int    `json:"count"`
+}
+
+// requestCounts are the requests served by this instance; each instance
+// counts its own.
+type requestCounts struct {
+    SinceStart int64     `json:"since_start"`
+    LastDay    int64     `json:"last_24h"`
+    StartedAt  time.Time `json:"started_at"`
+}
+
+// getAdminStats serves the figures an admin dashboard shows.
+func getAdminStats(response http.ResponseWriter, request *http.Request) {
+    ctx := request.Context()
+    now := time.Now().UTC()
+
+    var result adminStats
+    var err error
+    result.Jokes.Total, result.Jokes.Hidden, err = stats.CountJokes(ctx)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    // Days run from midnight UTC, today included, with empty days listed.
+    first := now.Truncate(24*time.Hour).AddDate(0, 0, 1-statsDays)
+    dates, err := stats.SubmissionDates(ctx, first)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    counts := map[string]int{}
+    for _, date := range dates {
+        counts[date.UTC().Format(dayLayout)]++
+    }
+    for day := first; !day.After(now); day = day.AddDate(0, 0, 1) {
+        key := day.Format(dayLayout)
+        result.SubmissionsPerDay = append(result.SubmissionsPerDay, dayCount{Date: key, Count: counts[key]})
+    }
+
+    result.TopAuthors, err = authors.ListAuthors(ctx, 0, statsTopAuthors)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    result.PendingModeration, err = moderation.CountOpenFlags(ctx)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    result.Requests = requestCounts{SinceStart: requestsServed.Value(), LastDay: requestVolume.lastDay(now), StartedAt: startedAt}
+    writeJSON(response, request, http.StatusOK, result)
+}
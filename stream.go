@@ -0,0 +1,140 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// streamPollInterval is how often a stream checks the database for jokes
+// stored by other instances. Jokes stored by this one are sent right away.
+const streamPollInterval = 5 * time.Second
+
+// streamHeartbeat is how often an idle stream sends a comment, so proxies
+// don't close it and clients notice when it's gone.
+const streamHeartbeat = 15 * time.Second
+
+// streamBatchSize is how many jokes a stream reads at once, e.g. when a
+// client resumes after a long time.
+const streamBatchSize = 100
+
+// streamingEnabled is unset where responses can't be streamed, such as on
+// Lambda, where the whole response is returned at once.
+var streamingEnabled = true
+
+// jokeSignal wakes up the streams waiting for new jokes.
+type jokeSignal struct {
+    mu sync.Mutex
+    ch chan struct{}
+}
+
+// wait returns a channel that is closed when notify is next called.
+func (s *jokeSignal) wait() <-chan struct{} {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.ch == nil {
+        s.ch = make(chan struct{})
+    }
+    return s.ch
+}
+
+func (s *jokeSignal) notify() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.ch != nil {
+        close(s.ch)
+        s.ch = nil
+    }
+}
+
+// newJokes is notified whenever this instance stores jokes.
+var newJokes = &jokeSignal{}
+
+// streamsClosing is closed when the server shuts down. Streams never finish
+// on their own, so they'd otherwise hold up the shutdown until it times out.
+var streamsClosing = make(chan struct{})
+
+func closeStreams() {
+    close(streamsClosing)
+}
+
+// streamJokes sends new jokes as Server-Sent Events, each with the joke's id
+// as the event id. A client reconnecting with Last-Event-ID gets the jokes it
+// missed first; otherwise the stream starts with the next joke stored.
+func streamJokes(response http.ResponseWriter, request *http.Request) {
+    if !streamingEnabled {
+        writeError(response, http.StatusNotImplemented, "streaming isn't available on this deployment")
+        return
+    }
+
+    ctx := request.Context()
+    var last int
+    if id := request.Header.Get("Last-Event-ID"); id != "" {
+        var ok bool
+        last, ok = parseJokeRef(id)
+        if !ok {
+            writeError(response, http.StatusBadRequest, "Last-Event-ID must be a joke id")
+            return
+        }
+    } else {
+        var err error
+        last, err = repo.MaxID(ctx)
+        if err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+    }
+
+    header := response.Header()
+    header.Set("Content-Type", "text/event-stream")
+    header.Set("Cache-Control", "no-cache")
+    // Stops nginx from buffering the stream.
+    header.Set("X-Accel-Buffering", "no")
+    response.WriteHeader(http.StatusOK)
+    fmt.Fprintf(response, "retry: %d\n\n", streamPollInterval.Milliseconds())
+
+    keepOpen(response, time.Time{})
+    controller := http.NewResponseController(response)
+    poll := time.NewTicker(streamPollInterval)
+    defer poll.Stop()
+    heartbeat := time.NewTicker(streamHeartbeat)
+    defer heartbeat.Stop()
+
+    for {
+        // Taken before reading, so a joke stored meanwhile isn't missed.
+        wake := newJokes.wait()
+
+        jokes, err := repo.ListAfter(ctx, last, streamBatchSize, "")
+        if err != nil {
+            if ctx.Err() == nil {
+                slog.ErrorContext(ctx, "Error streaming jokes", "error", err)
+            }
+            return
+        }
+        for _, joke := range jokes {
+            data, _ := json.Marshal(joke)
+            fmt.Fprintf(response, "id: %s\nevent: joke\ndata: %s\n\n", jokeRef(joke.Id), data)
+            last = joke.Id
+        }
+        if err := controller.Flush(); err != nil {
+            return
+        }
+        if len(jokes) == streamBatchSize {
+            continue
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-streamsClosing:
+            return
+        case <-wake:
+        case <-poll.C:
+        case <-heartbeat.C:
+            fmt.Fprint(response, ": ping\n\n")
+        }
+    }
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/mail"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+const (
+    // subscriptionInterval is how often the digest loop looks for
+    // subscribers due an email.
+    subscriptionInterval = time.Minute
+
+    // subscriptionBatchSize is how many subscribers the loop claims at once.
+    subscriptionBatchSize = 50
+)
+
+// errSubscriptionNotFound is returned for tokens that don't belong to a
+// subscription, or to one whose confirmation has expired.
+var errSubscriptionNotFound = errors.New("subscription not found")
+
+// subscriptionFrequencies are how often subscribers can get a joke.
+var subscriptionFrequencies = []string{"daily", "weekly"}
+
+// Subscription is an email address that gets the joke of the day. It only
+// gets email once the address is confirmed. Token is the secret in the
+// confirmation and unsubscribe links.
+type Subscription struct {
+    Id          int        `json:"-"`
+    Email       string     `json:"email"`
+    Frequency   string     `json:"frequency"`
+    Token       string     `json:"-"`
+    CreatedAt   time.Time  `json:"created_at"`
+    ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+    LastSentAt  *time.Time `json:"-"`
+}
+
+// SubscriptionRepository stores email subscriptions.
+type SubscriptionRepository interface {
+    // Subscribe stores an unconfirmed subscription for email with token, and
+    // returns it. If email already has a subscription, that one is returned
+    // instead, with frequency and a fresh created_at if it isn't confirmed
+    // yet.
+    Subscribe(ctx context.Context, email, frequency, token string) (Subscription, error)
+
+    // ConfirmSubscription confirms the subscription with token, unless it's
+    // unconfirmed and was created before since, and returns it. Confirming
+    // twice is fine.
+    ConfirmSubscription(ctx context.Context, token string, since time.Time) (Subscription, error)
+
+    // Unsubscribe deletes the subscription with token and returns it.
+    Unsubscribe(ctx context.Context, token string) (Subscription, error)
+
+    // ClaimDigests returns up to limit confirmed subscriptions that haven't
+    // been sent anything since dailyBefore, or weeklyBefore for weekly ones,
+    // and marks them sent at now, so other instances skip them.
+    ClaimDigests(ctx context.Context, dailyBefore, weeklyBefore, now time.Time, limit int) ([]Subscription, error)
+
+    // ReleaseDigest puts back the last sent time of a subscription whose
+    // email couldn't be sent, so it's tried again.
+    ReleaseDigest(ctx context.Context, id int, lastSentAt *time.Time) error
+
+    // PurgeUnconfirmed deletes subscriptions created before before that were
+    // never confirmed, and returns how many.
+    PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error)
+}
+
+var subscriptions SubscriptionRepository
+
+// subscriptionConfig is read by loadSubscriptions.
+var subscriptionConfig struct {
+    // confirmTTL is how long a confirmation link works.
+    confirmTTL time.Duration
+    // digestHour is the hour of the day, in UTC, from which jokes are sent.
+    digestHour int
+}
+
+// loadSubscriptions sets up the mailer and reads SUBSCRIPTION_CONFIRM_TTL
+// and DIGEST_HOUR. Emails link back to the API, so they need PUBLIC_URL.
+func loadSubscriptions() error {
+    var err error
+    mailer, err = loadMailer()
+    if err != nil || mailer == nil {
+        return err
+    }
+    if os.Getenv("PUBLIC_URL") == "" {
+        return errors.New("MAILER requires PUBLIC_URL")
+    }
+
+    subscriptionConfig.confirmTTL, err = getEnvDuration("SUBSCRIPTION_CONFIRM_TTL", 48*time.Hour)
+    if err != nil {
+        return err
+    }
+    subscriptionConfig.digestHour, err = getEnvInt("DIGEST_HOUR", 8)
+    if err != nil {
+        return err
+    }
+    if subscriptionConfig.digestHour < 0 || subscriptionConfig.digestHour > 23 {
+        return errors.New("DIGEST_HOUR must be between 0 and 23")
+    }
+    return nil
+}
+
+// subscriptionLink returns the link to path with the subscription's token.
+func subscriptionLink(path string, s Subscription) string {
+    return strings.TrimRight(os.Getenv("PUBLIC_URL"), "/") + apiV1 + path + "?token=" + url.QueryEscape(s.Token)
+}
+
+func confirmationEmail(s Subscription) mailMessage {
+    return mailMessage{
+        To:      s.Email,
+        Subject: "Confirm your dad joke subscription",
+        Body: fmt.Sprintf("Someone, hopefully you, asked for a %s dad joke at this address.\n\n"+
+            "To start getting them, confirm your subscription:\n%s\n\n"+
+            "If it wasn't you, ignore this email and you won't hear from us again.\n",
+            s.Frequency, subscriptionLink("/subscribe/confirm", s)),
+    }
+}
+
+// digestEmail carries the joke of the day, and for weekly subscribers last
+// week's best of, if there is one. The List-Unsubscribe headers let mail
+// clients offer one-click unsubscribing.
+func digestEmail(s Subscription, joke Joke, best *BestOf) mailMessage {
+    subject := "Your dad joke of the day"
+    if s.Frequency == "weekly" {
+        subject = "Your dad joke of the week"
+    }
+    author := stripBidiControls(joke.Author)
+    if author == "" {
+        author = "Anonymous"
+    }
+    unsubscribe := subscriptionLink("/unsubscribe", s)
+    extra := ""
+    if s.Frequency == "weekly" && best != nil {
+        extra = fmt.Sprintf("%s\n\n%s\n\n", bestOfTitle(best.Week), bestOfText(*best))
+    }
+
+    return mailMessage{
+        To:      s.Email,
+        Subject: subject,
+        Body:    fmt.Sprintf("%s\n\n- %s\n\n%s--\nUnsubscribe: %s\n", joke.plainText(), author, extra, unsubscribe),
+        Header: map[string]string{
+            "List-Unsubscribe":      "<" + unsubscribe + ">",
+            "List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+        },
+    }
+}
+
+// runSubscriptions emails the joke of the day to the subscribers due one,
+// and clears out unconfirmed subscriptions, until ctx is cancelled.
+// Subscribers are marked before they're emailed, so a crash mid-send skips
+// an email rather than sending it twice.
+func runSubscriptions(ctx context.Context) {
+    ticker := time.NewTicker(subscriptionInterval)
+    defer ticker.Stop()
+
+    for {
+        now := time.Now().UTC().Truncate(time.Second)
+        if _, err := subscriptions.PurgeUnconfirmed(ctx, now.Add(-subscriptionConfig.confirmTTL)); err != nil {
+            slog.Error("Error purging unconfirmed subscriptions", "error", err)
+        }
+        if now.Hour() >= subscriptionConfig.digestHour {
+            sendDigests(ctx, now)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func sendDigests(ctx context.Context, now time.Time) {
+    today := now.Truncate(24 * time.Hour)
+    var joke *Joke
+    var best *BestOf
+
+    for {
+        due, err := subscriptions.ClaimDigests(ctx, today, today.AddDate(0, 0, -6), now, subscriptionBatchSize)
+        if err != nil {
+            slog.Error("Error loading subscriptions", "error", err)
+            return
+        }
+        if len(due) > 0 && joke == nil {
+            j, err := dailyJoke(ctx, today.Format(dayLayout))
+            if err != nil {
+                slog.Error("Error picking the joke of the day", "error", err)
+                for _, s := range due {
+                    subscriptions.ReleaseDigest(ctx, s.Id, s.LastSentAt)
+                }
+                return
+            }
+            joke = &j
+
+            // Without the best of, weekly digests still get the joke.
+            best, err = latestBestOf(ctx, now)
+            if err != nil {
+                slog.Error("Error loading the best of", "error", err)
+            }
+        }
+
+        for _, s := range due {
+            if err := mailer.Send(ctx, digestEmail(s, *joke, best)); err != nil {
+                slog.Warn("Error emailing subscriber", "subscription", s.Id, "error", err)
+                if err := subscriptions.ReleaseDigest(ctx, s.Id, s.LastSentAt); err != nil {
+                    slog.Error("Error releasing subscription", "subscription", s.Id, "error", err)
+                }
+            }
+        }
+        if len(due) < subscriptionBatchSize {
+            return
+        }
+    }
+}
+
+type subscribeRequest struct {
+    Email     string `json:"email"`
+    Frequency string `json:"frequency"`
+}
+
+func (s *subscribeRequest) validate() error {
+    var problems validationErrors
+    s.Email = strings.ToLower(strings.TrimSpace(s.Email))
+    if address, err := mail.ParseAddress(s.Email); s.Email == "" {
+        problems.add("email", "is required")
+    } else if err != nil || address.Address != s.Email || len(s.Email) > 254 {
+        problems.add("email", "must be an email address")
+    }
+
+    if s.Frequency == "" {
+        s.Frequency = "daily"
+    }
+    if s.Frequency != "daily" && s.Frequency != "weekly" {
+        problems.add("frequency", "must be one of %s", strings.Join(subscriptionFrequencies, ", "))
+    }
+    return problems.err()
+}
+
+// subscriptionMessage is the response of /subscribe, which is the same
+// whether or not the address was subscribed already, so the endpoint can't
+// be used to find out, and of /unsubscribe.
+type subscriptionMessage struct {
+    Message string `json:"message"`
+}
+
+// subscribe starts a subscription and emails a confirmation link. Asking
+// again for an unconfirmed address sends the link again; a confirmed one is
+// left as it is.
+func subscribe(response http.ResponseWriter, request *http.Request) {
+    if mailer == nil {
+        writeError(response, http.StatusNotFound, "email subscriptions aren't enabled")
+        return
+    }
+    if !checkProofOfWork(response, request) {
+        return
+    }
+
+    var body subscribeRequest
+    if !decodeRequest(response, request, &body) {
+        return
+    }
+
+    token := make([]byte, 32)
+    rand.Read(token)
+    s, err := subscriptions.Subscribe(request.Context(), body.Email, body.Frequency, hex.EncodeToString(token))
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+    if s.ConfirmedAt == nil {
+        if err := enqueueJob(request.Context(), "mail.send", confirmationEmail(s)); err != nil {
+            writeInternalError(response, request, err)
+            return
+        }
+    }
+
+    writeJSON(response, request, http.StatusAccepted, subscriptionMessage{
+        Message: "check your inbox for a link to confirm the subscription",
+    })
+}
+
+func confirmSubscription(response http.ResponseWriter, request *http.Request) {
+    since := time.Now().UTC().Add(-subscriptionConfig.confirmTTL)
+    s, err := subscriptions.ConfirmSubscription(request.Context(), request.URL.Query().Get("token"), since)
+    if errors.Is(err, errSubscriptionNotFound) {
+        writeError(response, http.StatusNotFound, "this link is invalid or has expired")
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, s)
+}
+
+// unsubscribe deletes a subscription. It takes GET for the link in emails
+// and POST for one-click unsubscribing from mail clients.
+func unsubscribe(response http.ResponseWriter, request *http.Request) {
+    _, err := subscriptions.Unsubscribe(request.Context(), request.URL.Query().Get("token"))
+    if errors.Is(err, errSubscriptionNotFound) {
+        writeError(response, http.StatusNotFound, "this link is invalid or you've unsubscribed already")
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, subscriptionMessage{Message: "you've been unsubscribed"})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "strings"
+    "unicode"
+
+    "golang.org/x/text/unicode/norm"
+)
+
+// Characters that matter to emoji sequences and right-to-left text.
+const (
+    zeroWidthJoiner    = '\u200d'
+    zeroWidthNonJoiner = '\u200c'
+    leftToRightMark    = '\u200e'
+    rightToLeftMark    = '\u200f'
+    arabicLetterMark   = '\u061c'
+)
+
+// rtlScripts are the scripts written right to left.
+var rtlScripts = []*unicode.RangeTable{
+    unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko,
+    unicode.Samaritan, unicode.Mandaic, unicode.Adlam, unicode.Hanifi_Rohingya,
+}
+
+// normalizeJoke puts the text of a submitted joke in Unicode normalization
+// form C, so an accented letter typed as a letter and a combining mark is
+// stored, counted and compared the same as the precomposed letter. Its
+// language is put in canonical form, or defaulted; an invalid one is left
+// for the limits to reject.
+func normalizeJoke(joke *Joke) {
+    joke.Text = norm.NFC.String(joke.Text)
+    joke.Author = norm.NFC.String(joke.Author)
+    if lang, ok := canonicalLanguage(joke.Language); ok {
+        joke.Language = lang
+    }
+}
+
+// isBidiControl reports whether r is one of the explicit embedding, override
+// or isolate controls. Left unbalanced they reorder whatever text follows
+// them, such as the rest of a page the joke is embedded in.
+func isBidiControl(r rune) bool {
+    return r >= '\u202a' && r <= '\u202e' || r >= '\u2066' && r <= '\u2069'
+}
+
+// isInvisibleFormat reports whether r is one of the format characters that
+// printable text needs: joiners for emoji sequences and Persian and Indic
+// scripts, direction marks, and the tags of subdivision flags.
+func isInvisibleFormat(r rune) bool {
+    switch r {
+    case zeroWidthJoiner, zeroWidthNonJoiner, leftToRightMark, rightToLeftMark, arabicLetterMark:
+        return true
+    }
+    return r >= '\U000e0020' && r <= '\U000e007f'
+}
+
+// stripBidiControls removes the explicit bidi controls from s, for output
+// that mustn't leak direction changes into its surroundings.
+func stripBidiControls(s string) string {
+    return strings.Map(func(r rune) rune {
+        if isBidiControl(r) {
+            return -1
+        }
+        return r
+    }, s)
+}
+
+// isRTL reports whether s should be laid out right to left. Like dir="auto"
+// in HTML it goes by the first letter, so emoji, digits and punctuation
+// don't decide.
+func isRTL(s string) bool {
+    for _, r := range s {
+        if r == rightToLeftMark || r == arabicLetterMark || unicode.In(r, rtlScripts...) {
+            return true
+        }
+        if r == leftToRightMark || unicode.IsLetter(r) {
+            return false
+        }
+    }
+    return false
+}
+
+// extendsCluster reports whether r attaches to the character before it
+// rather than starting a new one: combining marks, variation selectors,
+// skin tone modifiers, joiners and tags.
+func extendsCluster(r rune) bool {
+    return unicode.In(r, unicode.Mn, unicode.Me) ||
+        r >= '\U0001f3fb' && r <= '\U0001f3ff' ||
+        r == zeroWidthJoiner || r >= '\U000e0020' && r <= '\U000e007f'
+}
+
+// clusters splits s into what readers see as single characters. It's an
+// approximation of Unicode grapheme clusters that keeps combining marks and
+// emoji sequences, including flags, together.
+func clusters(s string) []string {
+    var result []string
+    var joined, regional bool
+    start := 0
+    for i, r := range s {
+        isRegional := r >= '\U0001f1e6' && r <= '\U0001f1ff'
+        switch {
+        case i == 0, joined, extendsCluster(r):
+        case isRegional && regional:
+            // The second half of a flag.
+            isRegional = false
+        default:
+            result = append(result, s[start:i])
+            start = i
+        }
+        joined, regional = r == zeroWidthJoiner, isRegional
+    }
+    if start < len(s) {
+        result = append(result, s[start:])
+    }
+    return result
+}
+
+// displayWidth estimates how many columns s takes up: wide characters such
+// as emoji and CJK count two and the characters extending a cluster none.
+func displayWidth(s string) int {
+    width := 0
+    for _, cluster := range clusters(s) {
+        width += clusterWidth(cluster)
+    }
+    return width
+}
+
+func clusterWidth(cluster string) int {
+    for _, r := range cluster {
+        if isInvisibleFormat(r) {
+            continue
+        }
+        if r >= '\u1100' && unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+            r >= '\U0001f000' && r <= '\U0001faff' || r >= '\u2600' && r <= '\u27bf' {
+            return 2
+        }
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "regexp"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// errThemeNotFound is returned when no theme has the given name.
+var errThemeNotFound = errors.New("theme not found")
+
+// Theme styles the SVG and PNG joke cards. Operators store their own under a
+// name and pick one with ?theme=; fields left out of a theme take the value
+// of defaultTheme. PNG cards only use the colors.
+type Theme struct {
+    Name        string    `json:"name"`
+    Background  string    `json:"background"`
+    Border      string    `json:"border"`
+    TextColor   string    `json:"text_color"`
+    AuthorColor string    `json:"author_color"`
+    FontFamily  string    `json:"font_family"`
+    FontSize    int       `json:"font_size"`
+    Width       int       `json:"width"`
+    UpdatedAt   time.Time `json:"updated_at,omitzero"`
+}
+
+// defaultTheme is the card used without ?theme=.
+var defaultTheme = Theme{
+    Name:        "default",
+    Background:  "#fffaf0",
+    Border:      "#f5a623",
+    TextColor:   "#222",
+    AuthorColor: "#666",
+    FontFamily:  "sans-serif",
+    FontSize:    18,
+    Width:       600,
+}
+
+// builtinThemes can be picked without storing them first. A stored theme of
+// the same name takes their place.
+var builtinThemes = []Theme{
+    defaultTheme.named("light"),
+    {
+        Name:        "dark",
+        Background:  "#1e1e24",
+        Border:      "#f5a623",
+        TextColor:   "#f0f0f0",
+        AuthorColor: "#a0a0a8",
+        FontFamily:  defaultTheme.FontFamily,
+        FontSize:    defaultTheme.FontSize,
+        Width:       defaultTheme.Width,
+    },
+}
+
+// Limits of theme fields, so a theme can't make cards unreadable or huge.
+const (
+    minThemeFontSize = 8
+    maxThemeFontSize = 72
+    minThemeWidth    = 200
+    maxThemeWidth    = 2000
+)
+
+var (
+    themeNamePattern  = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+    themeColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+    themeFontPattern  = regexp.MustCompile(`^[A-Za-z0-9 ,'-]{1,255}$`)
+)
+
+// ThemeRepository stores card themes.
+type ThemeRepository interface {
+    // Themes returns every stored theme, by name.
+    Themes(ctx context.Context) ([]Theme, error)
+
+    // Theme returns the theme called name, or errThemeNotFound.
+    Theme(ctx context.Context, name string) (Theme, error)
+
+    // SaveTheme creates the theme or replaces the one with the same name.
+    SaveTheme(ctx context.Context, theme Theme) error
+
+    // DeleteTheme removes a theme, or returns errThemeNotFound.
+    DeleteTheme(ctx context.Context, name string) error
+}
+
+var themes ThemeRepository
+
+// named returns t under another name.
+func (t Theme) named(name string) Theme {
+    t.Name = name
+    return t
+}
+
+// withDefaults fills the fields left out of t from defaultTheme.
+func (t Theme) withDefaults() Theme {
+    if t.Background == "" {
+        t.Background = defaultTheme.Background
+    }
+    if t.Border == "" {
+        t.Border = defaultTheme.Border
+    }
+    if t.TextColor == "" {
+        t.TextColor = defaultTheme.TextColor
+    }
+    if t.AuthorColor == "" {
+        t.AuthorColor = defaultTheme.AuthorColor
+    }
+    if t.FontFamily == "" {
+        t.FontFamily = defaultTheme.FontFamily
+    }
+    if t.FontSize == 0 {
+        t.FontSize = defaultTheme.FontSize
+    }
+    if t.Width == 0 {
+        t.Width = defaultTheme.Width
+    }
+    return t
+}
+
+// validate checks a theme after withDefaults. Colors are hex only and fonts
+// a restricted character set, since both end up in SVG attributes.
+func (t *Theme) validate() error {
+    var problems validationErrors
+    if !themeNamePattern.MatchString(t.Name) || t.Name == defaultTheme.Name {
+        problems.add("name", "must be 1 to 64 lowercase letters, digits or dashes, and not default")
+    }
+    colors := []struct {
+        field, value string
+    }{
+        {"background", t.Background},
+        {"border", t.Border},
+        {"text_color", t.TextColor},
+        {"author_color", t.AuthorColor},
+    }
+    for _, color := range colors {
+        if !themeColorPattern.MatchString(color.value) {
+            problems.add(color.field, "must be a hex color like #f5a623")
+        }
+    }
+    if !themeFontPattern.MatchString(t.FontFamily) {
+        problems.add("font_family", "may only contain letters, digits, spaces, commas, quotes and dashes")
+    }
+    if t.FontSize < minThemeFontSize || t.FontSize > maxThemeFontSize {
+        problems.add("font_size", "must be between %d and %d", minThemeFontSize, maxThemeFontSize)
+    }
+    if t.Width < minThemeWidth || t.Width > maxThemeWidth {
+        problems.add("width", "must be between %d and %d", minThemeWidth, maxThemeWidth)
+    }
+    return problems.err()
+}
+
+// cacheKey identifies what the theme draws, for the media cache key of cards.
+func (t Theme) cacheKey() string {
+    t.Name, t.UpdatedAt = "", time.Time{}
+    data, _ := json.Marshal(t)
+    return string(data)
+}
+
+// findTheme returns the theme called name: the default theme, a stored one
+// or else a built-in one.
+func findTheme(ctx context.Context, name string) (Theme, error) {
+    if name == defaultTheme.Name {
+        return defaultTheme, nil
+    }
+    theme, err := themes.Theme(ctx, name)
+    if errors.Is(err, errThemeNotFound) {
+        for _, builtin := range builtinThemes {
+            if builtin.Name == name {
+                return builtin, nil
+            }
+        }
+    }
+    return theme, err
+}
+
+// requestedTheme returns the theme asked for with ?theme=, or the default.
+func requestedTheme(ctx context.Context, request *http.Request) (Theme, error) {
+    name := request.URL.Query().Get("theme")
+    if name == "" {
+        return defaultTheme, nil
+    }
+    return findTheme(ctx, name)
+}
+
+// listThemes serves the themes. The default theme is listed first, then the
+// built-in ones that no stored theme replaces, then the stored ones.
+func listThemes(response http.ResponseWriter, request *http.Request) {
+    list, err := themes.Themes(request.Context())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    stored := make(map[string]bool, len(list))
+    for _, theme := range list {
+        stored[theme.Name] = true
+    }
+    all := []Theme{defaultTheme}
+    for _, builtin := range builtinThemes {
+        if !stored[builtin.Name] {
+            all = append(all, builtin)
+        }
+    }
+    writeJSON(response, request, http.StatusOK, append(all, list...))
+}
+
+func getTheme(response http.ResponseWriter, request *http.Request) {
+    theme, err := findTheme(request.Context(), mux.Vars(request)["name"])
+    if errors.Is(err, errThemeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, theme)
+}
+
+// putTheme creates or replaces the theme named in the path.
+func putTheme(response http.ResponseWriter, request *http.Request) {
+    // Not decodeRequest: the name comes from the path and the defaults are
+    // filled in before validating.
+    var theme Theme
+    if !decodeBody(response, request, bodyLimits.json, &theme) {
+        return
+    }
+    theme = theme.withDefaults()
+    theme.Name = mux.Vars(request)["name"]
+    theme.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+    if err := theme.validate(); err != nil {
+        writeValidationError(response, "invalid_request", err)
+        return
+    }
+
+    if err := themes.SaveTheme(request.Context(), theme); err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, theme)
+}
+
+func deleteTheme(response http.ResponseWriter, request *http.Request) {
+    err := themes.DeleteTheme(request.Context(), mux.Vars(request)["name"])
+    if errors.Is(err, errThemeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "os"
+    "time"
+
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSetup is how serve serves HTTPS, read by loadTLS.
+type tlsSetup struct {
+    // certFile and keyFile are the certificate and key to serve, unless
+    // certificates come from autocert.
+    certFile, keyFile string
+    autocert          *autocert.Manager
+
+    // redirectAddr is the address of a plain HTTP listener that redirects
+    // to HTTPS, or empty for none.
+    redirectAddr string
+}
+
+// loadTLS reads TLS_CERT_FILE and TLS_KEY_FILE, or AUTOCERT_DOMAINS with
+// AUTOCERT_EMAIL and AUTOCERT_CACHE_DIR for certificates from Let's Encrypt,
+// and HTTP_REDIRECT_ADDR. It returns nil when the API is served over plain
+// HTTP.
+func loadTLS() (*tlsSetup, error) {
+    setup := &tlsSetup{
+        certFile:     os.Getenv("TLS_CERT_FILE"),
+        keyFile:      os.Getenv("TLS_KEY_FILE"),
+        redirectAddr: os.Getenv("HTTP_REDIRECT_ADDR"),
+    }
+    domains := splitList(os.Getenv("AUTOCERT_DOMAINS"))
+
+    switch {
+    case len(domains) > 0 && (setup.certFile != "" || setup.keyFile != ""):
+        return nil, errors.New("set either TLS_CERT_FILE and TLS_KEY_FILE or AUTOCERT_DOMAINS, not both")
+    case len(domains) > 0:
+        setup.autocert = &autocert.Manager{
+            Prompt:     autocert.AcceptTOS,
+            HostPolicy: autocert.HostWhitelist(domains...),
+            Cache:      autocert.DirCache(getEnv("AUTOCERT_CACHE_DIR", "autocert")),
+            Email:      os.Getenv("AUTOCERT_EMAIL"),
+        }
+    case setup.certFile != "" || setup.keyFile != "":
+        if setup.certFile == "" || setup.keyFile == "" {
+            return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+        }
+    case setup.redirectAddr != "":
+        return nil, errors.New("HTTP_REDIRECT_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE or AUTOCERT_DOMAINS")
+    default:
+        return nil, nil
+    }
+    return setup, nil
+}
+
+// listen serves server over TLS.
+func (t *tlsSetup) listen(server *http.Server) error {
+    if t.autocert != nil {
+        server.TLSConfig = t.autocert.TLSConfig()
+        return server.ListenAndServeTLS("", "")
+    }
+    return server.ListenAndServeTLS(t.certFile, t.keyFile)
+}
+
+// serveRedirects runs the plain HTTP listener until ctx is cancelled. It
+// answers Let's Encrypt's HTTP challenges when autocert is on, and sends
+// everything else to the same URL over HTTPS on the port of httpsAddr.
+func (t *tlsSetup) serveRedirects(ctx context.Context, httpsAddr string) {
+    _, port, _ := net.SplitHostPort(httpsAddr)
+    var handler http.Handler = http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+        host := request.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        }
+        if port != "" && port != "443" {
+            host = net.JoinHostPort(host, port)
+        }
+        http.Redirect(response, request, "https://"+host+request.URL.RequestURI(), http.StatusMovedPermanently)
+    })
+    if t.autocert != nil {
+        handler = t.autocert.HTTPHandler(handler)
+    }
+
+    server := &http.Server{Addr: t.redirectAddr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        <-ctx.Done()
+        server.Close()
+    }()
+
+    err := server.ListenAndServe()
+    if err != nil && !errors.Is(err, http.ErrServerClosed) {
+        fatal("Error starting HTTP redirect server", "error", err)
+    }
+}
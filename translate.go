@@ -0,0 +1,273 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "golang.org/x/text/language"
+)
+
+// translateTimeout bounds a call to the translation provider.
+const translateTimeout = 10 * time.Second
+
+var errTranslationNotFound = errors.New("translation not found")
+
+// JokeTranslation is a joke's text in another language, as cached in the
+// joke_translations table.
+type JokeTranslation struct {
+    JokeID    int
+    Language  string
+    Text      string
+    Provider  string
+    CreatedAt time.Time
+}
+
+// TranslationRepository caches translations, so a joke is only sent to the
+// provider once per language.
+type TranslationRepository interface {
+    // Translation returns the cached translation of a joke into lang, or
+    // errTranslationNotFound.
+    Translation(ctx context.Context, jokeID int, lang string) (JokeTranslation, error)
+
+    // SaveTranslation caches a translation, replacing any into the same
+    // language.
+    SaveTranslation(ctx context.Context, translation JokeTranslation) error
+}
+
+var translations TranslationRepository
+
+// translationNote marks a joke served in another language than it was
+// written in.
+type translationNote struct {
+    // Machine is set for machine translations, which all translations are
+    // for now.
+    Machine  bool   `json:"machine"`
+    Provider string `json:"provider"`
+    // SourceLanguage is the language the joke was written in.
+    SourceLanguage string `json:"source_lang"`
+}
+
+// Translator translates text with an online service. The implementation is
+// picked with TRANSLATOR.
+type Translator interface {
+    // name is stored with the translations, to credit the provider.
+    name() string
+
+    // Translate translates text from one BCP 47 language to another.
+    Translate(ctx context.Context, text, from, to string) (string, error)
+}
+
+// translator serves ?translate=. It's nil, and translation is turned off,
+// unless TRANSLATOR is set.
+var translator Translator
+
+// loadTranslator reads TRANSLATOR, which is deepl, google or libretranslate,
+// and the settings of that provider. It returns nil when TRANSLATOR is
+// unset.
+func loadTranslator() (Translator, error) {
+    kind := os.Getenv("TRANSLATOR")
+    if kind == "" {
+        return nil, nil
+    }
+    client := &http.Client{Timeout: translateTimeout}
+
+    switch kind {
+    case "deepl":
+        key := os.Getenv("DEEPL_API_KEY")
+        if key == "" {
+            return nil, errors.New("TRANSLATOR=deepl requires DEEPL_API_KEY")
+        }
+        // Keys of the free plan end in :fx and only work on its own host.
+        endpoint := "https://api.deepl.com/v2/translate"
+        if strings.HasSuffix(key, ":fx") {
+            endpoint = "https://api-free.deepl.com/v2/translate"
+        }
+        return &deeplTranslator{endpoint: endpoint, key: key, client: client}, nil
+    case "google":
+        key := os.Getenv("GOOGLE_TRANSLATE_API_KEY")
+        if key == "" {
+            return nil, errors.New("TRANSLATOR=google requires GOOGLE_TRANSLATE_API_KEY")
+        }
+        return &googleTranslator{key: key, client: client}, nil
+    case "libretranslate":
+        base := strings.TrimSuffix(os.Getenv("LIBRETRANSLATE_URL"), "/")
+        if u, err := url.Parse(base); err != nil || base == "" || u.Host == "" {
+            return nil, errors.New("TRANSLATOR=libretranslate requires LIBRETRANSLATE_URL, the URL of the server")
+        }
+        return &libreTranslator{url: base, key: os.Getenv("LIBRETRANSLATE_API_KEY"), client: client}, nil
+    default:
+        return nil, fmt.Errorf("TRANSLATOR must be deepl, google or libretranslate, not %q", kind)
+    }
+}
+
+// translateJoke returns joke translated into lang, from the cache or else
+// from the translator, which caches it.
+func translateJoke(ctx context.Context, joke Joke, lang string) (Joke, error) {
+    cached, err := translations.Translation(ctx, joke.Id, lang)
+    if errors.Is(err, errTranslationNotFound) {
+        cached = JokeTranslation{JokeID: joke.Id, Language: lang, Provider: translator.name(), CreatedAt: time.Now().UTC()}
+        cached.Text, err = translator.Translate(ctx, joke.Text, joke.Language, lang)
+        if err != nil {
+            return joke, fmt.Errorf("translating with %s: %w", translator.name(), err)
+        }
+        cached.Text = strings.TrimSpace(cached.Text)
+        // A failure to cache only costs another call next time.
+        if err := translations.SaveTranslation(ctx, cached); err != nil {
+            slog.ErrorContext(ctx, "Error caching a translation", "joke", joke.Id, "lang", lang, "error", err)
+        }
+    } else if err != nil {
+        return joke, err
+    }
+
+    joke.Translation = &translationNote{Machine: true, Provider: cached.Provider, SourceLanguage: joke.Language}
+    joke.Text, joke.Language = cached.Text, lang
+    return joke, nil
+}
+
+// baseLanguage returns the language of a BCP 47 tag without its region or
+// script, e.g. pt for pt-BR, which is what most providers expect.
+func baseLanguage(tag string) string {
+    base, _ := language.Make(tag).Base()
+    return base.String()
+}
+
+// postTranslation POSTs body as JSON to endpoint and decodes the JSON answer
+// into out.
+func postTranslation(ctx context.Context, client *http.Client, endpoint string, header http.Header, body, out any) error {
+    data, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    for name, values := range header {
+        request.Header[name] = values
+    }
+    request.Header.Set("Content-Type", "application/json")
+
+    response, err := client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        detail, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+        return fmt.Errorf("responded %s: %s", response.Status, bytes.TrimSpace(detail))
+    }
+    return json.NewDecoder(response.Body).Decode(out)
+}
+
+// deeplTranslator translates with the DeepL API.
+type deeplTranslator struct {
+    endpoint string
+    key      string
+    client   *http.Client
+}
+
+// deeplTargets are the regional variants DeepL translates into; other tags
+// are translated into their base language.
+var deeplTargets = map[string]bool{"EN-GB": true, "EN-US": true, "PT-BR": true, "PT-PT": true}
+
+func (t *deeplTranslator) name() string {
+    return "deepl"
+}
+
+func (t *deeplTranslator) Translate(ctx context.Context, text, from, to string) (string, error) {
+    target := strings.ToUpper(to)
+    if !deeplTargets[target] {
+        target = strings.ToUpper(baseLanguage(to))
+    }
+    var answer struct {
+        Translations []struct {
+            Text string `json:"text"`
+        } `json:"translations"`
+    }
+    err := postTranslation(ctx, t.client, t.endpoint, http.Header{"Authorization": {"DeepL-Auth-Key " + t.key}}, map[string]any{
+        "text":        []string{text},
+        "source_lang": strings.ToUpper(baseLanguage(from)),
+        "target_lang": target,
+    }, &answer)
+    if err != nil {
+        return "", err
+    }
+    if len(answer.Translations) == 0 {
+        return "", errors.New("DeepL returned no translation")
+    }
+    return answer.Translations[0].Text, nil
+}
+
+// googleTranslator translates with the Google Cloud Translation basic API.
+type googleTranslator struct {
+    key    string
+    client *http.Client
+}
+
+func (t *googleTranslator) name() string {
+    return "google"
+}
+
+func (t *googleTranslator) Translate(ctx context.Context, text, from, to string) (string, error) {
+    var answer struct {
+        Data struct {
+            Translations []struct {
+                TranslatedText string `json:"translatedText"`
+            } `json:"translations"`
+        } `json:"data"`
+    }
+    err := postTranslation(ctx, t.client, "https://translation.googleapis.com/language/translate/v2", http.Header{"X-Goog-Api-Key": {t.key}}, map[string]any{
+        "q":      text,
+        "source": baseLanguage(from),
+        "target": baseLanguage(to),
+        "format": "text",
+    }, &answer)
+    if err != nil {
+        return "", err
+    }
+    if len(answer.Data.Translations) == 0 {
+        return "", errors.New("Google returned no translation")
+    }
+    return answer.Data.Translations[0].TranslatedText, nil
+}
+
+// libreTranslator translates with a LibreTranslate server, which can be
+// self-hosted.
+type libreTranslator struct {
+    url    string
+    key    string
+    client *http.Client
+}
+
+func (t *libreTranslator) name() string {
+    return "libretranslate"
+}
+
+func (t *libreTranslator) Translate(ctx context.Context, text, from, to string) (string, error) {
+    body := map[string]any{
+        "q":      text,
+        "source": baseLanguage(from),
+        "target": baseLanguage(to),
+        "format": "text",
+    }
+    if t.key != "" {
+        body["api_key"] = t.key
+    }
+    var answer struct {
+        TranslatedText string `json:"translatedText"`
+    }
+    if err := postTranslation(ctx, t.client, t.url+"/translate", nil, body, &answer); err != nil {
+        return "", err
+    }
+    return answer.TranslatedText, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// jokeRetention is how long a deleted joke can be restored before it's
+// purged for good. Zero keeps deleted jokes forever.
+var jokeRetention = 30 * 24 * time.Hour
+
+// purgeInterval is how often jokes past jokeRetention are purged.
+const purgeInterval = time.Hour
+
+// deleteJoke deletes a joke. It's gone from every read, but can be restored
+// until it's purged.
+func deleteJoke(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+
+    err := repo.Delete(request.Context(), id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    slog.InfoContext(request.Context(), "Joke deleted", "joke_id", id)
+    jokeHidden(id)
+    response.WriteHeader(http.StatusNoContent)
+}
+
+// restoreJoke brings back a deleted joke that hasn't been purged yet.
+func restoreJoke(response http.ResponseWriter, request *http.Request) {
+    id, ok := parseJokeRef(mux.Vars(request)["id"])
+    if !ok {
+        writeError(response, http.StatusNotFound, errJokeNotFound.Error())
+        return
+    }
+
+    ctx := request.Context()
+    err := repo.Restore(ctx, id)
+    if errors.Is(err, errJokeNotFound) {
+        writeError(response, http.StatusNotFound, "joke isn't deleted")
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    slog.InfoContext(ctx, "Joke restored", "joke_id", id)
+    responses.invalidate()
+    response.WriteHeader(http.StatusNoContent)
+}
+
+// runPurge purges the jokes deleted longer than jokeRetention ago every
+// purgeInterval until ctx is cancelled.
+func runPurge(ctx context.Context) {
+    ticker := time.NewTicker(purgeInterval)
+    defer ticker.Stop()
+
+    for {
+        n, err := repo.PurgeDeleted(ctx, time.Now().Add(-jokeRetention))
+        if err != nil {
+            slog.Error("Error purging deleted jokes", "error", err)
+        } else if n > 0 {
+            slog.Info("Purged deleted jokes", "count", n)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/charmbracelet/bubbles/textinput"
+    tea "github.com/charmbracelet/bubbletea"
+)
+
+// tuiClient is the API client behind the tui command. It only uses the
+// public HTTP API, as any other client would.
+type tuiClient struct {
+    // base is the address of the versioned API, e.g.
+    // http://localhost:8080/v1.
+    base       string
+    adminToken string
+    http       *http.Client
+}
+
+// tuiJoke is a joke as clients see it. The id is kept as sent: a number, or
+// an opaque string when the server has an ID_KEY.
+type tuiJoke struct {
+    Id     json.RawMessage `json:"id"`
+    Date   time.Time       `json:"entry_date"`
+    Author string          `json:"author"`
+    Text   string          `json:"joke_text"`
+}
+
+func (j tuiJoke) id() string {
+    return strings.Trim(string(j.Id), `"`)
+}
+
+// tuiError is an error response of the API.
+type tuiError struct {
+    status int
+    err    apiError
+}
+
+func (e *tuiError) Error() string {
+    return fmt.Sprintf("%s (%d %s)", e.err.Message, e.status, e.err.Code)
+}
+
+// do sends a request with body, if any, as JSON and decodes the response
+// into result, if any. Error responses are returned as *tuiError.
+func (c *tuiClient) do(ctx context.Context, method, path string, header http.Header, body, result any) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reader = bytes.NewReader(data)
+    }
+    request, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+    if err != nil {
+        return err
+    }
+    if body != nil {
+        request.Header.Set("Content-Type", "application/json")
+    }
+    for name, values := range header {
+        request.Header[name] = values
+    }
+    request.Header.Set("Accept", "application/json")
+    if c.adminToken != "" {
+        request.Header.Set("Authorization", "Bearer "+c.adminToken)
+    }
+
+    response, err := c.http.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+
+    if response.StatusCode >= http.StatusBadRequest {
+        var e errorResponse
+        if err := json.NewDecoder(response.Body).Decode(&e); err != nil {
+            e.Error = apiError{Code: "unknown", Message: response.Status}
+        }
+        return &tuiError{status: response.StatusCode, err: e.Error}
+    }
+    if result == nil {
+        return nil
+    }
+    return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (c *tuiClient) random(ctx context.Context) (tuiJoke, error) {
+    var joke tuiJoke
+    err := c.do(ctx, "GET", "/random", nil, nil, &joke)
+    return joke, err
+}
+
+func (c *tuiClient) authorJokes(ctx context.Context, name string) ([]tuiJoke, error) {
+    var page struct {
+        Jokes []tuiJoke `json:"jokes"`
+    }
+    err := c.do(ctx, "GET", "/authors/"+url.PathEscape(name)+"/jokes?per_page=50", nil, nil, &page)
+    return page.Jokes, err
+}
+
+// submit submits a joke, solving a proof of work first if the server asks
+// for one.
+func (c *tuiClient) submit(ctx context.Context, text, author string) (tuiJoke, error) {
+    joke := struct {
+        Text   string `json:"joke_text"`
+        Author string `json:"author,omitempty"`
+    }{text, author}
+    var created tuiJoke
+    err := c.do(ctx, "POST", "/write", nil, joke, &created)
+    var e *tuiError
+    if !errors.As(err, &e) || e.err.Code != "proof_of_work_required" {
+        return created, err
+    }
+
+    var challenge powChallenge
+    if err := c.do(ctx, "GET", "/pow/challenge", nil, nil, &challenge); err != nil {
+        return created, err
+    }
+    solution, err := solveProofOfWork(ctx, challenge.Challenge, challenge.Difficulty)
+    if err != nil {
+        return created, err
+    }
+    header := http.Header{"X-Pow-Challenge": {challenge.Challenge}, "X-Pow-Solution": {solution}}
+    err = c.do(ctx, "POST", "/write", header, joke, &created)
+    return created, err
+}
+
+func (c *tuiClient) report(ctx context.Context, id, reason string) error {
+    return c.do(ctx, "POST", "/jokes/"+url.PathEscape(id)+"/report", nil, reportRequest{Reason: reason}, nil)
+}
+
+// tuiFlag is a flag in the moderation queue.
+type tuiFlag struct {
+    Id     int      `json:"id"`
+    Source string   `json:"source"`
+    Reason string   `json:"reason"`
+    Tag    string   `json:"tag"`
+    Joke   *tuiJoke `json:"joke"`
+}
+
+// flags lists the moderation queue, only the flags of jokes containing q
+// unless it's empty. It needs the admin token.
+func (c *tuiClient) flags(ctx context.Context, q string) ([]tuiFlag, error) {
+    var flags []tuiFlag
+    err := c.do(ctx, "GET", "/admin/flags?q="+url.QueryEscape(q), nil, nil, &flags)
+    return flags, err
+}
+
+func (c *tuiClient) moderate(ctx context.Context, action, tag string, ids []int) (moderationBatch, error) {
+    var batch moderationBatch
+    err := c.do(ctx, "POST", "/admin/flags/bulk", nil, moderateFlagsRequest{Action: action, Tag: tag, IDs: ids}, &batch)
+    return batch, err
+}
+
+// solveProofOfWork finds a solution whose hash with challenge starts with
+// difficulty zero bits, as powIssuer.verify checks.
+func solveProofOfWork(ctx context.Context, challenge string, difficulty int) (string, error) {
+    for n := uint64(0); ; n++ {
+        if n%100000 == 0 && ctx.Err() != nil {
+            return "", ctx.Err()
+        }
+        solution := strconv.FormatUint(n, 36)
+        sum := sha256.Sum256([]byte(challenge + ":" + solution))
+        if leadingZeroBits(sum[:]) >= difficulty {
+            return solution, nil
+        }
+    }
+}
+
+// tuiMode is the screen the TUI shows.
+type tuiMode int
+
+const (
+    tuiBrowse tuiMode = iota
+    tuiAuthorPrompt
+    tuiAuthorJokes
+    tuiSubmit
+    tuiReport
+    tuiQueue
+    tuiQueueTag
+    tuiQueueFilter
+)
+
+// Messages with the results of the API calls.
+type (
+    tuiJokeMsg struct {
+        joke tuiJoke
+        note string
+    }
+    tuiAuthorJokesMsg struct {
+        author string
+        jokes  []tuiJoke
+    }
+    tuiReportedMsg struct{ reason string }
+    tuiFlagsMsg    struct {
+        flags []tuiFlag
+        note  string
+    }
+    tuiErrMsg struct{ err error }
+)
+
+// tuiModel is the state of the TUI.
+type tuiModel struct {
+    ctx    context.Context
+    client *tuiClient
+
+    mode    tuiMode
+    joke    *tuiJoke
+    status  string
+    loading bool
+
+    author      textinput.Model
+    authorName  string
+    authorJokes []tuiJoke
+    cursor      int
+
+    // text and by are the fields of the submission form, focused the one
+    // at focus.
+    text, by textinput.Model
+    focus    int
+
+    // The moderation queue, for admins: the flags listed, matching
+    // flagQuery, the ones selected for a bulk action, and the prompts for
+    // a tag and a filter. The cursor is shared with the author's jokes.
+    flags       []tuiFlag
+    selected    map[int]bool
+    flagQuery   string
+    tag, filter textinput.Model
+}
+
+func newTUIModel(ctx context.Context, client *tuiClient) tuiModel {
+    m := tuiModel{ctx: ctx, client: client, loading: true}
+    m.author = textinput.New()
+    m.author.Placeholder = "author name"
+    m.text = textinput.New()
+    m.text.Placeholder = "Why did the scarecrow win an award? ..."
+    m.by = textinput.New()
+    m.by.Placeholder = "your name (optional)"
+    m.tag = textinput.New()
+    m.tag.Placeholder = "spam-wave"
+    m.filter = textinput.New()
+    m.filter.Placeholder = "text or author"
+    return m
+}
+
+// fetchFlags loads the moderation queue, noting note once it's loaded.
+func (m tuiModel) fetchFlags(note string) tea.Cmd {
+    return func() tea.Msg {
+        flags, err := m.client.flags(m.ctx, m.flagQuery)
+        if err != nil {
+            return tuiErrMsg{err}
+        }
+        return tuiFlagsMsg{flags: flags, note: note}
+    }
+}
+
+// moderate applies action to the selected flags, or to the one under the
+// cursor when none are selected, and reloads the queue.
+func (m tuiModel) moderate(action, tag string) tea.Cmd {
+    var ids []int
+    for _, flag := range m.flags {
+        if m.selected[flag.Id] {
+            ids = append(ids, flag.Id)
+        }
+    }
+    if len(ids) == 0 && len(m.flags) > 0 {
+        ids = []int{m.flags[m.cursor].Id}
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    return func() tea.Msg {
+        batch, err := m.client.moderate(m.ctx, action, tag, ids)
+        if err != nil {
+            return tuiErrMsg{err}
+        }
+        verb := map[string]string{moderateApprove: "Approved", moderateReject: "Rejected", moderateTag: "Tagged"}[action]
+        return m.fetchFlags(fmt.Sprintf("%s %d flags.", verb, len(batch.Flags)))()
+    }
+}
+
+func (m tuiModel) fetchRandom() tea.Msg {
+    joke, err := m.client.random(m.ctx)
+    if err != nil {
+        return tuiErrMsg{err}
+    }
+    return tuiJokeMsg{joke: joke}
+}
+
+func (m tuiModel) Init() tea.Cmd {
+    return m.fetchRandom
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+    switch msg := msg.(type) {
+    case tuiErrMsg:
+        m.loading = false
+        m.status = "Error: " + msg.err.Error()
+        return m, nil
+    case tuiJokeMsg:
+        m.loading = false
+        m.mode = tuiBrowse
+        m.joke = &msg.joke
+        m.status = msg.note
+        return m, nil
+    case tuiAuthorJokesMsg:
+        m.loading = false
+        m.mode = tuiAuthorJokes
+        m.authorName, m.authorJokes, m.cursor = msg.author, msg.jokes, 0
+        m.status = ""
+        return m, nil
+    case tuiReportedMsg:
+        m.loading = false
+        m.mode = tuiBrowse
+        m.status = "Reported as " + msg.reason + ". Thanks!"
+        return m, nil
+    case tuiFlagsMsg:
+        m.loading = false
+        m.mode = tuiQueue
+        m.flags, m.selected = msg.flags, map[int]bool{}
+        m.cursor = max(min(m.cursor, len(m.flags)-1), 0)
+        m.status = msg.note
+        return m, nil
+    case tea.KeyMsg:
+        if msg.String() == "ctrl+c" {
+            return m, tea.Quit
+        }
+        if m.loading {
+            return m, nil
+        }
+        return m.handleKey(msg)
+    }
+    return m, nil
+}
+
+func (m tuiModel) handleKey(key tea.KeyMsg) (tea.Model, tea.Cmd) {
+    switch m.mode {
+    case tuiBrowse:
+        switch key.String() {
+        case "q", "esc":
+            return m, tea.Quit
+        case "n", " ", "enter":
+            m.loading = true
+            return m, m.fetchRandom
+        case "a":
+            m.mode = tuiAuthorPrompt
+            m.status = ""
+            m.author.SetValue("")
+            return m, m.author.Focus()
+        case "w":
+            m.mode = tuiSubmit
+            m.status = ""
+            m.focus = 0
+            m.by.Blur()
+            return m, m.text.Focus()
+        case "r":
+            if m.joke != nil {
+                m.mode = tuiReport
+                m.status = ""
+            }
+        case "m":
+            if m.client.adminToken != "" {
+                m.loading = true
+                m.status = ""
+                m.cursor = 0
+                return m, m.fetchFlags("")
+            }
+        }
+        return m, nil
+
+    case tuiQueue:
+        switch key.String() {
+        case "esc", "q":
+            m.mode = tuiBrowse
+            m.status = ""
+        case "up", "k":
+            m.cursor = max(m.cursor-1, 0)
+        case "down", "j":
+            m.cursor = max(min(m.cursor+1, len(m.flags)-1), 0)
+        case " ", "x":
+            if len(m.flags) > 0 {
+                if id := m.flags[m.cursor].Id; m.selected[id] {
+                    delete(m.selected, id)
+                } else {
+                    m.selected[id] = true
+                }
+                m.cursor = min(m.cursor+1, len(m.flags)-1)
+            }
+        case "*":
+            // Select every flag listed, or none if they all are.
+            all := len(m.selected) > 0
+            for _, flag := range m.flags {
+                all = all && m.selected[flag.Id]
+            }
+            m.selected = map[int]bool{}
+            if !all {
+                for _, flag := range m.flags {
+                    m.selected[flag.Id] = true
+                }
+            }
+        case "y":
+            m.loading = true
+            return m, m.moderate(moderateApprove, "")
+        case "d":
+            m.loading = true
+            return m, m.moderate(moderateReject, "")
+        case "t":
+            m.mode = tuiQueueTag
+            m.tag.SetValue("")
+            return m, m.tag.Focus()
+        case "/":
+            m.mode = tuiQueueFilter
+            m.filter.SetValue(m.flagQuery)
+            return m, m.filter.Focus()
+        case "g":
+            m.loading = true
+            return m, m.fetchFlags("")
+        }
+        return m, nil
+
+    case tuiQueueTag:
+        switch key.String() {
+        case "esc":
+            m.mode = tuiQueue
+            return m, nil
+        case "enter":
+            tag := strings.TrimSpace(m.tag.Value())
+            if tag == "" {
+                return m, nil
+            }
+            m.mode = tuiQueue
+            m.loading = true
+            return m, m.moderate(moderateTag, tag)
+        }
+        var cmd tea.Cmd
+        m.tag, cmd = m.tag.Update(key)
+        return m, cmd
+
+    case tuiQueueFilter:
+        switch key.String() {
+        case "esc":
+            m.mode = tuiQueue
+            return m, nil
+        case "enter":
+            m.flagQuery = strings.TrimSpace(m.filter.Value())
+            m.loading = true
+            m.cursor = 0
+            return m, m.fetchFlags("")
+        }
+        var cmd tea.Cmd
+        m.filter, cmd = m.filter.Update(key)
+        return m, cmd
+
+    case tuiAuthorPrompt:
+        switch key.String() {
+        case "esc":
+            m.mode = tuiBrowse
+            return m, nil
+        case "enter":
+            name := strings.TrimSpace(m.author.Value())
+            if name == "" {
+                return m, nil
+            }
+            m.loading = true
+            return m, func() tea.Msg {
+                jokes, err := m.client.authorJokes(m.ctx, name)
+                if err != nil {
+                    return tuiErrMsg{err}
+                }
+                return tuiAuthorJokesMsg{author: name, jokes: jokes}
+            }
+        }
+        var cmd tea.Cmd
+        m.author, cmd = m.author.Update(key)
+        return m, cmd
+
+    case tuiAuthorJokes:
+        switch key.String() {
+        case "esc", "q":
+            m.mode = tuiBrowse
+        case "up", "k":
+            m.cursor = max(m.cursor-1, 0)
+        case "down", "j":
+            m.cursor = min(m.cursor+1, len(m.authorJokes)-1)
+        case "enter":
+            if len(m.authorJokes) > 0 {
+                joke := m.authorJokes[m.cursor]
+                m.joke = &joke
+                m.mode = tuiBrowse
+            }
+        }
+        return m, nil
+
+    case tuiSubmit:
+        switch key.String() {
+        case "esc":
+            m.mode = tuiBrowse
+            return m, nil
+        case "tab", "shift+tab", "up", "down":
+            m.focus = 1 - m.focus
+            if m.focus == 0 {
+                m.by.Blur()
+                return m, m.text.Focus()
+            }
+            m.text.Blur()
+            return m, m.by.Focus()
+        case "enter":
+            text, author := strings.TrimSpace(m.text.Value()), strings.TrimSpace(m.by.Value())
+            if text == "" {
+                m.status = "The joke can't be empty."
+                return m, nil
+            }
+            m.loading = true
+            m.status = "Submitting..."
+            return m, func() tea.Msg {
+                joke, err := m.client.submit(m.ctx, text, author)
+                if err != nil {
+                    return tuiErrMsg{err}
+                }
+                return tuiJokeMsg{joke: joke, note: "Submitted as joke " + joke.id() + "."}
+            }
+        }
+        var cmd tea.Cmd
+        if m.focus == 0 {
+            m.text, cmd = m.text.Update(key)
+        } else {
+            m.by, cmd = m.by.Update(key)
+        }
+        return m, cmd
+
+    case tuiReport:
+        if key.String() == "esc" {
+            m.mode = tuiBrowse
+            return m, nil
+        }
+        i, err := strconv.Atoi(key.String())
+        if err != nil || i < 1 || i > len(reportReasons) {
+            return m, nil
+        }
+        reason, id := reportReasons[i-1], m.joke.id()
+        m.loading = true
+        return m, func() tea.Msg {
+            if err := m.client.report(m.ctx, id, reason); err != nil {
+                return tuiErrMsg{err}
+            }
+            return tuiReportedMsg{reason: reason}
+        }
+    }
+    return m, nil
+}
+
+func (m tuiModel) View() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Dad Jokes  %s\n\n", m.client.base)
+
+    switch m.mode {
+    case tuiBrowse:
+        if m.joke != nil {
+            fmt.Fprintf(&b, "%s\n\n", m.joke.Text)
+            author := m.joke.Author
+            if author == "" {
+                author = "anonymous"
+            }
+            fmt.Fprintf(&b, "  #%s by %s, %s\n\n", m.joke.id(), author, m.joke.Date.Format("2 Jan 2006"))
+        }
+        if m.client.adminToken != "" {
+            b.WriteString("n next  a jokes by author  w write  r report  m moderate  q quit\n")
+        } else {
+            b.WriteString("n next  a jokes by author  w write  r report  q quit\n")
+        }
+    case tuiAuthorPrompt:
+        fmt.Fprintf(&b, "Jokes by: %s\n\n", m.author.View())
+        b.WriteString("enter search  esc back\n")
+    case tuiAuthorJokes:
+        fmt.Fprintf(&b, "Jokes by %s\n\n", m.authorName)
+        if len(m.authorJokes) == 0 {
+            b.WriteString("  none yet\n")
+        }
+        for i, joke := range m.authorJokes {
+            cursor := "  "
+            if i == m.cursor {
+                cursor = "> "
+            }
+            fmt.Fprintf(&b, "%s%s\n", cursor, joke.Text)
+        }
+        b.WriteString("\nup/down move  enter show  esc back\n")
+    case tuiSubmit:
+        fmt.Fprintf(&b, "Joke:   %s\nAuthor: %s\n\n", m.text.View(), m.by.View())
+        b.WriteString("tab switch field  enter submit  esc back\n")
+    case tuiReport:
+        fmt.Fprintf(&b, "Report joke #%s as:\n\n", m.joke.id())
+        for i, reason := range reportReasons {
+            fmt.Fprintf(&b, "  %d %s\n", i+1, reason)
+        }
+        b.WriteString("\nesc back\n")
+    case tuiQueue, tuiQueueTag, tuiQueueFilter:
+        b.WriteString("Moderation queue")
+        if m.flagQuery != "" {
+            fmt.Fprintf(&b, " matching %q", m.flagQuery)
+        }
+        fmt.Fprintf(&b, ", %d selected\n\n", len(m.selected))
+        if len(m.flags) == 0 {
+            b.WriteString("  nothing to moderate\n")
+        }
+        for i, flag := range m.flags {
+            cursor, mark := "  ", "[ ]"
+            if i == m.cursor {
+                cursor = "> "
+            }
+            if m.selected[flag.Id] {
+                mark = "[x]"
+            }
+            text := "(joke gone)"
+            if flag.Joke != nil {
+                text, _, _ = strings.Cut(flag.Joke.Text, "\n")
+            }
+            tag := ""
+            if flag.Tag != "" {
+                tag = " #" + flag.Tag
+            }
+            fmt.Fprintf(&b, "%s%s %s: %s  (%s%s)\n", cursor, mark, flag.Source, text, flag.Reason, tag)
+        }
+        switch m.mode {
+        case tuiQueueTag:
+            fmt.Fprintf(&b, "\nTag: %s\nenter tag  esc back\n", m.tag.View())
+        case tuiQueueFilter:
+            fmt.Fprintf(&b, "\nFilter: %s\nenter filter  esc back\n", m.filter.View())
+        default:
+            b.WriteString("\nup/down move  space select  * select all  y approve  d reject  t tag  / filter  g reload  esc back\n")
+        }
+    }
+
+    if m.loading && m.status == "" {
+        b.WriteString("\nLoading...\n")
+    } else if m.status != "" {
+        fmt.Fprintf(&b, "\n%s\n", m.status)
+    }
+    return b.String()
+}
+
+// runTUI runs the terminal client against the API at apiURL, the service
+// root.
+func runTUI(ctx context.Context, apiURL, adminToken string) error {
+    client := &tuiClient{
+        base:       strings.TrimSuffix(apiURL, "/") + "/v1",
+        adminToken: adminToken,
+        http:       &http.Client{Timeout: 30 * time.Second},
+    }
+    _, err := tea.NewProgram(newTUIModel(ctx, client), tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+    if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+        return nil
+    }
+    return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// fieldError is a problem with one field of a request body.
+type fieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// validationErrors lists every problem found in a request body, so clients
+// can fix them all in one go instead of one per round trip.
+type validationErrors []fieldError
+
+func (e validationErrors) Error() string {
+    messages := make([]string, 0, len(e))
+    for _, problem := range e {
+        messages = append(messages, problem.Field+" "+problem.Message)
+    }
+    return strings.Join(messages, "; ")
+}
+
+// add records a problem with field. The message follows the field name, as
+// in "joke_text is required".
+func (e *validationErrors) add(field, format string, args ...any) {
+    *e = append(*e, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns e as an error, or nil when nothing was found. Returning the
+// empty slice itself would make a non-nil error.
+func (e validationErrors) err() error {
+    if len(e) == 0 {
+        return nil
+    }
+    return e
+}
+
+// validator is implemented by request bodies that check their own fields.
+// validate returns validationErrors, or nil when the body is valid.
+type validator interface {
+    validate() error
+}
+
+// decodeRequest decodes the JSON request body into value and, when value is
+// a validator, validates it. If either fails it writes the error response
+// and returns false.
+func decodeRequest(response http.ResponseWriter, request *http.Request, value any) bool {
+    if !decodeBody(response, request, bodyLimits.json, value) {
+        return false
+    }
+
+    if v, ok := value.(validator); ok {
+        if err := v.validate(); err != nil {
+            writeValidationError(response, "invalid_request", err)
+            return false
+        }
+    }
+    return true
+}
+
+// writeValidationError answers with 400 Bad Request and code, listing the
+// problems in err in details.errors.
+func writeValidationError(response http.ResponseWriter, code string, err error) {
+    var problems validationErrors
+    errors.As(err, &problems)
+    writeAPIError(response, http.StatusBadRequest, apiError{
+        Code:    code,
+        Message: err.Error(),
+        Details: map[string]validationErrors{"errors": problems},
+    })
+}
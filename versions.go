@@ -0,0 +1,151 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+// apiV1 is the path prefix of the current API version. A version with
+// breaking response-shape changes gets its own prefix and register function,
+// mounted next to v1 in serve, reusing whichever handlers didn't change.
+const apiV1 = "/v1"
+
+// legacyDeprecated is when the unprefixed routes were deprecated in favour of
+// /v1. It's sent in the Deprecation header.
+var legacyDeprecated = time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+
+// apiMount registers routes on the root router under prefix, wrapped in
+// middleware. It stands in for a mux subrouter, which reports a wrong method
+// on one of several routes as 404 rather than 405.
+type apiMount struct {
+    router     *mux.Router
+    prefix     string
+    middleware []mux.MiddlewareFunc
+}
+
+func (m apiMount) HandleFunc(path string, handler http.HandlerFunc) *mux.Route {
+    var h http.Handler = handler
+    for i := len(m.middleware) - 1; i >= 0; i-- {
+        h = m.middleware[i](h)
+    }
+    return m.router.Handle(m.prefix+path, h)
+}
+
+// registerV1 adds the v1 API routes to api. serve mounts them under /v1,
+// and again at the root for clients written before the API was versioned.
+func registerV1(api apiMount, d *dialect) {
+    api.HandleFunc("/random", getRandomJoke).Methods("GET")
+    api.HandleFunc("/joke-of-the-day", getJokeOfTheDay).Methods("GET")
+    api.HandleFunc("/jokes", conditional(responses.cached(listJokes))).Methods("GET")
+    api.HandleFunc("/jokes/export", newExportHandler(d)).Methods("GET")
+    api.HandleFunc("/jokes/stream", streamJokes).Methods("GET")
+    api.HandleFunc("/jokes/next", getNextJoke).Methods("GET")
+    api.HandleFunc("/ws", serveWebSocket).Methods("GET")
+    api.HandleFunc("/jokes/batch", requireAdmin(requireSchema(importJokes))).Methods("POST")
+    api.HandleFunc("/jokes/{id:[0-9A-Za-z]+}", conditional(getJoke)).Methods("GET")
+    api.HandleFunc("/jokes/{id:[0-9A-Za-z]+}/image", getJokeImage).Methods("GET")
+    api.HandleFunc("/jokes/{id:[0-9A-Za-z]+}/report", requireSchema(reportJoke)).Methods("POST")
+    api.HandleFunc("/write", requireSchema(saveJoke)).Methods("POST")
+    api.HandleFunc("/pow/challenge", getPowChallenge).Methods("GET")
+    api.HandleFunc("/subscribe", requireSchema(subscribe)).Methods("POST")
+    api.HandleFunc("/subscribe/confirm", requireSchema(confirmSubscription)).Methods("GET")
+    api.HandleFunc("/unsubscribe", requireSchema(unsubscribe)).Methods("GET", "POST")
+    api.HandleFunc("/feed.rss", getRSSFeed).Methods("GET")
+    api.HandleFunc("/feed.atom", getAtomFeed).Methods("GET")
+    api.HandleFunc("/collections/best-of", listBestOf).Methods("GET")
+    api.HandleFunc("/collections/best-of/feed.rss", getBestOfFeed).Methods("GET")
+    api.HandleFunc("/collections/best-of/{week:[0-9]+-[0-9]+}", conditional(getBestOf)).Methods("GET")
+    api.HandleFunc("/integrations/slack", slackIntegration).Methods("POST")
+    api.HandleFunc("/embed.js", getEmbedScript).Methods("GET")
+    api.HandleFunc("/embed.svg", getEmbedSVG).Methods("GET")
+    api.HandleFunc("/themes", listThemes).Methods("GET")
+    api.HandleFunc("/themes/{name}", getTheme).Methods("GET")
+    api.HandleFunc("/changes", getChanges).Methods("GET")
+    api.HandleFunc("/limits", getLimits).Methods("GET")
+    api.HandleFunc("/authors", conditional(responses.cached(listAuthors))).Methods("GET")
+    api.HandleFunc("/authors/{name}/jokes", conditional(responses.cached(getAuthorJokes))).Methods("GET")
+    api.HandleFunc("/authors/{name}/stats", responses.cached(getAuthorStats)).Methods("GET")
+    api.HandleFunc("/federation/jokes", responses.cached(getFederatedJokes)).Methods("GET")
+    api.HandleFunc("/admin/authors/merge", requireAdmin(requireSchema(mergeAuthors))).Methods("POST")
+    api.HandleFunc("/admin/quality", requireAdmin(getQualityReport)).Methods("GET")
+    api.HandleFunc("/admin/stats", requireAdmin(getAdminStats)).Methods("GET")
+    api.HandleFunc("/admin/audit", requireAdmin(getAuditLog)).Methods("GET")
+    api.HandleFunc("/admin/schedule", requireAdmin(getSchedule)).Methods("GET")
+    api.HandleFunc("/admin/schedule", requireAdmin(requireSchema(putSchedule))).Methods("PUT")
+    api.HandleFunc("/admin/schedule/{date}", requireAdmin(requireSchema(deleteScheduledJoke))).Methods("DELETE")
+    api.HandleFunc("/admin/flags", requireAdmin(listFlags)).Methods("GET")
+    api.HandleFunc("/admin/flags/{id:[0-9]+}/resolve", requireAdmin(requireSchema(resolveFlag))).Methods("POST")
+    api.HandleFunc("/admin/flags/bulk", requireAdmin(requireSchema(moderateFlags))).Methods("POST")
+    api.HandleFunc("/admin/queue/stats", requireAdmin(getQueueStats)).Methods("GET")
+    api.HandleFunc("/admin/queue/priority", requireAdmin(listPriorityFlags)).Methods("GET")
+    api.HandleFunc("/admin/jokes/{id:[0-9A-Za-z]+}", requireAdmin(requireSchema(deleteJoke))).Methods("DELETE")
+    api.HandleFunc("/admin/jokes/{id:[0-9A-Za-z]+}/restore", requireAdmin(requireSchema(restoreJoke))).Methods("POST")
+    api.HandleFunc("/admin/jokes/{id:[0-9A-Za-z]+}/shares", requireAdmin(getShareStats)).Methods("GET")
+    api.HandleFunc("/admin/reports", requireAdmin(listReports)).Methods("GET")
+    api.HandleFunc("/admin/reports/{id:[0-9A-Za-z]+}/resolve", requireAdmin(requireSchema(resolveReports))).Methods("POST")
+    api.HandleFunc("/admin/media", requireAdmin(purgeMedia)).Methods("DELETE")
+    api.HandleFunc("/admin/seed", requireAdmin(requireSchema(seed))).Methods("POST")
+    api.HandleFunc("/admin/corpus/sync", requireAdmin(requireSchema(syncCorpusNow))).Methods("POST")
+    api.HandleFunc("/admin/themes/{name}", requireAdmin(requireSchema(putTheme))).Methods("PUT")
+    api.HandleFunc("/admin/themes/{name}", requireAdmin(requireSchema(deleteTheme))).Methods("DELETE")
+    api.HandleFunc("/admin/webhooks", requireAdmin(listWebhooks)).Methods("GET")
+    api.HandleFunc("/admin/webhooks", requireAdmin(requireSchema(createWebhook))).Methods("POST")
+    api.HandleFunc("/admin/webhooks/{id:[0-9]+}", requireAdmin(requireSchema(deleteWebhook))).Methods("DELETE")
+}
+
+// deprecated marks responses from the legacy unprefixed routes with the
+// deprecation headers, linking to the same resource under /v1. Sunset is
+// only sent when LEGACY_SUNSET is set.
+func deprecated(sunset time.Time) mux.MiddlewareFunc {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+            setDeprecationHeaders(response, request, apiDeprecation{
+                since:     legacyDeprecated,
+                sunset:    sunset,
+                successor: request.URL.RequestURI(),
+            })
+            next.ServeHTTP(response, request)
+        })
+    }
+}
+
+// legacySunset reads LEGACY_SUNSET, the day the unprefixed routes will be
+// removed, as YYYY-MM-DD. It returns the zero time when it isn't set.
+func legacySunset() (time.Time, error) {
+    value := os.Getenv("LEGACY_SUNSET")
+    if value == "" {
+        return time.Time{}, nil
+    }
+    sunset, err := time.Parse(time.DateOnly, value)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("LEGACY_SUNSET: %w", err)
+    }
+    return sunset, nil
+}
+
+// apiURL returns the public URL of the current API version, used to build
+// links to jokes and other API resources.
+func apiURL(request *http.Request) string {
+    return baseURL(request) + apiV1
+}
+
+// unversioned strips the version prefix from a path or route template, so
+// /v1/write and its legacy alias /write are treated as the same route.
+func unversioned(path string) string {
+    if rest, ok := strings.CutPrefix(path, apiV1); ok && strings.HasPrefix(rest, "/") {
+        return rest
+    }
+    return path
+}
+
+// routeKey is the name a route goes by in settings and documentation: its
+// template without the version prefix or the patterns of its variables, e.g.
+// /jokes/{id} for /v1/jokes/{id:[0-9A-Za-z]+}.
+func routeKey(template string) string {
+    return pathVariable.ReplaceAllString(unversioned(template), "{$1}")
+}
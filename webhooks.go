@@ -0,0 +1,252 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "github.com/gorilla/mux"
+)
+
+const (
+    // webhookPollInterval is how often the delivery worker looks for due
+    // deliveries.
+    webhookPollInterval = 5 * time.Second
+
+    // webhookMaxAttempts is how many times a delivery is tried before it's
+    // given up on.
+    webhookMaxAttempts = 8
+
+    // webhookBaseBackoff is the wait after the first failed attempt. It
+    // doubles with every attempt after that.
+    webhookBaseBackoff = 30 * time.Second
+
+    // webhookTimeout bounds a single delivery attempt.
+    webhookTimeout = 10 * time.Second
+
+    // webhookBatchSize is how many deliveries the worker claims at once.
+    // They're claimed for long enough to attempt them all, so another
+    // instance doesn't send them at the same time.
+    webhookBatchSize = 10
+)
+
+// errWebhookNotFound is returned when a webhook id doesn't exist.
+var errWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is a URL registered to be notified of events. The secret is only
+// returned when the webhook is created.
+type Webhook struct {
+    Id        int       `json:"id"`
+    URL       string    `json:"url"`
+    Secret    string    `json:"secret,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// webhookDelivery is one event queued for one webhook.
+type webhookDelivery struct {
+    Id       int
+    URL      string
+    Secret   string
+    Event    string
+    Payload  []byte
+    Attempts int
+}
+
+// webhookEvent is the body POSTed to webhooks.
+type webhookEvent struct {
+    Event string `json:"event"`
+    Joke  Joke   `json:"joke"`
+}
+
+// WebhookRepository stores webhooks and their delivery queue. Deliveries are
+// queued in the database, so they survive restarts and events from the
+// import command are delivered by the server.
+type WebhookRepository interface {
+    CreateWebhook(ctx context.Context, url, secret string) (Webhook, error)
+    ListWebhooks(ctx context.Context) ([]Webhook, error)
+
+    // DeleteWebhook removes a webhook and its pending deliveries, or returns
+    // errWebhookNotFound.
+    DeleteWebhook(ctx context.Context, id int) error
+
+    // EnqueueWebhooks queues payload for delivery to every webhook.
+    EnqueueWebhooks(ctx context.Context, event string, payload []byte) error
+
+    // ClaimDeliveries returns up to limit deliveries that are due, and holds
+    // each one for lease so other instances skip it.
+    ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhookDelivery, error)
+
+    // FinishDelivery records the outcome of an attempt. A nil retryAt means
+    // the delivery won't be tried again; attemptErr is empty on success.
+    FinishDelivery(ctx context.Context, id int, retryAt *time.Time, attemptErr string) error
+}
+
+var webhooks WebhookRepository
+
+// notifyJokeCreated queues a joke.created event for every webhook. Failing
+// to queue it is logged, since the joke itself has been stored.
+func notifyJokeCreated(ctx context.Context, joke Joke) {
+    payload, err := json.Marshal(webhookEvent{Event: "joke.created", Joke: joke})
+    if err == nil {
+        err = webhooks.EnqueueWebhooks(ctx, "joke.created", payload)
+    }
+    if err != nil {
+        slog.ErrorContext(ctx, "Error queueing webhooks", "joke", joke.Id, "error", err)
+    }
+}
+
+// signWebhook returns the X-Dadjokes-Signature header value for payload.
+func signWebhook(secret string, payload []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(payload)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait before retrying a delivery that has failed
+// attempts times.
+func webhookBackoff(attempts int) time.Duration {
+    return webhookBaseBackoff << (attempts - 1)
+}
+
+// runWebhooks delivers queued events until ctx is cancelled.
+func runWebhooks(ctx context.Context) {
+    client := &http.Client{Timeout: webhookTimeout}
+    ticker := time.NewTicker(webhookPollInterval)
+    defer ticker.Stop()
+
+    for {
+        deliveries, err := webhooks.ClaimDeliveries(ctx, webhookBatchSize, (webhookBatchSize+1)*webhookTimeout)
+        if err != nil {
+            slog.Error("Error loading webhook deliveries", "error", err)
+        }
+        for _, delivery := range deliveries {
+            deliverWebhook(ctx, client, delivery)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func deliverWebhook(ctx context.Context, client *http.Client, delivery webhookDelivery) {
+    err := postWebhook(ctx, client, delivery)
+    attempts := delivery.Attempts + 1
+
+    var retryAt *time.Time
+    var attemptErr string
+    if err != nil {
+        attemptErr = err.Error()
+        if attempts < webhookMaxAttempts {
+            next := time.Now().UTC().Add(webhookBackoff(attempts)).Truncate(time.Second)
+            retryAt = &next
+        }
+        slog.Warn("Error delivering webhook", "delivery", delivery.Id, "url", delivery.URL, "attempt", attempts, "error", err)
+    }
+
+    if err := webhooks.FinishDelivery(ctx, delivery.Id, retryAt, attemptErr); err != nil {
+        slog.Error("Error recording webhook delivery", "delivery", delivery.Id, "error", err)
+    }
+}
+
+func postWebhook(ctx context.Context, client *http.Client, delivery webhookDelivery) error {
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", "application/json")
+    request.Header.Set("X-Dadjokes-Event", delivery.Event)
+    request.Header.Set("X-Dadjokes-Delivery", strconv.Itoa(delivery.Id))
+    request.Header.Set("X-Dadjokes-Signature", signWebhook(delivery.Secret, delivery.Payload))
+
+    response, err := client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return fmt.Errorf("%s responded %s", delivery.URL, response.Status)
+    }
+    return nil
+}
+
+type createWebhookRequest struct {
+    URL    string `json:"url"`
+    Secret string `json:"secret"`
+}
+
+func (c *createWebhookRequest) validate() error {
+    var problems validationErrors
+    target, err := url.Parse(c.URL)
+    switch {
+    case c.URL == "":
+        problems.add("url", "is required")
+    case err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "":
+        problems.add("url", "must be an absolute http or https URL")
+    }
+    return problems.err()
+}
+
+// createWebhook registers a webhook. Without a secret one is generated; it's
+// only shown in this response.
+func createWebhook(response http.ResponseWriter, request *http.Request) {
+    var create createWebhookRequest
+    if !decodeRequest(response, request, &create) {
+        return
+    }
+
+    if create.Secret == "" {
+        secret := make([]byte, 32)
+        rand.Read(secret)
+        create.Secret = hex.EncodeToString(secret)
+    }
+
+    webhook, err := webhooks.CreateWebhook(request.Context(), create.URL, create.Secret)
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusCreated, webhook)
+}
+
+func listWebhooks(response http.ResponseWriter, request *http.Request) {
+    list, err := webhooks.ListWebhooks(request.Context())
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    writeJSON(response, request, http.StatusOK, list)
+}
+
+func deleteWebhook(response http.ResponseWriter, request *http.Request) {
+    id, _ := strconv.Atoi(mux.Vars(request)["id"])
+    err := webhooks.DeleteWebhook(request.Context(), id)
+    if errors.Is(err, errWebhookNotFound) {
+        writeError(response, http.StatusNotFound, err.Error())
+        return
+    }
+    if err != nil {
+        writeInternalError(response, request, err)
+        return
+    }
+
+    response.WriteHeader(http.StatusNoContent)
+}
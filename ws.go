@@ -0,0 +1,378 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+const (
+    // wsPingInterval is how often idle connections are pinged. A client
+    // that hasn't answered within wsPongWait is disconnected.
+    wsPingInterval = 30 * time.Second
+    wsPongWait     = 60 * time.Second
+    // wsWriteWait is how long a message may take to send.
+    wsWriteWait = 10 * time.Second
+    // wsMaxMessageSize bounds the messages clients send, which are only
+    // ever small commands.
+    wsMaxMessageSize = 1024
+    // wsSendBuffer is how many messages may wait for a slow client before
+    // it's disconnected.
+    wsSendBuffer = 16
+)
+
+// wsUpgrader accepts WebSocket connections from pages on this host, and
+// from the origins CORS_ORIGINS allows, which newHandler sets up.
+var wsUpgrader = websocket.Upgrader{
+    ReadBufferSize:  1024,
+    WriteBufferSize: 4096,
+}
+
+// wsMessageLimit is how many messages a connection may send per window.
+var wsMessageLimit = rateLimit{Requests: 30, Window: time.Minute}
+
+// wsClients is the hub all connections register with.
+var wsClients = newWSHub()
+
+// wsRequest is a message from a client. ID is echoed in the reply, so
+// clients can match replies to requests.
+type wsRequest struct {
+    Action string `json:"action"`
+    ID     string `json:"id,omitempty"`
+}
+
+// wsMessage is a message to a client: a reply, with the ID of its request,
+// or a new joke for subscribers.
+type wsMessage struct {
+    Type  string    `json:"type"`
+    ID    string    `json:"id,omitempty"`
+    Joke  *Joke     `json:"joke,omitempty"`
+    Error *apiError `json:"error,omitempty"`
+}
+
+// loadWebSockets reads WS_MESSAGE_LIMIT, the messages a connection may send
+// as requests/window (default 30/1m), and lets the origins allowed by the
+// CORS policy connect.
+func loadWebSockets(crossOrigin *corsPolicy) error {
+    if spec := strings.TrimSpace(getEnv("WS_MESSAGE_LIMIT", "")); spec != "" {
+        requests, window, ok := strings.Cut(spec, "/")
+        n, err := strconv.Atoi(requests)
+        d, err2 := time.ParseDuration(window)
+        if !ok || err != nil || n < 1 || err2 != nil || d <= 0 {
+            return fmt.Errorf("WS_MESSAGE_LIMIT: %q is not requests/window", spec)
+        }
+        wsMessageLimit = rateLimit{Requests: n, Window: d}
+    }
+
+    wsUpgrader.CheckOrigin = func(request *http.Request) bool {
+        origin := request.Header.Get("Origin")
+        if origin == "" {
+            return true
+        }
+        if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, request.Host) {
+            return true
+        }
+        return crossOrigin != nil && crossOrigin.allowed(origin)
+    }
+    return nil
+}
+
+// wsClient is one WebSocket connection. Only its write loop writes to conn;
+// the read loop and the hub hand it messages through send.
+type wsClient struct {
+    conn *websocket.Conn
+    send chan []byte
+    // quit is closed by the hub when it drops the client, and done by the
+    // write loop when it stops.
+    quit chan struct{}
+    done chan struct{}
+
+    // The read loop's count of messages in the current window.
+    messages    int
+    windowStart time.Time
+}
+
+// wsSubscription turns a client's new-joke broadcasts on or off.
+type wsSubscription struct {
+    client *wsClient
+    on     bool
+}
+
+// wsHub keeps track of the connections and sends new jokes to the ones that
+// subscribed. Only run touches clients.
+type wsHub struct {
+    register      chan *wsClient
+    unregister    chan *wsClient
+    subscriptions chan wsSubscription
+    // stopped is closed when run returns, so clients don't block on it.
+    stopped chan struct{}
+
+    // clients maps each connection to whether it's subscribed.
+    clients map[*wsClient]bool
+    // last is the id of the last joke broadcast, or -1 while nobody is
+    // subscribed.
+    last int
+}
+
+func newWSHub() *wsHub {
+    return &wsHub{
+        register:      make(chan *wsClient),
+        unregister:    make(chan *wsClient),
+        subscriptions: make(chan wsSubscription),
+        stopped:       make(chan struct{}),
+        clients:       map[*wsClient]bool{},
+        last:          -1,
+    }
+}
+
+// run serves the hub until ctx is cancelled or the server shuts down, then
+// closes every connection. Like the SSE stream, it hears about jokes stored
+// by this instance right away and polls for the ones stored by others.
+func (h *wsHub) run(ctx context.Context) {
+    defer close(h.stopped)
+    defer func() {
+        for client := range h.clients {
+            h.drop(client)
+        }
+    }()
+
+    poll := time.NewTicker(streamPollInterval)
+    defer poll.Stop()
+
+    for {
+        wake := newJokes.wait()
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-streamsClosing:
+            return
+        case client := <-h.register:
+            h.clients[client] = false
+        case client := <-h.unregister:
+            h.drop(client)
+        case s := <-h.subscriptions:
+            if _, ok := h.clients[s.client]; ok {
+                h.clients[s.client] = s.on
+                if s.on && h.last < 0 {
+                    h.resume(ctx)
+                }
+            }
+        case <-wake:
+            h.broadcast(ctx)
+        case <-poll.C:
+            h.broadcast(ctx)
+        }
+    }
+}
+
+// drop forgets client and tells its write loop to close the connection.
+func (h *wsHub) drop(client *wsClient) {
+    if _, ok := h.clients[client]; ok {
+        delete(h.clients, client)
+        close(client.quit)
+    }
+}
+
+// resume starts broadcasting from the latest joke, so the first subscriber
+// after a quiet spell gets the jokes from now on rather than everything
+// since the last broadcast.
+func (h *wsHub) resume(ctx context.Context) {
+    last, err := repo.MaxID(ctx)
+    if err != nil {
+        slog.ErrorContext(ctx, "Error broadcasting jokes", "error", err)
+        return
+    }
+    h.last = last
+}
+
+// broadcast sends the jokes stored since the last broadcast to subscribers.
+// Subscribers too slow to keep up are dropped rather than holding up the
+// others.
+func (h *wsHub) broadcast(ctx context.Context) {
+    var subscribers []*wsClient
+    for client, subscribed := range h.clients {
+        if subscribed {
+            subscribers = append(subscribers, client)
+        }
+    }
+    if len(subscribers) == 0 {
+        h.last = -1
+        return
+    }
+
+    if h.last < 0 {
+        h.resume(ctx)
+        return
+    }
+
+    jokes, err := repo.ListAfter(ctx, h.last, streamBatchSize, "")
+    if err != nil {
+        if ctx.Err() == nil {
+            slog.ErrorContext(ctx, "Error broadcasting jokes", "error", err)
+        }
+        return
+    }
+    for _, joke := range jokes {
+        data, _ := json.Marshal(wsMessage{Type: "new_joke", Joke: &joke})
+        for _, client := range subscribers {
+            if _, ok := h.clients[client]; !ok {
+                continue
+            }
+            select {
+            case client.send <- data:
+            default:
+                h.drop(client)
+            }
+        }
+        h.last = joke.Id
+    }
+}
+
+// serveWebSocket upgrades the request to a WebSocket connection. Clients
+// send {"action": "random"} for a random joke, and "subscribe" or
+// "unsubscribe" to start or stop getting new jokes as they're stored.
+func serveWebSocket(response http.ResponseWriter, request *http.Request) {
+    if !streamingEnabled {
+        writeError(response, http.StatusNotImplemented, "WebSockets aren't available on this deployment")
+        return
+    }
+    if !websocket.IsWebSocketUpgrade(request) {
+        writeError(response, http.StatusBadRequest, "this endpoint only accepts WebSocket connections")
+        return
+    }
+
+    // Upgrade answers failed handshakes itself.
+    conn, err := wsUpgrader.Upgrade(response, request, nil)
+    if err != nil {
+        return
+    }
+    client := &wsClient{
+        conn: conn,
+        send: make(chan []byte, wsSendBuffer),
+        quit: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+
+    select {
+    case wsClients.register <- client:
+    case <-wsClients.stopped:
+        conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteWait))
+        conn.Close()
+        return
+    }
+    go client.writeLoop()
+    client.readLoop(request.Context())
+
+    select {
+    case wsClients.unregister <- client:
+    case <-wsClients.stopped:
+    }
+}
+
+// readLoop handles the client's messages until the connection fails or is
+// closed. The request context outlives the hijacked connection, so it's
+// only used for the database calls.
+func (c *wsClient) readLoop(ctx context.Context) {
+    defer c.conn.Close()
+    c.conn.SetReadLimit(wsMaxMessageSize)
+    c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+    c.conn.SetPongHandler(func(string) error {
+        return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+    })
+
+    for {
+        _, data, err := c.conn.ReadMessage()
+        if err != nil {
+            return
+        }
+        c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+        reply := c.handle(ctx, data)
+        message, _ := json.Marshal(reply)
+        // Waiting for room holds off reading, so a client that sends faster
+        // than it reads slows itself down.
+        select {
+        case c.send <- message:
+        case <-c.done:
+            return
+        }
+    }
+}
+
+// handle answers one message from the client.
+func (c *wsClient) handle(ctx context.Context, data []byte) wsMessage {
+    var request wsRequest
+    if err := json.Unmarshal(data, &request); err != nil {
+        return wsError("", "bad_request", "messages must be JSON objects with an action")
+    }
+    if !c.allow(time.Now()) {
+        return wsError(request.ID, "rate_limited", fmt.Sprintf("at most %d messages per %s", wsMessageLimit.Requests, wsMessageLimit.Window))
+    }
+
+    switch request.Action {
+    case "random":
+        joke, err := randomJoke(ctx, "")
+        if err != nil {
+            slog.ErrorContext(ctx, "Error handling WebSocket message", "error", err)
+            return wsError(request.ID, "internal", "internal server error")
+        }
+        return wsMessage{Type: "joke", ID: request.ID, Joke: &joke}
+    case "subscribe", "unsubscribe":
+        select {
+        case wsClients.subscriptions <- wsSubscription{client: c, on: request.Action == "subscribe"}:
+        case <-wsClients.stopped:
+        }
+        return wsMessage{Type: request.Action + "d", ID: request.ID}
+    default:
+        return wsError(request.ID, "bad_request", `action must be "random", "subscribe" or "unsubscribe"`)
+    }
+}
+
+// allow counts a message against the connection's limit, in fixed windows
+// like the HTTP rate limits.
+func (c *wsClient) allow(now time.Time) bool {
+    if now.Sub(c.windowStart) >= wsMessageLimit.Window {
+        c.windowStart, c.messages = now, 0
+    }
+    c.messages++
+    return c.messages <= wsMessageLimit.Requests
+}
+
+func wsError(id, code, message string) wsMessage {
+    return wsMessage{Type: "error", ID: id, Error: &apiError{Code: code, Message: message}}
+}
+
+// writeLoop sends the client its messages and pings until the hub drops it
+// or a write fails.
+func (c *wsClient) writeLoop() {
+    ping := time.NewTicker(wsPingInterval)
+    defer ping.Stop()
+    defer close(c.done)
+    defer c.conn.Close()
+
+    for {
+        select {
+        case message := <-c.send:
+            c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+            if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+                return
+            }
+        case <-ping.C:
+            if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+                return
+            }
+        case <-c.quit:
+            c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteWait))
+            return
+        }
+    }
+}